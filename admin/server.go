@@ -0,0 +1,251 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/service"
+	"strings"
+	"time"
+)
+
+const (
+	// time to wait for requests in progress on Stop
+	shutdownTimeout = 5 * time.Second
+	// Off disables the admin server in the -admin flag
+	Off = "off"
+)
+
+// # Server
+//
+// Server serves the admin API of a service over a Unix socket or TCP.
+// The address is bound by NewServer, so an address in use is reported before the service starts.
+//
+// - GET /v1/status : pipelines, sensors & exporters
+//
+// - GET /v1/sensors, /v1/pipelines, /v1/exporters : components of one kind
+//
+// - POST /v1/sensors/{name}/{action}, /v1/pipelines/{name}/{action} : action is pause, resume or restart
+//
+// Responses are JSON. Errors are {"error": "..."} with 400 for unknown action, 404 for unknown component
+// & 409 if the service can not be controlled.
+type Server struct {
+	svc      service.Service
+	listener net.Listener
+	server   *http.Server
+	// socketPath is removed on Stop. empty if the server listens on TCP.
+	socketPath string
+	done       chan struct{}
+	// dependency
+	logger plogger.PolvoLogger
+}
+
+// NewServer listens on address & serves the admin API of svc.
+// address is "unix:///path/to.sock", "tcp://host:port" or a path of Unix socket.
+// The Unix socket is accessible only by the owner of the agent.
+func NewServer(address string, svc service.Service, logger plogger.PolvoLogger) (*Server, error) {
+	var (
+		listener   net.Listener
+		socketPath string
+		err        error
+	)
+
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		listener, err = net.Listen("tcp", strings.TrimPrefix(address, "tcp://"))
+	default:
+		socketPath = strings.TrimPrefix(address, "unix://")
+		listener, err = listenUnix(socketPath)
+	}
+	if err != nil {
+		return nil, perror.PolvoGeneralError{
+			Code:   perror.SystemError,
+			Origin: err,
+			Msg:    "error while construct new admin server",
+		}
+	}
+	s := new(Server)
+	s.svc = svc
+	s.listener = listener
+	s.socketPath = socketPath
+	s.logger = logger
+	s.done = make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, s.svc.Status())
+	})
+	mux.HandleFunc("GET /v1/sensors", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, s.svc.Status().Sensors)
+	})
+	mux.HandleFunc("GET /v1/pipelines", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, s.svc.Status().Pipelines)
+	})
+	mux.HandleFunc("GET /v1/exporters", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, s.svc.Status().Exporters)
+	})
+	mux.HandleFunc("POST /v1/sensors/{name}/{action}", s.controlSensor)
+	mux.HandleFunc("POST /v1/pipelines/{name}/{action}", s.controlPipeline)
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// listenUnix removes the stale socket of the previous agent & listens on path.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is used by another agent", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Start serves requests in background.
+func (s *Server) Start() {
+	go func() {
+		defer close(s.done)
+		s.logger.PrintInfo("admin server is listening on %s", s.listener.Addr())
+		if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.PrintError("admin server is closed. %s", err.Error())
+		}
+	}()
+}
+
+// Stop closes the listener, waits for requests in progress & removes the socket.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+	if s.socketPath != "" {
+		os.Remove(s.socketPath)
+	}
+	if err != nil {
+		return perror.PolvoGeneralError{
+			Code:   perror.SystemError,
+			Origin: err,
+			Msg:    "error while stop admin server",
+		}
+	}
+	return nil
+}
+
+func (s *Server) controlSensor(w http.ResponseWriter, req *http.Request) {
+	var control func(name string) error
+
+	name, action := req.PathValue("name"), req.PathValue("action")
+	switch action {
+	case "pause":
+		control = s.svc.PauseSensor
+	case "resume":
+		control = s.svc.ResumeSensor
+	case "restart":
+		control = s.svc.RestartSensor
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown action %s", action))
+		return
+	}
+	if err := control(name); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	s.logger.PrintInfo("admin: %s sensor [%s]", action, name)
+	for _, sensorStatus := range s.svc.Status().Sensors {
+		if sensorStatus.Name == name {
+			writeJSON(w, http.StatusOK, sensorStatus)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) controlPipeline(w http.ResponseWriter, req *http.Request) {
+	var control func(name string) error
+
+	name, action := req.PathValue("name"), req.PathValue("action")
+	switch action {
+	case "pause":
+		control = s.svc.PausePipeline
+	case "resume":
+		control = s.svc.ResumePipeline
+	case "restart":
+		control = s.svc.RestartPipeline
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown action %s", action))
+		return
+	}
+	if err := control(name); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	s.logger.PrintInfo("admin: %s pipeline [%s]", action, name)
+	for _, pipelineStatus := range s.svc.Status().Pipelines {
+		if pipelineStatus.Name == name {
+			writeJSON(w, http.StatusOK, pipelineStatus)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// statusCode maps errors of service control to HTTP status.
+func statusCode(err error) int {
+	var pipelineErr perror.PolvoPipelineError
+	if !errors.As(err, &pipelineErr) {
+		return http.StatusInternalServerError
+	}
+	switch pipelineErr.Code {
+	case perror.ErrInvalidSensorName, perror.ErrInvalidPipelineName:
+		return http.StatusNotFound
+	case perror.ErrPipelineControl:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(body, '\n'))
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(body, '\n'))
+}
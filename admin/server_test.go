@@ -0,0 +1,116 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"polvo/admin"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/service"
+	"strings"
+	"testing"
+)
+
+// fakeService records the controlled sensors. methods which are not used by admin panic.
+type fakeService struct {
+	service.Service
+	paused map[string]bool
+}
+
+func (fs *fakeService) Status() service.Status {
+	return service.Status{
+		Sensors: []service.SensorStatus{{Name: "dummy_sensor", Running: true, Pid: 42, Paused: fs.paused["dummy_sensor"]}},
+	}
+}
+
+func (fs *fakeService) PauseSensor(name string) error {
+	if name != "dummy_sensor" {
+		return perror.PolvoPipelineError{Code: perror.ErrInvalidSensorName, Origin: fmt.Errorf("sensor %s is not defined", name), Msg: "test"}
+	}
+	fs.paused[name] = true
+	return nil
+}
+
+func (fs *fakeService) RestartPipeline(name string) error {
+	return perror.PolvoPipelineError{Code: perror.ErrPipelineControl, Origin: fmt.Errorf("service is already stopped"), Msg: "test"}
+}
+
+func TestServer(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "polvo-admin")
+	if err != nil {
+		t.Fatalf("error while create temp directory %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	loger := plogger.NewLogger(tmpDir)
+	defer loger.Close()
+
+	socketPath := filepath.Join(tmpDir, "admin.sock")
+	server, err := admin.NewServer("unix://"+socketPath, &fakeService{paused: make(map[string]bool)}, loger)
+	if err != nil {
+		t.Fatalf("NewServer() = %v, want nil", err)
+	}
+	server.Start()
+	if info, err := os.Stat(socketPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket %s = %v, want mode 0600", socketPath, info)
+	}
+	// socket of running server is not taken over
+	if _, err = admin.NewServer(socketPath, &fakeService{}, loger); err == nil {
+		t.Errorf("NewServer(%s) = nil, want error for socket in use", socketPath)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	request := func(method string, path string) (int, string) {
+		req, _ := http.NewRequest(method, "http://polvo"+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s = %v, want nil", method, path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "/v1/sensors", http.StatusOK, `"pid":42`},
+		{http.MethodPost, "/v1/sensors/dummy_sensor/pause", http.StatusOK, `"paused":true`},
+		{http.MethodGet, "/v1/status", http.StatusOK, `"paused":true`},
+		{http.MethodPost, "/v1/sensors/unknown/pause", http.StatusNotFound, `"error":`},
+		{http.MethodPost, "/v1/sensors/dummy_sensor/kill", http.StatusBadRequest, `unknown action kill`},
+		{http.MethodPost, "/v1/pipelines/sample_pipe/restart", http.StatusConflict, `already stopped`},
+		{http.MethodGet, "/v1/sensors/dummy_sensor/pause", http.StatusMethodNotAllowed, ``},
+	}
+	for _, test := range tests {
+		code, body := request(test.method, test.path)
+		if code != test.code || !strings.Contains(body, test.body) {
+			t.Errorf("%s %s = %d %s, want %d %s", test.method, test.path, code, body, test.code, test.body)
+		}
+	}
+	_, body := request(http.MethodGet, "/v1/status")
+	var status service.Status
+	if err = json.Unmarshal([]byte(body), &status); err != nil || len(status.Sensors) != 1 {
+		t.Errorf("GET /v1/status = %s, want status of dummy_sensor", body)
+	}
+
+	// socket is removed on stop
+	if err = server.Stop(); err != nil {
+		t.Errorf("Stop() = %v, want nil", err)
+	}
+	if _, err = os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("socket %s exists after Stop()", socketPath)
+	}
+}
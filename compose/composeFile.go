@@ -3,6 +3,7 @@ package compose
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	perror "polvo/error"
@@ -31,11 +32,21 @@ type ComposeFile interface {
 	GetExporterCompose(name string) *ExporterInfo
 	GetServiceCompose() *Service
 	GetCompose() *Compose
+	// Sources returns the files read to construct compose.
+	// They are the compose file, extended files & files of ${file:path}.
+	Sources() []string
 	String() string
 }
 
 type composeFile struct {
 	compose *Compose
+	// path & nodes of compose file to report positions of problems
+	path   string
+	root   *yaml.Node
+	issues ValidationErrors
+	// files of nodes declared in extended files
+	origins map[*yaml.Node]string
+	sources []string
 }
 
 // Getter for Sensor
@@ -74,6 +85,11 @@ func (c *composeFile) GetCompose() *Compose {
 	return c.compose
 }
 
+// Getter for source files of Compose
+func (c *composeFile) Sources() []string {
+	return c.sources
+}
+
 // Stringer for ComposeFile
 func (c *composeFile) String() string {
 	sensorStr := "compose: \n-------------Sensor --------------\n"
@@ -113,6 +129,9 @@ func NewComposeFile(composeFilePath string) (ComposeFile, error) {
 	)
 
 	newComp := new(composeFile)
+	newComp.path = composeFilePath
+	newComp.origins = make(map[*yaml.Node]string)
+	newComp.sources = []string{composeFilePath}
 
 	// read config file
 	file, err := os.ReadFile(composeFilePath)
@@ -131,48 +150,50 @@ func NewComposeFile(composeFilePath string) (ComposeFile, error) {
 		}
 	}
 
-	// parse config file
-	err = yaml.Unmarshal(file, &wrapper)
-	if err != nil {
-		return nil, perror.PolvoComposeError{
-			Code:   perror.ErrInvalidCompose,
-			Origin: err,
-			Msg:    "error while Construct new composeFile",
-		}
+	// parse config file with extended files & interpolate variables.
+	// nodes are kept to report positions of problems.
+	newComp.root = newComp.loadNode(composeFilePath, file, make(map[string]bool))
+	if newComp.root == nil || len(newComp.issues) > 0 {
+		return nil, newComp.validationError()
+	}
+	// type errors are reported & the rest of wrapper is verified
+	if err = newComp.root.Decode(&wrapper); err != nil {
+		newComp.reportYAMLError(composeFilePath, err)
 	}
 
 	newComp.compose = new(Compose)
+	// get filters from wrapper. sensors & pipelines refer to them.
+	newComp.compose.Filters = newComp.getFilters(wrapper.Filters)
 	// get sensor from wrapper
 	newComp.compose.Sensors, err = newComp.getSensor(wrapper.Sensors)
 	if err != nil {
-		return nil, perror.PolvoComposeError{
-			Code:   perror.ErrInvalidCompose,
-			Origin: err,
-			Msg:    "error while Construct new composeFile",
-		}
+		return nil, err
 	}
 	// get exporter from wrapper
-	newComp.compose.Exporters, err = newComp.getExporters(wrapper.Exporters)
-	if err != nil {
-		return nil, perror.PolvoComposeError{
-			Code:   perror.ErrInvalidCompose,
-			Origin: err,
-			Msg:    "error while Construct new composeFile",
-		}
-	}
+	newComp.compose.Exporters = newComp.getExporters(wrapper.Exporters)
+	// get metrics listener from wrapper
+	newComp.compose.Metrics = newComp.getMetrics(wrapper.Metrics)
 	// get service from wrapper
-	newComp.compose.Service, err = newComp.getService(wrapper.Service)
+	newComp.compose.Service, err = newComp.getService(wrapper)
 	if err != nil {
-		return nil, perror.PolvoComposeError{
-			Code:   perror.ErrInvalidCompose,
-			Origin: err,
-			Msg:    "error while Construct new composeFile",
-		}
+		return nil, err
+	}
+	if len(newComp.issues) > 0 {
+		return nil, newComp.validationError()
 	}
-
 	return newComp, nil
 }
 
+// validationError returns all problems of compose file.
+func (c *composeFile) validationError() error {
+	c.issues.sort()
+	return perror.PolvoComposeError{
+		Code:   perror.ErrInvalidCompose,
+		Origin: c.issues,
+		Msg:    "error while Construct new composeFile",
+	}
+}
+
 // getSensor constructs Sensor struct from SensorWrapper & verifies the sensor compose file.
 func (c *composeFile) getSensor(wrapperMap map[string]SensorWrapper) (map[string]*SensorInfo, error) {
 	var (
@@ -184,58 +205,195 @@ func (c *composeFile) getSensor(wrapperMap map[string]SensorWrapper) (map[string
 	sensorMap = make(map[string]*SensorInfo)
 
 	for sensorName, sensorObj := range wrapperMap {
-		// null check
-		if sensorObj.ExecPath == "" {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.SensorNotFoundError,
-				Msg:    "error in getSensor.",
-				Origin: fmt.Errorf("exec_path is empty"),
-			}
+		issues := len(c.issues)
+		// check type is valid
+		sensorType := sensorObj.Type
+		if sensorType == "" {
+			sensorType = SensorTypeExec
+		}
+		if !AvailableSensorType[sensorType] {
+			c.report(perror.InvalidSensorError, fmt.Errorf("type [%s] is not valid", sensorType), "sensors", sensorName, "type")
 		}
-		// check execPath is exist.
-		if execFileInfo, err = os.Stat(sensorObj.ExecPath); err != nil {
-			if os.IsNotExist(err) {
-				return nil, perror.PolvoComposeError{
-					Code:   perror.SensorNotFoundError,
+		// tail sensor has no executable. files are checked instead.
+		var tail *TailInfo
+		if sensorType == SensorTypeTail {
+			if tail, err = c.getTail(sensorName, sensorObj); err != nil {
+				return nil, err
+			}
+		} else if sensorObj.ExecPath == "" {
+			// null check & check execPath is exist.
+			c.report(perror.SensorNotFoundError, fmt.Errorf("exec_path is empty"), "sensors", sensorName, "exec_path")
+		} else if execFileInfo, err = os.Stat(sensorObj.ExecPath); err != nil {
+			if !os.IsNotExist(err) && !os.IsPermission(err) {
+				return nil, perror.PolvoGeneralError{
+					Code:   perror.SystemError,
 					Msg:    "error in getSensor.",
 					Origin: err,
 				}
 			}
-			return nil, perror.PolvoGeneralError{
-				Code:   perror.SystemError,
-				Msg:    "error in getSensor.",
-				Origin: err,
-			}
+			c.report(perror.SensorNotFoundError, err, "sensors", sensorName, "exec_path")
+		} else if execFileInfo.Mode()&0111 == 0 {
+			// check exePath is executable
+			c.report(perror.InvalidSensorError, fmt.Errorf("exec_path is not executable"), "sensors", sensorName, "exec_path")
 		}
-		// check exePath is executable
-		if execFileInfo.Mode()&0111 == 0 {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidSensorError,
-				Msg:    "error in getSensor.",
-				Origin: fmt.Errorf("exec_path is not executable"),
-			}
+		// check events header exists. lines of files are not events, so it is optional for tail sensor.
+		if len(sensorObj.EventsHeader) <= 0 && sensorType != SensorTypeTail {
+			c.report(perror.InvalidSensorError, fmt.Errorf("events_header is empty"), "sensors", sensorName, "events_header")
+		}
+		// check format of stdout is valid
+		format := sensorObj.Format
+		if format == "" && sensorType == SensorTypeTail {
+			format = FormatRaw
+		} else if format == "" {
+			format = FormatJSON
+		}
+		if !AvailableSensorFormat[format] {
+			c.report(perror.InvalidSensorError, fmt.Errorf("format [%s] is not valid", format), "sensors", sensorName, "format")
+		}
+		// check framing is valid
+		framing := FramingInfo{Mode: sensorObj.Framing.Mode, MaxRecordSize: sensorObj.Framing.MaxRecordSize}
+		if framing.Mode == "" {
+			framing.Mode = FramingLine
+		}
+		if !AvailableFramingMode[framing.Mode] {
+			c.report(perror.InvalidSensorError, fmt.Errorf("framing mode [%s] is not valid", framing.Mode), "sensors", sensorName, "framing", "mode")
+		} else if format == FormatMsgpack && framing.Mode != FramingLengthPrefixed {
+			c.report(perror.InvalidSensorError, fmt.Errorf("format [%s] requires framing mode [%s]", format, FramingLengthPrefixed), "sensors", sensorName, "format")
+		}
+		if sensorType == SensorTypeTail && framing.Mode != FramingLine {
+			c.report(perror.InvalidSensorError, fmt.Errorf("tail sensor requires framing mode [%s]", FramingLine), "sensors", sensorName, "framing", "mode")
+		}
+		if framing.MaxRecordSize < 0 {
+			c.report(perror.InvalidSensorError, fmt.Errorf("max_record_size must not be negative"), "sensors", sensorName, "framing", "max_record_size")
 		}
-		// check events header exists
-		if len(sensorObj.EventsHeader) <= 0 {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidSensorError,
-				Msg:    "error in getSensor.",
-				Origin: fmt.Errorf("events_header is empty"),
+		// check schema policies are valid
+		schema := c.getSchema(sensorName, sensorObj.Schema)
+		// check restart policy is valid
+		restart := sensorObj.Restart
+		if restart.Policy != "" && !AvailableRestartPolicy[restart.Policy] {
+			c.report(perror.InvalidSensorError, fmt.Errorf("restart policy [%s] is not valid", restart.Policy), "sensors", sensorName, "restart", "policy")
+		}
+		if restart.MaxRestarts < 0 || restart.Window < 0 || restart.Backoff < 0 || restart.MaxBackoff < 0 {
+			c.report(perror.InvalidSensorError, fmt.Errorf("max_restarts, window, backoff & max_backoff must not be negative"), "sensors", sensorName, "restart")
+		}
+		// check stderr rate limit is valid
+		if sensorObj.StderrRateLimit < 0 {
+			c.report(perror.InvalidSensorError, fmt.Errorf("stderr_rate_limit must not be negative"), "sensors", sensorName, "stderr_rate_limit")
+		}
+		// check filters are defined
+		filters := c.lookupFilters(perror.InvalidSensorError, sensorObj.Filters, "sensors", sensorName, "filters")
+		// resolve credential. tail sensor runs in the agent.
+		var credential *SensorCredential
+		if sensorType != SensorTypeTail {
+			if credential, err = c.getCredential(sensorObj); err != nil {
+				c.report(perror.PrivilegeError, err, "sensors", sensorName)
 			}
 		}
+		if len(c.issues) > issues {
+			continue
+		}
 		// add sensor
 		sensorMap[sensorName] = &SensorInfo{
 			Name:         sensorName,
+			Type:         sensorType,
 			ExecPath:     sensorObj.ExecPath,
 			Param:        sensorObj.Param,
 			RunAsRoot:    sensorObj.RunAsRoot,
+			Format:       format,
+			Framing:      framing,
 			EventsHeader: sensorObj.EventsHeader,
+			Schema:       schema,
+			Restart: RestartInfo{
+				Policy:      restart.Policy,
+				MaxRestarts: restart.MaxRestarts,
+				Window:      restart.Window,
+				Backoff:     restart.Backoff,
+				MaxBackoff:  restart.MaxBackoff,
+			},
+			Credential:      credential,
+			StderrRateLimit: sensorObj.StderrRateLimit,
+			Filters:         filters,
+			Tail:            tail,
 		}
 	}
 	return sensorMap, nil
 }
 
+// getTail constructs TailInfo of a tail sensor & verifies its paths and state file.
+func (c *composeFile) getTail(sensorName string, sensorObj SensorWrapper) (*TailInfo, error) {
+	if len(sensorObj.Paths) <= 0 {
+		c.report(perror.InvalidSensorError, fmt.Errorf("paths is empty"), "sensors", sensorName, "paths")
+	}
+	for _, path := range sensorObj.Paths {
+		if path == "" {
+			c.report(perror.InvalidSensorError, fmt.Errorf("path is empty"), "sensors", sensorName, "paths")
+		} else if _, err := filepath.Match(path, ""); err != nil {
+			c.report(perror.InvalidSensorError, fmt.Errorf("path [%s] is not a valid glob pattern", path), "sensors", sensorName, "paths")
+		}
+	}
+	if sensorObj.StatePath != "" {
+		ok, err := isValidPath(sensorObj.StatePath)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			c.report(perror.InvalidSensorError, fmt.Errorf("directory of state_path [%s] does not exist", sensorObj.StatePath), "sensors", sensorName, "state_path")
+		}
+	}
+	if sensorObj.PollInterval < 0 {
+		c.report(perror.InvalidSensorError, fmt.Errorf("poll_interval must not be negative"), "sensors", sensorName, "poll_interval")
+	}
+	startAt := sensorObj.StartAt
+	if startAt == "" {
+		startAt = TailStartAtBeginning
+	}
+	if !AvailableTailStartAt[startAt] {
+		c.report(perror.InvalidSensorError, fmt.Errorf("start_at [%s] is not valid", startAt), "sensors", sensorName, "start_at")
+	}
+	return &TailInfo{
+		Paths:        sensorObj.Paths,
+		StatePath:    sensorObj.StatePath,
+		PollInterval: sensorObj.PollInterval,
+		StartAt:      startAt,
+	}, nil
+}
+
+// getSchema constructs SchemaInfo from SchemaWrapper. Empty policies are replaced by "pass".
+func (c *composeFile) getSchema(sensorName string, schemaObj *SchemaWrapper) *SchemaInfo {
+	if schemaObj == nil {
+		return nil
+	}
+	schema := &SchemaInfo{Projection: schemaObj.Projection}
+	for _, policy := range []struct {
+		key   string
+		value string
+		dest  *string
+	}{
+		{"missing", schemaObj.Missing, &schema.Missing},
+		{"extra", schemaObj.Extra, &schema.Extra},
+		{"undeclared", schemaObj.Undeclared, &schema.Undeclared},
+	} {
+		if policy.value == "" {
+			policy.value = SchemaPass
+		}
+		if !AvailableSchemaPolicy[policy.value] {
+			c.report(perror.InvalidSensorError, fmt.Errorf("schema policy [%s] is not valid", policy.value), "sensors", sensorName, "schema", policy.key)
+		}
+		*policy.dest = policy.value
+	}
+	return schema
+}
+
 func isValidIPPort(addr string) bool {
+	// strip network scheme (tcp://, udp://)
+	if idx := strings.Index(addr, "://"); idx >= 0 {
+		switch addr[:idx] {
+		case "tcp", "udp":
+			addr = addr[idx+3:]
+		default:
+			return false
+		}
+	}
 	// replace localhost to 127.0.0.1
 	addr = strings.Replace(addr, "localhost", "127.0.0.1", -1)
 	// IP check
@@ -258,6 +416,32 @@ func isValidIPPort(addr string) bool {
 	return true
 }
 
+// isValidHTTPURL checks destination is an absolute http(s) url.
+func isValidHTTPURL(destination string) bool {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isValidBrokerList checks destination is a comma separated list of host:port.
+func isValidBrokerList(destination string) bool {
+	if destination == "" {
+		return false
+	}
+	for _, broker := range strings.Split(destination, ",") {
+		host, port, err := net.SplitHostPort(broker)
+		if err != nil || host == "" {
+			return false
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return false
+		}
+	}
+	return true
+}
+
 func isValidPath(path string) (bool, error) {
 	dirPath := filepath.Dir(path)
 
@@ -275,149 +459,260 @@ func isValidPath(path string) (bool, error) {
 	return true, nil
 }
 
+func isValidFilePath(path string) bool {
+	result, err := isValidPath(path)
+	return err == nil && result
+}
+
 // getExporter constructs Exporter struct from ExporterWrapper & verifies the exporter compose file.
-func (c *composeFile) getExporters(wrapperMap map[string]ExporterWrapper) (map[string]*ExporterInfo, error) {
+func (c *composeFile) getExporters(wrapperMap map[string]ExporterWrapper) map[string]*ExporterInfo {
 	exporterMap := make(map[string]*ExporterInfo)
 
 	for exporterName, exporterObj := range wrapperMap {
-		// null check
-		if exporterObj.Mode == "" {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidExporterError,
-				Msg:    "error in getExporter.",
-				Origin: fmt.Errorf("mode is empty"),
-			}
-		}
-		if exporterObj.Destination == "" {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidExporterError,
-				Msg:    "error in getExporter.",
-				Origin: fmt.Errorf("destination is empty"),
-			}
-		}
-		// check mode is valid
-		if !AvailableExporterMode.IsValid(exporterObj.Mode) {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidExporterError,
-				Msg:    "error in getExporter.",
-				Origin: fmt.Errorf("mode [%s] is not valid", exporterObj.Mode),
-			}
-		}
-		// check destination is valid
-		switch exporterObj.Mode {
-		case "file":
-			// check destination is valid path
-			result, err := isValidPath(exporterObj.Destination)
-			if err != nil || !result {
-				return nil, perror.PolvoComposeError{
-					Code:   perror.InvalidExporterError,
-					Msg:    "error in getExporter.",
-					Origin: fmt.Errorf("destination [%s] is not valid path", exporterObj.Destination),
-				}
-			}
-		case "network":
-			// check destination is ip url format
-			// check destination is ip url format
-			if !isValidIPPort(exporterObj.Destination) {
-				return nil, perror.PolvoComposeError{
-					Code:   perror.InvalidExporterError,
-					Msg:    "error in getExporter.",
-					Origin: fmt.Errorf("destination is not valid url"),
-				}
-			}
+		issues := len(c.issues)
+		// null check & check mode is valid
+		switch {
+		case exporterObj.Mode == "":
+			c.report(perror.InvalidExporterError, fmt.Errorf("mode is empty"), "exporters", exporterName, "mode")
+		case !AvailableExporterMode.IsValid(exporterObj.Mode):
+			c.report(perror.InvalidExporterError, fmt.Errorf("mode [%s] is not valid", exporterObj.Mode), "exporters", exporterName, "mode")
+		case exporterObj.Destination == "":
+			c.report(perror.InvalidExporterError, fmt.Errorf("destination is empty"), "exporters", exporterName, "destination")
+		case !AvailableExporterMode.IsValidDestination(exporterObj.Mode, exporterObj.Destination):
+			// check destination is valid for mode
+			c.report(perror.InvalidExporterError, fmt.Errorf("destination [%s] is not valid for mode [%s]", exporterObj.Destination, exporterObj.Mode), "exporters", exporterName, "destination")
 		}
 		// check timeout is valid
 		if exporterObj.Timeout <= 0 {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidExporterError,
-				Msg:    "error in getExporter.",
-				Origin: fmt.Errorf("timeout is invalid"),
-			}
+			c.report(perror.InvalidExporterError, fmt.Errorf("timeout is invalid"), "exporters", exporterName, "timeout")
+		}
+		// check queue is valid
+		queue := c.getQueue(exporterName, exporterObj.Queue)
+		if len(c.issues) > issues {
+			continue
 		}
 		// add exporter to map
 		exporterMap[exporterName] = &ExporterInfo{
 			Name:        exporterName,
+			Mode:        exporterObj.Mode,
 			Destination: exporterObj.Destination,
 			Timeout:     exporterObj.Timeout,
+			Options:     exporterObj.Options,
+			Queue:       queue,
+		}
+	}
+	return exporterMap
+}
+
+// getQueue constructs QueueInfo from QueueWrapper & verifies it. nil wrapper means the queue is not used.
+func (c *composeFile) getQueue(exporterName string, wrapper *QueueWrapper) *QueueInfo {
+	if wrapper == nil {
+		return nil
+	}
+	issues := len(c.issues)
+	// queue directory is created by the exporter. its parent must exist.
+	if wrapper.Path == "" || !isValidFilePath(filepath.Clean(wrapper.Path)) {
+		c.report(perror.InvalidExporterError, fmt.Errorf("queue path [%s] is not valid", wrapper.Path), "exporters", exporterName, "queue", "path")
+	}
+	if wrapper.MaxBytes < 0 || wrapper.SegmentBytes < 0 || wrapper.FsyncInterval < 0 {
+		c.report(perror.InvalidExporterError, fmt.Errorf("max_bytes, segment_bytes & fsync_interval must not be negative"), "exporters", exporterName, "queue")
+	}
+	if wrapper.MaxBytes > 0 && wrapper.SegmentBytes > wrapper.MaxBytes {
+		c.report(perror.InvalidExporterError, fmt.Errorf("segment_bytes [%d] is larger than max_bytes [%d]", wrapper.SegmentBytes, wrapper.MaxBytes), "exporters", exporterName, "queue", "segment_bytes")
+	}
+	if wrapper.Fsync != "" && !AvailableFsyncPolicy[wrapper.Fsync] {
+		c.report(perror.InvalidExporterError, fmt.Errorf("fsync policy [%s] is not valid", wrapper.Fsync), "exporters", exporterName, "queue", "fsync")
+	}
+	if len(c.issues) > issues {
+		return nil
+	}
+	return &QueueInfo{
+		Path:          wrapper.Path,
+		MaxBytes:      wrapper.MaxBytes,
+		SegmentBytes:  wrapper.SegmentBytes,
+		Fsync:         wrapper.Fsync,
+		FsyncInterval: wrapper.FsyncInterval,
+	}
+}
+
+// getMetrics constructs MetricsInfo from MetricsWrapper & verifies it. nil wrapper means metrics are not served.
+func (c *composeFile) getMetrics(wrapper *MetricsWrapper) *MetricsInfo {
+	if wrapper == nil {
+		return nil
+	}
+	issues := len(c.issues)
+	// host may be empty to listen on all interfaces
+	host, port, err := net.SplitHostPort(wrapper.Listen)
+	if err != nil {
+		c.report(perror.InvalidServiceError, fmt.Errorf("listen [%s] is not valid", wrapper.Listen), "metrics", "listen")
+	} else if portNum, err := strconv.Atoi(port); err != nil || portNum < 0 || portNum > 65535 ||
+		(host != "" && host != "localhost" && net.ParseIP(host) == nil) {
+		c.report(perror.InvalidServiceError, fmt.Errorf("listen [%s] is not valid", wrapper.Listen), "metrics", "listen")
+	}
+	path := wrapper.Path
+	if path == "" {
+		path = DefaultMetricsPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		c.report(perror.InvalidServiceError, fmt.Errorf("path [%s] must start with /", wrapper.Path), "metrics", "path")
+	}
+	if len(c.issues) > issues {
+		return nil
+	}
+	return &MetricsInfo{
+		Listen: wrapper.Listen,
+		Path:   path,
+	}
+}
+
+// getFilters constructs FilterInfo from FilterWrapper & reads filter files.
+// Rules of filters are verified when the service constructs filter operators.
+func (c *composeFile) getFilters(wrapperMap map[string]FilterWrapper) map[string]*FilterInfo {
+	filterMap := make(map[string]*FilterInfo)
+
+	for filterName, filterObj := range wrapperMap {
+		hasRules := filterObj.Rules.Kind != 0
+		// either path or rules
+		if (filterObj.Path == "") == !hasRules {
+			c.report(perror.InvalidFilterError, fmt.Errorf("filter %s must have either path or rules", filterName), "filters", filterName)
+			continue
+		}
+		info := &FilterInfo{Name: filterName, Path: filterObj.Path}
+		if hasRules {
+			if filterObj.Rules.Kind != yaml.MappingNode {
+				c.report(perror.InvalidFilterError, fmt.Errorf("rules of filter %s must be map", filterName), "filters", filterName, "rules")
+				continue
+			}
+			data, err := yaml.Marshal(&filterObj.Rules)
+			if err != nil {
+				c.report(perror.InvalidFilterError, err, "filters", filterName, "rules")
+				continue
+			}
+			info.Data = data
+		} else {
+			data, err := os.ReadFile(filterObj.Path)
+			if err != nil {
+				c.report(perror.InvalidFilterError, err, "filters", filterName, "path")
+				continue
+			}
+			info.Data = data
+		}
+		filterMap[filterName] = info
+	}
+	return filterMap
+}
+
+// lookupFilters returns the filters of the names.
+func (c *composeFile) lookupFilters(code perror.PolvoErrCompose, names []string, keys ...string) []*FilterInfo {
+	filters := make([]*FilterInfo, 0, len(names))
+	for _, name := range names {
+		filter, ok := c.compose.Filters[name]
+		if !ok {
+			// invalid filters are already reported
+			if _, declared := c.declared("filters", name); !declared {
+				c.report(code, fmt.Errorf("filter %s is not defined", name), append(keys, name)...)
+			}
+			continue
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// declared returns the node of keys in compose file & whether it exists.
+func (c *composeFile) declared(keys ...string) (*yaml.Node, bool) {
+	if c.root == nil {
+		return nil, false
+	}
+	node := c.root
+	for _, key := range keys {
+		keyNode, valueNode := lookupNode(node, key)
+		if keyNode == nil {
+			return nil, false
 		}
+		node = valueNode
 	}
-	return exporterMap, nil
+	return node, true
 }
 
 // getService constructs Service struct from ServiceWrapper & verifies the service compose file.
-func (c *composeFile) getService(wrapper ServiceWrapper) (*Service, error) {
+// Sensors & exporters which are not used by any pipeline are reported, too.
+func (c *composeFile) getService(composeWrapper ComposeWrapper) (*Service, error) {
 	var (
 		service Service
 	)
 
+	wrapper := composeWrapper.Service
 	// null check
 	if wrapper.Description == "" {
-		return nil, perror.PolvoComposeError{
-			Code:   perror.InvalidServiceError,
-			Msg:    "error in getService.",
-			Origin: fmt.Errorf("description is empty"),
-		}
+		c.report(perror.InvalidServiceError, fmt.Errorf("description is empty"), "service", "description")
 	}
 	if wrapper.Group == "" {
-		return nil, perror.PolvoComposeError{
-			Code:   perror.InvalidServiceError,
-			Msg:    "error in getService.",
-			Origin: fmt.Errorf("group is empty"),
-		}
+		c.report(perror.InvalidServiceError, fmt.Errorf("group is empty"), "service", "group")
 	}
 	if len(wrapper.Pipelines) <= 0 {
-		return nil, perror.PolvoComposeError{
-			Code:   perror.InvalidServiceError,
-			Msg:    "error in getService.",
-			Origin: fmt.Errorf("sensors is empty"),
-		}
+		c.report(perror.InvalidServiceError, fmt.Errorf("pipelines is empty"), "service", "pipelines")
 	}
 	// check pipeline is valid
 	pipelines := make(map[string]PipelineInfo)
+	usedSensors := make(map[string]bool)
+	usedExporters := make(map[string]bool)
 	for pipeName, pipeline := range wrapper.Pipelines {
+		issues := len(c.issues)
 		sensors := make([]*SensorInfo, 0)
 		// null check
 		if len(pipeline.Sensors) <= 0 {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidServiceError,
-				Msg:    "error in getService.",
-				Origin: fmt.Errorf("%s's sensors is empty", pipeName),
-			}
+			c.report(perror.InvalidServiceError, fmt.Errorf("%s's sensors is empty", pipeName), "service", "pipelines", pipeName, "sensors")
 		}
 		if len(pipeline.Exporter) <= 0 {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidServiceError,
-				Msg:    "error in getService.",
-				Origin: fmt.Errorf("%s's exporters is empty", pipeName),
-			}
+			c.report(perror.InvalidServiceError, fmt.Errorf("%s's exporters is empty", pipeName), "service", "pipelines", pipeName, "exporter")
 		}
 		// check pipeline sensors & exporters are valid
+		// sensors & exporters which are declared but invalid are already reported
+		valid := true
 		for _, sensorName := range pipeline.Sensors {
+			usedSensors[sensorName] = true
 			sensor, ok := c.compose.Sensors[sensorName]
 			if !ok {
-				return nil, perror.PolvoComposeError{
-					Code:   perror.InvalidServiceError,
-					Msg:    "error in getService.",
-					Origin: fmt.Errorf("%s's sensor %s is not defined", pipeName, sensorName),
+				valid = false
+				if _, declared := composeWrapper.Sensors[sensorName]; !declared {
+					c.report(perror.InvalidServiceError, fmt.Errorf("%s's sensor %s is not defined", pipeName, sensorName), "service", "pipelines", pipeName, "sensors", sensorName)
 				}
+				continue
 			}
 			sensors = append(sensors, sensor)
 		}
+		usedExporters[pipeline.Exporter] = true
 		exporter, ok := c.compose.Exporters[pipeline.Exporter]
-		if !ok {
-			return nil, perror.PolvoComposeError{
-				Code:   perror.InvalidServiceError,
-				Msg:    "error in getService.",
-				Origin: fmt.Errorf("%s's exporter %s is not defined", pipeName, pipeline.Exporter),
+		if !ok && len(pipeline.Exporter) > 0 {
+			valid = false
+			if _, declared := composeWrapper.Exporters[pipeline.Exporter]; !declared {
+				c.report(perror.InvalidServiceError, fmt.Errorf("%s's exporter %s is not defined", pipeName, pipeline.Exporter), "service", "pipelines", pipeName, "exporter")
 			}
 		}
+		filters := c.lookupFilters(perror.InvalidServiceError, pipeline.Filters, "service", "pipelines", pipeName, "filters")
+		if !valid || len(c.issues) > issues {
+			continue
+		}
 		// TODO: read valid exporter & sensor from config file
 		// add pipeline to map
 		pipelines[pipeName] = PipelineInfo{
 			Sensors:  sensors,
+			Filters:  filters,
 			Exporter: exporter,
 		}
 	}
+	// logs of unused sensors are dropped & unused exporters never receive logs
+	for sensorName := range composeWrapper.Sensors {
+		if !usedSensors[sensorName] {
+			c.report(perror.InvalidSensorError, fmt.Errorf("sensor %s is not used by any pipeline", sensorName), "sensors", sensorName)
+		}
+	}
+	for exporterName := range composeWrapper.Exporters {
+		if !usedExporters[exporterName] {
+			c.report(perror.InvalidExporterError, fmt.Errorf("exporter %s is not used by any pipeline", exporterName), "exporters", exporterName)
+		}
+	}
 	// get machine from os
 	serviceMachine, err := os.Hostname()
 	if err != nil {
@@ -1,6 +1,7 @@
 package compose_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"polvo/compose"
@@ -65,6 +66,21 @@ func TestComposeFileFailedInSensorWrongExecPath(t *testing.T) {
 	}
 }
 
+func TestComposeFileFailedInSensorWrongRestart(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_wrong_restart.yml"))
+	if err == nil {
+		t.Errorf("error should be created in composer %v", err)
+		return
+	}
+	if reflect.TypeOf(err) == reflect.TypeOf(perror.PolvoComposeError{}) {
+		t.Logf("errorType is %v\n %v", reflect.TypeOf(err), err)
+		return
+	} else {
+		t.Errorf("errorType is %v. but error should be %v", reflect.TypeOf(err), reflect.TypeOf(perror.PolvoComposeError{}))
+		return
+	}
+}
+
 func TestComposeFileFailedInSensorNotExecutable(t *testing.T) {
 	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_not_executable.yml"))
 	if err == nil {
@@ -139,3 +155,302 @@ func TestComposeFileFailedInServiceWrongExporterInPipeline(t *testing.T) {
 		return
 	}
 }
+
+func TestComposeFileExporterOptions(t *testing.T) {
+	composr, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_exporter_options.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	exporter := composr.GetExporterCompose("siem")
+	if exporter == nil {
+		t.Fatalf("siem not found")
+	}
+	if exporter.Mode != "file" {
+		t.Errorf("exporter.Mode = %s, want file", exporter.Mode)
+	}
+	var options struct {
+		BufferSize uint `yaml:"buffer_size"`
+	}
+	if err = exporter.DecodeOptions(&options); err != nil {
+		t.Fatalf("exporter.DecodeOptions() = %v, want nil", err)
+	}
+	if options.BufferSize != 16 {
+		t.Errorf("options.BufferSize = %d, want 16", options.BufferSize)
+	}
+}
+
+func TestComposeFileExporterQueue(t *testing.T) {
+	composr, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_exporter_options.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	queue := composr.GetExporterCompose("siem").Queue
+	if queue == nil {
+		t.Fatalf("queue of siem not found")
+	}
+	if queue.Path != "./testdata/siem_queue" || queue.MaxBytes != 1048576 || queue.Fsync != "always" {
+		t.Errorf("queue = %+v, want path, max_bytes & fsync", *queue)
+	}
+
+	_, err = compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_exporter_queue_invalid.yml"))
+	if err == nil {
+		t.Fatalf("NewComposeFile(compose_exporter_queue_invalid.yml) = nil, want error")
+	}
+	if _, ok := err.(perror.PolvoComposeError); !ok {
+		t.Errorf("NewComposeFile(compose_exporter_queue_invalid.yml) = %T, want PolvoComposeError", err)
+	}
+}
+
+func TestComposeFileSensorUnprivileged(t *testing.T) {
+	// privileges of agent are not checked while compose file is parsed
+	composr, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_unprivileged.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	credential := composr.GetSensorCompose("sensor1").Credential
+	if credential == nil {
+		t.Fatalf("credential of sensor1 not found")
+	}
+	want := compose.SensorCredential{
+		User:         "nobody",
+		Uid:          65534,
+		Gid:          65534,
+		Groups:       []uint32{1},
+		Capabilities: []uintptr{39, 38},
+		SwitchUser:   true,
+	}
+	if !reflect.DeepEqual(*credential, want) {
+		t.Errorf("credential = %+v, want %+v", *credential, want)
+	}
+}
+
+func TestComposeFileFailedInSensorWrongCapability(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_wrong_capability.yml"))
+	if err == nil {
+		t.Fatalf("error should be created in composer %v", err)
+	}
+	composeErr, ok := err.(perror.PolvoComposeError)
+	if !ok {
+		t.Fatalf("errorType is %v. but error should be %v", reflect.TypeOf(err), reflect.TypeOf(perror.PolvoComposeError{}))
+	}
+	// problems of compose are wrapped by NewComposeFile
+	validationErrs, ok := composeErr.Origin.(compose.ValidationErrors)
+	if !ok || len(validationErrs) != 1 || validationErrs[0].Code != perror.PrivilegeError {
+		t.Errorf("error = %v, want PrivilegeError", composeErr.Origin)
+	}
+}
+
+func TestComposeFileFilters(t *testing.T) {
+	composr, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_filters.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	filters := composr.GetCompose().Filters
+	if len(filters) != 2 || len(filters["noise"].Data) == 0 || len(filters["filter_agent"].Data) == 0 {
+		t.Fatalf("filters = %v, want noise & filter_agent with data", filters)
+	}
+	if filters["noise"].Path != "./testdata/filter_noise.yml" || filters["filter_agent"].Path != "" {
+		t.Errorf("filter paths = %s, %s, want ./testdata/filter_noise.yml & empty", filters["noise"].Path, filters["filter_agent"].Path)
+	}
+	sensor := composr.GetSensorCompose("sensor1")
+	if len(sensor.Filters) != 1 || sensor.Filters[0] != filters["noise"] {
+		t.Errorf("sensor1.Filters = %v, want [noise]", sensor.Filters)
+	}
+	pipeline := composr.GetServiceCompose().Pipeline["trace_pipe"]
+	if len(pipeline.Filters) != 1 || pipeline.Filters[0] != filters["filter_agent"] {
+		t.Errorf("trace_pipe.Filters = %v, want [filter_agent]", pipeline.Filters)
+	}
+	if len(composr.GetServiceCompose().Pipeline["log_pipe"].Filters) != 0 {
+		t.Errorf("log_pipe.Filters = %v, want empty", composr.GetServiceCompose().Pipeline["log_pipe"].Filters)
+	}
+}
+
+func TestComposeFileFailedInServiceWrongFilterInPipeline(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_service_wrong_filter_in_pipeline.yml"))
+	if err == nil {
+		t.Fatalf("error should be created in composer %v", err)
+	}
+	if _, ok := err.(perror.PolvoComposeError); !ok {
+		t.Errorf("errorType is %v. but error should be %v", reflect.TypeOf(err), reflect.TypeOf(perror.PolvoComposeError{}))
+	}
+}
+
+func TestComposeFileFailedWithMultipleErrors(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_multiple_errors.yml"))
+	if err == nil {
+		t.Fatalf("error should be created in composer %v", err)
+	}
+	var validationErrs compose.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		t.Fatalf("errorType is %v. but error should wrap %v", reflect.TypeOf(err), reflect.TypeOf(compose.ValidationErrors{}))
+	}
+	// problems are sorted by position
+	want := []struct {
+		code perror.PolvoErrCompose
+		path string
+		line int
+	}{
+		// missing key is reported at its parent
+		{perror.InvalidSensorError, "sensors.sensor1.events_header", 2},
+		{perror.SensorNotFoundError, "sensors.sensor1.exec_path", 3},
+		{perror.InvalidSensorError, "sensors.sensor2", 6},
+		{perror.InvalidExporterError, "exporters.exporter1.timeout", 16},
+		{perror.InvalidExporterError, "exporters.exporter2", 17},
+		{perror.InvalidServiceError, "service.group", 22},
+		{perror.InvalidServiceError, "service.pipelines.trace_pipe.sensors.ghost", 26},
+	}
+	if len(validationErrs) != len(want) {
+		t.Fatalf("NewComposeFile() = %v, want %d problems", validationErrs, len(want))
+	}
+	for idx, w := range want {
+		got := validationErrs[idx]
+		if got.Code != w.code || got.Path != w.path || got.Line != w.line {
+			t.Errorf("problem[%d] = %v %s line %d, want %v %s line %d", idx, got.Code, got.Path, got.Line, w.code, w.path, w.line)
+		}
+	}
+}
+
+func TestComposeFileInterpolationAndExtends(t *testing.T) {
+	t.Setenv("POLVO_TEST_TIMEOUT", "7")
+	composr, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_extends.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	sensor := composr.GetSensorCompose("sensor1")
+	if sensor == nil || sensor.ExecPath != "./testdata/sensor_sample" || sensor.Param != `--events="bashReadLine"` {
+		t.Errorf("sensor1 = %+v, want exec_path of base & param of file", sensor)
+	}
+	exporter := composr.GetExporterCompose("exporter1")
+	if exporter == nil || exporter.Destination != "localhost:4318" || exporter.Timeout != 7 || exporter.Mode != "network" {
+		t.Errorf("exporter1 = %+v, want localhost:4318 & timeout 7", exporter)
+	}
+	service := composr.GetServiceCompose()
+	if service.Description != "${literal}" || service.Group != "Sample group" {
+		t.Errorf("service = %s, %s, want ${literal} & Sample group", service.Description, service.Group)
+	}
+	if _, ok := service.Pipeline["log_pipe"]; ok || len(service.Pipeline) != 1 {
+		t.Errorf("pipelines = %v, want trace_pipe only", service.Pipeline)
+	}
+}
+
+func TestComposeFileFailedInInterpolation(t *testing.T) {
+	for _, fileName := range []string{"compose_interpolation_unset.yml", "compose_extends_recursive.yml"} {
+		_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", fileName))
+		var validationErrs compose.ValidationErrors
+		if !errors.As(err, &validationErrs) {
+			t.Errorf("NewComposeFile(%s) = %v, want ValidationErrors", fileName, err)
+			continue
+		}
+		if len(validationErrs) != 1 || validationErrs[0].Line <= 0 {
+			t.Errorf("NewComposeFile(%s) = %v, want a problem with position", fileName, validationErrs)
+		}
+	}
+}
+
+func TestComposeFileMetrics(t *testing.T) {
+	composr, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_metrics.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	want := &compose.MetricsInfo{Listen: "127.0.0.1:9464", Path: compose.DefaultMetricsPath}
+	if metrics := composr.GetCompose().Metrics; !reflect.DeepEqual(metrics, want) {
+		t.Errorf("metrics = %+v, want %+v", metrics, want)
+	}
+
+	_, err = compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_metrics_invalid.yml"))
+	var validationErrs compose.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		t.Fatalf("NewComposeFile() = %v, want ValidationErrors", err)
+	}
+	if len(validationErrs) != 2 || validationErrs[0].Path != "metrics.listen" || validationErrs[1].Path != "metrics.path" {
+		t.Errorf("NewComposeFile() = %v, want problems of metrics.listen & metrics.path", validationErrs)
+	}
+}
+
+func TestComposeFileFailedInSensorWrongSchema(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_wrong_schema.yml"))
+	var validationErrs compose.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		t.Fatalf("NewComposeFile() = %v, want ValidationErrors", err)
+	}
+	if len(validationErrs) != 1 || validationErrs[0].Path != "sensors.sensor1.schema.extra" {
+		t.Errorf("NewComposeFile() = %v, want problem of sensors.sensor1.schema.extra", validationErrs)
+	}
+}
+
+func TestComposeFileFailedInSensorWrongFormat(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_wrong_format.yml"))
+	var validationErrs compose.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		t.Fatalf("NewComposeFile() = %v, want ValidationErrors", err)
+	}
+	if len(validationErrs) != 1 || validationErrs[0].Path != "sensors.sensor1.format" {
+		t.Errorf("NewComposeFile() = %v, want problem of sensors.sensor1.format", validationErrs)
+	}
+}
+
+func TestComposeFileFailedInSensorWrongFraming(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_wrong_framing.yml"))
+	var validationErrs compose.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		t.Fatalf("NewComposeFile() = %v, want ValidationErrors", err)
+	}
+	if len(validationErrs) != 2 || validationErrs[0].Path != "sensors.sensor1.format" || validationErrs[1].Path != "sensors.sensor1.framing.max_record_size" {
+		t.Errorf("NewComposeFile() = %v, want problems of format & framing.max_record_size", validationErrs)
+	}
+}
+
+func TestComposeFileFailedInSensorWrongTail(t *testing.T) {
+	_, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_sensor_wrong_tail.yml"))
+	var validationErrs compose.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		t.Fatalf("NewComposeFile() = %v, want ValidationErrors", err)
+	}
+	want := []string{
+		"sensors.sensor1.paths",
+		"sensors.sensor1.state_path",
+		"sensors.sensor1.start_at",
+		"sensors.sensor1.framing.mode",
+	}
+	if len(validationErrs) != len(want) {
+		t.Fatalf("NewComposeFile() = %v, want problems of %v", validationErrs, want)
+	}
+	for i, path := range want {
+		if validationErrs[i].Path != path {
+			t.Errorf("validationErrs[%d].Path = %s, want %s", i, validationErrs[i].Path, path)
+		}
+	}
+}
+
+func TestExporterModeDestination(t *testing.T) {
+	// modes are validated without the exporter package
+	for _, test := range []struct {
+		mode        string
+		destination string
+		want        bool
+	}{
+		{"network", "tcp://localhost:4317", true},
+		{"otlp", "http://localhost:4318/v1/logs", true},
+		{"otlp", "localhost:4318", false},
+		{"kafka", "10.0.0.1:9092,10.0.0.2:9092", true},
+		{"kafka", "10.0.0.1", false},
+		{"opensearch", "https://search:9200", true},
+		{"elasticsearch", "search:9200", false},
+		{"carrier-pigeon", "roof", false},
+	} {
+		if got := compose.AvailableExporterMode.IsValidDestination(test.mode, test.destination); got != test.want {
+			t.Errorf("IsValidDestination(%s, %s) = %v, want %v", test.mode, test.destination, got, test.want)
+		}
+	}
+}
+
+func TestSensorCheckPrivileges(t *testing.T) {
+	root := &compose.SensorInfo{Name: "root_sensor", Type: compose.SensorTypeExec, RunAsRoot: true}
+	if err := root.CheckPrivileges(); (err == nil) != (os.Geteuid() == 0) {
+		t.Errorf("CheckPrivileges() = %v with euid %d", err, os.Geteuid())
+	}
+	tail := &compose.SensorInfo{Name: "tail_sensor", Type: compose.SensorTypeTail, RunAsRoot: true}
+	if err := tail.CheckPrivileges(); err != nil {
+		t.Errorf("CheckPrivileges() of tail sensor = %v, want nil", err)
+	}
+}
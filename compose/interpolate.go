@@ -0,0 +1,233 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	perror "polvo/error"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// key of compose files which are extended by the compose file
+const extendsKey = "extends"
+
+var (
+	// $${ is an escaped ${
+	interpolationPattern = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+	variableNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// # interpolate
+//
+// interpolate replaces variables in value.
+//
+// - ${VAR} is the environment variable VAR. It is an error if VAR is not set.
+//
+// - ${VAR:-default} is default if VAR is not set or empty.
+//
+// - ${file:path} is the content of file without trailing newlines. Relative path is resolved from baseDir.
+//
+// - $${ is replaced by literal ${.
+//
+// Files of ${file:path} are read by readFile.
+func interpolate(value string, baseDir string, readFile func(path string) ([]byte, error)) (string, error) {
+	var err error
+
+	ret := interpolationPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		expr := match[2 : len(match)-1]
+		// file
+		if path, ok := strings.CutPrefix(expr, "file:"); ok {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+			data, readErr := readFile(path)
+			if readErr != nil {
+				err = readErr
+				return match
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+		// environment variable
+		name, defaultValue, hasDefault := strings.Cut(expr, ":-")
+		if !variableNamePattern.MatchString(name) {
+			err = fmt.Errorf("invalid variable %s", match)
+			return match
+		}
+		envValue, ok := os.LookupEnv(name)
+		if hasDefault && envValue == "" {
+			return defaultValue
+		}
+		if !ok {
+			err = fmt.Errorf("variable %s is not set", name)
+			return match
+		}
+		return envValue
+	})
+	return ret, err
+}
+
+/************************************************************************************************************
+* Load compose nodes
+************************************************************************************************************/
+
+// loadNode parses compose file of path with the files it extends, and returns the merged mapping node.
+// Variables are interpolated in each file before merge. nil is returned if the file can not be parsed.
+//
+// Files of extends are merged in order, and the compose file overrides them.
+// Mappings are merged by keys. Sequences & scalars are replaced. null removes the key of extended files.
+func (c *composeFile) loadNode(path string, data []byte, visiting map[string]bool) *yaml.Node {
+	var doc yaml.Node
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+	visiting[absPath] = true
+	defer delete(visiting, absPath)
+
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		c.reportYAMLError(path, err)
+		return nil
+	}
+	if len(doc.Content) <= 0 {
+		// empty file
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		c.reportAt(perror.ErrInvalidCompose, fmt.Errorf("compose must be map"), path, root)
+		return nil
+	}
+	c.setOrigin(root, path)
+	c.interpolateNode(root, path, filepath.Dir(path), nil)
+
+	// extends
+	extendsNode := removeKey(root, extendsKey)
+	if extendsNode == nil {
+		return root
+	}
+	var extends []*yaml.Node
+	switch extendsNode.Kind {
+	case yaml.ScalarNode:
+		extends = []*yaml.Node{extendsNode}
+	case yaml.SequenceNode:
+		extends = extendsNode.Content
+	}
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: root.Line, Column: root.Column}
+	for _, baseNode := range extends {
+		if baseNode.Kind != yaml.ScalarNode || baseNode.Value == "" {
+			c.reportAt(perror.ErrInvalidCompose, fmt.Errorf("extends must be path or list of paths"), path, extendsNode)
+			continue
+		}
+		basePath := baseNode.Value
+		if !filepath.IsAbs(basePath) {
+			basePath = filepath.Join(filepath.Dir(path), basePath)
+		}
+		if absBasePath, err := filepath.Abs(basePath); err == nil && visiting[absBasePath] {
+			c.reportAt(perror.ErrInvalidCompose, fmt.Errorf("%s is extended recursively", baseNode.Value), path, baseNode)
+			continue
+		}
+		baseData, err := c.readSource(basePath)
+		if err != nil {
+			c.reportAt(perror.ErrInvalidCompose, err, path, baseNode)
+			continue
+		}
+		if base := c.loadNode(basePath, baseData, visiting); base != nil {
+			merged = c.mergeNode(merged, base)
+		}
+	}
+	return c.mergeNode(merged, root)
+}
+
+// interpolateNode interpolates scalar values of node. Keys of mappings are not interpolated.
+func (c *composeFile) interpolateNode(node *yaml.Node, path string, baseDir string, keys []string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			c.interpolateNode(node.Content[idx+1], path, baseDir, append(keys, node.Content[idx].Value))
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			c.interpolateNode(item, path, baseDir, keys)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+		value, err := interpolate(node.Value, baseDir, c.readSource)
+		if err != nil {
+			c.reportAt(perror.ErrInvalidCompose, fmt.Errorf("%s: %w", strings.Join(keys, "."), err), path, node)
+			return
+		}
+		node.Value = value
+		// plain scalars are resolved again. e.g. timeout: ${TIMEOUT:-5} is int
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = ""
+		}
+	}
+}
+
+// mergeNode returns override merged onto base.
+func (c *composeFile) mergeNode(base *yaml.Node, override *yaml.Node) *yaml.Node {
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+	merged := *base
+	merged.Content = append([]*yaml.Node(nil), base.Content...)
+	c.origins[&merged] = c.origins[base]
+	for idx := 0; idx+1 < len(override.Content); idx += 2 {
+		key, value := override.Content[idx], override.Content[idx+1]
+		if value.ShortTag() == "!!null" {
+			removeKey(&merged, key.Value)
+			continue
+		}
+		if _, baseValue := lookupNode(&merged, key.Value); baseValue != nil {
+			setKey(&merged, key, c.mergeNode(baseValue, value))
+			continue
+		}
+		merged.Content = append(merged.Content, key, value)
+	}
+	return &merged
+}
+
+// removeKey removes key from mapping node & returns its value. nil is returned if key does not exist.
+func removeKey(node *yaml.Node, key string) *yaml.Node {
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value == key {
+			value := node.Content[idx+1]
+			node.Content = append(node.Content[:idx:idx], node.Content[idx+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+// setKey replaces the key & value of key in mapping node.
+func setKey(node *yaml.Node, key *yaml.Node, value *yaml.Node) {
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value == key.Value {
+			node.Content[idx], node.Content[idx+1] = key, value
+			return
+		}
+	}
+}
+
+// readSource reads file & records it as a source of compose.
+func (c *composeFile) readSource(path string) ([]byte, error) {
+	c.sources = append(c.sources, path)
+	return os.ReadFile(path)
+}
+
+// setOrigin records path as the file of node & its children.
+func (c *composeFile) setOrigin(node *yaml.Node, path string) {
+	c.origins[node] = path
+	for _, child := range node.Content {
+		c.setOrigin(child, path)
+	}
+}
@@ -1,57 +1,158 @@
 package compose
 
-type ExporterMode []string
+import (
+	"bytes"
+
+	"gopkg.in/yaml.v3"
+)
+
+// # ExporterMode
+//
+// ExporterMode maps each available exporter mode to the validator of its destination.
+// New modes are added here with their validator, so compose files are validated in the same way
+// whether the exporter package is linked or not. The exporter package registers the constructor of each mode.
+type ExporterMode map[string]func(destination string) bool
 
 var AvailableExporterMode ExporterMode = ExporterMode{
-	"file",
-	"network",
+	"file":    isValidFilePath,
+	"network": isValidIPPort,
+	"otlp":    isValidHTTPURL,
+	"kafka":   isValidBrokerList,
+	// _bulk api of elasticsearch is compatible with opensearch
+	"opensearch":    isValidHTTPURL,
+	"elasticsearch": isValidHTTPURL,
 }
 
 func (em ExporterMode) IsValid(mode string) bool {
-	for _, m := range em {
-		if m == mode {
-			return true
-		}
+	_, ok := em[mode]
+	return ok
+}
+
+// IsValidDestination checks destination with the validator of mode.
+func (em ExporterMode) IsValidDestination(mode string, destination string) bool {
+	validator, ok := em[mode]
+	if !ok {
+		return false
 	}
-	return false
+	return validator(destination)
 }
 
 type SensorWrapper struct {
+	// Type is "exec" or "tail". default is "exec".
+	Type         string              `yaml:"type"`
 	ExecPath     string              `yaml:"exec_path"`
 	Param        string              `yaml:"param"`
 	RunAsRoot    bool                `yaml:"run_as_root"`
+	Format       string              `yaml:"format"`
+	Framing      FramingWrapper      `yaml:"framing"`
 	EventsHeader map[string][]string `yaml:"events_header"`
+	Schema       *SchemaWrapper      `yaml:"schema"`
+	Restart      RestartWrapper      `yaml:"restart"`
+	User         string              `yaml:"user"`
+	Group        string              `yaml:"group"`
+	Groups       []string            `yaml:"groups"`
+	Capabilities []string            `yaml:"capabilities"`
+	// maximum number of stderr lines per second written to the agent log
+	StderrRateLimit int `yaml:"stderr_rate_limit"`
+	// names of filters applied to all logs of the sensor
+	Filters []string `yaml:"filters"`
+	// options of tail sensor
+	Paths        []string `yaml:"paths"`
+	StatePath    string   `yaml:"state_path"`
+	PollInterval int      `yaml:"poll_interval"`
+	StartAt      string   `yaml:"start_at"`
+}
+
+type FramingWrapper struct {
+	Mode          string `yaml:"mode"`
+	MaxRecordSize int    `yaml:"max_record_size"`
+}
+
+// SchemaWrapper is the policies applied to events which do not match events_header.
+type SchemaWrapper struct {
+	Missing    string `yaml:"missing"`
+	Extra      string `yaml:"extra"`
+	Undeclared string `yaml:"undeclared"`
+	Projection bool   `yaml:"projection"`
+}
+
+type RestartWrapper struct {
+	Policy      string `yaml:"policy"`
+	MaxRestarts int    `yaml:"max_restarts"`
+	Window      int    `yaml:"window"`
+	Backoff     int    `yaml:"backoff"`
+	MaxBackoff  int    `yaml:"max_backoff"`
 }
 
 type ExporterWrapper struct {
-	Mode        string `yaml:"mode"`
-	Destination string `yaml:"destination"`
-	Timeout     int    `yaml:"timeout"`
+	Mode        string        `yaml:"mode"`
+	Destination string        `yaml:"destination"`
+	Timeout     int           `yaml:"timeout"`
+	Options     yaml.Node     `yaml:"options"`
+	Queue       *QueueWrapper `yaml:"queue"`
+}
+
+type QueueWrapper struct {
+	Path          string `yaml:"path"`
+	MaxBytes      int64  `yaml:"max_bytes"`
+	SegmentBytes  int64  `yaml:"segment_bytes"`
+	Fsync         string `yaml:"fsync"`
+	FsyncInterval int    `yaml:"fsync_interval"`
 }
 
 type PipelineWrapper struct {
 	Sensors  []string `yaml:"sensors"`
+	Filters  []string `yaml:"filters"`
 	Exporter string   `yaml:"exporter"`
 }
 
+// FilterWrapper is a filter file given by path, or filter rules written inline.
+type FilterWrapper struct {
+	Path  string    `yaml:"path"`
+	Rules yaml.Node `yaml:"rules"`
+}
+
 type ServiceWrapper struct {
 	Description string                     `yaml:"description"`
 	Group       string                     `yaml:"group"`
 	Pipelines   map[string]PipelineWrapper `yaml:"pipelines"`
 }
 
+// MetricsWrapper is the HTTP listener of Prometheus metrics.
+type MetricsWrapper struct {
+	Listen string `yaml:"listen"`
+	Path   string `yaml:"path"`
+}
+
 type ComposeWrapper struct {
 	Sensors   map[string]SensorWrapper   `yaml:"sensors"`
 	Exporters map[string]ExporterWrapper `yaml:"exporters"`
+	Filters   map[string]FilterWrapper   `yaml:"filters"`
+	Metrics   *MetricsWrapper            `yaml:"metrics"`
 	Service   ServiceWrapper             `yaml:"service"`
 }
 
 type Compose struct {
 	Sensors   map[string]*SensorInfo
 	Exporters map[string]*ExporterInfo
-	Service   *Service
+	Filters   map[string]*FilterInfo
+	// Metrics is nil if metrics are not served.
+	Metrics *MetricsInfo
+	Service *Service
+}
+
+// # MetricsInfo
+//
+// MetricsInfo is the HTTP listener which serves metrics in Prometheus text format.
+type MetricsInfo struct {
+	// Listen is host:port. Empty host listens on all interfaces.
+	Listen string
+	// Path is the url path of metrics. default is DefaultMetricsPath.
+	Path string
 }
 
+const DefaultMetricsPath = "/metrics"
+
 type Service struct {
 	Machine     string
 	OS          string
@@ -62,16 +163,198 @@ type Service struct {
 }
 
 type PipelineInfo struct {
-	Sensors  []*SensorInfo
+	Sensors []*SensorInfo
+	// Filters drop logs only for the exporter of the pipeline.
+	Filters  []*FilterInfo
 	Exporter *ExporterInfo
 }
 
+// # FilterInfo
+//
+// FilterInfo is a filter declared in compose. Data is the filter yaml read from Path or written inline.
+// Path is empty if the filter is written inline.
+type FilterInfo struct {
+	Name string
+	Path string
+	Data []byte
+}
+
 type SensorInfo struct {
 	Name         string
+	Type         string // one of AvailableSensorType
 	ExecPath     string
 	Param        string
 	RunAsRoot    bool
 	EventsHeader map[string][]string
+	// Format is the format of records of sensor. One of AvailableSensorFormat. default is "json".
+	Format  string
+	Framing FramingInfo
+	// Schema is nil if events are not checked against EventsHeader.
+	Schema  *SchemaInfo
+	Restart RestartInfo
+	// Credential is nil if the sensor runs with the credential of the agent.
+	Credential *SensorCredential
+	// StderrRateLimit is the maximum number of stderr lines per second. 0 means the default.
+	StderrRateLimit int
+	// Filters drop logs of the sensor for all pipelines.
+	Filters []*FilterInfo
+	// Tail is the files followed by a sensor of SensorTypeTail. nil for other types.
+	Tail *TailInfo
+}
+
+// types of sensor
+const (
+	// SensorTypeExec is a subprocess which writes records to stdout.
+	SensorTypeExec = "exec"
+	// SensorTypeTail follows files in the agent. Each line is a record.
+	SensorTypeTail = "tail"
+)
+
+var AvailableSensorType = map[string]bool{
+	SensorTypeExec: true,
+	SensorTypeTail: true,
+}
+
+// # TailInfo
+//
+// TailInfo is the files followed by a tail sensor.
+type TailInfo struct {
+	// Paths are glob patterns of files.
+	Paths []string
+	// StatePath is the file where read offsets are persisted. Empty means offsets are not persisted.
+	StatePath string
+	// PollInterval is the interval in milliseconds to read files. 0 means the default.
+	PollInterval int
+	// StartAt is one of AvailableTailStartAt. default is "beginning".
+	StartAt string
+}
+
+// positions where tail sensor starts to read files without persisted offset
+const (
+	TailStartAtBeginning = "beginning"
+	TailStartAtEnd       = "end"
+)
+
+var AvailableTailStartAt = map[string]bool{
+	TailStartAtBeginning: true,
+	TailStartAtEnd:       true,
+}
+
+// # SensorCredential
+//
+// SensorCredential is the unprivileged credential of a sensor with run_as_root: false.
+// User & groups are resolved into ids while the compose file is parsed.
+type SensorCredential struct {
+	User string
+	Uid  uint32
+	Gid  uint32
+	// Groups are supplementary group ids.
+	Groups []uint32
+	// Capabilities are raised as ambient capabilities of the sensor. e.g. CAP_BPF
+	Capabilities []uintptr
+	// SwitchUser is false if the agent already runs as Uid & Gid.
+	SwitchUser bool
+}
+
+// formats of records of sensor
+const (
+	// FormatJSON is a CommonLogWrapper object per line.
+	FormatJSON = "json"
+	// FormatCSV & FormatTSV are the event name followed by the fields of events_header for the event name.
+	FormatCSV = "csv"
+	FormatTSV = "tsv"
+	// FormatLogfmt is key=value pairs with quoted values. e.g. eventname=bashReadLine CommandLine="ls -al"
+	FormatLogfmt = "logfmt"
+	// FormatKeyValue is key=value pairs separated by whitespace without quoting.
+	FormatKeyValue = "kv"
+	// FormatMsgpack is a CommonLogWrapper map in MessagePack. It requires FramingLengthPrefixed.
+	FormatMsgpack = "msgpack"
+	// FormatRaw is the whole line as log with the sensor name as eventname. default of tail sensor.
+	FormatRaw = "raw"
+)
+
+var AvailableSensorFormat = map[string]bool{
+	FormatJSON:     true,
+	FormatCSV:      true,
+	FormatTSV:      true,
+	FormatLogfmt:   true,
+	FormatKeyValue: true,
+	FormatMsgpack:  true,
+	FormatRaw:      true,
+}
+
+// # FramingInfo
+//
+// FramingInfo splits stdout of sensor into records. Zero value reads lines up to the default max record size.
+type FramingInfo struct {
+	// Mode is one of AvailableFramingMode. default is "line".
+	Mode string
+	// MaxRecordSize is the maximum size of a record in bytes. Larger records are skipped. 0 means the default.
+	MaxRecordSize int
+}
+
+// framing modes of stdout of sensor
+const (
+	FramingLine = "line"
+	// FramingLengthPrefixed is records prefixed by their length in bytes as unsigned varint.
+	FramingLengthPrefixed = "length-prefixed"
+)
+
+var AvailableFramingMode = map[string]bool{
+	FramingLine:           true,
+	FramingLengthPrefixed: true,
+}
+
+// # SchemaInfo
+//
+// SchemaInfo checks the metadata of events against the fields declared in events_header for their eventname.
+// Empty policies are "pass".
+type SchemaInfo struct {
+	// Missing is the policy of events without some declared fields.
+	Missing string
+	// Extra is the policy of events with fields which are not declared.
+	Extra string
+	// Undeclared is the policy of events whose eventname is not declared in events_header.
+	Undeclared string
+	// Projection removes the fields which are not declared from metadata of declared events.
+	Projection bool
+}
+
+// policies of schema violations
+const (
+	// SchemaPass sends the event as it is.
+	SchemaPass = "pass"
+	// SchemaTag sends the event with the violations in schema_violations.
+	SchemaTag = "tag"
+	// SchemaDrop drops the event.
+	SchemaDrop = "drop"
+)
+
+var AvailableSchemaPolicy = map[string]bool{
+	SchemaPass: true,
+	SchemaTag:  true,
+	SchemaDrop: true,
+}
+
+// # RestartInfo
+//
+// RestartInfo is the restart policy of a sensor. Zero value means the sensor is never restarted.
+type RestartInfo struct {
+	// Policy is one of AvailableRestartPolicy. empty means "never".
+	Policy string
+	// MaxRestarts is the maximum number of restarts within Window. 0 means unlimited.
+	MaxRestarts int
+	// Window is the period in seconds in which restarts are counted. 0 means the whole lifetime.
+	Window int
+	// Backoff & MaxBackoff are the initial & maximum delay in milliseconds before restart.
+	Backoff    int
+	MaxBackoff int
+}
+
+var AvailableRestartPolicy = map[string]bool{
+	"never":      true,
+	"on-failure": true,
+	"always":     true,
 }
 
 type ExporterInfo struct {
@@ -79,4 +362,46 @@ type ExporterInfo struct {
 	Mode        string
 	Destination string
 	Timeout     int
+	// Options holds mode-specific options. It is decoded by the exporter of Mode.
+	Options yaml.Node
+	// Queue is the disk-backed queue in front of the exporter. nil if the queue is not used.
+	Queue *QueueInfo
+}
+
+// # QueueInfo
+//
+// QueueInfo configures the disk-backed queue of an exporter.
+// Zero values of MaxBytes, SegmentBytes, Fsync & FsyncInterval are replaced by defaults of the queue.
+type QueueInfo struct {
+	// Path is the directory of segment files. It is created if it does not exist.
+	Path string
+	// MaxBytes is the maximum size of all segments. The oldest segment is evicted when it is exceeded.
+	MaxBytes int64
+	// SegmentBytes is the size at which a new segment file is started.
+	SegmentBytes int64
+	// Fsync is the fsync policy. One of AvailableFsyncPolicy.
+	Fsync string
+	// FsyncInterval is the period in milliseconds of the "interval" fsync policy.
+	FsyncInterval int
+}
+
+var AvailableFsyncPolicy = map[string]bool{
+	"always":   true,
+	"interval": true,
+	"never":    true,
+}
+
+// DecodeOptions decodes mode-specific options into out.
+// Unknown option keys are rejected. If options are not defined, out is not modified.
+func (e *ExporterInfo) DecodeOptions(out interface{}) error {
+	if e.Options.Kind == 0 {
+		return nil
+	}
+	raw, err := yaml.Marshal(&e.Options)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	return decoder.Decode(out)
 }
@@ -0,0 +1,209 @@
+package compose
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// default user of sensors with run_as_root: false
+const defaultSensorUser = "nobody"
+
+// linux capabilities which can be given to sensors as ambient capabilities
+var AvailableCapabilities = map[string]uintptr{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// getCredential resolves the credential of a sensor with run_as_root: false.
+// nil is returned if the sensor runs with the credential of the agent.
+// Privileges of the agent are not checked here, so compose files are validated by any user. See CheckPrivileges.
+func (c *composeFile) getCredential(wrapper SensorWrapper) (*SensorCredential, error) {
+	if wrapper.RunAsRoot {
+		if wrapper.User != "" || wrapper.Group != "" || len(wrapper.Groups) > 0 || len(wrapper.Capabilities) > 0 {
+			return nil, fmt.Errorf("user, group, groups & capabilities are used only if run_as_root is false")
+		}
+		return nil, nil
+	}
+
+	credential := new(SensorCredential)
+	// resolve user & group
+	userName := wrapper.User
+	if userName == "" {
+		userName = defaultSensorUser
+	}
+	usr, err := lookupUser(userName)
+	if err != nil {
+		return nil, err
+	}
+	credential.User = usr.Username
+	credential.Uid = mustAtoi(usr.Uid)
+	credential.Gid = mustAtoi(usr.Gid)
+	if wrapper.Group != "" {
+		if credential.Gid, err = lookupGroupID(wrapper.Group); err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range wrapper.Groups {
+		gid, err := lookupGroupID(group)
+		if err != nil {
+			return nil, err
+		}
+		credential.Groups = append(credential.Groups, gid)
+	}
+	// resolve capabilities
+	for _, name := range wrapper.Capabilities {
+		capability, ok := AvailableCapabilities[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("capability [%s] is not valid", name)
+		}
+		credential.Capabilities = append(credential.Capabilities, capability)
+	}
+	credential.SwitchUser = credential.Uid != uint32(os.Geteuid()) || credential.Gid != uint32(os.Getegid()) || len(credential.Groups) > 0
+	return credential, nil
+}
+
+// # CheckPrivileges
+//
+// CheckPrivileges verifies the agent has privileges to launch the sensor.
+// It is called when the sensor is created, because the privileges belong to the running agent, not to the compose file.
+func (s *SensorInfo) CheckPrivileges() error {
+	// tail sensor runs in the agent
+	if s.Type == SensorTypeTail {
+		return nil
+	}
+	if s.RunAsRoot {
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("agent must run as root to run sensor [%s] as root", s.Name)
+		}
+		return nil
+	}
+	if s.Credential == nil {
+		return nil
+	}
+	permitted, effective, err := agentCapabilities()
+	if err != nil {
+		return fmt.Errorf("error while read capabilities of agent. %v", err)
+	}
+	if s.Credential.SwitchUser {
+		for _, required := range []string{"CAP_SETUID", "CAP_SETGID"} {
+			if effective&(1<<AvailableCapabilities[required]) == 0 {
+				return fmt.Errorf("agent lacks %s to run sensor [%s] as user [%s]", required, s.Name, s.Credential.User)
+			}
+		}
+	}
+	for _, capability := range s.Credential.Capabilities {
+		if permitted&(1<<capability) == 0 {
+			return fmt.Errorf("agent lacks %s to give it to sensor [%s]", capabilityName(capability), s.Name)
+		}
+	}
+	return nil
+}
+
+// capabilityName returns the name of capability in AvailableCapabilities.
+func capabilityName(capability uintptr) string {
+	for name, value := range AvailableCapabilities {
+		if value == capability {
+			return name
+		}
+	}
+	return strconv.Itoa(int(capability))
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroupID(name string) (uint32, error) {
+	var (
+		group *user.Group
+		err   error
+	)
+	if _, err = strconv.Atoi(name); err == nil {
+		group, err = user.LookupGroupId(name)
+	} else {
+		group, err = user.LookupGroup(name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return mustAtoi(group.Gid), nil
+}
+
+// mustAtoi converts uid & gid of os/user, which are always decimal on linux.
+func mustAtoi(id string) uint32 {
+	ret, _ := strconv.ParseUint(id, 10, 32)
+	return uint32(ret)
+}
+
+// agentCapabilities reads the permitted & effective capability sets of the agent.
+func agentCapabilities() (permitted uint64, effective uint64, err error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "CapPrm":
+			permitted, err = strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		case "CapEff":
+			effective, err = strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return permitted, effective, scanner.Err()
+}
@@ -0,0 +1,179 @@
+package compose
+
+import (
+	"encoding/json"
+	"errors"
+	perror "polvo/error"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// # ValidationError
+//
+// ValidationError is a problem of compose file.
+// Path is the dotted keys of the problem. e.g. sensors.ebpf.exec_path
+// Line & Column are the position of the deepest key of Path in the file. 0 means the position is unknown.
+type ValidationError struct {
+	Code   perror.PolvoErrCompose
+	File   string
+	Path   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+
+	sb.WriteString(e.File)
+	if e.Line > 0 {
+		sb.WriteString(":" + strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column))
+	}
+	sb.WriteString(": ")
+	if e.Path != "" {
+		sb.WriteString(e.Path + ": ")
+	}
+	sb.WriteString(e.Err.Error())
+	return sb.String()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code    string `json:"code"`
+		File    string `json:"file"`
+		Path    string `json:"path,omitempty"`
+		Line    int    `json:"line,omitempty"`
+		Column  int    `json:"column,omitempty"`
+		Message string `json:"message"`
+	}{e.Code.String(), e.File, e.Path, e.Line, e.Column, e.Err.Error()})
+}
+
+// # ValidationErrors
+//
+// ValidationErrors is all problems of compose file sorted by position.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, validationErr := range e {
+		msgs = append(msgs, validationErr.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, validationErr := range e {
+		errs = append(errs, validationErr)
+	}
+	return errs
+}
+
+func (e ValidationErrors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Line != e[j].Line {
+			return e[i].Line < e[j].Line
+		}
+		if e[i].Column != e[j].Column {
+			return e[i].Column < e[j].Column
+		}
+		return e[i].Path < e[j].Path
+	})
+}
+
+// report adds a problem at the keys of compose file.
+// keys are mapping keys, or scalar values of sequence items. e.g. service, pipelines, pipe, sensors, ebpf
+func (c *composeFile) report(code perror.PolvoErrCompose, err error, keys ...string) {
+	validationErr := &ValidationError{
+		Code: code,
+		File: c.path,
+		Path: strings.Join(keys, "."),
+		Err:  err,
+	}
+	if node := deepestNode(c.root, keys...); node != nil {
+		validationErr.Line, validationErr.Column = node.Line, node.Column
+		// node may be declared in extended files
+		if origin, ok := c.origins[node]; ok && origin != "" {
+			validationErr.File = origin
+		}
+	}
+	c.issues = append(c.issues, validationErr)
+}
+
+// reportAt adds a problem at node of file.
+func (c *composeFile) reportAt(code perror.PolvoErrCompose, err error, file string, node *yaml.Node) {
+	c.issues = append(c.issues, &ValidationError{
+		Code:   code,
+		File:   file,
+		Line:   node.Line,
+		Column: node.Column,
+		Err:    err,
+	})
+}
+
+// deepestNode returns the node of the deepest key of keys found in root.
+func deepestNode(root *yaml.Node, keys ...string) *yaml.Node {
+	if root == nil {
+		return nil
+	}
+	node, deepest := root, root
+	for _, key := range keys {
+		keyNode, valueNode := lookupNode(node, key)
+		if keyNode == nil {
+			break
+		}
+		deepest, node = keyNode, valueNode
+	}
+	return deepest
+}
+
+// lookupNode returns the key & value nodes of key in mapping node, or the item of key in sequence node.
+func lookupNode(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if node.Content[idx].Value == key {
+				return node.Content[idx], node.Content[idx+1]
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode && item.Value == key {
+				return item, item
+			}
+		}
+	}
+	return nil, nil
+}
+
+// e.g. "line 12: cannot unmarshal !!str `abc` into int"
+var yamlLinePattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// reportYAMLError adds problems of type errors of yaml. yaml stops at syntax errors, so it is reported as a single problem.
+func (c *composeFile) reportYAMLError(file string, err error) {
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		c.issues = append(c.issues, &ValidationError{Code: perror.ErrInvalidCompose, File: file, Err: err})
+		return
+	}
+	for _, msg := range typeErr.Errors {
+		validationErr := &ValidationError{
+			Code: perror.ErrInvalidCompose,
+			File: file,
+			Err:  errors.New(msg),
+		}
+		if match := yamlLinePattern.FindStringSubmatch(msg); match != nil {
+			validationErr.Line, _ = strconv.Atoi(match[1])
+			validationErr.Err = errors.New(match[2])
+		}
+		c.issues = append(c.issues, validationErr)
+	}
+}
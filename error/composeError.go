@@ -12,8 +12,29 @@ const (
 	InvalidSensorError
 	InvalidExporterError
 	InvalidServiceError
+	PrivilegeError
+	InvalidFilterError
 )
 
+var composeErrorNames = [...]string{
+	ErrInvalidCompose:    "ErrInvalidCompose",
+	UnsupportedOsError:   "UnsupportedOsError",
+	UnsupportedArchError: "UnsupportedArchError",
+	SensorNotFoundError:  "SensorNotFoundError",
+	InvalidSensorError:   "InvalidSensorError",
+	InvalidExporterError: "InvalidExporterError",
+	InvalidServiceError:  "InvalidServiceError",
+	PrivilegeError:       "PrivilegeError",
+	InvalidFilterError:   "InvalidFilterError",
+}
+
+func (c PolvoErrCompose) String() string {
+	if c < 0 || int(c) >= len(composeErrorNames) {
+		return fmt.Sprintf("PolvoErrCompose(%d)", int(c))
+	}
+	return composeErrorNames[c]
+}
+
 type PolvoComposeError struct {
 	Code   PolvoErrCompose
 	Origin error
@@ -23,3 +44,7 @@ type PolvoComposeError struct {
 func (e PolvoComposeError) Error() string {
 	return fmt.Sprintf("Polvo Compose Error: %s\n\t: %s", e.Msg, e.Origin.Error())
 }
+
+func (e PolvoComposeError) Unwrap() error {
+	return e.Origin
+}
@@ -19,3 +19,7 @@ type PolvoFilterError struct {
 func (e PolvoFilterError) Error() string {
 	return fmt.Sprintf("Polvo Filter Error: %s\n\t: %s", e.Msg, e.Origin.Error())
 }
+
+func (e PolvoFilterError) Unwrap() error {
+	return e.Origin
+}
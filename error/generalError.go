@@ -22,3 +22,7 @@ type PolvoGeneralError struct {
 func (e PolvoGeneralError) Error() string {
 	return fmt.Sprintf("Polvo General Error: %s\n\t: %s", e.Msg, e.Origin.Error())
 }
+
+func (e PolvoGeneralError) Unwrap() error {
+	return e.Origin
+}
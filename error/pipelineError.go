@@ -18,6 +18,9 @@ const (
 	ErrFilterWorkerCreate
 	ErrExporterCreate
 	ErrProcessorCreate
+	ErrPipelineReload
+	ErrInvalidPipelineName
+	ErrPipelineControl
 )
 
 type PolvoPipelineError struct {
@@ -29,3 +32,7 @@ type PolvoPipelineError struct {
 func (e PolvoPipelineError) Error() string {
 	return fmt.Sprintf("Polvo Pipeline Error: %s\n\t: %s", e.Msg, e.Origin.Error())
 }
+
+func (e PolvoPipelineError) Unwrap() error {
+	return e.Origin
+}
@@ -0,0 +1,282 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"polvo/compose"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/metrics"
+	"polvo/service/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// default batch options
+	defaultBatchSize     = 512
+	defaultFlushInterval = 1000
+	// retry backoff of batch exporter
+	batchInitialBackoff = 100 * time.Millisecond
+	batchMaxBackoff     = 30 * time.Second
+)
+
+// batchOptions is the options shared by exporters which send logs in batches.
+// It is embedded inline in the mode-specific options.
+type batchOptions struct {
+	// BufferSize is the capacity of log channel. 0 means unbuffered.
+	BufferSize uint `yaml:"buffer_size"`
+	// BatchSize is the maximum number of logs in a batch.
+	BatchSize int `yaml:"batch_size"`
+	// FlushInterval is the maximum time in milliseconds a log waits in a pending batch.
+	FlushInterval int `yaml:"flush_interval"`
+}
+
+func (bo *batchOptions) setDefaults() {
+	if bo.BatchSize <= 0 {
+		bo.BatchSize = defaultBatchSize
+	}
+	if bo.FlushInterval <= 0 {
+		bo.FlushInterval = defaultFlushInterval
+	}
+}
+
+// # batchExporter
+//
+// batchExporter implements the thread control of exporters which send logs in batches.
+// A concrete exporter embeds batchExporter and provides callbacks:
+//
+// - appendFunc: converts a log & appends it to the pending batch. It must release the log.
+// It returns false if the log is dropped without being appended.
+//
+// - flushFunc: sends the pending batch. If retry is true, the same batch is sent again after backoff.
+//
+// - resetFunc: clears the pending batch after it is sent or dropped.
+//
+// The batch is flushed when it is full, when FlushInterval elapsed, and when the exporter is stopped.
+// Received logs are acked after the batch is sent or dropped for good, not when it is dropped while stopping.
+type batchExporter struct {
+	// dependency injection
+	logger plogger.PolvoLogger
+	info   *compose.ExporterInfo
+	// fields
+	exporterName  string
+	batchSize     int
+	flushInterval time.Duration
+	pending       int
+	received      int
+	ackFunc       func(int)
+	writeDuration *metrics.Histogram
+	errorRecorder
+	// stream
+	logChannel chan *model.CommonLogWrapper
+	// callbacks
+	appendFunc func(*model.CommonLogWrapper) bool
+	flushFunc  func() (retry bool, err error)
+	resetFunc  func()
+	// thread control
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waitGrp sync.WaitGroup
+	// conditional variable
+	isClosed  int32
+	isStarted int32
+}
+
+func newBatchExporter(info *compose.ExporterInfo,
+	options batchOptions,
+	logger plogger.PolvoLogger,
+	appendFunc func(*model.CommonLogWrapper) bool,
+	flushFunc func() (bool, error),
+	resetFunc func()) *batchExporter {
+
+	be := new(batchExporter)
+	// dependency injection
+	be.logger = logger
+	be.info = info
+	// set fields
+	options.setDefaults()
+	be.exporterName = info.Name
+	be.batchSize = options.BatchSize
+	be.flushInterval = time.Duration(options.FlushInterval) * time.Millisecond
+	be.writeDuration = exporterWriteDuration.With(info.Name)
+	be.appendFunc = appendFunc
+	be.flushFunc = flushFunc
+	be.resetFunc = resetFunc
+	// context
+	be.ctx, be.cancel = context.WithCancel(context.Background())
+	// init pipeline
+	if options.BufferSize == 0 {
+		be.logChannel = make(chan *model.CommonLogWrapper)
+	} else {
+		be.logChannel = make(chan *model.CommonLogWrapper, options.BufferSize)
+	}
+	// set conditional variable to 0
+	atomic.StoreInt32(&be.isClosed, 0)
+	atomic.StoreInt32(&be.isStarted, 0)
+	return be
+}
+
+/************************************************************
+* Getter & Setter
+************************************************************/
+
+func (be *batchExporter) Name() string {
+	return be.exporterName
+}
+
+func (be *batchExporter) LogChannel() chan<- *model.CommonLogWrapper {
+	return be.logChannel
+}
+
+func (be *batchExporter) setAckFunc(ackFunc func(int)) {
+	be.ackFunc = ackFunc
+}
+
+/************************************************************
+* Methods
+************************************************************/
+
+func (be *batchExporter) Start() {
+	be.waitGrp.Add(1)
+	go be.exportThread()
+	// set conditional variable to 1
+	atomic.StoreInt32(&be.isStarted, 1)
+}
+
+func (be *batchExporter) Stop() error {
+	// prevent double close
+	if atomic.LoadInt32(&be.isClosed) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is already closed"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Close()", be.exporterName),
+		}
+	}
+	// prevent call Stop() before Start()
+	if atomic.LoadInt32(&be.isStarted) <= 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is not started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Close()", be.exporterName),
+		}
+	}
+	// cancel context & wait for export thread to flush the rest of logs.
+	// logs are not waited in the channel, because they are never received while a batch is retried.
+	be.cancel()
+	be.waitGrp.Wait()
+	// close log channel
+	close(be.logChannel)
+	// set conditional variable to 1
+	atomic.StoreInt32(&be.isClosed, 1)
+	return nil
+}
+
+func (be *batchExporter) Wait() {
+	be.waitGrp.Wait()
+}
+
+/************************************************************
+* goroutines & private methods
+************************************************************/
+
+func (be *batchExporter) exportThread() {
+	var logWrapper *model.CommonLogWrapper
+
+	defer be.waitGrp.Done()
+
+	ticker := time.NewTicker(be.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-be.ctx.Done():
+			// flush remaining logs & logs left in the channel. each batch is tried only once.
+			for {
+				select {
+				case logWrapper = <-be.logChannel:
+					be.append(logWrapper)
+				default:
+					be.flush()
+					return
+				}
+			}
+		case <-ticker.C:
+			be.flush()
+		case logWrapper = <-be.logChannel:
+			be.append(logWrapper)
+		}
+	}
+}
+
+// append adds a log to the pending batch & flushes the batch if it is full.
+func (be *batchExporter) append(logWrapper *model.CommonLogWrapper) {
+	be.received++
+	// logs which can not be serialized are not counted in the batch
+	if !be.appendFunc(logWrapper) {
+		return
+	}
+	be.pending++
+	if be.pending >= be.batchSize {
+		be.flush()
+	}
+}
+
+// flush sends the pending batch. Retryable errors are retried with backoff until the exporter is stopped.
+func (be *batchExporter) flush() {
+	if be.pending == 0 {
+		// logs which can not be serialized are finished
+		be.ack()
+		return
+	}
+	startedAt := time.Now()
+	defer func() {
+		be.writeDuration.Observe(time.Since(startedAt).Seconds())
+	}()
+	backoff := batchInitialBackoff
+	for {
+		retry, err := be.flushFunc()
+		if err == nil {
+			break
+		}
+		be.record(err)
+		if !retry {
+			be.logger.PrintError("exporter [%s]: batch of %d logs is dropped. %s", be.exporterName, be.pending, err.Error())
+			break
+		}
+		be.logger.PrintError("exporter [%s]: error while send batch. retry after %v. %s", be.exporterName, backoff, err.Error())
+		if !be.sleep(backoff) {
+			// logs are not acked, so a queue in front of the exporter replays them
+			be.logger.PrintError("exporter [%s]: batch of %d logs is dropped while stopping", be.exporterName, be.pending)
+			be.resetFunc()
+			be.pending, be.received = 0, 0
+			return
+		}
+		backoff = min(backoff*2, batchMaxBackoff)
+	}
+	be.resetFunc()
+	be.pending = 0
+	be.ack()
+}
+
+// ack reports logs received since the last ack to ackFunc.
+func (be *batchExporter) ack() {
+	if be.ackFunc != nil && be.received > 0 {
+		be.ackFunc(be.received)
+	}
+	be.received = 0
+}
+
+// sleep waits for d. It returns false if the exporter is stopped while waiting.
+func (be *batchExporter) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-be.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,605 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"polvo/compose"
+	plogger "polvo/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// default queue options
+	defaultQueueMaxBytes      = 256 << 20
+	defaultQueueSegmentBytes  = 16 << 20
+	defaultQueueFsync         = "interval"
+	defaultQueueFsyncInterval = 1000
+	// file layout
+	queueSegmentExt    = ".seg"
+	queueCursorFile    = "cursor"
+	queueRecordHeader  = 8
+	queueCursorSize    = 28
+	queueSegmentIDSize = 20
+)
+
+var (
+	errQueueClosed    = errors.New("queue is closed")
+	errRecordTooLarge = errors.New("record is larger than max_bytes of queue")
+	queueCRCTable     = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// queueSegment is a segment file of diskQueue.
+type queueSegment struct {
+	id    uint64
+	size  int64
+	count int64
+}
+
+// queueToken is the position of a record returned by pop. It is passed to ack after the record is delivered.
+// Acking a token acks all records popped before it, too.
+type queueToken struct {
+	segment uint64
+	index   int64
+	next    int64
+}
+
+// # QueueStats
+//
+// QueueStats is the accounting of a disk-backed queue.
+type QueueStats struct {
+	// Queued is the number of records waiting in the queue.
+	Queued int64
+	// Bytes is the size of all segment files.
+	Bytes int64
+	// Evicted is the number of records removed unread because the queue exceeded max bytes.
+	Evicted uint64
+	// Dropped is the number of records lost because they were too large, corrupted or not decodable.
+	Dropped uint64
+}
+
+// # diskQueue
+//
+// diskQueue is a persistent FIFO of byte records stored in segment files in a directory.
+//
+// - each record is written as [length uint32][crc32c uint32][payload]. corrupted records are dropped on read.
+//
+// - segments are named by increasing sequence numbers. A new segment is started when the active one reaches SegmentBytes.
+//
+// - when the size of all segments exceeds MaxBytes, the oldest segment is evicted even if it is not read yet.
+//
+// - pop moves the pop position only. The read position moves when records are acked & is saved in the cursor file,
+// so records which are popped but not delivered yet are replayed after restart.
+//
+// diskQueue supports a single producer & a single consumer.
+type diskQueue struct {
+	// dependency injection
+	logger plogger.PolvoLogger
+	// options
+	path          string
+	maxBytes      int64
+	segmentBytes  int64
+	fsync         string
+	exporterName  string
+	segments      []*queueSegment
+	writer        *os.File
+	reader        *os.File
+	readerSegment uint64
+	// read position is the oldest record which is not acked in segments[0]
+	readOffset int64
+	readIndex  int64
+	// pop position is the next record returned by pop
+	popSegment    uint64
+	popOffset     int64
+	popIndex      int64
+	stats         QueueStats
+	isDirty       bool
+	isCursorDirty bool
+	isPopStopped  bool
+	isClosed      bool
+	// lock
+	lock sync.Mutex
+	cond *sync.Cond
+}
+
+// openDiskQueue opens the queue in info.Path. Segments left by the previous run are replayed from the saved cursor.
+// Zero values of info are replaced by defaults.
+func openDiskQueue(exporterName string, info compose.QueueInfo, logger plogger.PolvoLogger) (*diskQueue, error) {
+	dq := new(diskQueue)
+	// dependency injection
+	dq.logger = logger
+	// set options
+	if info.MaxBytes == 0 {
+		info.MaxBytes = defaultQueueMaxBytes
+	}
+	if info.SegmentBytes == 0 {
+		info.SegmentBytes = min(defaultQueueSegmentBytes, info.MaxBytes/4)
+	}
+	if info.Fsync == "" {
+		info.Fsync = defaultQueueFsync
+	}
+	dq.path = info.Path
+	dq.maxBytes = info.MaxBytes
+	dq.segmentBytes = max(info.SegmentBytes, 1)
+	dq.fsync = info.Fsync
+	dq.exporterName = exporterName
+	dq.cond = sync.NewCond(&dq.lock)
+
+	if err := os.MkdirAll(dq.path, 0o700); err != nil {
+		return nil, err
+	}
+	if err := dq.loadSegments(); err != nil {
+		return nil, err
+	}
+	if err := dq.loadCursor(); err != nil {
+		return nil, err
+	}
+	// new records are written to a new segment
+	if err := dq.rotate(); err != nil {
+		return nil, err
+	}
+	return dq, nil
+}
+
+/************************************************************
+* Methods
+************************************************************/
+
+// push appends a record. The oldest segments are evicted if the queue exceeds max bytes.
+func (dq *diskQueue) push(payload []byte) error {
+	var header [queueRecordHeader]byte
+
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	if dq.isClosed {
+		return errQueueClosed
+	}
+	recordSize := int64(queueRecordHeader + len(payload))
+	if recordSize > dq.maxBytes {
+		dq.stats.Dropped++
+		return errRecordTooLarge
+	}
+	active := dq.segments[len(dq.segments)-1]
+	if active.size > 0 && active.size+recordSize > dq.segmentBytes {
+		if err := dq.rotate(); err != nil {
+			return err
+		}
+	}
+	// evict oldest segments
+	for dq.stats.Bytes+recordSize > dq.maxBytes {
+		if len(dq.segments) == 1 {
+			if err := dq.rotate(); err != nil {
+				return err
+			}
+		}
+		if err := dq.evictOldest(); err != nil {
+			return err
+		}
+	}
+	// write record
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(payload, queueCRCTable))
+	if _, err := dq.writer.Write(append(header[:], payload...)); err != nil {
+		return err
+	}
+	active = dq.segments[len(dq.segments)-1]
+	active.size += recordSize
+	active.count++
+	dq.stats.Bytes += recordSize
+	dq.stats.Queued++
+	if dq.fsync == "always" {
+		if err := dq.writer.Sync(); err != nil {
+			return err
+		}
+	} else {
+		dq.isDirty = true
+	}
+	dq.cond.Signal()
+	return nil
+}
+
+// pop returns the oldest record which is not popped yet. It blocks until a record is pushed or pop is stopped.
+// The record is not removed until ack is called with the returned token or a later one.
+func (dq *diskQueue) pop() ([]byte, queueToken, error) {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	for {
+		if dq.isClosed || dq.isPopStopped {
+			return nil, queueToken{}, errQueueClosed
+		}
+		idx := dq.popPosition()
+		segment := dq.segments[idx]
+		if dq.popIndex < segment.count {
+			payload, err := dq.readRecord(segment, dq.popOffset)
+			if err == nil {
+				token := queueToken{
+					segment: segment.id,
+					index:   dq.popIndex,
+					next:    dq.popOffset + int64(queueRecordHeader+len(payload)),
+				}
+				dq.popIndex++
+				dq.popOffset = token.next
+				return payload, token, nil
+			}
+			// the rest of the segment can not be read
+			unread := segment.count - dq.popIndex
+			dq.logger.PrintError("exporter [%s]: %d logs in queue segment %d are dropped. %s", dq.exporterName, unread, segment.id, err.Error())
+			dq.stats.Dropped += uint64(unread)
+			dq.stats.Queued -= unread
+			// the segment ends at the pop position. no more records are written to it.
+			if idx == len(dq.segments)-1 {
+				if err = dq.rotate(); err != nil {
+					return nil, queueToken{}, err
+				}
+			}
+			segment.count = dq.popIndex
+			if err = dq.removeAcked(); err != nil {
+				return nil, queueToken{}, err
+			}
+			continue
+		}
+		// every record of the segment is popped
+		if idx < len(dq.segments)-1 {
+			dq.popSegment, dq.popOffset, dq.popIndex = dq.segments[idx+1].id, 0, 0
+			continue
+		}
+		dq.cond.Wait()
+	}
+}
+
+// stopPop makes blocked & later pop return errQueueClosed. Popped records can be acked until the queue is closed.
+func (dq *diskQueue) stopPop() {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	dq.isPopStopped = true
+	dq.cond.Broadcast()
+}
+
+// ack removes the record of token & the records popped before it. Records evicted after pop are ignored.
+func (dq *diskQueue) ack(token queueToken) error {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	if dq.isClosed {
+		return errQueueClosed
+	}
+	// segments before the segment of token are acked entirely
+	for len(dq.segments) > 1 && dq.segments[0].id < token.segment {
+		dq.stats.Queued -= dq.segments[0].count - dq.readIndex
+		if err := dq.removeOldest(); err != nil {
+			return err
+		}
+	}
+	if dq.segments[0].id != token.segment || token.index < dq.readIndex {
+		return nil
+	}
+	dq.stats.Queued -= token.index + 1 - dq.readIndex
+	dq.readIndex = token.index + 1
+	dq.readOffset = token.next
+	dq.isCursorDirty = true
+	if err := dq.removeAcked(); err != nil {
+		return err
+	}
+	if dq.fsync == "always" {
+		return dq.writeCursor()
+	}
+	return nil
+}
+
+// drop counts the record of token as dropped. e.g. it can not be decoded.
+// The record is removed when it or a later record is acked.
+func (dq *diskQueue) drop(token queueToken) {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	dq.stats.Dropped++
+}
+
+// sync flushes written records & the cursor according to the fsync policy.
+func (dq *diskQueue) sync() error {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	if dq.isClosed {
+		return nil
+	}
+	return dq.syncLocked()
+}
+
+// close syncs & closes the queue. Blocked pop returns errQueueClosed.
+func (dq *diskQueue) close() error {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	if dq.isClosed {
+		return nil
+	}
+	err := dq.syncLocked()
+	dq.isClosed = true
+	dq.cond.Broadcast()
+	if dq.reader != nil {
+		dq.reader.Close()
+	}
+	if closeErr := dq.writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (dq *diskQueue) Stats() QueueStats {
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+
+	return dq.stats
+}
+
+/************************************************************
+* private methods
+************************************************************/
+
+func (dq *diskQueue) syncLocked() error {
+	if dq.isDirty && dq.fsync != "never" {
+		if err := dq.writer.Sync(); err != nil {
+			return err
+		}
+	}
+	dq.isDirty = false
+	if dq.isCursorDirty {
+		return dq.writeCursor()
+	}
+	return nil
+}
+
+// popPosition returns the index of the segment at the pop position.
+// If the segment is removed, the pop position moves to the read position.
+func (dq *diskQueue) popPosition() int {
+	for idx, segment := range dq.segments {
+		if segment.id == dq.popSegment {
+			return idx
+		}
+	}
+	dq.popSegment, dq.popOffset, dq.popIndex = dq.segments[0].id, dq.readOffset, dq.readIndex
+	return 0
+}
+
+// removeAcked removes the oldest segments whose records are all acked. The active segment is kept.
+func (dq *diskQueue) removeAcked() error {
+	for len(dq.segments) > 1 && dq.readIndex >= dq.segments[0].count {
+		if err := dq.removeOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dq *diskQueue) segmentPath(id uint64) string {
+	return filepath.Join(dq.path, fmt.Sprintf("%0*d%s", queueSegmentIDSize, id, queueSegmentExt))
+}
+
+// loadSegments scans segment files of the previous run. Truncated or corrupted tails are cut off.
+func (dq *diskQueue) loadSegments() error {
+	entries, err := os.ReadDir(dq.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, queueSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, queueSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segment, err := dq.scanSegment(id)
+		if err != nil {
+			return err
+		}
+		dq.segments = append(dq.segments, segment)
+		dq.stats.Bytes += segment.size
+		dq.stats.Queued += segment.count
+	}
+	sort.Slice(dq.segments, func(i, j int) bool { return dq.segments[i].id < dq.segments[j].id })
+	return nil
+}
+
+func (dq *diskQueue) scanSegment(id uint64) (*queueSegment, error) {
+	var header [queueRecordHeader]byte
+
+	file, err := os.OpenFile(dq.segmentPath(id), os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	segment := &queueSegment{id: id}
+	for {
+		if _, err = io.ReadFull(file, header[:]); err != nil {
+			break
+		}
+		// a torn or corrupted header must not allocate more than the record can be
+		length := int64(binary.BigEndian.Uint32(header[:]))
+		if length > info.Size()-segment.size-queueRecordHeader || queueRecordHeader+length > dq.maxBytes {
+			err = fmt.Errorf("record length %d exceeds segment", length)
+			break
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(file, payload); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, queueCRCTable) != binary.BigEndian.Uint32(header[4:]) {
+			err = fmt.Errorf("checksum mismatch")
+			break
+		}
+		segment.size += int64(queueRecordHeader + len(payload))
+		segment.count++
+	}
+	if err == io.EOF {
+		return segment, nil
+	}
+	// cut off the broken tail written before crash
+	dq.logger.PrintError("exporter [%s]: %d bytes of broken tail in queue segment %d are truncated. %v",
+		dq.exporterName, info.Size()-segment.size, id, err)
+	return segment, file.Truncate(segment.size)
+}
+
+// loadCursor restores the read position & removes segments which are already read.
+func (dq *diskQueue) loadCursor() error {
+	raw, err := os.ReadFile(filepath.Join(dq.path, queueCursorFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(raw) != queueCursorSize || crc32.Checksum(raw[:24], queueCRCTable) != binary.BigEndian.Uint32(raw[24:]) {
+		dq.logger.PrintError("exporter [%s]: queue cursor is broken. queue is replayed from the oldest segment", dq.exporterName)
+		return nil
+	}
+	segmentID := binary.BigEndian.Uint64(raw)
+	offset := int64(binary.BigEndian.Uint64(raw[8:]))
+	index := int64(binary.BigEndian.Uint64(raw[16:]))
+	for len(dq.segments) > 0 && dq.segments[0].id < segmentID {
+		// records of the segment are already acked
+		dq.stats.Queued -= dq.segments[0].count
+		if err := dq.removeOldest(); err != nil {
+			return err
+		}
+	}
+	if len(dq.segments) > 0 && dq.segments[0].id == segmentID && index <= dq.segments[0].count && offset <= dq.segments[0].size {
+		dq.readOffset = offset
+		dq.readIndex = index
+		dq.stats.Queued -= index
+	}
+	return nil
+}
+
+func (dq *diskQueue) writeCursor() error {
+	var raw [queueCursorSize]byte
+
+	if len(dq.segments) == 0 {
+		return nil
+	}
+	binary.BigEndian.PutUint64(raw[:], dq.segments[0].id)
+	binary.BigEndian.PutUint64(raw[8:], uint64(dq.readOffset))
+	binary.BigEndian.PutUint64(raw[16:], uint64(dq.readIndex))
+	binary.BigEndian.PutUint32(raw[24:], crc32.Checksum(raw[:24], queueCRCTable))
+
+	// replace cursor atomically
+	tmpPath := filepath.Join(dq.path, queueCursorFile+".tmp")
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(raw[:]); err == nil && dq.fsync != "never" {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	dq.isCursorDirty = false
+	return os.Rename(tmpPath, filepath.Join(dq.path, queueCursorFile))
+}
+
+// rotate starts a new active segment.
+func (dq *diskQueue) rotate() error {
+	var id uint64 = 1
+
+	if len(dq.segments) > 0 {
+		id = dq.segments[len(dq.segments)-1].id + 1
+	}
+	file, err := os.OpenFile(dq.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if dq.writer != nil {
+		if dq.fsync != "never" {
+			dq.writer.Sync()
+		}
+		dq.writer.Close()
+	}
+	dq.writer = file
+	dq.segments = append(dq.segments, &queueSegment{id: id})
+	return nil
+}
+
+// evictOldest removes the oldest segment & counts its unread records as evicted.
+func (dq *diskQueue) evictOldest() error {
+	oldest := dq.segments[0]
+	unread := oldest.count - dq.readIndex
+	if unread > 0 {
+		dq.logger.PrintError("exporter [%s]: queue exceeds %d bytes. %d oldest logs are evicted", dq.exporterName, dq.maxBytes, unread)
+		dq.stats.Evicted += uint64(unread)
+		dq.stats.Queued -= unread
+		dq.readIndex = oldest.count
+	}
+	return dq.removeOldest()
+}
+
+// removeOldest deletes the oldest segment file & moves the read position to the next segment.
+func (dq *diskQueue) removeOldest() error {
+	oldest := dq.segments[0]
+	if dq.reader != nil && dq.readerSegment == oldest.id {
+		dq.reader.Close()
+		dq.reader = nil
+	}
+	if err := os.Remove(dq.segmentPath(oldest.id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dq.segments = dq.segments[1:]
+	dq.stats.Bytes -= oldest.size
+	dq.readOffset = 0
+	dq.readIndex = 0
+	dq.isCursorDirty = true
+	return nil
+}
+
+// readRecord reads the record at offset of segment.
+func (dq *diskQueue) readRecord(segment *queueSegment, offset int64) ([]byte, error) {
+	var header [queueRecordHeader]byte
+
+	if dq.reader != nil && dq.readerSegment != segment.id {
+		dq.reader.Close()
+		dq.reader = nil
+	}
+	if dq.reader == nil {
+		file, err := os.Open(dq.segmentPath(segment.id))
+		if err != nil {
+			return nil, err
+		}
+		dq.reader = file
+		dq.readerSegment = segment.id
+	}
+	if _, err := dq.reader.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:]))
+	if offset+queueRecordHeader+size > segment.size {
+		return nil, fmt.Errorf("record at %d exceeds segment size", offset)
+	}
+	payload := make([]byte, size)
+	if _, err := dq.reader.ReadAt(payload, offset+queueRecordHeader); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(payload, queueCRCTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, fmt.Errorf("checksum mismatch at %d", offset)
+	}
+	return payload, nil
+}
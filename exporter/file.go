@@ -7,17 +7,43 @@ import (
 	"polvo/compose"
 	perror "polvo/error"
 	plogger "polvo/logger"
+	"polvo/metrics"
+	"polvo/service/model"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// fileOptions is the mode-specific options of file exporter.
+type fileOptions struct {
+	// BufferSize is the capacity of log channel. 0 means unbuffered.
+	BufferSize uint `yaml:"buffer_size"`
+}
+
+func init() {
+	Register("file", func(info *compose.ExporterInfo,
+		_ *compose.Service,
+		wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+		_ func(*model.CommonLogWrapper),
+		logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+		var options fileOptions
+		if err := decodeOptions(info, &options); err != nil {
+			return nil, err
+		}
+		return NewFileExporter(info.Name, options.BufferSize, wrapFunc, logger, info)
+	})
+}
+
 type fileExporter[log any] struct {
 	// dependency injection
 	logger plogger.PolvoLogger
 	info   *compose.ExporterInfo
 	// fields
-	exporterName string
+	exporterName  string
+	ackFunc       func(int)
+	writeDuration *metrics.Histogram
+	errorRecorder
 	// stream
 	logChannel  chan *log
 	writeStream *os.File
@@ -43,11 +69,16 @@ func (fe *fileExporter[log]) LogChannel() chan<- *log {
 	return fe.logChannel
 }
 
+func (fe *fileExporter[log]) setAckFunc(ackFunc func(int)) {
+	fe.ackFunc = ackFunc
+}
+
 /************************************************************
 * Methods
 ************************************************************/
 
 func (fe *fileExporter[log]) Start() {
+	fe.waitGrp.Add(1)
 	go fe.exportThread()
 	// set conditional variable to 1
 	atomic.StoreInt32(&fe.isStarted, 1)
@@ -77,8 +108,9 @@ func (fe *fileExporter[log]) Stop() error {
 		}
 		time.Sleep(10 * time.Millisecond)
 	}
-	// cancel context
+	// cancel context & wait for export thread to release the write stream
 	fe.cancel()
+	fe.waitGrp.Wait()
 	// close log channel
 	close(fe.logChannel)
 	// close write stream
@@ -118,6 +150,7 @@ func NewFileExporter[log any](name string,
 	// set fields
 	newFE.exporterName = name
 	newFE.wrapFunc = wrapFunc
+	newFE.writeDuration = exporterWriteDuration.With(name)
 	// open stream
 	err := newFE.openStream(maxSize)
 	if err != nil {
@@ -168,8 +201,6 @@ func (fe *fileExporter[log]) exportThread() {
 		out        []byte
 		err        error
 	)
-	// wait group
-	fe.waitGrp.Add(1)
 	defer fe.waitGrp.Done()
 
 	for {
@@ -180,18 +211,30 @@ func (fe *fileExporter[log]) exportThread() {
 			// wrap log
 			out, err = fe.wrapFunc(logWrapper)
 			if err != nil {
+				fe.record(err)
 				fe.logger.PrintError("error while wrap log %s", err.Error())
+				fe.ack()
 				continue
 			}
 			// append newline
 			out = append(out, '\n')
 			// export log
+			startedAt := time.Now()
 			_, err = fe.writeStream.Write(out)
+			fe.writeDuration.Observe(time.Since(startedAt).Seconds())
 			if err != nil {
 				fe.logger.PrintError("error while write log %s", err.Error())
 				// this is critical error and unrecoverable. so panic
 				panic(err)
 			}
+			fe.ack()
 		}
 	}
 }
+
+// ack reports a finished log to ackFunc.
+func (fe *fileExporter[log]) ack() {
+	if fe.ackFunc != nil {
+		fe.ackFunc(1)
+	}
+}
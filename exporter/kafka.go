@@ -0,0 +1,296 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"polvo/compose"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/service/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kafkaOptions is the mode-specific options of kafka exporter.
+type kafkaOptions struct {
+	batchOptions `yaml:",inline"`
+	// Topic is the kafka topic logs are produced to. It is required.
+	Topic string `yaml:"topic"`
+	// Key is the field used as the partition key.
+	// One of eventname, source, timestamp or metadata.<key> (nested keys are separated by dots).
+	// If it is empty, logs are distributed to partitions in round-robin.
+	Key string `yaml:"key"`
+	// Acks is the number of acknowledgments required. 0, 1 or -1 (all). default is -1.
+	Acks *int16 `yaml:"acks"`
+	// Compression is "none" (default) or "gzip".
+	Compression string `yaml:"compression"`
+	// ClientID is sent to brokers in every request. default is polvo.
+	ClientID string `yaml:"client_id"`
+}
+
+func init() {
+	Register("kafka", func(info *compose.ExporterInfo,
+		_ *compose.Service,
+		wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+		_ func(*model.CommonLogWrapper),
+		logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+		var options kafkaOptions
+		if err := decodeOptions(info, &options); err != nil {
+			return nil, err
+		}
+		return NewKafkaExporter(info, options, wrapFunc, logger)
+	})
+}
+
+// # kafkaExporter
+//
+// kafkaExporter produces logs to a kafka topic speaking the kafka wire protocol.
+// The destination is a comma separated list of bootstrap brokers. e.g. 10.0.0.1:9092,10.0.0.2:9092
+//
+// - value of each record is the json serialized log.
+//
+// - partition is chosen by the default partitioner of kafka (murmur2 of key), or in round-robin if key is not set.
+//
+// - partition leaders are discovered with metadata request & refreshed when a broker reports a stale leader.
+//
+// - only the partitions rejected with retryable errors are sent again.
+type kafkaExporter struct {
+	*batchExporter
+	// dependency injection
+	wrapFunc func(*model.CommonLogWrapper) ([]byte, error)
+	// fields
+	client      *kafkaClient
+	topic       string
+	keyPath     []string
+	acks        int16
+	compression int16
+	partitions  kafkaTopicMetadata
+	roundRobin  int
+	records     []*kafkaRecord
+}
+
+func NewKafkaExporter(info *compose.ExporterInfo,
+	options kafkaOptions,
+	wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+	logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+	newKE := new(kafkaExporter)
+	// validate options
+	if err := newKE.setOptions(options); err != nil {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrExporterCreate,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while construct new exporter[%s]", info.Name),
+		}
+	}
+	// set fields
+	newKE.wrapFunc = wrapFunc
+	if options.ClientID == "" {
+		options.ClientID = "polvo"
+	}
+	newKE.client = newKafkaClient(options.ClientID, strings.Split(info.Destination, ","), time.Duration(info.Timeout)*time.Second)
+	newKE.batchExporter = newBatchExporter(info, options.batchOptions, logger, newKE.append, newKE.send, newKE.reset)
+	newKE.records = make([]*kafkaRecord, 0, newKE.batchSize)
+	return newKE, nil
+}
+
+func (ke *kafkaExporter) setOptions(options kafkaOptions) error {
+	if options.Topic == "" {
+		return fmt.Errorf("topic is required")
+	}
+	ke.topic = options.Topic
+	// key
+	switch {
+	case options.Key == "":
+	case options.Key == "eventname", options.Key == "source", options.Key == "timestamp":
+		ke.keyPath = []string{options.Key}
+	case strings.HasPrefix(options.Key, "metadata.") && len(options.Key) > len("metadata."):
+		ke.keyPath = strings.Split(options.Key, ".")
+	default:
+		return fmt.Errorf("invalid key %s. key must be eventname, source, timestamp or metadata.<key>", options.Key)
+	}
+	// acks
+	ke.acks = -1
+	if options.Acks != nil {
+		switch *options.Acks {
+		case 0, 1, -1:
+			ke.acks = *options.Acks
+		default:
+			return fmt.Errorf("invalid acks %d. acks must be 0, 1 or -1", *options.Acks)
+		}
+	}
+	// compression
+	switch options.Compression {
+	case "", "none":
+		ke.compression = kafkaCompressionNone
+	case "gzip":
+		ke.compression = kafkaCompressionGzip
+	default:
+		return fmt.Errorf("invalid compression %s. compression must be none or gzip", options.Compression)
+	}
+	return nil
+}
+
+func (ke *kafkaExporter) Stop() error {
+	if err := ke.batchExporter.Stop(); err != nil {
+		return err
+	}
+	ke.client.close()
+	return nil
+}
+
+/************************************************************
+* batch callbacks
+************************************************************/
+
+func (ke *kafkaExporter) append(log *model.CommonLogWrapper) bool {
+	// key must be read before wrapFunc returns log to the pool
+	key := ke.key(log)
+	value, err := ke.wrapFunc(log)
+	if err != nil {
+		ke.logger.PrintError("exporter [%s]: error while serialize log. %s", ke.exporterName, err.Error())
+		return false
+	}
+	ke.records = append(ke.records, &kafkaRecord{
+		key:       key,
+		value:     value,
+		timestamp: time.Now().UnixMilli(),
+		partition: kafkaUnassignedPartiton,
+	})
+	return true
+}
+
+func (ke *kafkaExporter) reset() {
+	ke.records = ke.records[:0]
+}
+
+func (ke *kafkaExporter) send() (retry bool, err error) {
+	if len(ke.records) == 0 {
+		return false, nil
+	}
+	// discover partition leaders
+	if ke.partitions == nil {
+		ke.partitions, err = ke.client.metadata(ke.topic)
+		if err != nil {
+			return true, err
+		}
+	}
+	// group records by leader & partition
+	requests := make(map[int32]map[int32][]*kafkaRecord)
+	for _, record := range ke.records {
+		if record.partition == kafkaUnassignedPartiton {
+			record.partition = ke.partition(record.key)
+		}
+		leader, ok := ke.partitions[record.partition]
+		if !ok || leader == kafkaNoPartitionLeader {
+			// partition count is changed or leader election is in progress
+			ke.partitions = nil
+			return true, kafkaError{code: kafkaErrLeaderNotAvailable, msg: fmt.Sprintf("partition %d", record.partition)}
+		}
+		if requests[leader] == nil {
+			requests[leader] = make(map[int32][]*kafkaRecord)
+		}
+		requests[leader][record.partition] = append(requests[leader][record.partition], record)
+	}
+
+	// produce
+	done := make(map[int32]bool)
+	for leader, partitions := range requests {
+		batches := make(map[int32][]byte, len(partitions))
+		for partition, records := range partitions {
+			if batches[partition], err = encodeKafkaRecordBatch(records, ke.compression); err != nil {
+				return false, err
+			}
+		}
+		result, produceErr := ke.client.produce(leader, ke.topic, ke.acks, batches)
+		if produceErr != nil {
+			// leader may be moved
+			ke.partitions = nil
+			err = produceErr
+			retry = true
+			continue
+		}
+		for partition := range batches {
+			code, ok := result[partition]
+			switch {
+			case !ok:
+				retry = true
+				err = fmt.Errorf("broker %d did not respond partition %d", leader, partition)
+			case code == kafkaErrNone:
+				done[partition] = true
+			case isRetryableKafkaError(code):
+				ke.partitions = nil
+				retry = true
+				err = kafkaError{code: code, msg: fmt.Sprintf("produce to partition %d", partition)}
+			default:
+				// not retryable. records of this partition are dropped.
+				done[partition] = true
+				ke.logger.PrintError("exporter [%s]: %d logs of partition %d are dropped. %s", ke.exporterName,
+					len(partitions[partition]), partition, kafkaError{code: code, msg: "produce"}.Error())
+			}
+		}
+	}
+	// keep records of failed partitions only
+	remains := ke.records[:0]
+	for _, record := range ke.records {
+		if !done[record.partition] {
+			remains = append(remains, record)
+		}
+	}
+	ke.records = remains
+	if len(ke.records) == 0 {
+		return false, nil
+	}
+	return retry, err
+}
+
+/************************************************************
+* private methods
+************************************************************/
+
+// key returns the partition key of log. nil if key is not configured or the field does not exist.
+func (ke *kafkaExporter) key(log *model.CommonLogWrapper) []byte {
+	if len(ke.keyPath) == 0 {
+		return nil
+	}
+	switch ke.keyPath[0] {
+	case "eventname":
+		return []byte(log.EventName)
+	case "source":
+		return []byte(log.Source)
+	case "timestamp":
+		return []byte(log.Timestmp)
+	}
+	// metadata.<key>
+	var val interface{} = log.MetaData
+	for _, field := range ke.keyPath[1:] {
+		nested, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if val, ok = nested[field]; !ok {
+			return nil
+		}
+	}
+	switch val := val.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(val)
+	case float64:
+		return []byte(strconv.FormatFloat(val, 'f', -1, 64))
+	default:
+		out, _ := json.Marshal(val)
+		return out
+	}
+}
+
+func (ke *kafkaExporter) partition(key []byte) int32 {
+	if key == nil {
+		ke.roundRobin++
+		return int32(ke.roundRobin % len(ke.partitions))
+	}
+	return kafkaPartition(key, len(ke.partitions))
+}
@@ -0,0 +1,574 @@
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// kafka api keys & versions used by kafka exporter
+const (
+	kafkaAPIProduce         = 0
+	kafkaAPIMetadata        = 3
+	kafkaProduceVersion     = 3
+	kafkaMetadataVersion    = 1
+	kafkaRecordBatchMagic   = 2
+	kafkaCompressionNone    = 0
+	kafkaCompressionGzip    = 1
+	kafkaMaxResponseSize    = 64 << 20
+	kafkaNoPartitionLeader  = -1
+	kafkaUnassignedPartiton = -1
+)
+
+// kafka error codes handled by kafka exporter
+const (
+	kafkaErrNone                    = 0
+	kafkaErrUnknownTopicOrPartition = 3
+	kafkaErrLeaderNotAvailable      = 5
+	kafkaErrNotLeaderForPartition   = 6
+	kafkaErrRequestTimedOut         = 7
+	kafkaErrNetworkException        = 13
+	kafkaErrNotEnoughReplicas       = 19
+	kafkaErrNotEnoughReplicasAfter  = 20
+)
+
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// isRetryableKafkaError reports whether the error code is transient.
+func isRetryableKafkaError(code int16) bool {
+	switch code {
+	case kafkaErrUnknownTopicOrPartition,
+		kafkaErrLeaderNotAvailable,
+		kafkaErrNotLeaderForPartition,
+		kafkaErrRequestTimedOut,
+		kafkaErrNetworkException,
+		kafkaErrNotEnoughReplicas,
+		kafkaErrNotEnoughReplicasAfter:
+		return true
+	}
+	return false
+}
+
+/************************************************************
+* encoder & decoder
+************************************************************/
+
+// kafkaEncoder writes big-endian primitives of the kafka protocol.
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) putInt8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *kafkaEncoder) putInt16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *kafkaEncoder) putInt32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *kafkaEncoder) putInt64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *kafkaEncoder) putString(s string) {
+	e.putInt16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) putNullString() {
+	e.putInt16(-1)
+}
+
+func (e *kafkaEncoder) putBytes(b []byte) {
+	e.putInt32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// putVarint writes zigzag varint of record fields.
+func (e *kafkaEncoder) putVarint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+// putVarBytes writes varint length & bytes. nil is written as -1.
+func (e *kafkaEncoder) putVarBytes(b []byte) {
+	if b == nil {
+		e.putVarint(-1)
+		return
+	}
+	e.putVarint(int64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// kafkaDecoder reads big-endian primitives of the kafka protocol.
+// The first error is kept and every following read returns zero value.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	ret := d.buf[:n]
+	d.buf = d.buf[n:]
+	return ret
+}
+
+func (d *kafkaDecoder) getInt8() int8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *kafkaDecoder) getInt16() int16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *kafkaDecoder) getInt32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *kafkaDecoder) getInt64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *kafkaDecoder) getString() string {
+	size := d.getInt16()
+	if size < 0 {
+		return ""
+	}
+	return string(d.next(int(size)))
+}
+
+func (d *kafkaDecoder) getBytes() []byte {
+	size := d.getInt32()
+	if size < 0 {
+		return nil
+	}
+	return d.next(int(size))
+}
+
+func (d *kafkaDecoder) getVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *kafkaDecoder) getVarBytes() []byte {
+	size := d.getVarint()
+	if size < 0 {
+		return nil
+	}
+	return d.next(int(size))
+}
+
+// getArrayLen reads array length. null array is returned as 0.
+func (d *kafkaDecoder) getArrayLen() int {
+	size := d.getInt32()
+	if size < 0 {
+		return 0
+	}
+	// each element has at least 1 byte
+	if int(size) > len(d.buf) {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return int(size)
+}
+
+/************************************************************
+* record batch
+************************************************************/
+
+// kafkaRecord is a record waiting in the pending batch of kafka exporter.
+type kafkaRecord struct {
+	key       []byte
+	value     []byte
+	timestamp int64
+	partition int32
+}
+
+// encodeKafkaRecordBatch encodes records into RecordBatch (magic v2).
+func encodeKafkaRecordBatch(records []*kafkaRecord, compression int16) ([]byte, error) {
+	var (
+		body   kafkaEncoder
+		record kafkaEncoder
+		batch  kafkaEncoder
+	)
+
+	firstTimestamp := records[0].timestamp
+	maxTimestamp := firstTimestamp
+	for offsetDelta, r := range records {
+		maxTimestamp = max(maxTimestamp, r.timestamp)
+		// Record
+		record.buf = record.buf[:0]
+		record.putInt8(0)
+		record.putVarint(r.timestamp - firstTimestamp)
+		record.putVarint(int64(offsetDelta))
+		record.putVarBytes(r.key)
+		record.putVarBytes(r.value)
+		record.putVarint(0) // headers
+		body.putVarint(int64(len(record.buf)))
+		body.buf = append(body.buf, record.buf...)
+	}
+	payload := body.buf
+	if compression == kafkaCompressionGzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		payload = compressed.Bytes()
+	}
+
+	// fields covered by crc
+	var crcBody kafkaEncoder
+	crcBody.putInt16(compression)
+	crcBody.putInt32(int32(len(records) - 1))
+	crcBody.putInt64(firstTimestamp)
+	crcBody.putInt64(maxTimestamp)
+	crcBody.putInt64(-1) // producer id
+	crcBody.putInt16(-1) // producer epoch
+	crcBody.putInt32(-1) // base sequence
+	crcBody.putInt32(int32(len(records)))
+	crcBody.buf = append(crcBody.buf, payload...)
+
+	batch.putInt64(0)                                   // base offset
+	batch.putInt32(int32(4 + 1 + 4 + len(crcBody.buf))) // batch length
+	batch.putInt32(-1)                                  // partition leader epoch
+	batch.putInt8(kafkaRecordBatchMagic)
+	batch.putInt32(int32(crc32.Checksum(crcBody.buf, kafkaCRCTable)))
+	batch.buf = append(batch.buf, crcBody.buf...)
+	return batch.buf, nil
+}
+
+// kafkaPartition returns the partition of key with the default partitioner of kafka (murmur2).
+func kafkaPartition(key []byte, numPartitions int) int32 {
+	return int32((murmur2(key) & 0x7fffffff) % uint32(numPartitions))
+}
+
+// murmur2 is the hash function used by the default partitioner of kafka java client.
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+/************************************************************
+* client
+************************************************************/
+
+// kafkaClient sends requests to kafka brokers.
+// It is not thread-safe and is used only by the export thread of kafka exporter.
+type kafkaClient struct {
+	clientID      string
+	timeout       time.Duration
+	bootstrap     []string
+	correlationID int32
+	// broker id -> address & connection
+	brokers map[int32]string
+	conns   map[int32]net.Conn
+}
+
+func newKafkaClient(clientID string, bootstrap []string, timeout time.Duration) *kafkaClient {
+	return &kafkaClient{
+		clientID:  clientID,
+		timeout:   timeout,
+		bootstrap: bootstrap,
+		brokers:   make(map[int32]string),
+		conns:     make(map[int32]net.Conn),
+	}
+}
+
+// close closes every broker connection.
+func (c *kafkaClient) close() {
+	for id, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, id)
+	}
+}
+
+// closeBroker drops the connection of broker after an error.
+func (c *kafkaClient) closeBroker(id int32) {
+	if conn, ok := c.conns[id]; ok {
+		conn.Close()
+		delete(c.conns, id)
+	}
+}
+
+func (c *kafkaClient) broker(id int32) (net.Conn, error) {
+	if conn, ok := c.conns[id]; ok {
+		return conn, nil
+	}
+	addr, ok := c.brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker %d", id)
+	}
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[id] = conn
+	return conn, nil
+}
+
+// roundTrip sends a request and reads its response body.
+// If expectResponse is false (produce with acks=0), nil is returned after the request is written.
+func (c *kafkaClient) roundTrip(conn net.Conn, apiKey int16, apiVersion int16, body []byte, expectResponse bool) ([]byte, error) {
+	var request kafkaEncoder
+
+	c.correlationID++
+	// request header v1
+	request.putInt32(0) // size placeholder
+	request.putInt16(apiKey)
+	request.putInt16(apiVersion)
+	request.putInt32(c.correlationID)
+	request.putString(c.clientID)
+	request.buf = append(request.buf, body...)
+	binary.BigEndian.PutUint32(request.buf, uint32(len(request.buf)-4))
+
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := conn.Write(request.buf); err != nil {
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+	// response header v0
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header))
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	if correlationID := int32(binary.BigEndian.Uint32(header[4:])); correlationID != c.correlationID {
+		return nil, fmt.Errorf("correlation id mismatch %d != %d", correlationID, c.correlationID)
+	}
+	response := make([]byte, size-4)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// kafkaTopicMetadata is partition -> leader broker id of a topic.
+type kafkaTopicMetadata map[int32]int32
+
+// metadata requests metadata of topic from bootstrap brokers and updates broker addresses.
+func (c *kafkaClient) metadata(topic string) (kafkaTopicMetadata, error) {
+	var (
+		request kafkaEncoder
+		lastErr error
+	)
+
+	request.putInt32(1)
+	request.putString(topic)
+
+	for _, addr := range c.bootstrap {
+		conn, err := net.DialTimeout("tcp", addr, c.timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		response, err := c.roundTrip(conn, kafkaAPIMetadata, kafkaMetadataVersion, request.buf, true)
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return c.parseMetadata(topic, response)
+	}
+	return nil, fmt.Errorf("no bootstrap broker is available. %v", lastErr)
+}
+
+func (c *kafkaClient) parseMetadata(topic string, response []byte) (kafkaTopicMetadata, error) {
+	d := &kafkaDecoder{buf: response}
+
+	// brokers
+	brokers := make(map[int32]string)
+	for i, n := 0, d.getArrayLen(); i < n; i++ {
+		id := d.getInt32()
+		host := d.getString()
+		port := d.getInt32()
+		d.getString() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.getInt32() // controller id
+
+	var (
+		partitions kafkaTopicMetadata
+		topicErr   int16
+		found      bool
+	)
+	for i, n := 0, d.getArrayLen(); i < n; i++ {
+		errCode := d.getInt16()
+		name := d.getString()
+		d.getInt8() // is internal
+		metadata := make(kafkaTopicMetadata)
+		for j, m := 0, d.getArrayLen(); j < m; j++ {
+			d.getInt16() // partition error code
+			partition := d.getInt32()
+			leader := d.getInt32()
+			for k, l := 0, d.getArrayLen(); k < l; k++ {
+				d.getInt32() // replicas
+			}
+			for k, l := 0, d.getArrayLen(); k < l; k++ {
+				d.getInt32() // isr
+			}
+			metadata[partition] = leader
+		}
+		if name == topic {
+			partitions, topicErr, found = metadata, errCode, true
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid metadata response. %v", d.err)
+	}
+	if !found {
+		return nil, fmt.Errorf("topic %s is not found in metadata", topic)
+	}
+	if topicErr != kafkaErrNone {
+		return nil, kafkaError{code: topicErr, msg: fmt.Sprintf("metadata of topic %s", topic)}
+	}
+	if len(partitions) == 0 {
+		return nil, kafkaError{code: kafkaErrLeaderNotAvailable, msg: fmt.Sprintf("topic %s has no partition", topic)}
+	}
+	// update broker addresses & drop connections of moved brokers
+	for id, addr := range brokers {
+		if c.brokers[id] != addr {
+			c.closeBroker(id)
+		}
+	}
+	c.brokers = brokers
+	return partitions, nil
+}
+
+// produce sends a produce request of partition -> record batch to broker.
+// It returns error code per partition. With acks=0 every partition is reported as success.
+func (c *kafkaClient) produce(broker int32, topic string, acks int16, batches map[int32][]byte) (map[int32]int16, error) {
+	var request kafkaEncoder
+
+	request.putNullString() // transactional id
+	request.putInt16(acks)
+	request.putInt32(int32(c.timeout / time.Millisecond))
+	request.putInt32(1)
+	request.putString(topic)
+	request.putInt32(int32(len(batches)))
+	for partition, batch := range batches {
+		request.putInt32(partition)
+		request.putBytes(batch)
+	}
+
+	conn, err := c.broker(broker)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.roundTrip(conn, kafkaAPIProduce, kafkaProduceVersion, request.buf, acks != 0)
+	if err != nil {
+		c.closeBroker(broker)
+		return nil, err
+	}
+	result := make(map[int32]int16)
+	if acks == 0 {
+		for partition := range batches {
+			result[partition] = kafkaErrNone
+		}
+		return result, nil
+	}
+
+	d := &kafkaDecoder{buf: response}
+	for i, n := 0, d.getArrayLen(); i < n; i++ {
+		d.getString() // topic
+		for j, m := 0, d.getArrayLen(); j < m; j++ {
+			partition := d.getInt32()
+			result[partition] = d.getInt16()
+			d.getInt64() // base offset
+			d.getInt64() // log append time
+		}
+	}
+	d.getInt32() // throttle time
+	if d.err != nil {
+		c.closeBroker(broker)
+		return nil, fmt.Errorf("invalid produce response. %v", d.err)
+	}
+	return result, nil
+}
+
+// kafkaError is an error code returned by broker.
+type kafkaError struct {
+	code int16
+	msg  string
+}
+
+func (e kafkaError) Error() string {
+	return fmt.Sprintf("kafka error code %d in %s", e.code, e.msg)
+}
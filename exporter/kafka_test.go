@@ -0,0 +1,341 @@
+package exporter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"polvo/compose"
+	"polvo/exporter"
+	"polvo/service/model"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// kafkaProduced is a record received by fakeKafkaBroker.
+type kafkaProduced struct {
+	partition int32
+	key       []byte
+	value     []byte
+}
+
+// fakeKafkaBroker is an in-process kafka stand-in which answers Metadata v1 & Produce v3.
+type fakeKafkaBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	topic      string
+	partitions int32
+	// the first rejectProduce produce requests are answered with NOT_LEADER_FOR_PARTITION
+	rejectProduce int32
+	metadataCalls int32
+	records       chan kafkaProduced
+	waitGrp       sync.WaitGroup
+}
+
+func newFakeKafkaBroker(t *testing.T, topic string, partitions int32, rejectProduce int32) *fakeKafkaBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v, want nil", err)
+	}
+	broker := &fakeKafkaBroker{
+		t:             t,
+		listener:      listener,
+		topic:         topic,
+		partitions:    partitions,
+		rejectProduce: rejectProduce,
+		records:       make(chan kafkaProduced, 64),
+	}
+	broker.waitGrp.Add(1)
+	go broker.serve()
+	return broker
+}
+
+func (b *fakeKafkaBroker) Close() {
+	b.listener.Close()
+	b.waitGrp.Wait()
+}
+
+func (b *fakeKafkaBroker) serve() {
+	defer b.waitGrp.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.waitGrp.Add(1)
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeKafkaBroker) handle(conn net.Conn) {
+	defer b.waitGrp.Done()
+	defer conn.Close()
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		request := make([]byte, size)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		apiKey := int16(binary.BigEndian.Uint16(request))
+		correlationID := binary.BigEndian.Uint32(request[4:])
+		clientIDLen := int(binary.BigEndian.Uint16(request[8:]))
+		body := request[10+clientIDLen:]
+
+		var response []byte
+		switch apiKey {
+		case 3:
+			atomic.AddInt32(&b.metadataCalls, 1)
+			response = b.metadata()
+		case 0:
+			response = b.produce(body)
+		default:
+			b.t.Errorf("unexpected api key %d", apiKey)
+			return
+		}
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(response)+4))
+		out = binary.BigEndian.AppendUint32(out, correlationID)
+		if _, err := conn.Write(append(out, response...)); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeKafkaBroker) metadata() []byte {
+	host, portStr, _ := net.SplitHostPort(b.listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	var out []byte
+	// brokers
+	out = binary.BigEndian.AppendUint32(out, 1)
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = appendKafkaString(out, host)
+	out = binary.BigEndian.AppendUint32(out, uint32(port))
+	out = binary.BigEndian.AppendUint16(out, 0xffff) // null rack
+	// controller id
+	out = binary.BigEndian.AppendUint32(out, 0)
+	// topics
+	out = binary.BigEndian.AppendUint32(out, 1)
+	out = binary.BigEndian.AppendUint16(out, 0)
+	out = appendKafkaString(out, b.topic)
+	out = append(out, 0)
+	out = binary.BigEndian.AppendUint32(out, uint32(b.partitions))
+	for p := int32(0); p < b.partitions; p++ {
+		out = binary.BigEndian.AppendUint16(out, 0)
+		out = binary.BigEndian.AppendUint32(out, uint32(p))
+		out = binary.BigEndian.AppendUint32(out, 0) // leader
+		out = binary.BigEndian.AppendUint32(out, 1) // replicas
+		out = binary.BigEndian.AppendUint32(out, 0)
+		out = binary.BigEndian.AppendUint32(out, 1) // isr
+		out = binary.BigEndian.AppendUint32(out, 0)
+	}
+	return out
+}
+
+func (b *fakeKafkaBroker) produce(body []byte) []byte {
+	var errCode uint16
+	if atomic.AddInt32(&b.rejectProduce, -1) >= 0 {
+		errCode = 6
+	}
+
+	// transactional id, acks, timeout
+	body = body[2+2+4:]
+	if topicCount := binary.BigEndian.Uint32(body); topicCount != 1 {
+		b.t.Errorf("produce topic count = %d, want 1", topicCount)
+	}
+	topicLen := int(binary.BigEndian.Uint16(body[4:]))
+	if topic := string(body[6 : 6+topicLen]); topic != b.topic {
+		b.t.Errorf("produce topic = %s, want %s", topic, b.topic)
+	}
+	body = body[6+topicLen:]
+	partitionCount := binary.BigEndian.Uint32(body)
+	body = body[4:]
+
+	var out []byte
+	out = binary.BigEndian.AppendUint32(out, 1)
+	out = appendKafkaString(out, b.topic)
+	out = binary.BigEndian.AppendUint32(out, partitionCount)
+	for i := uint32(0); i < partitionCount; i++ {
+		partition := int32(binary.BigEndian.Uint32(body))
+		batchLen := binary.BigEndian.Uint32(body[4:])
+		batch := body[8 : 8+batchLen]
+		body = body[8+batchLen:]
+		if errCode == 0 {
+			b.decodeBatch(partition, batch)
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(partition))
+		out = binary.BigEndian.AppendUint16(out, errCode)
+		out = binary.BigEndian.AppendUint64(out, 0)
+		out = binary.BigEndian.AppendUint64(out, 0xffffffffffffffff)
+	}
+	// throttle time
+	return binary.BigEndian.AppendUint32(out, 0)
+}
+
+// decodeBatch checks crc of RecordBatch v2 & decodes its records.
+func (b *fakeKafkaBroker) decodeBatch(partition int32, batch []byte) {
+	if magic := batch[16]; magic != 2 {
+		b.t.Errorf("magic = %d, want 2", magic)
+		return
+	}
+	crc := binary.BigEndian.Uint32(batch[17:])
+	if want := crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)); crc != want {
+		b.t.Errorf("crc = %x, want %x", crc, want)
+		return
+	}
+	attributes := binary.BigEndian.Uint16(batch[21:])
+	count := int(binary.BigEndian.Uint32(batch[57:]))
+	records := batch[61:]
+	if attributes&7 == 1 {
+		reader, err := gzip.NewReader(bytes.NewReader(records))
+		if err != nil {
+			b.t.Errorf("gzip.NewReader() = %v, want nil", err)
+			return
+		}
+		if records, err = io.ReadAll(reader); err != nil {
+			b.t.Errorf("error while decompress records %v", err)
+			return
+		}
+	}
+	varint := func() int64 {
+		v, n := binary.Varint(records)
+		records = records[n:]
+		return v
+	}
+	varBytes := func() []byte {
+		size := varint()
+		if size < 0 {
+			return nil
+		}
+		ret := records[:size]
+		records = records[size:]
+		return ret
+	}
+	for i := 0; i < count; i++ {
+		varint()              // length
+		records = records[1:] // attributes
+		varint()              // timestamp delta
+		varint()              // offset delta
+		key := varBytes()
+		value := varBytes()
+		varint() // headers
+		b.records <- kafkaProduced{partition: partition, key: key, value: value}
+	}
+}
+
+func appendKafkaString(out []byte, s string) []byte {
+	out = binary.BigEndian.AppendUint16(out, uint16(len(s)))
+	return append(out, s...)
+}
+
+func (b *fakeKafkaBroker) receive(t *testing.T, count int) []kafkaProduced {
+	ret := make([]kafkaProduced, 0, count)
+	for len(ret) < count {
+		select {
+		case record := <-b.records:
+			ret = append(ret, record)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("broker received %d records, want %d", len(ret), count)
+		}
+	}
+	return ret
+}
+
+func newKafkaExporter(t *testing.T, destination string, options string) exporter.Exporter[model.CommonLogWrapper] {
+	info := &compose.ExporterInfo{
+		Name:        "kafka",
+		Mode:        "kafka",
+		Destination: destination,
+		Timeout:     1,
+		Options:     optionsNode(t, options),
+	}
+	exp, err := exporter.New(info, nil, marshalCommon, nil, loger)
+	if err != nil {
+		t.Fatalf("exporter.New(kafka) = %v, want nil", err)
+	}
+	return exp
+}
+
+func TestKafkaExporterProduce(t *testing.T) {
+	broker := newFakeKafkaBroker(t, "polvo", 4, 0)
+	defer broker.Close()
+
+	exp := newKafkaExporter(t, broker.listener.Addr().String(),
+		"{topic: polvo, key: metadata.Commandline, compression: gzip, batch_size: 2}")
+	exp.Start()
+	exp.LogChannel() <- newCommonLog(t)
+	exp.LogChannel() <- newCommonLog(t)
+
+	records := broker.receive(t, 2)
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+
+	for _, record := range records {
+		if string(record.key) != "echo hello world" {
+			t.Errorf("key = %s, want echo hello world", record.key)
+		}
+		var log model.CommonLogWrapper
+		if err := json.Unmarshal(record.value, &log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", record.value, err)
+		}
+		if log.EventName != "bashReadline" {
+			t.Errorf("eventname = %s, want bashReadline", log.EventName)
+		}
+	}
+	// records with the same key are produced to the same partition
+	if records[0].partition != records[1].partition {
+		t.Errorf("partitions = %d, %d, want the same partition", records[0].partition, records[1].partition)
+	}
+}
+
+func TestKafkaExporterRetryNotLeader(t *testing.T) {
+	broker := newFakeKafkaBroker(t, "polvo", 1, 1)
+	defer broker.Close()
+
+	exp := newKafkaExporter(t, broker.listener.Addr().String(), "{topic: polvo, acks: 1, batch_size: 1}")
+	exp.Start()
+	exp.LogChannel() <- newCommonLog(t)
+
+	broker.receive(t, 1)
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+	// metadata is refreshed after NOT_LEADER_FOR_PARTITION
+	if calls := atomic.LoadInt32(&broker.metadataCalls); calls != 2 {
+		t.Errorf("metadata requests = %d, want 2", calls)
+	}
+	select {
+	case record := <-broker.records:
+		t.Errorf("unexpected duplicated record %s", record.value)
+	default:
+	}
+}
+
+func TestKafkaExporterInvalidOptions(t *testing.T) {
+	for _, options := range []string{
+		"{key: source}",
+		"{topic: polvo, key: pid}",
+		"{topic: polvo, acks: 2}",
+		"{topic: polvo, compression: zstd}",
+	} {
+		info := &compose.ExporterInfo{
+			Name:        "kafka",
+			Mode:        "kafka",
+			Destination: "127.0.0.1:9092",
+			Timeout:     1,
+			Options:     optionsNode(t, options),
+		}
+		if _, err := exporter.New(info, nil, marshalCommon, nil, loger); err == nil {
+			t.Errorf("exporter.New(%s) = nil, want error", options)
+		}
+	}
+}
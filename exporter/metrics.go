@@ -0,0 +1,10 @@
+package exporter
+
+import "polvo/metrics"
+
+// metrics of exporters
+var (
+	exporterWriteDuration = metrics.Default.NewHistogramVec("polvo_exporter_write_duration_seconds",
+		"Time spent writing logs to the destination, including retries. Batch exporters observe a whole batch.",
+		nil, "exporter")
+)
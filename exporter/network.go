@@ -0,0 +1,311 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"polvo/compose"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/metrics"
+	"polvo/service/model"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// reconnect backoff of network exporter
+	networkInitialBackoff = 100 * time.Millisecond
+	networkMaxBackoff     = 30 * time.Second
+)
+
+// networkOptions is the mode-specific options of network exporter.
+type networkOptions struct {
+	// BufferSize is the capacity of log channel. 0 means unbuffered.
+	BufferSize uint `yaml:"buffer_size"`
+}
+
+func init() {
+	Register("network", func(info *compose.ExporterInfo,
+		_ *compose.Service,
+		wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+		_ func(*model.CommonLogWrapper),
+		logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+		var options networkOptions
+		if err := decodeOptions(info, &options); err != nil {
+			return nil, err
+		}
+		return NewNetworkExporter(info.Name, options.BufferSize, wrapFunc, logger, info)
+	})
+}
+
+// # networkExporter
+//
+// networkExporter streams newline-delimited logs to the destination over TCP or UDP.
+// The destination is "host:port" (TCP) or "tcp://host:port", "udp://host:port".
+// Timeout of ExporterInfo is used as dial & write deadline in seconds.
+// If the connection is lost, networkExporter reconnects with exponential backoff and retries the pending log.
+// A log is acked after it is written. The pending log is not acked when it is dropped while stopping.
+type networkExporter[log any] struct {
+	// dependency injection
+	logger plogger.PolvoLogger
+	info   *compose.ExporterInfo
+	// fields
+	exporterName  string
+	network       string
+	address       string
+	timeout       time.Duration
+	ackFunc       func(int)
+	writeDuration *metrics.Histogram
+	errorRecorder
+	// stream
+	logChannel chan *log
+	conn       net.Conn
+	// thread control
+	ctx      context.Context
+	cancel   context.CancelFunc
+	waitGrp  sync.WaitGroup
+	wrapFunc func(*log) ([]byte, error)
+	// conditional variable
+	isClosed  int32
+	isStarted int32
+}
+
+/************************************************************
+* Getter & Setter
+************************************************************/
+
+func (ne *networkExporter[log]) Name() string {
+	return ne.exporterName
+}
+
+func (ne *networkExporter[log]) LogChannel() chan<- *log {
+	return ne.logChannel
+}
+
+func (ne *networkExporter[log]) setAckFunc(ackFunc func(int)) {
+	ne.ackFunc = ackFunc
+}
+
+/************************************************************
+* Methods
+************************************************************/
+
+func (ne *networkExporter[log]) Start() {
+	ne.waitGrp.Add(1)
+	go ne.exportThread()
+	// set conditional variable to 1
+	atomic.StoreInt32(&ne.isStarted, 1)
+}
+
+func (ne *networkExporter[log]) Stop() error {
+	// prevent double close
+	if atomic.LoadInt32(&ne.isClosed) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is already closed"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Close()", ne.exporterName),
+		}
+	}
+	// prevent call Stop() before Start()
+	if atomic.LoadInt32(&ne.isStarted) <= 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is not started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Close()", ne.exporterName),
+		}
+	}
+	// cancel context & wait for export thread to export the rest of logs & release the connection.
+	// logs are not waited in the channel, because they are never exported while the destination is down.
+	ne.cancel()
+	ne.waitGrp.Wait()
+	// close log channel
+	close(ne.logChannel)
+	// close connection
+	if ne.conn != nil {
+		err := ne.conn.Close()
+		ne.conn = nil
+		if err != nil {
+			return perror.PolvoGeneralError{
+				Code:   perror.SystemError,
+				Origin: err,
+				Msg:    fmt.Sprintf("error while close Destination connection[%s]", ne.info.Destination),
+			}
+		}
+	}
+	// set conditional variable to 1
+	atomic.StoreInt32(&ne.isClosed, 1)
+	return nil
+}
+
+func (ne *networkExporter[log]) Wait() {
+	ne.waitGrp.Wait()
+}
+
+/************************************************************
+* Constructor
+************************************************************/
+
+func NewNetworkExporter[log any](name string,
+	maxSize uint,
+	wrapFunc func(*log) ([]byte, error),
+	logger plogger.PolvoLogger,
+	info *compose.ExporterInfo) (Exporter[log], error) {
+
+	newNE := new(networkExporter[log])
+	// dependency injection
+	newNE.logger = logger
+	newNE.info = info
+	// context
+	newNE.ctx, newNE.cancel = context.WithCancel(context.Background())
+	// set fields
+	newNE.exporterName = name
+	newNE.wrapFunc = wrapFunc
+	newNE.timeout = time.Duration(info.Timeout) * time.Second
+	newNE.writeDuration = exporterWriteDuration.With(name)
+	newNE.network, newNE.address = SplitNetworkDestination(info.Destination)
+	if newNE.network != "tcp" && newNE.network != "udp" {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrExporterCreate,
+			Origin: fmt.Errorf("unsupported network protocol %s", newNE.network),
+			Msg:    fmt.Sprintf("error while construct new exporter[%s]", name),
+		}
+	}
+	// init pipeline
+	if maxSize == 0 {
+		newNE.logChannel = make(chan *log)
+	} else {
+		newNE.logChannel = make(chan *log, maxSize)
+	}
+	// set conditional variable to 0
+	atomic.StoreInt32(&newNE.isClosed, 0)
+	atomic.StoreInt32(&newNE.isStarted, 0)
+	return newNE, nil
+}
+
+// # SplitNetworkDestination
+//
+// SplitNetworkDestination splits "udp://host:port" into network and address.
+// If the scheme is omitted, tcp is used.
+func SplitNetworkDestination(destination string) (network string, address string) {
+	if idx := strings.Index(destination, "://"); idx >= 0 {
+		return strings.ToLower(destination[:idx]), destination[idx+3:]
+	}
+	return "tcp", destination
+}
+
+/************************************************************
+* goroutines & private methods
+************************************************************/
+
+func (ne *networkExporter[log]) exportThread() {
+	var logWrapper *log
+
+	defer ne.waitGrp.Done()
+
+	for {
+		select {
+		case <-ne.ctx.Done():
+			// logs left in the channel are tried once
+			for {
+				select {
+				case logWrapper = <-ne.logChannel:
+					if !ne.export(logWrapper) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case logWrapper = <-ne.logChannel:
+			if !ne.export(logWrapper) {
+				return
+			}
+		}
+	}
+}
+
+// export wraps & writes a log. It returns false if the log is dropped because the exporter is stopped.
+func (ne *networkExporter[log]) export(logWrapper *log) bool {
+	// wrap log
+	out, err := ne.wrapFunc(logWrapper)
+	if err != nil {
+		ne.record(err)
+		ne.logger.PrintError("error while wrap log %s", err.Error())
+		ne.ack()
+		return true
+	}
+	// append newline
+	out = append(out, '\n')
+	// export log. retry until the log is written or exporter is stopped.
+	startedAt := time.Now()
+	written := ne.write(out)
+	ne.writeDuration.Observe(time.Since(startedAt).Seconds())
+	if !written {
+		ne.logger.PrintError("exporter [%s]: log is dropped while stopping", ne.exporterName)
+		return false
+	}
+	ne.ack()
+	return true
+}
+
+// ack reports a finished log to ackFunc.
+func (ne *networkExporter[log]) ack() {
+	if ne.ackFunc != nil {
+		ne.ackFunc(1)
+	}
+}
+
+// write sends out to the destination.
+// It returns false only if the exporter is stopped before the log is written.
+func (ne *networkExporter[log]) write(out []byte) bool {
+	var err error
+
+	backoff := networkInitialBackoff
+	for {
+		if ne.conn == nil {
+			ne.conn, err = net.DialTimeout(ne.network, ne.address, ne.timeout)
+			if err != nil {
+				ne.conn = nil
+				ne.record(err)
+				ne.logger.PrintError("exporter [%s]: error while dial %s://%s. retry after %v. %s", ne.exporterName, ne.network, ne.address, backoff, err.Error())
+				if !ne.sleep(backoff) {
+					return false
+				}
+				backoff = min(backoff*2, networkMaxBackoff)
+				continue
+			}
+			ne.logger.PrintInfo("exporter [%s]: connected to %s://%s", ne.exporterName, ne.network, ne.address)
+		}
+		ne.conn.SetWriteDeadline(time.Now().Add(ne.timeout))
+		_, err = ne.conn.Write(out)
+		if err == nil {
+			return true
+		}
+		// drop connection & reconnect
+		ne.record(err)
+		ne.logger.PrintError("exporter [%s]: error while write log. reconnect... %s", ne.exporterName, err.Error())
+		ne.conn.Close()
+		ne.conn = nil
+		if !ne.sleep(backoff) {
+			return false
+		}
+		backoff = min(backoff*2, networkMaxBackoff)
+	}
+}
+
+// sleep waits for d. It returns false if the exporter is stopped while waiting.
+func (ne *networkExporter[log]) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ne.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,176 @@
+package exporter_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"polvo/compose"
+	"polvo/exporter"
+	plogger "polvo/logger"
+	"testing"
+	"time"
+)
+
+type sampleLog struct {
+	EventName string `json:"eventname"`
+	Index     int    `json:"index"`
+}
+
+func wrap(log *sampleLog) ([]byte, error) {
+	return json.Marshal(log)
+}
+
+var (
+	loger   plogger.PolvoLogger
+	logpath string
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	// setup
+	logpath, err = os.MkdirTemp("", "polvo-exporter")
+	if err != nil {
+		fmt.Printf("error while create log directory %v", err)
+		os.Exit(1)
+	}
+	loger = plogger.NewLogger(logpath)
+	// run tests
+	code := m.Run()
+	// teardown
+	loger.Close()
+	os.RemoveAll(logpath)
+	os.Exit(code)
+}
+
+func readLines(t *testing.T, conn net.Conn, count int) []sampleLog {
+	ret := make([]sampleLog, 0, count)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	for len(ret) < count && scanner.Scan() {
+		var log sampleLog
+		if err := json.Unmarshal(scanner.Bytes(), &log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", scanner.Text(), err)
+		}
+		ret = append(ret, log)
+	}
+	if len(ret) != count {
+		t.Fatalf("received %d logs, want %d. %v", len(ret), count, scanner.Err())
+	}
+	return ret
+}
+
+func TestNetworkExporterTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error while listen %v", err)
+	}
+	defer listener.Close()
+
+	info := &compose.ExporterInfo{Name: "network", Mode: "network", Destination: listener.Addr().String(), Timeout: 1}
+	exp, err := exporter.NewNetworkExporter("network", 0, wrap, loger, info)
+	if err != nil {
+		t.Fatalf("error while create exporter %v", err)
+	}
+	exp.Start()
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			exp.LogChannel() <- &sampleLog{EventName: "bashReadline", Index: i}
+		}
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("error while accept %v", err)
+	}
+	defer conn.Close()
+	for i, log := range readLines(t, conn, 10) {
+		if log.Index != i {
+			t.Errorf("log[%d].Index = %d, want %d", i, log.Index, i)
+		}
+	}
+	if err = exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+}
+
+func TestNetworkExporterReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error while listen %v", err)
+	}
+	defer listener.Close()
+
+	info := &compose.ExporterInfo{Name: "network", Mode: "network", Destination: "tcp://" + listener.Addr().String(), Timeout: 1}
+	exp, err := exporter.NewNetworkExporter("network", 0, wrap, loger, info)
+	if err != nil {
+		t.Fatalf("error while create exporter %v", err)
+	}
+	exp.Start()
+
+	// first connection is closed by collector after the first log
+	exp.LogChannel() <- &sampleLog{EventName: "first"}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("error while accept %v", err)
+	}
+	readLines(t, conn, 1)
+	conn.Close()
+
+	// exporter should reconnect and deliver remaining logs
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			exp.LogChannel() <- &sampleLog{EventName: "second", Index: i}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	conn, err = listener.Accept()
+	if err != nil {
+		t.Fatalf("error while accept %v", err)
+	}
+	defer conn.Close()
+	logs := readLines(t, conn, 1)
+	if logs[0].EventName != "second" {
+		t.Errorf("log.EventName = %s, want second", logs[0].EventName)
+	}
+	<-done
+	if err = exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+}
+
+func TestNetworkExporterUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error while listen %v", err)
+	}
+	defer server.Close()
+
+	info := &compose.ExporterInfo{Name: "network", Mode: "network", Destination: "udp://" + server.LocalAddr().String(), Timeout: 1}
+	exp, err := exporter.NewNetworkExporter("network", 0, wrap, loger, info)
+	if err != nil {
+		t.Fatalf("error while create exporter %v", err)
+	}
+	exp.Start()
+	exp.LogChannel() <- &sampleLog{EventName: "udp", Index: 7}
+
+	buf := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("error while read datagram %v", err)
+	}
+	var log sampleLog
+	if err = json.Unmarshal(buf[:n], &log); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = %v, want nil", buf[:n], err)
+	}
+	if log.Index != 7 {
+		t.Errorf("log.Index = %d, want 7", log.Index)
+	}
+	if err = exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+}
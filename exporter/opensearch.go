@@ -0,0 +1,333 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"polvo/compose"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/service/model"
+	"strings"
+	"time"
+)
+
+// default index pattern of opensearch exporter
+const defaultOpenSearchIndex = "polvo-{eventname}-{date:2006.01.02}"
+
+// openSearchOptions is the mode-specific options of opensearch exporter.
+type openSearchOptions struct {
+	batchOptions `yaml:",inline"`
+	// Index is the index pattern. default is polvo-{eventname}-{date:2006.01.02}
+	//
+	// {eventname}, {source} & {metadata.<key>} are replaced by fields of the log.
+	// {date:<layout>} is replaced by timestamp of the log formatted with the go time layout.
+	Index string `yaml:"index"`
+	// Username & Password are used for basic authentication.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Headers are added to every bulk request.
+	Headers map[string]string `yaml:"headers"`
+	// Trace logs every bulk request in the service log.
+	Trace bool `yaml:"trace"`
+}
+
+func init() {
+	constructor := func(info *compose.ExporterInfo,
+		_ *compose.Service,
+		wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+		_ func(*model.CommonLogWrapper),
+		logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+		var options openSearchOptions
+		if err := decodeOptions(info, &options); err != nil {
+			return nil, err
+		}
+		return NewOpenSearchExporter(info, options, wrapFunc, logger)
+	}
+	// _bulk api of elasticsearch is compatible with opensearch
+	Register("opensearch", constructor)
+	Register("elasticsearch", constructor)
+}
+
+// # openSearchExporter
+//
+// openSearchExporter indexes logs into OpenSearch (or Elasticsearch) with the _bulk api.
+// The destination is the url of the cluster. e.g. https://localhost:9200
+//
+// - index of each document is made from the index pattern & fields of the log. It is always lowercased.
+//
+// - the bulk response is checked item by item. Only documents rejected with 429 or 5xx are sent again.
+//
+// - documents rejected with other errors (e.g. mapping error) are dropped & logged.
+type openSearchExporter struct {
+	*batchExporter
+	// dependency injection
+	wrapFunc func(*model.CommonLogWrapper) ([]byte, error)
+	// fields
+	client   *http.Client
+	bulkURL  string
+	index    *indexPattern
+	options  openSearchOptions
+	tracer   *plogger.CustomLoggerForOpenSearch
+	docs     []openSearchDoc
+	retained []openSearchDoc
+}
+
+// openSearchDoc is a document waiting in the pending bulk request.
+type openSearchDoc struct {
+	index  string
+	source []byte
+}
+
+// openSearchBulkResponse is the part of _bulk response checked by opensearch exporter.
+type openSearchBulkResponse struct {
+	Errors bool                                  `json:"errors"`
+	Items  []map[string]openSearchBulkItemResult `json:"items"`
+}
+
+type openSearchBulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func NewOpenSearchExporter(info *compose.ExporterInfo,
+	options openSearchOptions,
+	wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+	logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+	var err error
+
+	newOE := new(openSearchExporter)
+	// parse index pattern
+	if options.Index == "" {
+		options.Index = defaultOpenSearchIndex
+	}
+	newOE.index, err = newIndexPattern(options.Index)
+	if err != nil {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrExporterCreate,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while construct new exporter[%s]", info.Name),
+		}
+	}
+	// set fields
+	newOE.wrapFunc = wrapFunc
+	newOE.options = options
+	newOE.client = &http.Client{Timeout: time.Duration(info.Timeout) * time.Second}
+	newOE.bulkURL = strings.TrimSuffix(info.Destination, "/") + "/_bulk"
+	if options.Trace {
+		newOE.tracer = &plogger.CustomLoggerForOpenSearch{Logger: logger.Logger()}
+	}
+	newOE.batchExporter = newBatchExporter(info, options.batchOptions, logger, newOE.append, newOE.send, newOE.reset)
+	newOE.docs = make([]openSearchDoc, 0, newOE.batchSize)
+	return newOE, nil
+}
+
+/************************************************************
+* batch callbacks
+************************************************************/
+
+func (oe *openSearchExporter) append(log *model.CommonLogWrapper) bool {
+	// index must be made before wrapFunc returns log to the pool
+	index := oe.index.expand(log)
+	source, err := oe.wrapFunc(log)
+	if err != nil {
+		oe.logger.PrintError("exporter [%s]: error while serialize log. %s", oe.exporterName, err.Error())
+		return false
+	}
+	oe.docs = append(oe.docs, openSearchDoc{index: index, source: source})
+	return true
+}
+
+func (oe *openSearchExporter) reset() {
+	oe.docs = oe.docs[:0]
+}
+
+func (oe *openSearchExporter) send() (retry bool, err error) {
+	var body bytes.Buffer
+
+	if len(oe.docs) == 0 {
+		return false, nil
+	}
+	// encode bulk request in ndjson
+	for _, doc := range oe.docs {
+		action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": doc.index}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(bytes.TrimRight(doc.source, "\n"))
+		body.WriteByte('\n')
+	}
+
+	request, err := http.NewRequest(http.MethodPost, oe.bulkURL, &body)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	for key, val := range oe.options.Headers {
+		request.Header.Set(key, val)
+	}
+	if oe.options.Username != "" {
+		request.SetBasicAuth(oe.options.Username, oe.options.Password)
+	}
+	start := time.Now()
+	response, err := oe.client.Do(request)
+	if oe.tracer != nil {
+		oe.tracer.LogRoundTrip(request, response, err, start, time.Since(start))
+	}
+	if err != nil {
+		// network error is retryable
+		return true, err
+	}
+	defer response.Body.Close()
+	payload, err := io.ReadAll(response.Body)
+	if err != nil {
+		return true, err
+	}
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+	case isRetryableBulkStatus(response.StatusCode):
+		return true, fmt.Errorf("cluster returns %s", response.Status)
+	default:
+		return false, fmt.Errorf("cluster returns %s. %s", response.Status, payload)
+	}
+
+	var result openSearchBulkResponse
+	if err = json.Unmarshal(payload, &result); err != nil {
+		return false, fmt.Errorf("invalid bulk response. %v", err)
+	}
+	if !result.Errors {
+		return false, nil
+	}
+	return oe.retainRejected(result)
+}
+
+/************************************************************
+* private methods
+************************************************************/
+
+// retainRejected keeps only the documents rejected with retryable status in the pending batch.
+func (oe *openSearchExporter) retainRejected(result openSearchBulkResponse) (retry bool, err error) {
+	if len(result.Items) != len(oe.docs) {
+		return false, fmt.Errorf("bulk response has %d items for %d documents", len(result.Items), len(oe.docs))
+	}
+
+	var (
+		dropped   int
+		lastError json.RawMessage
+	)
+	oe.retained = oe.retained[:0]
+	for i, item := range result.Items {
+		// item has a single key of the action. e.g. {"index": {...}}
+		for _, status := range item {
+			switch {
+			case status.Status >= 200 && status.Status < 300:
+			case isRetryableBulkStatus(status.Status):
+				oe.retained = append(oe.retained, oe.docs[i])
+			default:
+				dropped++
+				lastError = status.Error
+			}
+		}
+	}
+	if dropped > 0 {
+		oe.logger.PrintError("exporter [%s]: %d documents are rejected & dropped. %s", oe.exporterName, dropped, lastError)
+	}
+	if len(oe.retained) == 0 {
+		return false, nil
+	}
+	// swap buffers so the next retry sends only rejected documents
+	oe.docs, oe.retained = oe.retained, oe.docs
+	return true, fmt.Errorf("%d documents are rejected with retryable status", len(oe.docs))
+}
+
+// isRetryableBulkStatus reports whether the status means the cluster is temporarily unavailable.
+func isRetryableBulkStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+/************************************************************
+* index pattern
+************************************************************/
+
+// indexPattern is a parsed index pattern of opensearch exporter.
+type indexPattern struct {
+	// literals & placeholders in order
+	segments []indexSegment
+}
+
+type indexSegment struct {
+	literal     string
+	placeholder string
+	// time layout of {date:<layout>}
+	layout string
+	// key path of {metadata.<key>}
+	path []string
+}
+
+func newIndexPattern(pattern string) (*indexPattern, error) {
+	ip := new(indexPattern)
+	for rest := pattern; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			ip.segments = append(ip.segments, indexSegment{literal: rest})
+			break
+		}
+		closing := strings.IndexByte(rest[open:], '}')
+		if closing < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in index pattern %s", pattern)
+		}
+		if open > 0 {
+			ip.segments = append(ip.segments, indexSegment{literal: rest[:open]})
+		}
+		placeholder := rest[open+1 : open+closing]
+		switch {
+		case placeholder == "eventname", placeholder == "source":
+			ip.segments = append(ip.segments, indexSegment{placeholder: placeholder})
+		case strings.HasPrefix(placeholder, "date:") && len(placeholder) > len("date:"):
+			ip.segments = append(ip.segments, indexSegment{placeholder: "date", layout: strings.TrimPrefix(placeholder, "date:")})
+		case strings.HasPrefix(placeholder, "metadata.") && len(placeholder) > len("metadata."):
+			ip.segments = append(ip.segments, indexSegment{placeholder: "metadata", path: strings.Split(placeholder, ".")[1:]})
+		default:
+			return nil, fmt.Errorf("invalid placeholder {%s} in index pattern %s", placeholder, pattern)
+		}
+		rest = rest[open+closing+1:]
+	}
+	return ip, nil
+}
+
+// expand returns the lowercased index name of log.
+// If timestamp of log is not RFC3339, the current time is used for {date}.
+func (ip *indexPattern) expand(log *model.CommonLogWrapper) string {
+	var out strings.Builder
+
+	for _, segment := range ip.segments {
+		switch segment.placeholder {
+		case "":
+			out.WriteString(segment.literal)
+		case "eventname":
+			out.WriteString(log.EventName)
+		case "source":
+			out.WriteString(log.Source)
+		case "date":
+			ts, err := time.Parse(time.RFC3339Nano, log.Timestmp)
+			if err != nil {
+				ts = time.Now()
+			}
+			out.WriteString(ts.Format(segment.layout))
+		case "metadata":
+			var val interface{} = log.MetaData
+			for _, field := range segment.path {
+				nested, _ := val.(map[string]interface{})
+				val = nested[field]
+			}
+			if val != nil {
+				out.WriteString(fmt.Sprint(val))
+			}
+		}
+	}
+	return strings.ToLower(out.String())
+}
@@ -0,0 +1,181 @@
+package exporter_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"polvo/compose"
+	"polvo/exporter"
+	"polvo/service/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bulkRequest is the decoded ndjson body of a _bulk request.
+type bulkRequest struct {
+	indices []string
+	docs    []map[string]interface{}
+}
+
+func decodeBulkRequest(t *testing.T, r *http.Request) bulkRequest {
+	var ret bulkRequest
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var action map[string]map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			t.Errorf("json.Unmarshal(%s) = %v, want nil", scanner.Text(), err)
+			return ret
+		}
+		ret.indices = append(ret.indices, action["index"]["_index"])
+		if !scanner.Scan() {
+			t.Errorf("bulk request has an action without document")
+			return ret
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Errorf("json.Unmarshal(%s) = %v, want nil", scanner.Text(), err)
+		}
+		ret.docs = append(ret.docs, doc)
+	}
+	return ret
+}
+
+func newOpenSearchExporter(t *testing.T, destination string, options string) exporter.Exporter[model.CommonLogWrapper] {
+	info := &compose.ExporterInfo{
+		Name:        "opensearch",
+		Mode:        "opensearch",
+		Destination: destination,
+		Timeout:     1,
+		Options:     optionsNode(t, options),
+	}
+	exp, err := exporter.New(info, nil, marshalCommon, nil, loger)
+	if err != nil {
+		t.Fatalf("exporter.New(opensearch) = %v, want nil", err)
+	}
+	return exp
+}
+
+func TestOpenSearchExporterPartialFailure(t *testing.T) {
+	requests := make(chan bulkRequest, 4)
+	cluster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			t.Errorf("path = %s, want /_bulk", r.URL.Path)
+		}
+		if user, pass, _ := r.BasicAuth(); user != "admin" || pass != "secret" {
+			t.Errorf("BasicAuth() = %s:%s, want admin:secret", user, pass)
+		}
+		request := decodeBulkRequest(t, r)
+		items := make([]string, 0, len(request.docs))
+		for _, doc := range request.docs {
+			// PID decides the result of each item on the first request
+			status := 201
+			if len(requests) == 0 {
+				switch doc["metadata"].(map[string]interface{})["PID"].(float64) {
+				case 2:
+					status = 429
+				case 3:
+					status = 400
+				}
+			}
+			items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"status %d"}}}`, status, status))
+		}
+		requests <- request
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer cluster.Close()
+
+	exp := newOpenSearchExporter(t, cluster.URL,
+		"{index: 'polvo-{eventname}-{date:2006.01.02}', username: admin, password: secret, batch_size: 3}")
+	exp.Start()
+	for pid := 1; pid <= 3; pid++ {
+		log := newCommonLog(t)
+		log.MetaData.(map[string]interface{})["PID"] = float64(pid)
+		exp.LogChannel() <- log
+	}
+
+	var received []bulkRequest
+	for len(received) < 2 {
+		select {
+		case request := <-requests:
+			received = append(received, request)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("cluster received %d requests, want 2", len(received))
+		}
+	}
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+
+	if len(received[0].docs) != 3 {
+		t.Fatalf("len(first request) = %d, want 3", len(received[0].docs))
+	}
+	if received[0].indices[0] != "polvo-bashreadline-2025.03.11" {
+		t.Errorf("index = %s, want polvo-bashreadline-2025.03.11", received[0].indices[0])
+	}
+	// only the document rejected with 429 is sent again
+	if len(received[1].docs) != 1 {
+		t.Fatalf("len(retried request) = %d, want 1", len(received[1].docs))
+	}
+	if pid := received[1].docs[0]["metadata"].(map[string]interface{})["PID"]; pid != float64(2) {
+		t.Errorf("retried PID = %v, want 2", pid)
+	}
+	select {
+	case request := <-requests:
+		t.Errorf("unexpected request %v", request.docs)
+	default:
+	}
+}
+
+func TestOpenSearchExporterSkipsUnserializableLogs(t *testing.T) {
+	requests := make(chan bulkRequest, 4)
+	cluster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := decodeBulkRequest(t, r)
+		requests <- request
+		fmt.Fprint(w, `{"took":1,"errors":false,"items":[]}`)
+	}))
+	defer cluster.Close()
+
+	exp := newOpenSearchExporter(t, cluster.URL, "{batch_size: 3, flush_interval: 60000}")
+	exp.Start()
+	for pid := 1; pid <= 4; pid++ {
+		log := newCommonLog(t)
+		log.MetaData.(map[string]interface{})["PID"] = float64(pid)
+		if pid == 2 {
+			// json can not encode infinity
+			log.MetaData.(map[string]interface{})["score"] = math.Inf(1)
+		}
+		exp.LogChannel() <- log
+	}
+
+	// the batch is full when 3 logs are appended
+	select {
+	case request := <-requests:
+		if len(request.docs) != 3 {
+			t.Errorf("len(request) = %d, want 3", len(request.docs))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cluster received no request")
+	}
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+}
+
+func TestOpenSearchExporterInvalidIndex(t *testing.T) {
+	for _, options := range []string{"{index: 'polvo-{pid}'}", "{index: 'polvo-{eventname'}"} {
+		info := &compose.ExporterInfo{
+			Name:        "opensearch",
+			Mode:        "opensearch",
+			Destination: "http://localhost:9200",
+			Timeout:     1,
+			Options:     optionsNode(t, options),
+		}
+		if _, err := exporter.New(info, nil, marshalCommon, nil, loger); err == nil {
+			t.Errorf("exporter.New(%s) = nil, want error", options)
+		}
+	}
+}
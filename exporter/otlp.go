@@ -0,0 +1,155 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"polvo/compose"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/service/model"
+	"time"
+)
+
+// otlpOptions is the mode-specific options of otlp exporter.
+type otlpOptions struct {
+	batchOptions `yaml:",inline"`
+	// Encoding is "protobuf" (default) or "json".
+	Encoding string `yaml:"encoding"`
+	// Headers are added to every export request. e.g. authorization header of collector.
+	Headers map[string]string `yaml:"headers"`
+}
+
+func init() {
+	Register("otlp", func(info *compose.ExporterInfo,
+		service *compose.Service,
+		_ func(*model.CommonLogWrapper) ([]byte, error),
+		releaseFunc func(*model.CommonLogWrapper),
+		logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+		var options otlpOptions
+		if err := decodeOptions(info, &options); err != nil {
+			return nil, err
+		}
+		return NewOTLPExporter(info, options, service, releaseFunc, logger)
+	})
+}
+
+// # otlpExporter
+//
+// otlpExporter converts logs into OpenTelemetry LogRecords and sends them in batches to an OTLP/HTTP endpoint.
+// The destination is the full url of logs endpoint. e.g. http://localhost:4318/v1/logs
+//
+// - eventname & source are set as attributes and as event_name of LogRecord.
+//
+// - timestamp is parsed into time_unix_nano. log is set as body.
+//
+// - metadata is flattened into attributes with dotted keys.
+//
+// - machine, os, arch & group of service are set as resource attributes.
+type otlpExporter struct {
+	*batchExporter
+	// dependency injection
+	releaseFunc func(*model.CommonLogWrapper)
+	// fields
+	client      *http.Client
+	encoding    string
+	contentType string
+	headers     map[string]string
+	resource    []otlpKeyValue
+	records     []otlpRecord
+}
+
+func NewOTLPExporter(info *compose.ExporterInfo,
+	options otlpOptions,
+	service *compose.Service,
+	releaseFunc func(*model.CommonLogWrapper),
+	logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+	newOE := new(otlpExporter)
+	// set encoding
+	switch options.Encoding {
+	case "", "protobuf":
+		newOE.encoding = "protobuf"
+		newOE.contentType = "application/x-protobuf"
+	case "json":
+		newOE.encoding = "json"
+		newOE.contentType = "application/json"
+	default:
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrExporterCreate,
+			Origin: fmt.Errorf("invalid encoding %s. encoding must be protobuf or json", options.Encoding),
+			Msg:    fmt.Sprintf("error while construct new exporter[%s]", info.Name),
+		}
+	}
+	// set fields
+	newOE.releaseFunc = releaseFunc
+	newOE.client = &http.Client{Timeout: time.Duration(info.Timeout) * time.Second}
+	newOE.headers = options.Headers
+	newOE.resource = newOTLPResource(service)
+	newOE.batchExporter = newBatchExporter(info, options.batchOptions, logger, newOE.append, newOE.send, newOE.reset)
+	newOE.records = make([]otlpRecord, 0, newOE.batchSize)
+	return newOE, nil
+}
+
+/************************************************************
+* batch callbacks
+************************************************************/
+
+func (oe *otlpExporter) append(log *model.CommonLogWrapper) bool {
+	oe.records = append(oe.records, newOTLPRecord(log, time.Now()))
+	// record does not reference log anymore
+	if oe.releaseFunc != nil {
+		oe.releaseFunc(log)
+	}
+	return true
+}
+
+func (oe *otlpExporter) reset() {
+	oe.records = oe.records[:0]
+}
+
+func (oe *otlpExporter) send() (retry bool, err error) {
+	var body []byte
+
+	// encode request
+	switch oe.encoding {
+	case "json":
+		body, err = encodeOTLPJSON(oe.resource, oe.records)
+		if err != nil {
+			return false, err
+		}
+	default:
+		body = encodeOTLPProtobuf(oe.resource, oe.records)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, oe.info.Destination, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", oe.contentType)
+	for key, val := range oe.headers {
+		request.Header.Set(key, val)
+	}
+	response, err := oe.client.Do(request)
+	if err != nil {
+		// network error is retryable
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode == http.StatusTooManyRequests,
+		response.StatusCode == http.StatusBadGateway,
+		response.StatusCode == http.StatusServiceUnavailable,
+		response.StatusCode == http.StatusGatewayTimeout:
+		// retryable response of OTLP/HTTP
+		return true, fmt.Errorf("collector returns %s", response.Status)
+	default:
+		return false, fmt.Errorf("collector returns %s", response.Status)
+	}
+}
@@ -0,0 +1,358 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"polvo/compose"
+	"polvo/service/model"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// otlpScopeName is the instrumentation scope of every log record exported by polvo.
+const otlpScopeName = "polvo"
+
+// # otlpKeyValue
+//
+// otlpKeyValue is the KeyValue message of opentelemetry-proto.
+// Value is one of string, bool, int64, float64, []interface{} (ArrayValue) and []otlpKeyValue (KeyValueList).
+type otlpKeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// # otlpRecord
+//
+// otlpRecord is the LogRecord message of opentelemetry-proto converted from CommonLogWrapper.
+type otlpRecord struct {
+	TimeUnixNano         uint64
+	ObservedTimeUnixNano uint64
+	EventName            string
+	Body                 string
+	Attributes           []otlpKeyValue
+}
+
+// newOTLPRecord converts log into otlpRecord.
+// eventname & source are set as attributes and metadata is flattened into attributes with dotted keys.
+// If timestamp of log is not RFC3339, time_unix_nano is left unset.
+func newOTLPRecord(log *model.CommonLogWrapper, observed time.Time) otlpRecord {
+	record := otlpRecord{
+		ObservedTimeUnixNano: uint64(observed.UnixNano()),
+		EventName:            log.EventName,
+		Body:                 log.Log,
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, log.Timestmp); err == nil {
+		record.TimeUnixNano = uint64(ts.UnixNano())
+	}
+	record.Attributes = append(record.Attributes,
+		otlpKeyValue{Key: "eventname", Value: log.EventName},
+		otlpKeyValue{Key: "source", Value: log.Source},
+	)
+	switch metadata := log.MetaData.(type) {
+	case nil:
+	case map[string]interface{}:
+		record.Attributes = flattenOTLPAttributes("", metadata, record.Attributes)
+	default:
+		record.Attributes = append(record.Attributes, otlpKeyValue{Key: "metadata", Value: toOTLPValue(metadata)})
+	}
+	return record
+}
+
+// newOTLPResource returns resource attributes of the service.
+func newOTLPResource(service *compose.Service) []otlpKeyValue {
+	resource := []otlpKeyValue{{Key: "service.name", Value: "polvo"}}
+	if service == nil {
+		return resource
+	}
+	return append(resource,
+		otlpKeyValue{Key: "host.name", Value: service.Machine},
+		otlpKeyValue{Key: "host.arch", Value: service.Arch},
+		otlpKeyValue{Key: "os.type", Value: service.OS},
+		otlpKeyValue{Key: "polvo.group", Value: service.Group},
+	)
+}
+
+// flattenOTLPAttributes appends nested maps as dotted keys in sorted order.
+// Metadata keys colliding with header attributes are prefixed with "metadata.".
+func flattenOTLPAttributes(prefix string, metadata map[string]interface{}, out []otlpKeyValue) []otlpKeyValue {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fullKey := key
+		if prefix != "" {
+			fullKey = prefix + "." + key
+		} else if key == "eventname" || key == "source" {
+			fullKey = "metadata." + key
+		}
+		if nested, ok := metadata[key].(map[string]interface{}); ok {
+			out = flattenOTLPAttributes(fullKey, nested, out)
+			continue
+		}
+		out = append(out, otlpKeyValue{Key: fullKey, Value: toOTLPValue(metadata[key])})
+	}
+	return out
+}
+
+// toOTLPValue converts a json decoded value into AnyValue.
+// Integral numbers are converted into int64 because json decodes every number as float64.
+func toOTLPValue(val interface{}) interface{} {
+	switch val := val.(type) {
+	case nil:
+		return ""
+	case string, bool, int64:
+		return val
+	case int:
+		return int64(val)
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int64(val)
+		}
+		return val
+	case []interface{}:
+		values := make([]interface{}, 0, len(val))
+		for _, v := range val {
+			values = append(values, toOTLPValue(v))
+		}
+		return values
+	case []string:
+		values := make([]interface{}, 0, len(val))
+		for _, v := range val {
+			values = append(values, v)
+		}
+		return values
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		kvs := make([]otlpKeyValue, 0, len(val))
+		for _, key := range keys {
+			kvs = append(kvs, otlpKeyValue{Key: key, Value: toOTLPValue(val[key])})
+		}
+		return kvs
+	default:
+		out, _ := json.Marshal(val)
+		return string(out)
+	}
+}
+
+/************************************************************
+* protobuf encoding
+************************************************************/
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type protoBuffer []byte
+
+func (pb *protoBuffer) appendTag(field int, wireType int) {
+	*pb = binary.AppendUvarint(*pb, uint64(field<<3|wireType))
+}
+
+func (pb *protoBuffer) appendVarint(field int, v uint64) {
+	pb.appendTag(field, wireVarint)
+	*pb = binary.AppendUvarint(*pb, v)
+}
+
+func (pb *protoBuffer) appendFixed64(field int, v uint64) {
+	pb.appendTag(field, wireFixed64)
+	*pb = binary.LittleEndian.AppendUint64(*pb, v)
+}
+
+func (pb *protoBuffer) appendBytes(field int, b []byte) {
+	pb.appendTag(field, wireBytes)
+	*pb = binary.AppendUvarint(*pb, uint64(len(b)))
+	*pb = append(*pb, b...)
+}
+
+func (pb *protoBuffer) appendString(field int, s string) {
+	pb.appendTag(field, wireBytes)
+	*pb = binary.AppendUvarint(*pb, uint64(len(s)))
+	*pb = append(*pb, s...)
+}
+
+// appendMessage encodes the embedded message written by encode.
+func (pb *protoBuffer) appendMessage(field int, encode func(*protoBuffer)) {
+	var child protoBuffer
+	encode(&child)
+	pb.appendBytes(field, child)
+}
+
+// AnyValue
+func (pb *protoBuffer) appendAnyValue(val interface{}) {
+	switch val := val.(type) {
+	case string:
+		pb.appendString(1, val)
+	case bool:
+		b := uint64(0)
+		if val {
+			b = 1
+		}
+		pb.appendVarint(2, b)
+	case int64:
+		pb.appendVarint(3, uint64(val))
+	case float64:
+		pb.appendFixed64(4, math.Float64bits(val))
+	case []interface{}:
+		pb.appendMessage(5, func(array *protoBuffer) {
+			for _, v := range val {
+				array.appendMessage(1, func(value *protoBuffer) { value.appendAnyValue(v) })
+			}
+		})
+	case []otlpKeyValue:
+		pb.appendMessage(6, func(list *protoBuffer) {
+			for _, kv := range val {
+				list.appendMessage(1, func(child *protoBuffer) { child.appendKeyValue(kv) })
+			}
+		})
+	}
+}
+
+// KeyValue
+func (pb *protoBuffer) appendKeyValue(kv otlpKeyValue) {
+	pb.appendString(1, kv.Key)
+	pb.appendMessage(2, func(value *protoBuffer) { value.appendAnyValue(kv.Value) })
+}
+
+// encodeOTLPProtobuf encodes ExportLogsServiceRequest with a single resource & scope.
+func encodeOTLPProtobuf(resource []otlpKeyValue, records []otlpRecord) []byte {
+	var request protoBuffer
+
+	// ExportLogsServiceRequest.resource_logs
+	request.appendMessage(1, func(resourceLogs *protoBuffer) {
+		// ResourceLogs.resource
+		resourceLogs.appendMessage(1, func(res *protoBuffer) {
+			for _, kv := range resource {
+				res.appendMessage(1, func(child *protoBuffer) { child.appendKeyValue(kv) })
+			}
+		})
+		// ResourceLogs.scope_logs
+		resourceLogs.appendMessage(2, func(scopeLogs *protoBuffer) {
+			// ScopeLogs.scope
+			scopeLogs.appendMessage(1, func(scope *protoBuffer) {
+				scope.appendString(1, otlpScopeName)
+			})
+			// ScopeLogs.log_records
+			for _, record := range records {
+				scopeLogs.appendMessage(2, func(lr *protoBuffer) {
+					if record.TimeUnixNano != 0 {
+						lr.appendFixed64(1, record.TimeUnixNano)
+					}
+					lr.appendMessage(5, func(body *protoBuffer) { body.appendAnyValue(record.Body) })
+					for _, kv := range record.Attributes {
+						lr.appendMessage(6, func(child *protoBuffer) { child.appendKeyValue(kv) })
+					}
+					lr.appendFixed64(11, record.ObservedTimeUnixNano)
+					if record.EventName != "" {
+						lr.appendString(12, record.EventName)
+					}
+				})
+			}
+		})
+	})
+	return request
+}
+
+/************************************************************
+* JSON encoding
+************************************************************/
+
+// OTLP/JSON uses lowerCamelCase field names & encodes 64 bit integers as strings.
+
+type otlpJSONKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpJSONRecord struct {
+	TimeUnixNano         string                 `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string                 `json:"observedTimeUnixNano"`
+	Body                 map[string]interface{} `json:"body"`
+	Attributes           []otlpJSONKeyValue     `json:"attributes"`
+	EventName            string                 `json:"eventName,omitempty"`
+}
+
+type otlpJSONScopeLogs struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	LogRecords []otlpJSONRecord `json:"logRecords"`
+}
+
+type otlpJSONResourceLogs struct {
+	Resource struct {
+		Attributes []otlpJSONKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []otlpJSONScopeLogs `json:"scopeLogs"`
+}
+
+type otlpJSONRequest struct {
+	ResourceLogs []otlpJSONResourceLogs `json:"resourceLogs"`
+}
+
+func toOTLPJSONValue(val interface{}) map[string]interface{} {
+	switch val := val.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": val}
+	case bool:
+		return map[string]interface{}{"boolValue": val}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": val}
+	case []interface{}:
+		values := make([]map[string]interface{}, 0, len(val))
+		for _, v := range val {
+			values = append(values, toOTLPJSONValue(v))
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case []otlpKeyValue:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": toOTLPJSONAttributes(val)}}
+	}
+	return map[string]interface{}{}
+}
+
+func toOTLPJSONAttributes(kvs []otlpKeyValue) []otlpJSONKeyValue {
+	ret := make([]otlpJSONKeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		ret = append(ret, otlpJSONKeyValue{Key: kv.Key, Value: toOTLPJSONValue(kv.Value)})
+	}
+	return ret
+}
+
+// encodeOTLPJSON encodes ExportLogsServiceRequest in OTLP/JSON with a single resource & scope.
+func encodeOTLPJSON(resource []otlpKeyValue, records []otlpRecord) ([]byte, error) {
+	var request otlpJSONRequest
+
+	request.ResourceLogs = make([]otlpJSONResourceLogs, 1)
+	resourceLogs := &request.ResourceLogs[0]
+	resourceLogs.Resource.Attributes = toOTLPJSONAttributes(resource)
+	resourceLogs.ScopeLogs = make([]otlpJSONScopeLogs, 1)
+	scopeLogs := &resourceLogs.ScopeLogs[0]
+	scopeLogs.Scope.Name = otlpScopeName
+	scopeLogs.LogRecords = make([]otlpJSONRecord, 0, len(records))
+	for _, record := range records {
+		jsonRecord := otlpJSONRecord{
+			ObservedTimeUnixNano: strconv.FormatUint(record.ObservedTimeUnixNano, 10),
+			Body:                 toOTLPJSONValue(record.Body),
+			Attributes:           toOTLPJSONAttributes(record.Attributes),
+			EventName:            record.EventName,
+		}
+		if record.TimeUnixNano != 0 {
+			jsonRecord.TimeUnixNano = strconv.FormatUint(record.TimeUnixNano, 10)
+		}
+		scopeLogs.LogRecords = append(scopeLogs.LogRecords, jsonRecord)
+	}
+	return json.Marshal(&request)
+}
@@ -0,0 +1,204 @@
+package exporter_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"polvo/compose"
+	"polvo/exporter"
+	"polvo/service/model"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const sampleCommonLog = `
+{
+	"eventname": "bashReadline",
+	"source": "eBPF",
+	"timestamp": "2025-03-11T15:29:34+09:00",
+	"log": "A user has entered a command in the bash shell",
+	"metadata": {
+		"Commandline": "echo hello world",
+		"PID": 191998,
+		"process": {"parent": {"exe": "/bin/bash"}}
+	}
+}
+`
+
+func newCommonLog(t *testing.T) *model.CommonLogWrapper {
+	log := new(model.CommonLogWrapper)
+	if err := json.Unmarshal([]byte(sampleCommonLog), log); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = %v, want nil", sampleCommonLog, err)
+	}
+	return log
+}
+
+func newOTLPExporter(t *testing.T, destination string, options string) exporter.Exporter[model.CommonLogWrapper] {
+	info := &compose.ExporterInfo{
+		Name:        "otel",
+		Mode:        "otlp",
+		Destination: destination,
+		Timeout:     1,
+		Options:     optionsNode(t, options),
+	}
+	service := &compose.Service{Machine: "host1", OS: "linux", Arch: "amd64", Group: "soc"}
+	exp, err := exporter.New(info, service, marshalCommon, func(*model.CommonLogWrapper) {}, loger)
+	if err != nil {
+		t.Fatalf("exporter.New(otlp) = %v, want nil", err)
+	}
+	return exp
+}
+
+func TestOTLPExporterJSON(t *testing.T) {
+	requests := make(chan map[string]interface{}, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %s, want application/json", r.Header.Get("Content-Type"))
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("error while decode request %v", err)
+		}
+		requests <- body
+	}))
+	defer collector.Close()
+
+	exp := newOTLPExporter(t, collector.URL+"/v1/logs", "{encoding: json, batch_size: 2}")
+	exp.Start()
+	exp.LogChannel() <- newCommonLog(t)
+	exp.LogChannel() <- newCommonLog(t)
+
+	var body map[string]interface{}
+	select {
+	case body = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector did not receive a request")
+	}
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+
+	resourceLogs := body["resourceLogs"].([]interface{})[0].(map[string]interface{})
+	resource := attributesOf(resourceLogs["resource"].(map[string]interface{}))
+	if resource["host.name"] != "host1" || resource["polvo.group"] != "soc" {
+		t.Errorf("resource attributes = %v, want host.name & polvo.group", resource)
+	}
+	records := resourceLogs["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{})
+	if len(records) != 2 {
+		t.Fatalf("len(logRecords) = %d, want 2", len(records))
+	}
+	record := records[0].(map[string]interface{})
+	want := time.Date(2025, 3, 11, 6, 29, 34, 0, time.UTC).UnixNano()
+	if record["timeUnixNano"] != strconv.FormatInt(want, 10) {
+		t.Errorf("timeUnixNano = %v, want %d", record["timeUnixNano"], want)
+	}
+	attributes := attributesOf(record)
+	if attributes["eventname"] != "bashReadline" || attributes["source"] != "eBPF" {
+		t.Errorf("attributes = %v, want eventname & source", attributes)
+	}
+	if attributes["process.parent.exe"] != "/bin/bash" {
+		t.Errorf("attributes[process.parent.exe] = %v, want /bin/bash", attributes["process.parent.exe"])
+	}
+	if attributes["PID"] != "191998" {
+		t.Errorf("attributes[PID] = %v, want intValue 191998", attributes["PID"])
+	}
+}
+
+func TestOTLPExporterProtobufRetry(t *testing.T) {
+	var calls int32
+	bodies := make(chan []byte, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// first request is rejected with retryable status
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("Content-Type = %s, want application/x-protobuf", r.Header.Get("Content-Type"))
+		}
+		bodies <- body
+	}))
+	defer collector.Close()
+
+	exp := newOTLPExporter(t, collector.URL+"/v1/logs", "{batch_size: 1}")
+	exp.Start()
+	exp.LogChannel() <- newCommonLog(t)
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector did not receive a retried request")
+	}
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+
+	// ExportLogsServiceRequest.resource_logs[0].scope_logs[0].log_records[0]
+	resourceLogs := protoFields(t, body)[1][0].([]byte)
+	scopeLogs := protoFields(t, resourceLogs)[2][0].([]byte)
+	records := protoFields(t, scopeLogs)[2]
+	if len(records) != 1 {
+		t.Fatalf("len(log_records) = %d, want 1", len(records))
+	}
+	record := protoFields(t, records[0].([]byte))
+	want := uint64(time.Date(2025, 3, 11, 6, 29, 34, 0, time.UTC).UnixNano())
+	if record[1][0].(uint64) != want {
+		t.Errorf("time_unix_nano = %v, want %d", record[1][0], want)
+	}
+	if string(record[12][0].([]byte)) != "bashReadline" {
+		t.Errorf("event_name = %s, want bashReadline", record[12][0])
+	}
+}
+
+// attributesOf returns string & int attributes of an OTLP/JSON message.
+func attributesOf(message map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, attr := range message["attributes"].([]interface{}) {
+		kv := attr.(map[string]interface{})
+		value := kv["value"].(map[string]interface{})
+		if v, ok := value["stringValue"]; ok {
+			ret[kv["key"].(string)] = v
+		}
+		if v, ok := value["intValue"]; ok {
+			ret[kv["key"].(string)] = v
+		}
+	}
+	return ret
+}
+
+// protoFields decodes a protobuf message into field number -> values.
+// varint & fixed64 fields are decoded as uint64 and length-delimited fields as []byte.
+func protoFields(t *testing.T, message []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			t.Fatalf("invalid protobuf tag")
+		}
+		message = message[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(message)
+			message = message[n:]
+			fields[field] = append(fields[field], v)
+		case 1:
+			fields[field] = append(fields[field], binary.LittleEndian.Uint64(message))
+			message = message[8:]
+		case 2:
+			size, n := binary.Uvarint(message)
+			message = message[n:]
+			fields[field] = append(fields[field], message[:size])
+			message = message[size:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,318 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"polvo/compose"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/service/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// # queueExporter
+//
+// queueExporter puts a disk-backed queue in front of another exporter.
+// It is created by New when the exporter has a queue section in the compose file.
+//
+// - received logs are serialized into the queue, so a slow or unavailable destination does not stall the pipeline.
+//
+// - a log is removed from the queue after the wrapped exporter delivers it. Exporters which do not implement acknowledger
+// are regarded to deliver a log when it is handed over.
+//
+// - logs left in the queue when the agent stops are replayed on the next start.
+// It includes logs which the wrapped exporter dropped while stopping, e.g. destination is down.
+type queueExporter struct {
+	// dependency injection
+	logger      plogger.PolvoLogger
+	exporter    Exporter[model.CommonLogWrapper]
+	releaseFunc func(*model.CommonLogWrapper)
+	// fields
+	exporterName  string
+	queue         *diskQueue
+	fsyncInterval time.Duration
+	// unacked is the tokens of logs handed over to an acknowledger & not acked yet, in order
+	isAcknowledger bool
+	unacked        []unackedToken
+	unackedLock    sync.Mutex
+	errorRecorder
+	// stream
+	logChannel chan *model.CommonLogWrapper
+	// thread control
+	ctx        context.Context
+	cancel     context.CancelFunc
+	enqueueGrp sync.WaitGroup
+	dequeueGrp sync.WaitGroup
+	// conditional variable
+	isClosed  int32
+	isStarted int32
+}
+
+// # acknowledger
+//
+// acknowledger is implemented by exporters which report when received logs are finished.
+// ackFunc is called with the number of logs received since the last call, after they are delivered or dropped for good.
+// Logs which are not delivered when the exporter is stopped are not reported. setAckFunc must be called before Start.
+type acknowledger interface {
+	setAckFunc(ackFunc func(count int))
+}
+
+// unackedToken is a token of log handed over to the wrapped exporter.
+// skipped is true for records dropped by the queue. They are acked together with the logs handed over before them.
+type unackedToken struct {
+	token   queueToken
+	skipped bool
+}
+
+func NewQueueExporter(info *compose.ExporterInfo,
+	exporter Exporter[model.CommonLogWrapper],
+	releaseFunc func(*model.CommonLogWrapper),
+	logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+	var err error
+
+	newQE := new(queueExporter)
+	// dependency injection
+	newQE.logger = logger
+	newQE.exporter = exporter
+	newQE.releaseFunc = releaseFunc
+	if acker, ok := exporter.(acknowledger); ok {
+		acker.setAckFunc(newQE.ackLogs)
+		newQE.isAcknowledger = true
+	}
+	// open queue
+	newQE.exporterName = info.Name
+	newQE.queue, err = openDiskQueue(info.Name, *info.Queue, logger)
+	if err != nil {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrExporterCreate,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while open queue of exporter[%s]", info.Name),
+		}
+	}
+	newQE.fsyncInterval = time.Duration(info.Queue.FsyncInterval) * time.Millisecond
+	if newQE.fsyncInterval == 0 {
+		newQE.fsyncInterval = defaultQueueFsyncInterval * time.Millisecond
+	}
+	// context
+	newQE.ctx, newQE.cancel = context.WithCancel(context.Background())
+	// init pipeline
+	newQE.logChannel = make(chan *model.CommonLogWrapper)
+	// set conditional variable to 0
+	atomic.StoreInt32(&newQE.isClosed, 0)
+	atomic.StoreInt32(&newQE.isStarted, 0)
+	return newQE, nil
+}
+
+/************************************************************
+* Getter & Setter
+************************************************************/
+
+func (qe *queueExporter) Name() string {
+	return qe.exporterName
+}
+
+func (qe *queueExporter) LogChannel() chan<- *model.CommonLogWrapper {
+	return qe.logChannel
+}
+
+// LastError returns the last error of the queue or the wrapped exporter, whichever is later.
+func (qe *queueExporter) LastError() (string, time.Time) {
+	msg, at := qe.errorRecorder.LastError()
+	if reporter, ok := qe.exporter.(ErrorReporter); ok {
+		if wrappedMsg, wrappedAt := reporter.LastError(); wrappedAt.After(at) {
+			return wrappedMsg, wrappedAt
+		}
+	}
+	return msg, at
+}
+
+// QueueStats returns the accounting of the queue.
+func (qe *queueExporter) QueueStats() QueueStats {
+	return qe.queue.Stats()
+}
+
+/************************************************************
+* Methods
+************************************************************/
+
+func (qe *queueExporter) Start() {
+	qe.exporter.Start()
+	qe.enqueueGrp.Add(1)
+	go qe.enqueueThread()
+	qe.dequeueGrp.Add(2)
+	go qe.dequeueThread()
+	go qe.syncThread()
+	// set conditional variable to 1
+	atomic.StoreInt32(&qe.isStarted, 1)
+}
+
+func (qe *queueExporter) Stop() error {
+	// prevent double close
+	if atomic.LoadInt32(&qe.isClosed) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is already closed"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Close()", qe.exporterName),
+		}
+	}
+	// prevent call Stop() before Start()
+	if atomic.LoadInt32(&qe.isStarted) <= 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is not started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Close()", qe.exporterName),
+		}
+	}
+	// stop receiving & wait for received logs to be queued
+	qe.cancel()
+	qe.enqueueGrp.Wait()
+	close(qe.logChannel)
+	// stop handing over. the wrapped exporter acks logs delivered while stopping.
+	qe.queue.stopPop()
+	qe.dequeueGrp.Wait()
+	stopErr := qe.exporter.Stop()
+	// logs which are not delivered stay in the queue
+	if err := qe.queue.close(); err != nil {
+		qe.logger.PrintError("exporter [%s]: error while close queue. %s", qe.exporterName, err.Error())
+	}
+	// set conditional variable to 1
+	atomic.StoreInt32(&qe.isClosed, 1)
+	return stopErr
+}
+
+func (qe *queueExporter) Wait() {
+	qe.enqueueGrp.Wait()
+	qe.dequeueGrp.Wait()
+	qe.exporter.Wait()
+}
+
+/************************************************************
+* goroutines & private methods
+************************************************************/
+
+// enqueueThread serializes received logs into the queue.
+func (qe *queueExporter) enqueueThread() {
+	defer qe.enqueueGrp.Done()
+
+	for {
+		select {
+		case <-qe.ctx.Done():
+			return
+		case logWrapper := <-qe.logChannel:
+			payload, err := json.Marshal(logWrapper)
+			// queue does not reference log anymore
+			if qe.releaseFunc != nil {
+				qe.releaseFunc(logWrapper)
+			}
+			if err != nil {
+				qe.record(err)
+				qe.logger.PrintError("exporter [%s]: error while serialize log. %s", qe.exporterName, err.Error())
+				continue
+			}
+			if err = qe.queue.push(payload); err != nil {
+				qe.record(err)
+				qe.logger.PrintError("exporter [%s]: error while push log to queue. %s", qe.exporterName, err.Error())
+			}
+		}
+	}
+}
+
+// dequeueThread hands logs in the queue over to the wrapped exporter.
+func (qe *queueExporter) dequeueThread() {
+	defer qe.dequeueGrp.Done()
+
+	for {
+		payload, token, err := qe.queue.pop()
+		if err != nil {
+			if err != errQueueClosed {
+				qe.record(err)
+				qe.logger.PrintError("exporter [%s]: error while pop log from queue. %s", qe.exporterName, err.Error())
+			}
+			return
+		}
+		// the wrapped exporter releases the only reference of the log
+		logWrapper := &model.CommonLogWrapper{RefCount: 1}
+		if err = json.Unmarshal(payload, logWrapper); err != nil {
+			qe.logger.PrintError("exporter [%s]: log in queue is dropped. %s", qe.exporterName, err.Error())
+			qe.queue.drop(token)
+			qe.skip(token)
+			continue
+		}
+		// token is added before the log is handed over, so the exporter can ack the log at once
+		if qe.isAcknowledger {
+			qe.unackedLock.Lock()
+			qe.unacked = append(qe.unacked, unackedToken{token: token})
+			qe.unackedLock.Unlock()
+		}
+		select {
+		case <-qe.ctx.Done():
+			return
+		case qe.exporter.LogChannel() <- logWrapper:
+			if !qe.isAcknowledger {
+				qe.ack(token)
+			}
+		}
+	}
+}
+
+// ackLogs acks the oldest count logs handed over to the wrapped exporter & records skipped after them.
+// It is the ackFunc of acknowledger.
+func (qe *queueExporter) ackLogs(count int) {
+	qe.unackedLock.Lock()
+	count = min(count, len(qe.unacked))
+	if count <= 0 {
+		qe.unackedLock.Unlock()
+		return
+	}
+	last := qe.unacked[count-1].token
+	qe.unacked = qe.unacked[count:]
+	for len(qe.unacked) > 0 && qe.unacked[0].skipped {
+		last = qe.unacked[0].token
+		qe.unacked = qe.unacked[1:]
+	}
+	qe.unackedLock.Unlock()
+	qe.ack(last)
+}
+
+// skip acks the record of token which is not handed over. It waits for logs handed over before it.
+func (qe *queueExporter) skip(token queueToken) {
+	qe.unackedLock.Lock()
+	if len(qe.unacked) > 0 {
+		qe.unacked = append(qe.unacked, unackedToken{token: token, skipped: true})
+		qe.unackedLock.Unlock()
+		return
+	}
+	qe.unackedLock.Unlock()
+	qe.ack(token)
+}
+
+func (qe *queueExporter) ack(token queueToken) {
+	if err := qe.queue.ack(token); err != nil {
+		qe.record(err)
+		qe.logger.PrintError("exporter [%s]: error while save queue cursor. %s", qe.exporterName, err.Error())
+	}
+}
+
+// syncThread flushes the queue periodically.
+func (qe *queueExporter) syncThread() {
+	defer qe.dequeueGrp.Done()
+
+	ticker := time.NewTicker(qe.fsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-qe.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := qe.queue.sync(); err != nil {
+				qe.record(err)
+				qe.logger.PrintError("exporter [%s]: error while sync queue. %s", qe.exporterName, err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package exporter_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"polvo/compose"
+	"polvo/exporter"
+	plogger "polvo/logger"
+	"polvo/service/model"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubExporter never reads its log channel. The test reads it instead.
+// destination "blocked" makes the channel unbuffered, so the queue can not hand over any log.
+type stubExporter struct {
+	name       string
+	logChannel chan *model.CommonLogWrapper
+}
+
+func (s *stubExporter) Name() string                               { return s.name }
+func (s *stubExporter) LogChannel() chan<- *model.CommonLogWrapper { return s.logChannel }
+func (s *stubExporter) Start()                                     {}
+func (s *stubExporter) Wait()                                      {}
+func (s *stubExporter) Stop() error                                { return nil }
+
+var lastStub *stubExporter
+
+func init() {
+	exporter.Register("stub", func(info *compose.ExporterInfo,
+		_ *compose.Service,
+		_ func(*model.CommonLogWrapper) ([]byte, error),
+		_ func(*model.CommonLogWrapper),
+		_ plogger.PolvoLogger) (exporter.Exporter[model.CommonLogWrapper], error) {
+
+		lastStub = &stubExporter{name: info.Name, logChannel: make(chan *model.CommonLogWrapper, 64)}
+		if info.Destination == "blocked" {
+			lastStub.logChannel = make(chan *model.CommonLogWrapper)
+		}
+		return lastStub, nil
+	})
+}
+
+type queueStatser interface {
+	QueueStats() exporter.QueueStats
+}
+
+func newQueueExporter(t *testing.T, destination string, queue compose.QueueInfo) exporter.Exporter[model.CommonLogWrapper] {
+	info := &compose.ExporterInfo{
+		Name:        "queued",
+		Mode:        "stub",
+		Destination: destination,
+		Timeout:     1,
+		Queue:       &queue,
+	}
+	exp, err := exporter.New(info, nil, marshalCommon, nil, loger)
+	if err != nil {
+		t.Fatalf("exporter.New(queue) = %v, want nil", err)
+	}
+	return exp
+}
+
+func sendIndexedLogs(t *testing.T, exp exporter.Exporter[model.CommonLogWrapper], from int, to int) {
+	for i := from; i < to; i++ {
+		log := newCommonLog(t)
+		log.MetaData.(map[string]interface{})["PID"] = float64(i)
+		exp.LogChannel() <- log
+	}
+}
+
+func receivePIDs(t *testing.T, count int) []int {
+	ret := make([]int, 0, count)
+	for len(ret) < count {
+		select {
+		case log := <-lastStub.logChannel:
+			ret = append(ret, int(log.MetaData.(map[string]interface{})["PID"].(float64)))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d logs from queue, want %d", len(ret), count)
+		}
+	}
+	return ret
+}
+
+func waitQueued(t *testing.T, exp exporter.Exporter[model.CommonLogWrapper], want int64) exporter.QueueStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := exp.(queueStatser).QueueStats()
+		if stats.Queued == want || time.Now().After(deadline) {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueueExporterReplay(t *testing.T) {
+	queue := compose.QueueInfo{Path: t.TempDir(), Fsync: "always"}
+
+	// destination is down. logs stay in the queue.
+	exp := newQueueExporter(t, "blocked", queue)
+	exp.Start()
+	sendIndexedLogs(t, exp, 0, 10)
+	if stats := waitQueued(t, exp, 10); stats.Queued != 10 {
+		t.Errorf("stats.Queued = %d, want 10", stats.Queued)
+	}
+	if err := exp.Stop(); err != nil {
+		t.Fatalf("error while stop exporter %v", err)
+	}
+
+	// restart. queued logs are replayed in order.
+	exp = newQueueExporter(t, "collect", queue)
+	exp.Start()
+	sendIndexedLogs(t, exp, 10, 12)
+	pids := receivePIDs(t, 12)
+	for i, pid := range pids {
+		if pid != i {
+			t.Fatalf("replayed PIDs = %v, want 0..11 in order", pids)
+		}
+	}
+	if stats := waitQueued(t, exp, 0); stats.Queued != 0 {
+		t.Errorf("stats.Queued = %d, want 0", stats.Queued)
+	}
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+}
+
+func TestQueueExporterEvictOldest(t *testing.T) {
+	// each record is about 250 bytes. segments hold 4 records & queue holds 8 records.
+	queue := compose.QueueInfo{Path: t.TempDir(), MaxBytes: 2000, SegmentBytes: 1000, Fsync: "never"}
+
+	exp := newQueueExporter(t, "blocked", queue)
+	exp.Start()
+	sendIndexedLogs(t, exp, 0, 20)
+	// wait for the last log to be queued
+	var stats exporter.QueueStats
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if stats = exp.(queueStatser).QueueStats(); stats.Queued+int64(stats.Evicted) == 20 {
+			break
+		}
+	}
+	if stats.Evicted == 0 {
+		t.Errorf("stats.Evicted = 0, want > 0")
+	}
+	if stats.Bytes > queue.MaxBytes {
+		t.Errorf("stats.Bytes = %d, want <= %d", stats.Bytes, queue.MaxBytes)
+	}
+	if err := exp.Stop(); err != nil {
+		t.Fatalf("error while stop exporter %v", err)
+	}
+
+	// only the newest logs survive
+	exp = newQueueExporter(t, "collect", queue)
+	exp.Start()
+	remains := int(20 - stats.Evicted)
+	pids := receivePIDs(t, remains)
+	if pids[len(pids)-1] != 19 || pids[0] != 20-remains {
+		t.Errorf("replayed PIDs = %v, want the newest %d logs", pids, remains)
+	}
+	if err := exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+}
+
+// stopQueueExporter stops exp after count logs are queued.
+func stopQueueExporter(t *testing.T, exp exporter.Exporter[model.CommonLogWrapper], count int64) {
+	if stats := waitQueued(t, exp, count); stats.Queued != count {
+		t.Errorf("stats.Queued = %d, want %d", stats.Queued, count)
+	}
+	if err := exp.Stop(); err != nil {
+		t.Fatalf("error while stop exporter %v", err)
+	}
+}
+
+func TestQueueExporterCursorSkipsAckedSegments(t *testing.T) {
+	queue := compose.QueueInfo{Path: t.TempDir(), Fsync: "always"}
+
+	// each run writes a new segment
+	for run := 0; run < 2; run++ {
+		exp := newQueueExporter(t, "blocked", queue)
+		exp.Start()
+		sendIndexedLogs(t, exp, run*5, run*5+5)
+		stopQueueExporter(t, exp, int64(run*5+5))
+	}
+	segments, err := filepath.Glob(filepath.Join(queue.Path, "*.seg"))
+	if err != nil || len(segments) < 2 {
+		t.Fatalf("segments = %v, want 2 segments at least", segments)
+	}
+	// cursor at the start of the second segment, as if the process stopped before the first segment was removed
+	var cursor [28]byte
+	id, _ := strconv.ParseUint(strings.TrimSuffix(filepath.Base(segments[1]), ".seg"), 10, 64)
+	binary.BigEndian.PutUint64(cursor[:], id)
+	binary.BigEndian.PutUint32(cursor[24:], crc32.Checksum(cursor[:24], crc32.MakeTable(crc32.Castagnoli)))
+	if err = os.WriteFile(filepath.Join(queue.Path, "cursor"), cursor[:], 0o600); err != nil {
+		t.Fatalf("error while write cursor %v", err)
+	}
+
+	exp := newQueueExporter(t, "collect", queue)
+	if stats := exp.(queueStatser).QueueStats(); stats.Queued != 5 {
+		t.Errorf("stats.Queued = %d, want 5 logs of the second segment", stats.Queued)
+	}
+	exp.Start()
+	if pids := receivePIDs(t, 5); pids[0] != 5 {
+		t.Errorf("replayed PIDs = %v, want 5..9", pids)
+	}
+	stopQueueExporter(t, exp, 0)
+}
+
+func TestQueueExporterCorruptedRecordLength(t *testing.T) {
+	queue := compose.QueueInfo{Path: t.TempDir(), Fsync: "always"}
+
+	exp := newQueueExporter(t, "blocked", queue)
+	exp.Start()
+	sendIndexedLogs(t, exp, 0, 3)
+	stopQueueExporter(t, exp, 3)
+
+	// torn header claiming a record of almost 4GiB
+	segments, err := filepath.Glob(filepath.Join(queue.Path, "*.seg"))
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("segments = %v, want a segment", segments)
+	}
+	file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatalf("error while open segment %v", err)
+	}
+	file.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0})
+	file.Close()
+
+	// the record is the end of the segment & cut off
+	exp = newQueueExporter(t, "collect", queue)
+	exp.Start()
+	if pids := receivePIDs(t, 3); pids[2] != 2 {
+		t.Errorf("replayed PIDs = %v, want 0..2", pids)
+	}
+	stopQueueExporter(t, exp, 0)
+}
+
+func newQueuedExporter(t *testing.T, mode string, destination string, options string, queue compose.QueueInfo) exporter.Exporter[model.CommonLogWrapper] {
+	info := &compose.ExporterInfo{
+		Name:        "queued",
+		Mode:        mode,
+		Destination: destination,
+		Timeout:     1,
+		Options:     optionsNode(t, options),
+		Queue:       &queue,
+	}
+	exp, err := exporter.New(info, nil, marshalCommon, nil, loger)
+	if err != nil {
+		t.Fatalf("exporter.New(%s) = %v, want nil", mode, err)
+	}
+	return exp
+}
+
+func expectPIDs(t *testing.T, pids chan int, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case pid := <-pids:
+			if pid != i {
+				t.Fatalf("delivered PID = %d, want %d", pid, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("delivered %d logs, want %d", i, count)
+		}
+	}
+}
+
+func TestQueueExporterReplaysLogsUndeliveredByNetworkExporter(t *testing.T) {
+	queue := compose.QueueInfo{Path: t.TempDir(), Fsync: "always"}
+	// destination is down
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error while listen %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	// the network exporter holds some logs while it reconnects, and drops them when the agent stops
+	exp := newQueuedExporter(t, "network", address, "{buffer_size: 4}", queue)
+	exp.Start()
+	sendIndexedLogs(t, exp, 0, 10)
+	time.Sleep(300 * time.Millisecond)
+	stopQueueExporter(t, exp, 10)
+
+	// destination is up after restart. every log is replayed.
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("error while listen %v", err)
+	}
+	defer listener.Close()
+	pids := make(chan int, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var log model.CommonLogWrapper
+			if json.Unmarshal(scanner.Bytes(), &log) == nil {
+				pids <- int(log.MetaData.(map[string]interface{})["PID"].(float64))
+			}
+		}
+	}()
+	exp = newQueuedExporter(t, "network", address, "{buffer_size: 4}", queue)
+	exp.Start()
+	expectPIDs(t, pids, 10)
+	stopQueueExporter(t, exp, 0)
+}
+
+func TestQueueExporterReplaysLogsUndeliveredByBatchExporter(t *testing.T) {
+	queue := compose.QueueInfo{Path: t.TempDir(), Fsync: "always"}
+	var isDown atomic.Bool
+	pids := make(chan int, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("error while decode request %v", err)
+			return
+		}
+		resourceLogs := body["resourceLogs"].([]interface{})[0].(map[string]interface{})
+		for _, record := range resourceLogs["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{}) {
+			pid, _ := strconv.Atoi(attributesOf(record.(map[string]interface{}))["PID"].(string))
+			pids <- pid
+		}
+	}))
+	defer collector.Close()
+
+	// the pending batch is retried while the collector is down, and dropped when the agent stops
+	isDown.Store(true)
+	exp := newQueuedExporter(t, "otlp", collector.URL+"/v1/logs", "{encoding: json, batch_size: 4}", queue)
+	exp.Start()
+	sendIndexedLogs(t, exp, 0, 10)
+	time.Sleep(300 * time.Millisecond)
+	stopQueueExporter(t, exp, 10)
+
+	// collector is up after restart. every log is replayed.
+	isDown.Store(false)
+	exp = newQueuedExporter(t, "otlp", collector.URL+"/v1/logs", "{encoding: json, batch_size: 2}", queue)
+	exp.Start()
+	expectPIDs(t, pids, 10)
+	stopQueueExporter(t, exp, 0)
+}
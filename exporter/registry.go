@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"fmt"
+	"polvo/compose"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/service/model"
+	"sort"
+	"sync"
+)
+
+// # Constructor
+//
+// Constructor creates an exporter from ExporterInfo.
+// Mode-specific options are decoded from info.Options by the constructor itself.
+//
+// wrapFunc serializes a log and returns it to the pool.
+// Exporters that read fields of the log without calling wrapFunc must call releaseFunc instead.
+type Constructor func(info *compose.ExporterInfo,
+	service *compose.Service,
+	wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+	releaseFunc func(*model.CommonLogWrapper),
+	logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Constructor)
+)
+
+// # Register
+//
+// Register adds the constructor of an exporter mode. It is usually called in the init function of the exporter implementation.
+// The mode & the validator of its destination are declared in compose.AvailableExporterMode.
+// So adding a new exporter mode does not need any change in the service package.
+func Register(mode string, constructor Constructor) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry[mode] = constructor
+}
+
+// # Modes
+//
+// Modes returns registered exporter modes in sorted order.
+func Modes() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	modes := make([]string, 0, len(registry))
+	for mode := range registry {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+// # New
+//
+// New creates an exporter with the constructor registered for info.Mode.
+// If info.Queue is set, the exporter is wrapped by a disk-backed queue.
+func New(info *compose.ExporterInfo,
+	service *compose.Service,
+	wrapFunc func(*model.CommonLogWrapper) ([]byte, error),
+	releaseFunc func(*model.CommonLogWrapper),
+	logger plogger.PolvoLogger) (Exporter[model.CommonLogWrapper], error) {
+
+	registryLock.RLock()
+	constructor, ok := registry[info.Mode]
+	registryLock.RUnlock()
+
+	if !ok {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrInvalidExporterName,
+			Origin: fmt.Errorf("exporter mode [%s] of exporter [%s] is not registered", info.Mode, info.Name),
+			Msg:    "error while construct new exporter",
+		}
+	}
+	exporter, err := constructor(info, service, wrapFunc, releaseFunc, logger)
+	if err != nil || info.Queue == nil {
+		return exporter, err
+	}
+	return NewQueueExporter(info, exporter, releaseFunc, logger)
+}
+
+// decodeOptions decodes mode-specific options of info into out.
+func decodeOptions(info *compose.ExporterInfo, out interface{}) error {
+	err := info.DecodeOptions(out)
+	if err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrExporterCreate,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while decode options of exporter[%s]", info.Name),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package exporter_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"polvo/compose"
+	"polvo/exporter"
+	"polvo/service/model"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func marshalCommon(log *model.CommonLogWrapper) ([]byte, error) {
+	return json.Marshal(log)
+}
+
+func optionsNode(t *testing.T, options string) yaml.Node {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(options), &node); err != nil {
+		t.Fatalf("yaml.Unmarshal(%s) = %v, want nil", options, err)
+	}
+	// unwrap document node
+	return *node.Content[0]
+}
+
+func TestRegistryNewWithFreeName(t *testing.T) {
+	info := &compose.ExporterInfo{
+		Name:        "siem",
+		Mode:        "file",
+		Destination: filepath.Join(logpath, "siem.log"),
+		Timeout:     5,
+		Options:     optionsNode(t, "buffer_size: 4"),
+	}
+	exp, err := exporter.New(info, nil, marshalCommon, nil, loger)
+	if err != nil {
+		t.Fatalf("exporter.New(%s) = %v, want nil", info.Mode, err)
+	}
+	if exp.Name() != "siem" {
+		t.Errorf("exporter.Name() = %s, want siem", exp.Name())
+	}
+	exp.Start()
+	if err = exp.Stop(); err != nil {
+		t.Errorf("error while stop exporter %v", err)
+	}
+}
+
+func TestRegistryNewWithUnknownOption(t *testing.T) {
+	info := &compose.ExporterInfo{
+		Name:        "siem",
+		Mode:        "file",
+		Destination: filepath.Join(logpath, "siem.log"),
+		Timeout:     5,
+		Options:     optionsNode(t, "bufer_size: 4"),
+	}
+	_, err := exporter.New(info, nil, marshalCommon, nil, loger)
+	if err == nil {
+		t.Fatalf("exporter.New() = nil, want error for unknown option")
+	}
+	t.Logf("exporter.New() = %v", err)
+}
+
+func TestRegistryNewWithUnknownMode(t *testing.T) {
+	info := &compose.ExporterInfo{Name: "siem", Mode: "carrier-pigeon", Destination: "roof", Timeout: 5}
+	_, err := exporter.New(info, nil, marshalCommon, nil, loger)
+	if err == nil {
+		t.Fatalf("exporter.New() = nil, want error for unknown mode")
+	}
+	t.Logf("exporter.New() = %v", err)
+}
+
+func TestRegistryModes(t *testing.T) {
+	// every mode accepted by compose has a constructor
+	modes := exporter.Modes()
+	for want := range compose.AvailableExporterMode {
+		found := false
+		for _, mode := range modes {
+			found = found || mode == want
+		}
+		if !found {
+			t.Errorf("exporter.Modes() = %v, want %s registered", modes, want)
+		}
+	}
+}
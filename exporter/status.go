@@ -0,0 +1,36 @@
+package exporter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// # ErrorReporter
+//
+// ErrorReporter is implemented by exporters which keep their last error. e.g. destination is unreachable
+// LastError returns the message & time of the last error. The message is empty if no error occurred.
+type ErrorReporter interface {
+	LastError() (string, time.Time)
+}
+
+type lastError struct {
+	msg string
+	at  time.Time
+}
+
+// errorRecorder keeps the last error of an exporter. It is embedded by exporters to implement ErrorReporter.
+type errorRecorder struct {
+	last atomic.Pointer[lastError]
+}
+
+func (er *errorRecorder) record(err error) {
+	er.last.Store(&lastError{msg: err.Error(), at: time.Now()})
+}
+
+func (er *errorRecorder) LastError() (string, time.Time) {
+	last := er.last.Load()
+	if last == nil {
+		return "", time.Time{}
+	}
+	return last.msg, last.at
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"polvo/compose"
+	"polvo/service/filter"
+	"polvo/service/model"
+	"strings"
+)
+
+// maximum size of an event line of filter-test
+const maxEventSize = 1024 * 1024
+
+// # filterTestCommand
+//
+// filterTestCommand reads JSON events line by line & prints the selections of filter matched by each event.
+// The filter is a filter file, or a filter declared in compose.
+//
+// usage: polvo filter-test (-filter <file> | -compose <file> -name <filter>) [-events <file>]
+//
+// events are read from stdin if -events is "-" or not given.
+func filterTestCommand(args []string) int {
+	flags := flag.NewFlagSet("filter-test", flag.ContinueOnError)
+	filterPath := flags.String("filter", "", "filter file")
+	composePath := flags.String("compose", "", "compose file which declares the filter")
+	filterName := flags.String("name", "", "name of the filter declared in compose")
+	eventsPath := flags.String("events", "-", "file of JSON events per line. - means stdin")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if (*filterPath == "") == (*composePath == "") || (*composePath != "") != (*filterName != "") || flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: polvo filter-test (-filter <file> | -compose <file> -name <filter>) [-events <file>]")
+		return exitUsage
+	}
+
+	filterOp, err := loadFilter(*filterPath, *composePath, *filterName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid filter %v\n", err)
+		return exitInvalidConfig
+	}
+
+	events := io.Reader(os.Stdin)
+	if *eventsPath != "-" {
+		file, err := os.Open(*eventsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error while open events %v\n", err)
+			return exitFailure
+		}
+		defer file.Close()
+		events = file
+	}
+	if err = testEvents(filterOp, events, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func loadFilter(filterPath, composePath, filterName string) (filter.FilterOperator, error) {
+	if filterPath != "" {
+		filterData, err := os.ReadFile(filterPath)
+		if err != nil {
+			return nil, err
+		}
+		return filter.NewFilterOperator(filterData)
+	}
+	composer, err := compose.NewComposeFile(composePath)
+	if err != nil {
+		return nil, err
+	}
+	filterInfo, ok := composer.GetCompose().Filters[filterName]
+	if !ok {
+		return nil, fmt.Errorf("filter %s is not declared in %s", filterName, composePath)
+	}
+	return filter.NewFilterOperator(filterInfo.Data)
+}
+
+// testEvents writes the verdict of each event in events.
+// Invalid events are reported & an error is returned after all events are tested.
+func testEvents(filterOp filter.FilterOperator, events io.Reader, out io.Writer) error {
+	var (
+		total    int
+		filtered int
+		invalid  int
+	)
+
+	scanner := bufio.NewScanner(events)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		total++
+		log := new(model.CommonLogWrapper)
+		if err := json.Unmarshal([]byte(line), log); err != nil {
+			invalid++
+			fmt.Fprintf(out, "line %d: invalid event: %v\n", lineNum, err)
+			continue
+		}
+		verdict := filter.Explain(filterOp, log)
+		var result string
+		switch {
+		case len(verdict.Deny) > 0:
+			result = "denied by " + strings.Join(verdict.Deny, ", ")
+		case verdict.Filtered && len(verdict.Allow) <= 0:
+			result = "denied, no allow selection matched"
+		case verdict.Filtered:
+			result = "denied"
+		case len(verdict.Allow) > 0:
+			result = "passed, allowed by " + strings.Join(verdict.Allow, ", ")
+		default:
+			result = "passed"
+		}
+		if verdict.Filtered {
+			filtered++
+		}
+		fmt.Fprintf(out, "line %d: %s: %s\n", lineNum, log.EventName, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error while read events %w", err)
+	}
+	fmt.Fprintf(out, "%d event(s), %d denied, %d invalid\n", total, filtered, invalid)
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid event(s)", invalid)
+	}
+	return nil
+}
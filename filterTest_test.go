@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"polvo/service/filter"
+	"strings"
+	"testing"
+)
+
+func TestFilterTestCommandExitCode(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"-filter", "testdata/filter_valid.yml", "-compose", "testdata/compose_valid.yml"}, exitUsage},
+		{[]string{"-compose", "testdata/compose_valid.yml"}, exitUsage},
+		{[]string{"-filter", "testdata/filter_valid.yml", "-events", "testdata/events.jsonl"}, exitOK},
+		{[]string{"-compose", "testdata/compose_valid.yml", "-name", "shell_only", "-events", "testdata/events.jsonl"}, exitOK},
+		{[]string{"-compose", "testdata/compose_valid.yml", "-name", "not_declared"}, exitInvalidConfig},
+		{[]string{"-filter", "testdata/filter_invalid.yml"}, exitInvalidConfig},
+		{[]string{"-filter", "testdata/filter_valid.yml", "-events", "testdata/events_invalid.jsonl"}, exitFailure},
+		{[]string{"-filter", "testdata/filter_valid.yml", "-events", "testdata/not_exist.jsonl"}, exitFailure},
+	} {
+		if got := filterTestCommand(test.args); got != test.want {
+			t.Errorf("filterTestCommand(%v) = %d, want %d", test.args, got, test.want)
+		}
+	}
+}
+
+func TestTestEvents(t *testing.T) {
+	filterOp, err := filter.NewFilterOperator([]byte(`
+allow:
+  shell:
+    "eventname|endswith": "Readline"
+deny:
+  filter_sudo:
+    condition:
+      "Commandline|startswith": "sudo"
+  filter_touch:
+    condition:
+      "Commandline|contains": "touch"
+`))
+	if err != nil {
+		t.Fatalf("NewFilterOperator() = %v, want nil", err)
+	}
+	for _, test := range []struct {
+		name    string
+		events  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "verdicts",
+			events: `{"eventname": "bashReadline", "metadata": {"Commandline": "sudo touch a"}}
+{"eventname": "bashReadline", "metadata": {"Commandline": "ls"}}
+
+{"eventname": "processCreate", "metadata": {"Commandline": "ls"}}
+`,
+			want: `line 1: bashReadline: denied by filter_sudo, filter_touch
+line 2: bashReadline: passed, allowed by shell
+line 4: processCreate: denied, no allow selection matched
+3 event(s), 2 denied, 0 invalid
+`,
+		},
+		{
+			name: "invalid event",
+			events: `not json
+{"eventname": "bashReadline", "metadata": {"Commandline": "ls"}}
+`,
+			want: `line 1: invalid event: invalid character 'o' in literal null (expecting 'u')
+line 2: bashReadline: passed, allowed by shell
+2 event(s), 0 denied, 1 invalid
+`,
+			wantErr: true,
+		},
+		{
+			name:   "no event",
+			events: "",
+			want:   "0 event(s), 0 denied, 0 invalid\n",
+		},
+	} {
+		var out bytes.Buffer
+		err := testEvents(filterOp, strings.NewReader(test.events), &out)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: testEvents() = %v, want error %v", test.name, err, test.wantErr)
+		}
+		if out.String() != test.want {
+			t.Errorf("%s: output = %q, want %q", test.name, out.String(), test.want)
+		}
+	}
+}
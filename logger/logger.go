@@ -121,7 +121,12 @@ func (c *CustomLoggerForOpenSearch) Debugf(format string, v ...interface{}) {
 }
 
 func (c *CustomLoggerForOpenSearch) LogRoundTrip(req *http.Request, res *http.Response, err error, start time.Time, d time.Duration) error {
-	c.Logger.Sugar().Infof("RoundTrip: %s %s %s %v %v", req.Method, req.URL, res.Status, err, d)
+	// res is nil if the request is failed
+	status := ""
+	if res != nil {
+		status = res.Status
+	}
+	c.Logger.Sugar().Infof("RoundTrip: %s %s %s %v %v", req.Method, req.URL, status, err, d)
 	// TODO: request/response body logging
 	return nil
 }
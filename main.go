@@ -1,16 +1,9 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"path/filepath"
-	"polvo/compose"
-	plogger "polvo/logger"
-	"polvo/service"
-	"polvo/service/filter"
-	"syscall"
+	"strings"
 )
 
 const logo = "         _nnnn_                      \n" +
@@ -31,72 +24,65 @@ const logo = "         _nnnn_                      \n" +
 	"     `-'       `--' ascii by hjm\n" +
 	"[Polvo_0.0.0 - ENKI WHITEHAT 2025]\n\n"
 
-func main() {
-	var (
-		loger    plogger.PolvoLogger
-		composer compose.ComposeFile
-		filterOp filter.FilterOperator
-		svc      service.Service
-		exitCode int
-	)
+// Exit codes of polvo
+const (
+	// command succeeded
+	exitOK = 0
+	// runtime error. e.g. events of filter-test can not be read
+	exitFailure = 1
+	// unknown subcommand or invalid flags
+	exitUsage = 2
+	// compose or filter file is invalid
+	exitInvalidConfig = 3
+	// service failed while running or stopping
+	exitServiceError = 75
+)
 
-	exitCode = 0
-	// handle signal
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+const usage = `Usage: polvo <command> [flags]
 
-	pwd, err := os.Getwd()
-	if err != nil {
-		fmt.Printf("error while get working directory %v", err)
-		os.Exit(1)
-	}
-	loger = plogger.NewLogger(pwd)
-	composer, err = compose.NewComposeFile(filepath.Join(pwd, os.Args[1]))
-	if err != nil {
-		loger.Close()
-		panic(err)
-	}
-
-	// init filter operator
-	filterData, err := os.ReadFile(filepath.Join(pwd, os.Args[2]))
-	if err != nil {
-		loger.Close()
-		panic(err)
-	}
-	filterOp, err = filter.NewFilterOperator(filterData)
-	if err != nil {
-		loger.Close()
-		panic(err)
-	}
+Commands:
+  run          run the service of compose file
+  validate     check compose & filter files and print all problems
+  filter-test  print the selections of filter matched by each JSON event
+  help         print this message
 
-	svc, err = service.NewService(composer.GetCompose(), loger, filterOp)
-	if err != nil {
-		loger.Close()
-		panic(err)
-	}
-	svc.Start()
+"polvo <compose> [filter]" is the same as "polvo run <compose> [filter]".
+Run "polvo <command> -h" for the flags of command.
 
-	go func() {
-		<-ctx.Done()
-		fmt.Println("Shutting down...")
-		err = svc.Stop()
-		if err != nil {
-			fmt.Printf("error while stop service %v", err)
-			exitCode = 75
-		}
-	}()
+Exit codes:
+  0   success
+  1   runtime error
+  2   invalid command or flags
+  3   invalid compose or filter file
+  75  service failed while running or stopping
+`
 
-	// print logo
-	fmt.Print(logo)
+// subcommands. each command returns the exit code.
+var commands = map[string]func(args []string) int{
+	"run":         runCommand,
+	"validate":    validateCommand,
+	"filter-test": filterTestCommand,
+}
 
-	err = svc.Wait()
-	if err != nil {
-		fmt.Printf("error while waiting service %v", err)
-		// call stop to ensure all resources are released
-		stop()
-		exitCode = 75
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		os.Exit(exitOK)
+	}
+	command, ok := commands[name]
+	if !ok {
+		if strings.HasPrefix(name, "-") {
+			fmt.Fprintf(os.Stderr, "unknown command %s\n\n%s", name, usage)
+			os.Exit(exitUsage)
+		}
+		// compose file given without command
+		command, args = runCommand, os.Args[1:]
 	}
-	fmt.Println("Service stopped")
-	loger.Close()
-	os.Exit(exitCode)
+	os.Exit(command(args))
 }
@@ -0,0 +1,468 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// types of metric families in Prometheus text format
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefaultBuckets are the upper bounds in seconds of histograms of latency.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+// # Sample
+//
+// Sample is a value reported by a Collector at scrape time.
+// Labels are pairs of label name & value. e.g. ["sensor", "ebpf"]
+type Sample struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+	Value  float64
+}
+
+// # Collector
+//
+// Collector reports samples computed at scrape time. e.g. length of channels
+// Samples of the same name must have the same help & type.
+type Collector interface {
+	Collect(emit func(Sample))
+}
+
+// family is a metric family owned by Registry.
+type family interface {
+	describe() (name string, help string, typ string)
+	// write writes samples of family without HELP & TYPE lines.
+	write(w io.Writer) error
+}
+
+// # Registry
+//
+// Registry holds metric families & collectors, and writes them in Prometheus text format.
+// Counters, gauges & histograms are updated by workers without lock.
+// Collectors are called only when the registry is written.
+type Registry struct {
+	lock       sync.RWMutex
+	families   map[string]family
+	collectors []Collector
+}
+
+// Default is the registry of polvo. Packages declare their metrics in it.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	r := new(Registry)
+	r.families = make(map[string]family)
+	return r
+}
+
+// register adds f. It panics if the name is already used, like duplicated flags.
+func (r *Registry) register(f family) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	name, _, _ := f.describe()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	r.families[name] = f
+}
+
+// Register adds a collector.
+func (r *Registry) Register(c Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Unregister removes a collector added by Register. Collectors are compared, so they must be pointers.
+func (r *Registry) Unregister(c Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for idx, collector := range r.collectors {
+		if collector == c {
+			r.collectors = append(r.collectors[:idx:idx], r.collectors[idx+1:]...)
+			return
+		}
+	}
+}
+
+// # WriteText
+//
+// WriteText writes all metrics in Prometheus text format. Families are sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.RLock()
+	families := make(map[string]family, len(r.families))
+	for name, f := range r.families {
+		families[name] = f
+	}
+	collectors := append([]Collector(nil), r.collectors...)
+	r.lock.RUnlock()
+
+	// samples of collectors are grouped by name
+	collected := make(map[string][]Sample)
+	for _, collector := range collectors {
+		collector.Collect(func(sample Sample) {
+			if _, ok := families[sample.Name]; ok {
+				// name is owned by family
+				return
+			}
+			collected[sample.Name] = append(collected[sample.Name], sample)
+		})
+	}
+
+	names := make([]string, 0, len(families)+len(collected))
+	for name := range families {
+		names = append(names, name)
+	}
+	for name := range collected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if f, ok := families[name]; ok {
+			_, help, typ := f.describe()
+			if err := writeHeader(w, name, help, typ); err != nil {
+				return err
+			}
+			if err := f.write(w); err != nil {
+				return err
+			}
+			continue
+		}
+		samples := collected[name]
+		if err := writeHeader(w, name, samples[0].Help, samples[0].Type); err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			if err := writeSample(w, name, labelString(sample.Labels), sample.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/************************************************************************************************************
+* Counter & Gauge
+************************************************************************************************************/
+
+// # Counter
+//
+// Counter is a monotonically increasing count. e.g. number of logs
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(delta uint64) {
+	c.value.Add(delta)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// # Gauge
+//
+// Gauge is a value which goes up & down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) Add(delta float64) {
+	addFloat(&g.bits, delta)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// # Histogram
+//
+// Histogram counts observations in buckets of upper bounds.
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sumBits atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(value float64) {
+	// buckets are not cumulative here. they are summed when written.
+	idx := sort.SearchFloat64s(h.buckets, value)
+	if idx < len(h.counts) {
+		h.counts[idx].Add(1)
+	}
+	h.count.Add(1)
+	addFloat(&h.sumBits, value)
+}
+
+// addFloat adds delta to float64 bits atomically.
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+/************************************************************************************************************
+* Vectors
+************************************************************************************************************/
+
+// vec is a metric family partitioned by label values.
+type vec[M any] struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	newMetric  func() *M
+	lock       sync.RWMutex
+	metrics    map[string]*M
+	labels     map[string][]string
+}
+
+func newVec[M any](name string, help string, typ string, labelNames []string, newMetric func() *M) *vec[M] {
+	return &vec[M]{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		newMetric:  newMetric,
+		metrics:    make(map[string]*M),
+		labels:     make(map[string][]string),
+	}
+}
+
+func (v *vec[M]) describe() (string, string, string) {
+	return v.name, v.help, v.typ
+}
+
+// with returns the metric of label values. It is created at the first call.
+// Workers keep the returned metric instead of calling with per log.
+func (v *vec[M]) with(labelValues ...string) *M {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s has %d labels, but %d values are given", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.lock.RLock()
+	metric, ok := v.metrics[key]
+	v.lock.RUnlock()
+	if ok {
+		return metric
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if metric, ok = v.metrics[key]; ok {
+		return metric
+	}
+	metric = v.newMetric()
+	v.metrics[key] = metric
+	v.labels[key] = append([]string(nil), labelValues...)
+	return metric
+}
+
+// each calls fn with the label string & metric in sorted order of label values.
+func (v *vec[M]) each(fn func(labels string, metric *M) error) error {
+	v.lock.RLock()
+	keys := make([]string, 0, len(v.metrics))
+	for key := range v.metrics {
+		keys = append(keys, key)
+	}
+	v.lock.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.lock.RLock()
+		metric, labelValues := v.metrics[key], v.labels[key]
+		v.lock.RUnlock()
+		pairs := make([]string, 0, 2*len(labelValues))
+		for idx, value := range labelValues {
+			pairs = append(pairs, v.labelNames[idx], value)
+		}
+		if err := fn(labelString(pairs), metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// # CounterVec
+//
+// CounterVec is counters partitioned by labels.
+type CounterVec struct {
+	*vec[Counter]
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	cv := &CounterVec{newVec(name, help, TypeCounter, labelNames, func() *Counter { return new(Counter) })}
+	r.register(cv)
+	return cv
+}
+
+// With returns the counter of label values in the order of label names.
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return cv.with(labelValues...)
+}
+
+func (cv *CounterVec) write(w io.Writer) error {
+	return cv.each(func(labels string, counter *Counter) error {
+		return writeSample(w, cv.name, labels, float64(counter.Value()))
+	})
+}
+
+// # GaugeVec
+//
+// GaugeVec is gauges partitioned by labels.
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	gv := &GaugeVec{newVec(name, help, TypeGauge, labelNames, func() *Gauge { return new(Gauge) })}
+	r.register(gv)
+	return gv
+}
+
+// With returns the gauge of label values in the order of label names.
+func (gv *GaugeVec) With(labelValues ...string) *Gauge {
+	return gv.with(labelValues...)
+}
+
+func (gv *GaugeVec) write(w io.Writer) error {
+	return gv.each(func(labels string, gauge *Gauge) error {
+		return writeSample(w, gv.name, labels, gauge.Value())
+	})
+}
+
+// # HistogramVec
+//
+// HistogramVec is histograms partitioned by labels. buckets are sorted upper bounds. nil means DefaultBuckets.
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	hv := &HistogramVec{newVec(name, help, TypeHistogram, labelNames, func() *Histogram { return newHistogram(buckets) })}
+	r.register(hv)
+	return hv
+}
+
+// With returns the histogram of label values in the order of label names.
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	return hv.with(labelValues...)
+}
+
+func (hv *HistogramVec) write(w io.Writer) error {
+	return hv.each(func(labels string, histogram *Histogram) error {
+		var cumulative uint64
+		for idx, bound := range histogram.buckets {
+			cumulative += histogram.counts[idx].Load()
+			if err := writeSample(w, hv.name+"_bucket", withLabel(labels, "le", formatFloat(bound)), float64(cumulative)); err != nil {
+				return err
+			}
+		}
+		count := histogram.count.Load()
+		if err := writeSample(w, hv.name+"_bucket", withLabel(labels, "le", "+Inf"), float64(count)); err != nil {
+			return err
+		}
+		if err := writeSample(w, hv.name+"_sum", labels, math.Float64frombits(histogram.sumBits.Load())); err != nil {
+			return err
+		}
+		return writeSample(w, hv.name+"_count", labels, float64(count))
+	})
+}
+
+/************************************************************************************************************
+* Text format
+************************************************************************************************************/
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func writeHeader(w io.Writer, name string, help string, typ string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, typ)
+	return err
+}
+
+func writeSample(w io.Writer, name string, labels string, value float64) error {
+	_, err := fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(value))
+	return err
+}
+
+// labelString formats pairs of label name & value. e.g. {sensor="ebpf"}
+func labelString(pairs []string) string {
+	if len(pairs) < 2 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for idx := 0; idx+1 < len(pairs); idx += 2 {
+		if idx > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(pairs[idx])
+		builder.WriteString(`="`)
+		builder.WriteString(labelEscaper.Replace(pairs[idx+1]))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+// withLabel appends a label to the label string.
+func withLabel(labels string, name string, value string) string {
+	label := labelString([]string{name, value})
+	if labels == "" {
+		return label
+	}
+	return labels[:len(labels)-1] + "," + label[1:]
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
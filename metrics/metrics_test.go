@@ -0,0 +1,117 @@
+package metrics_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	plogger "polvo/logger"
+	"polvo/metrics"
+	"strings"
+	"testing"
+)
+
+type staticCollector []metrics.Sample
+
+func (sc staticCollector) Collect(emit func(metrics.Sample)) {
+	for _, sample := range sc {
+		emit(sample)
+	}
+}
+
+func TestRegistryWriteText(t *testing.T) {
+	registry := metrics.NewRegistry()
+	events := registry.NewCounterVec("polvo_test_events_total", "Logs of sensor.", "sensor")
+	events.With("b").Add(3)
+	events.With("a").Inc()
+	registry.NewGaugeVec("polvo_test_depth", "Depth.", "queue").With(`q"1`).Set(2.5)
+	latency := registry.NewHistogramVec("polvo_test_seconds", "Latency.", []float64{1, 0.1}, "exporter")
+	latency.With("file").Observe(0.05)
+	latency.With("file").Observe(0.5)
+	latency.With("file").Observe(2)
+	collector := &staticCollector{{Name: "polvo_test_channel", Help: "Channel.", Type: metrics.TypeGauge, Labels: []string{"stage", "filter"}, Value: 1}}
+	registry.Register(collector)
+
+	var out bytes.Buffer
+	if err := registry.WriteText(&out); err != nil {
+		t.Fatalf("WriteText() = %v, want nil", err)
+	}
+	want := `# HELP polvo_test_channel Channel.
+# TYPE polvo_test_channel gauge
+polvo_test_channel{stage="filter"} 1
+# HELP polvo_test_depth Depth.
+# TYPE polvo_test_depth gauge
+polvo_test_depth{queue="q\"1"} 2.5
+# HELP polvo_test_events_total Logs of sensor.
+# TYPE polvo_test_events_total counter
+polvo_test_events_total{sensor="a"} 1
+polvo_test_events_total{sensor="b"} 3
+# HELP polvo_test_seconds Latency.
+# TYPE polvo_test_seconds histogram
+polvo_test_seconds_bucket{exporter="file",le="0.1"} 1
+polvo_test_seconds_bucket{exporter="file",le="1"} 2
+polvo_test_seconds_bucket{exporter="file",le="+Inf"} 3
+polvo_test_seconds_sum{exporter="file"} 2.55
+polvo_test_seconds_count{exporter="file"} 3
+`
+	if out.String() != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// collectors are not written after unregister
+	registry.Unregister(collector)
+	out.Reset()
+	registry.WriteText(&out)
+	if strings.Contains(out.String(), "polvo_test_channel") {
+		t.Errorf("WriteText() = %s, want no samples of unregistered collector", out.String())
+	}
+}
+
+func TestRegistryDuplicatedName(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounterVec("polvo_test_total", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewGaugeVec() with duplicated name does not panic")
+		}
+	}()
+	registry.NewGaugeVec("polvo_test_total", "Test.")
+}
+
+func TestServer(t *testing.T) {
+	logpath, err := os.MkdirTemp("", "polvo-metrics")
+	if err != nil {
+		t.Fatalf("error while create log directory %v", err)
+	}
+	defer os.RemoveAll(logpath)
+	loger := plogger.NewLogger(logpath)
+	defer loger.Close()
+
+	registry := metrics.NewRegistry()
+	registry.NewCounterVec("polvo_test_total", "Test.").With().Inc()
+	server, err := metrics.NewServer("127.0.0.1:0", "/metrics", registry, loger)
+	if err != nil {
+		t.Fatalf("NewServer() = %v, want nil", err)
+	}
+	server.Start()
+	defer server.Stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", server.Addr()))
+	if err != nil {
+		t.Fatalf("GET /metrics = %v, want nil", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "polvo_test_total 1\n") {
+		t.Errorf("GET /metrics = %d %s, want polvo_test_total 1", resp.StatusCode, body)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Content-Type = %s, want text/plain", contentType)
+	}
+
+	// port in use is reported by NewServer
+	if _, err = metrics.NewServer(server.Addr().String(), "/metrics", registry, loger); err == nil {
+		t.Errorf("NewServer(%s) = nil, want error for address in use", server.Addr())
+	}
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"time"
+)
+
+const (
+	// content type of Prometheus text format
+	textContentType = "text/plain; version=0.0.4; charset=utf-8"
+	// time to wait for scrapes in progress on Stop
+	shutdownTimeout = 5 * time.Second
+)
+
+// # Server
+//
+// Server serves the metrics of a registry in Prometheus text format over HTTP.
+// The address is bound by NewServer, so a port in use is reported before the service starts.
+type Server struct {
+	registry *Registry
+	listener net.Listener
+	server   *http.Server
+	done     chan struct{}
+	// dependency
+	logger plogger.PolvoLogger
+}
+
+// NewServer listens on address & serves the metrics of registry on path.
+func NewServer(address string, path string, registry *Registry, logger plogger.PolvoLogger) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, perror.PolvoGeneralError{
+			Code:   perror.SystemError,
+			Origin: err,
+			Msg:    "error while construct new metrics server",
+		}
+	}
+	s := new(Server)
+	s.registry = registry
+	s.listener = listener
+	s.logger = logger
+	s.done = make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle(path, s)
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Start serves scrapes in background.
+func (s *Server) Start() {
+	go func() {
+		defer close(s.done)
+		s.logger.PrintInfo("metrics server is listening on %s", s.listener.Addr())
+		if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.PrintError("metrics server is closed. %s", err.Error())
+		}
+	}()
+}
+
+// Stop closes the listener & waits for scrapes in progress.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		return perror.PolvoGeneralError{
+			Code:   perror.SystemError,
+			Origin: err,
+			Msg:    "error while stop metrics server",
+		}
+	}
+	return nil
+}
+
+// ServeHTTP writes the metrics of registry. Metrics are rendered before they are sent,
+// so a failed collector does not leave a truncated response.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body bytes.Buffer
+	if err := s.registry.WriteText(&body); err != nil {
+		http.Error(w, fmt.Sprintf("error while write metrics %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", textContentType)
+	w.Write(body.Bytes())
+}
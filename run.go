@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"polvo/admin"
+	"polvo/compose"
+	plogger "polvo/logger"
+	"polvo/metrics"
+	"polvo/service"
+	"polvo/service/filter"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// # runCommand
+//
+// runCommand runs the service of compose file until SIGINT or SIGTERM.
+// SIGHUP reloads compose & filter files.
+//
+// usage: polvo run -compose <file> [-filter <file>] [-log-dir <dir>] [-pidfile <file>] [-watch <interval>] [-admin <address>]
+//
+// compose & filter files can also be given as positional arguments.
+func runCommand(args []string) int {
+	var (
+		loger    plogger.PolvoLogger
+		composer compose.ComposeFile
+		filterOp filter.FilterOperator
+		svc      service.Service
+		reloader service.Reloader
+		exitCode int
+		// metricsServer is nil if metrics are not served
+		metricsServer *metrics.Server
+		// adminServer is nil if -admin is off
+		adminServer *admin.Server
+	)
+
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	composePath := flags.String("compose", "", "compose file")
+	filterPath := flags.String("filter", "", "global filter file applied to logs of all sensors")
+	logDir := flags.String("log-dir", "", "directory of service.log (default: working directory)")
+	pidFile := flags.String("pidfile", "", "file to write pid of agent")
+	watchInterval := flags.Duration("watch", 0, "interval to check compose & filter files are modified. 0 disables watcher")
+	adminAddress := flags.String("admin", "", "address of admin API. unix:///path, tcp://host:port or off (default: unix socket polvo-admin.sock in log directory)")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	// positional compose & filter files
+	positional := flags.Args()
+	if *composePath == "" && len(positional) > 0 {
+		*composePath, positional = positional[0], positional[1:]
+	}
+	if *filterPath == "" && len(positional) > 0 {
+		*filterPath, positional = positional[0], positional[1:]
+	}
+	if *composePath == "" || len(positional) > 0 || *watchInterval < 0 {
+		fmt.Fprintln(os.Stderr, "usage: polvo run -compose <file> [-filter <file>] [-log-dir <dir>] [-pidfile <file>] [-watch <interval>] [-admin <address>]")
+		return exitUsage
+	}
+
+	// resolve paths before working directory is used as log directory
+	var err error
+	for _, path := range []*string{composePath, filterPath, logDir, pidFile} {
+		if *path == "" {
+			continue
+		}
+		if *path, err = filepath.Abs(*path); err != nil {
+			fmt.Fprintf(os.Stderr, "error while resolve path %s %v\n", *path, err)
+			return exitFailure
+		}
+	}
+	if *logDir == "" {
+		if *logDir, err = os.Getwd(); err != nil {
+			fmt.Fprintf(os.Stderr, "error while get working directory %v\n", err)
+			return exitFailure
+		}
+	}
+	if *adminAddress == "" {
+		*adminAddress = "unix://" + filepath.Join(*logDir, "polvo-admin.sock")
+	}
+
+	composer, err = compose.NewComposeFile(*composePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid compose file %s %v\n", *composePath, err)
+		return exitInvalidConfig
+	}
+	// init global filter operator. filters can also be declared in compose per sensor & pipeline.
+	if *filterPath != "" {
+		filterData, err := os.ReadFile(*filterPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error while read filter file %v\n", err)
+			return exitInvalidConfig
+		}
+		filterOp, err = filter.NewFilterOperator(filterData)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid filter file %s %v\n", *filterPath, err)
+			return exitInvalidConfig
+		}
+	}
+
+	if *pidFile != "" {
+		if err = os.WriteFile(*pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error while write pidfile %v\n", err)
+			return exitFailure
+		}
+		defer os.Remove(*pidFile)
+	}
+
+	loger = plogger.NewLogger(*logDir)
+	defer loger.Close()
+
+	// listen before sensors are started, so a port in use does not leave running sensors
+	if metricsInfo := composer.GetCompose().Metrics; metricsInfo != nil {
+		if metricsServer, err = metrics.NewServer(metricsInfo.Listen, metricsInfo.Path, metrics.Default, loger); err != nil {
+			fmt.Fprintf(os.Stderr, "error while create metrics server %v\n", err)
+			return exitFailure
+		}
+	}
+
+	// handle signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	svc, err = service.NewService(composer.GetCompose(), loger, filterOp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error while create service %v\n", err)
+		return exitFailure
+	}
+	// serve metrics of service
+	if metricsServer != nil {
+		metrics.Default.Register(svc)
+		defer metrics.Default.Unregister(svc)
+		metricsServer.Start()
+		// metrics server is stopped after service. scrapes wait until service is stopped.
+		defer func() {
+			if err := metricsServer.Stop(); err != nil {
+				fmt.Fprintf(os.Stderr, "error while stop metrics server %v\n", err)
+			}
+		}()
+	}
+	// serve admin API of service. requests are rejected after service is stopped.
+	if *adminAddress != admin.Off {
+		if adminServer, err = admin.NewServer(*adminAddress, svc, loger); err != nil {
+			fmt.Fprintf(os.Stderr, "error while create admin server %v\n", err)
+			return exitFailure
+		}
+		adminServer.Start()
+		defer func() {
+			if err := adminServer.Stop(); err != nil {
+				fmt.Fprintf(os.Stderr, "error while stop admin server %v\n", err)
+			}
+		}()
+	}
+	svc.Start()
+
+	// reload compose & filter files on SIGHUP
+	reloader = service.NewReloader(svc, *composePath, *filterPath, loger)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				// errors are logged by reloader & the running service is kept
+				if err := reloader.Reload(); err != nil {
+					fmt.Fprintf(os.Stderr, "error while reload service %v\n", err)
+				}
+			}
+		}
+	}()
+	if *watchInterval > 0 {
+		go reloader.Watch(ctx, *watchInterval)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		fmt.Println("Shutting down...")
+		if err := svc.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "error while stop service %v\n", err)
+			exitCode = exitServiceError
+		}
+	}()
+
+	// print logo
+	fmt.Print(logo)
+
+	if err = svc.Wait(); err != nil {
+		fmt.Fprintf(os.Stderr, "error while waiting service %v\n", err)
+		exitCode = exitServiceError
+	}
+	// call stop to ensure all resources are released
+	stop()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		fmt.Fprintln(os.Stderr, "timeout while stop service")
+		exitCode = exitServiceError
+	}
+	fmt.Println("Service stopped")
+	return exitCode
+}
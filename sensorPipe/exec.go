@@ -19,6 +19,7 @@ import (
 // It provides a way to wait for the subprocess to finish or cancel it.
 type Promise interface {
 	Pid() int
+	Signal(sig syscall.Signal) error
 	Wait() (int, error)
 	Cancel() error
 }
@@ -33,10 +34,45 @@ func (p *promise) Pid() int {
 	return p.cmd.Process.Pid
 }
 
+// Signal sends sig to the process group of the subprocess, so children of the subprocess receive it too.
+func (p *promise) Signal(sig syscall.Signal) error {
+	if err := syscall.Kill(-p.cmd.Process.Pid, sig); err != nil {
+		return perror.PolvoGeneralError{
+			Code:   perror.SystemError,
+			Msg:    fmt.Sprintf("error while execute %s %v promise.Signal()", p.cmd.Path, p.cmd.Args),
+			Origin: err,
+		}
+	}
+	return nil
+}
+
+// # ProcAttr
+//
+// ProcAttr holds the attributes of the subprocess which differ from the agent.
+//
+// - Credential switches the user & groups of the subprocess. nil means the credential of the agent.
+//
+// - AmbientCaps are raised as ambient capabilities, so they are kept by the unprivileged subprocess.
+//
+// - Stderr receives the stderr of the subprocess. nil means the stderr of the agent.
+type ProcAttr struct {
+	Credential  *syscall.Credential
+	AmbientCaps []uintptr
+	Stderr      *os.File
+}
+
 // # Run
 //
 // Run starts a new subprocess with the given commandline and returns a Promise.
 func Run(inStream *os.File, outStream *os.File, arg0 string, args ...string) (Promise, error) {
+	return RunWithAttr(inStream, outStream, nil, arg0, args...)
+}
+
+// # RunWithAttr
+//
+// RunWithAttr starts a new subprocess with the given attributes and returns a Promise.
+// nil attr is the same as Run.
+func RunWithAttr(inStream *os.File, outStream *os.File, attr *ProcAttr, arg0 string, args ...string) (Promise, error) {
 	prom := new(promise)
 	// set conditional variable to -1
 	atomic.StoreInt32(&prom.waitCnt, -1)
@@ -48,6 +84,13 @@ func Run(inStream *os.File, outStream *os.File, arg0 string, args ...string) (Pr
 
 	// generate process group
 	prom.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if attr != nil {
+		prom.cmd.SysProcAttr.Credential = attr.Credential
+		prom.cmd.SysProcAttr.AmbientCaps = attr.AmbientCaps
+		if attr.Stderr != nil {
+			prom.cmd.Stderr = attr.Stderr
+		}
+	}
 
 	// set the error for run commandline goroutine
 	err := prom.cmd.Start()
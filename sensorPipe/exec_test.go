@@ -1,10 +1,13 @@
 package sensorPipe_test
 
 import (
+	"io"
 	"os"
 	perror "polvo/error"
 	"polvo/sensorPipe"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -102,3 +105,36 @@ func TestWaitFuncCallDuplicated(t *testing.T) {
 	}
 	t.Logf("Error: %v", err)
 }
+
+func TestPromiseWithCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching credential requires root")
+	}
+	readStream, writeStream, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() = %v, want nil", err)
+	}
+	defer readStream.Close()
+
+	// nobody with CAP_NET_RAW
+	attr := &sensorPipe.ProcAttr{
+		Credential:  &syscall.Credential{Uid: 65534, Gid: 65534},
+		AmbientCaps: []uintptr{13},
+	}
+	promise, err := sensorPipe.RunWithAttr(os.Stdin, writeStream, attr, "sh", "-c", "id -u; grep CapAmb /proc/self/status")
+	writeStream.Close()
+	if err != nil {
+		t.Fatalf("Error while executing promise %v", err)
+	}
+	output, _ := io.ReadAll(readStream)
+	if _, err = promise.Wait(); err != nil {
+		t.Errorf("Error while executing promise %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 2 || lines[0] != "65534" {
+		t.Fatalf("output = %q, want uid 65534", output)
+	}
+	if ambient := strings.TrimSpace(strings.TrimPrefix(lines[1], "CapAmb:")); ambient != "0000000000002000" {
+		t.Errorf("CapAmb = %s, want 0000000000002000", ambient)
+	}
+}
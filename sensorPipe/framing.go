@@ -0,0 +1,183 @@
+package sensorPipe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	perror "polvo/error"
+	"sync/atomic"
+)
+
+// framing modes of stdout of sensor
+const (
+	// FramingLine is a record per line.
+	FramingLine = "line"
+	// FramingLengthPrefixed is a record prefixed by its length in bytes as unsigned varint.
+	FramingLengthPrefixed = "length-prefixed"
+)
+
+// DefaultMaxRecordSize is the maximum size of a record when Framing.MaxRecordSize is 0.
+const DefaultMaxRecordSize = 1024 * 1024
+
+// # Framing
+//
+// Framing splits stdout of sensor into records which are passed to wrapFunc.
+// Records larger than MaxRecordSize are skipped & counted, and the records after them are read.
+type Framing struct {
+	// Mode is FramingLine or FramingLengthPrefixed. empty means FramingLine.
+	Mode string
+	// MaxRecordSize is the maximum size of a record in bytes. 0 means DefaultMaxRecordSize.
+	MaxRecordSize int
+}
+
+func (f *Framing) validate() error {
+	if f.Mode == "" {
+		f.Mode = FramingLine
+	}
+	if f.Mode != FramingLine && f.Mode != FramingLengthPrefixed {
+		return fmt.Errorf("invalid framing mode %s", f.Mode)
+	}
+	if f.MaxRecordSize < 0 {
+		return fmt.Errorf("invalid max record size %d", f.MaxRecordSize)
+	}
+	if f.MaxRecordSize == 0 {
+		f.MaxRecordSize = DefaultMaxRecordSize
+	}
+	return nil
+}
+
+// SetFraming sets the framing of stdout of sensor. Lines up to DefaultMaxRecordSize are read by default.
+// It must be called before Start.
+func (p *pipe[log]) SetFraming(framing Framing) error {
+	if atomic.LoadInt32(&p.isStarted) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("sensor is already started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetFraming()", p.sensorName),
+		}
+	}
+	if err := framing.validate(); err != nil {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidArgumentError,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetFraming()", p.sensorName),
+		}
+	}
+	p.scanner = newRecordScanner(p.readStream, framing, p.skipRecord)
+	return nil
+}
+
+// skipRecord counts & logs a record larger than the maximum record size.
+func (p *pipe[log]) skipRecord(size int) {
+	p.oversized.Inc()
+	p.logger.PrintError("pipeline [%s] sensor: record is skipped. size %d is larger than max record size", p.sensorName, size)
+}
+
+// newRecordScanner returns the scanner which splits stream by framing. onSkip is called with the size of skipped records.
+// The size of skipped lines is the size read until the limit is exceeded.
+func newRecordScanner(stream io.Reader, framing Framing, onSkip func(size int)) *bufio.Scanner {
+	scanner := bufio.NewScanner(stream)
+	// the capacity of initial buffer is also a limit of buffer
+	switch framing.Mode {
+	case FramingLengthPrefixed:
+		maxSize := framing.MaxRecordSize + binary.MaxVarintLen64
+		scanner.Buffer(make([]byte, 0, min(4096, maxSize)), maxSize)
+		scanner.Split(continueAfterSkip(scanLengthPrefixed(framing.MaxRecordSize, onSkip)))
+	default:
+		// newline is a part of the buffer
+		maxSize := framing.MaxRecordSize + 1
+		scanner.Buffer(make([]byte, 0, min(4096, maxSize)), maxSize)
+		scanner.Split(continueAfterSkip(scanLines(maxSize, onSkip)))
+	}
+	return scanner
+}
+
+// continueAfterSkip calls split again while it skips data without a token.
+// Otherwise bufio.Scanner blocks on reading more data although the next records are buffered.
+func continueAfterSkip(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		consumed := 0
+		for {
+			advance, token, err := split(data[consumed:], atEOF)
+			consumed += advance
+			if token != nil || err != nil || advance == 0 || consumed == len(data) {
+				return consumed, token, err
+			}
+		}
+	}
+}
+
+// scanLines splits lines like bufio.ScanLines, but skips lines which do not fit in maxSize
+// instead of failing with bufio.ErrTooLong.
+func scanLines(maxSize int, onSkip func(size int)) bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 && skipping {
+			// end of skipped line
+			skipping = false
+			return i + 1, nil, nil
+		} else if i >= maxSize {
+			// whole line is buffered before the limit is checked
+			onSkip(i + 1)
+			return i + 1, nil, nil
+		} else if i >= 0 || (atEOF && !skipping && len(data) < maxSize) {
+			return bufio.ScanLines(data, atEOF)
+		}
+		if skipping {
+			return len(data), nil, nil
+		}
+		if len(data) >= maxSize {
+			skipping = true
+			onSkip(len(data))
+			return len(data), nil, nil
+		}
+		// request more data
+		return 0, nil, nil
+	}
+}
+
+// scanLengthPrefixed splits records prefixed by their length as unsigned varint.
+// Records larger than maxSize are skipped without being buffered.
+func scanLengthPrefixed(maxSize int, onSkip func(size int)) bufio.SplitFunc {
+	remain := 0
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		// discard the rest of skipped record
+		if remain > 0 {
+			if len(data) == 0 && atEOF {
+				return 0, nil, nil
+			}
+			advance := min(remain, len(data))
+			remain -= advance
+			return advance, nil, nil
+		}
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+		size, n := binary.Uvarint(data)
+		if n < 0 {
+			// the stream can not be split anymore
+			return 0, nil, fmt.Errorf("invalid length prefix of record")
+		}
+		if n == 0 {
+			if atEOF {
+				return 0, nil, fmt.Errorf("truncated length prefix of record")
+			}
+			return 0, nil, nil
+		}
+		if size > uint64(maxSize) {
+			remain = int(min(size, math.MaxInt))
+			onSkip(remain)
+			return n, nil, nil
+		}
+		if len(data) < n+int(size) {
+			if atEOF {
+				return 0, nil, fmt.Errorf("truncated record of %d bytes", size)
+			}
+			return 0, nil, nil
+		}
+		return n + int(size), data[n : n+int(size)], nil
+	}
+}
@@ -0,0 +1,15 @@
+package sensorPipe
+
+import "polvo/metrics"
+
+// metrics of sensors
+var (
+	sensorLines = metrics.Default.NewCounterVec("polvo_sensor_lines_total",
+		"Lines read from stdout of sensor.", "sensor")
+	sensorWrapErrors = metrics.Default.NewCounterVec("polvo_sensor_wrap_errors_total",
+		"Lines of sensor skipped because they could not be wrapped into logs. e.g. invalid JSON", "sensor")
+	sensorOversizedRecords = metrics.Default.NewCounterVec("polvo_sensor_oversized_records_total",
+		"Records of sensor skipped because they are larger than the max record size.", "sensor")
+	sensorRestarts = metrics.Default.NewCounterVec("polvo_sensor_restarts_total",
+		"Restarts of sensor by its restart policy.", "sensor")
+)
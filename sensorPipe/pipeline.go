@@ -7,8 +7,10 @@ import (
 	"os"
 	perror "polvo/error"
 	plogger "polvo/logger"
+	"polvo/metrics"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -23,10 +25,20 @@ type Pipe[log any] interface {
 	// Getter & Setter
 	Name() string
 	IsRunning() bool
+	IsPaused() bool
+	Pid() int
+	StartedAt() time.Time
+	Restarts() int
+	SetRestartPolicy(RestartPolicy) error
+	SetProcAttr(*ProcAttr) error
+	SetStderrRateLimit(int) error
+	SetFraming(Framing) error
 	// methods
 	Start(string, ...string) error
 	Wait() error
 	Stop() error
+	Pause() error
+	Resume() error
 }
 
 type pipe[log any] struct {
@@ -36,6 +48,11 @@ type pipe[log any] struct {
 	readStream  *os.File
 	writeStream *os.File
 	scanner     *bufio.Scanner
+	// stderr of sensor
+	errReadStream  *os.File
+	errWriteStream *os.File
+	errScanner     *bufio.Scanner
+	stderrLimit    int
 	// thread control
 	ctx context.Context
 	eg  *errgroup.Group
@@ -43,12 +60,32 @@ type pipe[log any] struct {
 	waitScanner sync.WaitGroup
 	wrapFunc    func(string) (*log, error)
 	pid         int
+	startedAt   time.Time
 	promise     Promise
+	promiseLock sync.Mutex
+	// isPaused is guarded by promiseLock. the sensor is stopped by SIGSTOP while it is paused.
+	isPaused bool
+	// done is closed when Stop is called. started is closed after the first execution of sensor.
+	done      chan struct{}
+	started   chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	// restart
+	restartPolicy RestartPolicy
+	// attributes of sensor process
+	procAttr *ProcAttr
 	// conditional variable
-	isStarted int32
-	isClosed  int32
-	waitCount int32
-	procExit  int32
+	isStarted    int32
+	isClosed     int32
+	isStopping   int32
+	waitCount    int32
+	procExit     int32
+	restartCount int32
+	// metrics of sensor
+	lines      *metrics.Counter
+	wrapErrors *metrics.Counter
+	oversized  *metrics.Counter
+	restarts   *metrics.Counter
 	// dependency
 	logger plogger.PolvoLogger
 }
@@ -90,17 +127,30 @@ func NewPipe[log any](
 		}
 	}
 	newPipe.sensorName = sensorName
-	newPipe.scanner = bufio.NewScanner(newPipe.readStream)
+	newPipe.lines = sensorLines.With(sensorName)
+	newPipe.wrapErrors = sensorWrapErrors.With(sensorName)
+	newPipe.oversized = sensorOversizedRecords.With(sensorName)
+	newPipe.restarts = sensorRestarts.With(sensorName)
+	newPipe.scanner = newRecordScanner(newPipe.readStream, Framing{Mode: FramingLine, MaxRecordSize: DefaultMaxRecordSize}, newPipe.skipRecord)
+	newPipe.errScanner = bufio.NewScanner(newPipe.errReadStream)
+	newPipe.stderrLimit = defaultStderrRateLimit
 	// init thread control
 	newPipe.eg, newPipe.ctx = errgroup.WithContext(context.Background())
 	// init waitGroup
 	newPipe.waitScanner = sync.WaitGroup{}
 	newPipe.promise = nil
+	newPipe.done = make(chan struct{})
+	newPipe.started = make(chan struct{})
+	// sensor is not restarted by default
+	newPipe.restartPolicy = RestartPolicy{Policy: RestartNever}
+	newPipe.restartPolicy.validate()
 	// set conditional variable to 0
 	atomic.StoreInt32(&newPipe.isClosed, 0)
 	atomic.StoreInt32(&newPipe.isStarted, 0)
+	atomic.StoreInt32(&newPipe.isStopping, 0)
 	atomic.StoreInt32(&newPipe.waitCount, 0)
 	atomic.StoreInt32(&newPipe.procExit, 0)
+	atomic.StoreInt32(&newPipe.restartCount, 0)
 	// set dependencies
 	newPipe.logger = logger
 	return newPipe, nil
@@ -125,6 +175,17 @@ func (p *pipe[log]) openPipeStream() (err error) {
 			Msg:    "error while construct new pipeline",
 		}
 	}
+	p.errReadStream, p.errWriteStream, err = os.Pipe()
+	if err != nil {
+		p.readStream.Close()
+		p.writeStream.Close()
+		p.logger.PrintError("error while create console %s", err.Error())
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorExecute,
+			Origin: err,
+			Msg:    "error while construct new pipeline",
+		}
+	}
 	return nil
 }
 
@@ -136,13 +197,105 @@ func (p *pipe[log]) Name() string {
 	return p.sensorName
 }
 
+// IsRunning returns whether the process of sensor is alive. It is false while the sensor waits for restart.
+// Paused sensor is running.
 func (p *pipe[log]) IsRunning() bool {
-	if p.pid == 0 || p.promise == nil {
-		return false
+	p.promiseLock.Lock()
+	defer p.promiseLock.Unlock()
+
+	return p.promise != nil && atomic.LoadInt32(&p.procExit) <= 0 && atomic.LoadInt32(&p.isStopping) <= 0
+}
+
+// IsPaused returns whether the sensor is paused by Pause.
+func (p *pipe[log]) IsPaused() bool {
+	p.promiseLock.Lock()
+	defer p.promiseLock.Unlock()
+
+	return p.isPaused
+}
+
+// Pid returns the pid of the current process of sensor. 0 if the sensor is not running.
+func (p *pipe[log]) Pid() int {
+	if !p.IsRunning() {
+		return 0
+	}
+	p.promiseLock.Lock()
+	defer p.promiseLock.Unlock()
+
+	return p.pid
+}
+
+// StartedAt returns when the current process of sensor is started. zero if the sensor is not running.
+func (p *pipe[log]) StartedAt() time.Time {
+	if !p.IsRunning() {
+		return time.Time{}
+	}
+	p.promiseLock.Lock()
+	defer p.promiseLock.Unlock()
+
+	return p.startedAt
+}
+
+// Restarts returns how many times the sensor has been restarted.
+func (p *pipe[log]) Restarts() int {
+	return int(atomic.LoadInt32(&p.restartCount))
+}
+
+// SetRestartPolicy sets the restart policy. It must be called before Start.
+func (p *pipe[log]) SetRestartPolicy(policy RestartPolicy) error {
+	if atomic.LoadInt32(&p.isStarted) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("sensor is already started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetRestartPolicy()", p.sensorName),
+		}
+	}
+	if err := policy.validate(); err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorCreate,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetRestartPolicy()", p.sensorName),
+		}
 	}
-	proc, err := os.FindProcess(p.pid)
-	fmt.Fprintf(os.Stderr, "pid: %d, proc: %v, err: %v\n", p.pid, proc, err)
-	return err == nil
+	p.restartPolicy = policy
+	return nil
+}
+
+// SetProcAttr sets the credential & capabilities of the sensor process. It must be called before Start.
+func (p *pipe[log]) SetProcAttr(attr *ProcAttr) error {
+	if atomic.LoadInt32(&p.isStarted) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("sensor is already started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetProcAttr()", p.sensorName),
+		}
+	}
+	p.procAttr = attr
+	return nil
+}
+
+// SetStderrRateLimit sets the maximum number of stderr lines per second written to the agent log.
+// 0 means the default limit. It must be called before Start.
+func (p *pipe[log]) SetStderrRateLimit(linesPerSecond int) error {
+	if atomic.LoadInt32(&p.isStarted) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("sensor is already started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetStderrRateLimit()", p.sensorName),
+		}
+	}
+	if linesPerSecond < 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidArgumentError,
+			Origin: fmt.Errorf("invalid stderr rate limit %d", linesPerSecond),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetStderrRateLimit()", p.sensorName),
+		}
+	}
+	if linesPerSecond == 0 {
+		linesPerSecond = defaultStderrRateLimit
+	}
+	p.stderrLimit = linesPerSecond
+	return nil
 }
 
 /****************************************************
@@ -155,7 +308,7 @@ func (p *pipe[log]) IsRunning() bool {
 // It returns an error if the sensor is already started.
 func (p *pipe[log]) Start(arg0 string, arg1 ...string) error {
 	// prevent duplicated sensor thread
-	if atomic.LoadInt32(&p.isStarted) > 0 || p.promise != nil {
+	if atomic.LoadInt32(&p.isStarted) > 0 || p.currentPromise() != nil {
 		return perror.PolvoPipelineError{
 			Code:   perror.ErrSensorExecute,
 			Origin: fmt.Errorf("sensor is already started"),
@@ -164,19 +317,71 @@ func (p *pipe[log]) Start(arg0 string, arg1 ...string) error {
 	}
 	// start scanner thread
 	// scannerThread is not managed by errgroup because it will be terminated by EOF.
+	// waitGroup is set before the threads are started, so Stop can not wait before they are counted.
+	p.waitScanner.Add(2)
 	go p.scannerThread()
+	go p.stderrThread()
 
 	// start sensor thread
 	p.eg.Go(func() error {
 		return p.sensorThread(arg0, arg1...)
 	})
-	// live lock until promise is allocated
-	for p.promise == nil {
-		time.Sleep(1 * time.Millisecond)
-	}
+	// block until sensor is executed
+	<-p.started
 	// set conditional variable to 0
 	atomic.AddInt32(&p.isClosed, 0)
 	atomic.AddInt32(&p.isStarted, 1)
+	if p.currentPromise() == nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorExecute,
+			Origin: fmt.Errorf("failed to execute sensor %s", arg0),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Start()", p.sensorName),
+		}
+	}
+	return nil
+}
+
+// # Pause
+//
+// Pause stops the process group of sensor by SIGSTOP. The sensor keeps its state & output not read yet.
+// If Pause is called before Start or while the sensor waits for restart, the sensor is paused when it is executed.
+func (p *pipe[log]) Pause() error {
+	return p.setPaused(true, syscall.SIGSTOP, "Pause")
+}
+
+// # Resume
+//
+// Resume continues the sensor paused by Pause.
+func (p *pipe[log]) Resume() error {
+	return p.setPaused(false, syscall.SIGCONT, "Resume")
+}
+
+func (p *pipe[log]) setPaused(paused bool, sig syscall.Signal, method string) error {
+	p.promiseLock.Lock()
+	defer p.promiseLock.Unlock()
+
+	if atomic.LoadInt32(&p.isStopping) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("sensor is stopped"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].%s()", p.sensorName, method),
+		}
+	}
+	if p.isPaused == paused {
+		return nil
+	}
+	p.isPaused = paused
+	if p.promise == nil || atomic.LoadInt32(&p.procExit) > 0 {
+		return nil
+	}
+	if err := p.promise.Signal(sig); err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorPanic,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].%s()", p.sensorName, method),
+		}
+	}
+	p.logger.PrintInfo("pipeline [%s]: sensor is paused: %v", p.sensorName, paused)
 	return nil
 }
 
@@ -187,17 +392,30 @@ func (p *pipe[log]) Start(arg0 string, arg1 ...string) error {
 // Instead, pipeCloser goroutine will be called to close the logChannel. pipeCloser will be blocked by live lock until all logs are exported.
 func (p *pipe[log]) Stop() (err error) {
 	// stop sensor thread
+	p.promiseLock.Lock()
+	promise := p.promise
 	// prevent Call Stop() before Run()
-	if p.promise == nil {
+	if promise == nil {
+		p.promiseLock.Unlock()
 		return perror.PolvoGeneralError{
 			Code:   perror.InvalidOperationError,
 			Origin: fmt.Errorf("stop is called before start"),
 			Msg:    fmt.Sprintf("error while execute pipeline[%s].Stop()", p.sensorName),
 		}
 	}
+	// prevent restart of sensor & wake up scanner thread blocked on logChannel
+	p.stopOnce.Do(func() {
+		atomic.StoreInt32(&p.isStopping, 1)
+		close(p.done)
+	})
+	// stopped process does not handle signals of Cancel
+	if p.isPaused && atomic.LoadInt32(&p.procExit) <= 0 {
+		promise.Signal(syscall.SIGCONT)
+	}
+	p.promiseLock.Unlock()
 	// prevent call stop when sensor is already stopped
 	if atomic.LoadInt32(&p.procExit) <= 0 {
-		err = p.promise.Cancel()
+		err = promise.Cancel()
 		if err != nil {
 			return perror.PolvoPipelineError{
 				Code:   perror.ErrSensorPanic,
@@ -225,7 +443,9 @@ func (p *pipe[log]) Stop() (err error) {
 	}
 	// set conditional variable to 1
 	atomic.AddInt32(&p.isClosed, 1)
+	p.promiseLock.Lock()
 	p.promise = nil
+	p.promiseLock.Unlock()
 	return nil
 }
 
@@ -287,7 +507,17 @@ func (p *pipe[log]) flushStreams() error {
 			Msg:    fmt.Sprintf("error while execute pipeline[%s].streamCloser", p.sensorName),
 		}
 	}
+	// stderr is drained by EOF. it is closed after stderrThread is finished.
+	err = p.errWriteStream.Close()
+	if err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorPanic,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].streamCloser", p.sensorName),
+		}
+	}
 	p.waitScanner.Wait()
+	p.errReadStream.Close()
 	return nil
 }
 
@@ -298,21 +528,27 @@ func (p *pipe[log]) scannerThread() error {
 	)
 
 	p.logger.PrintInfo("pipeline [%s]: scanner thread is started", p.sensorName)
-	// set waitGroup
-	p.waitScanner.Add(1)
 	defer p.waitScanner.Done()
 
 	// read from readStream
 	// write to logger
 	for p.scanner.Scan() {
+		p.lines.Inc()
 		lg, err = p.wrapFunc(p.scanner.Text())
 		if err != nil {
 			// if error while wrap log, just skip this log
+			p.wrapErrors.Inc()
 			p.logger.PrintError("pipeline [%s] sensor: error while wrap log. %s", p.sensorName, err.Error())
 			continue
 		}
 		// send log to pipeline
-		p.logChannel <- lg
+		// logs are not delivered anymore after Stop is called
+		select {
+		case p.logChannel <- lg:
+		case <-p.done:
+			p.logger.PrintInfo("pipeline [%s]: scanner thread is closed", p.sensorName)
+			return nil
+		}
 	}
 
 	if err = p.scanner.Err(); err != nil {
@@ -323,10 +559,97 @@ func (p *pipe[log]) scannerThread() error {
 	return nil
 }
 
+// # stderrThread
+//
+// stderrThread forwards stderr of the sensor to the agent log line by line.
+func (p *pipe[log]) stderrThread() {
+	defer p.waitScanner.Done()
+
+	forwarder := newStderrForwarder(p.sensorName, p.logger.Logger(), p.stderrLimit, func() int {
+		p.promiseLock.Lock()
+		defer p.promiseLock.Unlock()
+		return p.pid
+	})
+	for p.errScanner.Scan() {
+		forwarder.forward(p.errScanner.Text(), time.Now())
+	}
+	if err := p.errScanner.Err(); err != nil {
+		p.logger.PrintError("pipeline [%s] sensor: error while read stderr of sensor. %s", p.sensorName, err.Error())
+	}
+}
+
+func (p *pipe[logWrapper]) currentPromise() Promise {
+	p.promiseLock.Lock()
+	defer p.promiseLock.Unlock()
+
+	return p.promise
+}
+
+// # sensorThread
+//
+// sensorThread executes the sensor & restarts it according to the restart policy.
+// It returns nil if the sensor is stopped by Stop, and the last error of the sensor if it is not restarted.
 func (p *pipe[logWrapper]) sensorThread(argv0 string, argv1 ...string) error {
-	var err error
+	tracker := newRestartTracker(p.restartPolicy)
+	for {
+		startedAt := time.Now()
+		err := p.runSensor(argv0, argv1...)
+		if atomic.LoadInt32(&p.isStopping) > 0 {
+			return nil
+		}
+		// sensor could not be executed. restart does not help.
+		if perr, ok := err.(perror.PolvoPipelineError); ok && perr.Code == perror.ErrSensorExecute {
+			return err
+		}
+		delay, reason := tracker.next(err, time.Since(startedAt), time.Now())
+		if reason != nil {
+			if err != nil {
+				p.logger.PrintError("pipeline [%s]: sensor is not restarted. %s", p.sensorName, reason.Error())
+			}
+			return err
+		}
+		p.logger.PrintError("pipeline [%s]: sensor exited. restart after %v", p.sensorName, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-p.done:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		atomic.AddInt32(&p.restartCount, 1)
+		p.restarts.Inc()
+	}
+}
+
+// runSensor executes the sensor once & blocks until it exits.
+func (p *pipe[logWrapper]) runSensor(argv0 string, argv1 ...string) error {
 	// execute sensor
-	p.promise, err = Run(os.Stdin, p.writeStream, argv0, argv1...)
+	p.promiseLock.Lock()
+	if atomic.LoadInt32(&p.isStopping) > 0 {
+		p.promiseLock.Unlock()
+		return nil
+	}
+	attr := ProcAttr{Stderr: p.errWriteStream}
+	if p.procAttr != nil {
+		attr.Credential = p.procAttr.Credential
+		attr.AmbientCaps = p.procAttr.AmbientCaps
+	}
+	promise, err := RunWithAttr(os.Stdin, p.writeStream, &attr, argv0, argv1...)
+	if err == nil {
+		p.promise = promise
+		p.pid = promise.Pid()
+		p.startedAt = time.Now()
+		atomic.StoreInt32(&p.procExit, 0)
+		// restarted sensor keeps paused
+		if p.isPaused {
+			if err := promise.Signal(syscall.SIGSTOP); err != nil {
+				p.logger.PrintError("pipeline [%s]: error while pause sensor. %s", p.sensorName, err.Error())
+			}
+		}
+	}
+	p.promiseLock.Unlock()
+	// unblock Start after the first execution
+	p.startOnce.Do(func() { close(p.started) })
 	if err != nil {
 		// if error occurs, uncontrollable error. so panic
 		p.logger.PrintError("failed to start pipeline [%s]: %v", p.sensorName, err)
@@ -336,10 +659,9 @@ func (p *pipe[logWrapper]) sensorThread(argv0 string, argv1 ...string) error {
 			Msg:    fmt.Sprintf("error in sensor[%s] thread", p.sensorName),
 		}
 	}
-	p.pid = p.promise.Pid()
 	p.logger.PrintInfo("pipeline [%s]: sensor thread is started", p.sensorName)
 	// blocked until sensor thread is finished
-	exitCode, err := p.promise.Wait()
+	exitCode, err := promise.Wait()
 	defer atomic.StoreInt32(&p.procExit, 1)
 
 	if err != nil {
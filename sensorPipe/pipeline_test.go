@@ -8,6 +8,7 @@ import (
 	"polvo/sensorPipe"
 	"strings"
 	"testing"
+	"time"
 )
 
 type Samplelog struct {
@@ -248,3 +249,301 @@ func TestPipelineCallWaitAfterStop(t *testing.T) {
 // 	}
 // 	t.Logf("Error: %v", err)
 // }
+
+func TestPipelineRestartOnFailure(t *testing.T) {
+	logChan := make(chan *Samplelog)
+	defer close(logChan)
+
+	pipe, err := sensorPipe.NewPipe("sensor", loger, logChan, Wrap)
+	if err != nil {
+		t.Fatalf("error while create pipeline %v", err)
+	}
+	err = pipe.SetRestartPolicy(sensorPipe.RestartPolicy{
+		Policy:      sensorPipe.RestartOnFailure,
+		MaxRestarts: 2,
+		Backoff:     10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("error while set restart policy %v", err)
+	}
+	err = pipe.Start(filepath.Join(pwd, "testdata", "dummy_crash.sh"))
+	if err != nil {
+		t.Fatalf("Error while starting pipeline %v", err)
+	}
+	defer pipe.Stop()
+
+	// every execution prints a log
+	for i := 0; i < 3; i++ {
+		select {
+		case <-logChan:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d logs, want 3", i)
+		}
+	}
+	err = pipe.Wait()
+	if err == nil {
+		t.Errorf("Error should be raised after restart limit")
+	}
+	if pipe.Restarts() != 2 {
+		t.Errorf("pipe.Restarts() = %d, want 2", pipe.Restarts())
+	}
+}
+
+func TestPipelineRestartStoppedByStop(t *testing.T) {
+	logChan := make(chan *Samplelog)
+	defer close(logChan)
+
+	pipe, err := sensorPipe.NewPipe("sensor", loger, logChan, Wrap)
+	if err != nil {
+		t.Fatalf("error while create pipeline %v", err)
+	}
+	err = pipe.SetRestartPolicy(sensorPipe.RestartPolicy{Policy: sensorPipe.RestartAlways, Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("error while set restart policy %v", err)
+	}
+	err = pipe.Start(filepath.Join(pwd, "testdata", "dummy.sh"))
+	if err != nil {
+		t.Fatalf("Error while starting pipeline %v", err)
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- pipe.Wait()
+	}()
+	for i := 0; i < 10; i++ {
+		<-logChan
+	}
+	if err = pipe.Stop(); err != nil {
+		t.Errorf("Error while stopping pipeline %v", err)
+	}
+	// sensor stopped by Stop is not restarted
+	select {
+	case err = <-waitErr:
+		if err != nil {
+			t.Errorf("pipe.Wait() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pipe.Wait() is not returned after Stop")
+	}
+	if pipe.Restarts() != 0 {
+		t.Errorf("pipe.Restarts() = %d, want 0", pipe.Restarts())
+	}
+}
+
+func TestPipelineInvalidRestartPolicy(t *testing.T) {
+	logChan := make(chan *Samplelog)
+	defer close(logChan)
+
+	pipe, err := sensorPipe.NewPipe("sensor", loger, logChan, Wrap)
+	if err != nil {
+		t.Fatalf("error while create pipeline %v", err)
+	}
+	err = pipe.SetRestartPolicy(sensorPipe.RestartPolicy{Policy: "sometimes"})
+	if err == nil {
+		t.Errorf("Error should have been raised")
+	}
+	t.Logf("Error: %v", err)
+}
+
+func TestPipelineStderrForwarded(t *testing.T) {
+	logChan := make(chan *Samplelog)
+	defer close(logChan)
+
+	pipe, err := sensorPipe.NewPipe("stderr_sensor", loger, logChan, Wrap)
+	if err != nil {
+		t.Fatalf("error while create pipeline %v", err)
+	}
+	if err = pipe.SetStderrRateLimit(6); err != nil {
+		t.Fatalf("error while set stderr rate limit %v", err)
+	}
+	err = pipe.Start(filepath.Join(pwd, "testdata", "dummy_stderr.sh"))
+	if err != nil {
+		t.Fatalf("Error while starting pipeline %v", err)
+	}
+	select {
+	case <-logChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("log of sensor is not received")
+	}
+	if err = pipe.Wait(); err != nil {
+		t.Errorf("pipe.Wait() = %v, want nil", err)
+	}
+	// Stop flushes stderr of sensor
+	if err = pipe.Stop(); err != nil {
+		t.Errorf("Error while stopping pipeline %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(logpath, "service.log"))
+	if err != nil {
+		t.Fatalf("error while read agent log %v", err)
+	}
+	var (
+		levels     = make(map[string]string)
+		suppressed bool
+		noises     int
+	)
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.Contains(line, `"sensor": "stderr_sensor"`) {
+			continue
+		}
+		if !strings.Contains(line, `"pid":`) {
+			t.Errorf("stderr log %s has no pid", line)
+		}
+		switch {
+		case strings.Contains(line, "ring buffer"):
+			levels["warning"] = line
+		case strings.Contains(line, "attach probe"):
+			levels["error"] = line
+		case strings.Contains(line, "is exiting"):
+			levels["info"] = line
+		case strings.Contains(line, "0 errors"):
+			levels["count"] = line
+		case strings.Contains(line, "no error found"):
+			levels["negation"] = line
+		case strings.Contains(line, "debugger"):
+			levels["word"] = line
+		case strings.Contains(line, "slow consumer"):
+			levels["field"] = line
+		case strings.Contains(line, "noise"):
+			noises++
+		case strings.Contains(line, `"suppressed": `):
+			suppressed = true
+		}
+	}
+	for text, want := range map[string]string{
+		"warning":  "\twarn\t",
+		"error":    "\terror\t",
+		"info":     "\tinfo\t",
+		"count":    "\tinfo\t",
+		"negation": "\tinfo\t",
+		"word":     "\tinfo\t",
+		"field":    "\twarn\t",
+	} {
+		if !strings.Contains(levels[text], want) {
+			t.Errorf("stderr log of %s = %q, want %q", text, levels[text], want)
+		}
+	}
+	if noises >= 50 || !suppressed {
+		t.Errorf("stderr logs are not rate limited. noises: %d, suppressed: %v", noises, suppressed)
+	}
+}
+
+func TestPipelineInvalidStderrRateLimit(t *testing.T) {
+	logChan := make(chan *Samplelog)
+	defer close(logChan)
+
+	pipe, err := sensorPipe.NewPipe("sensor", loger, logChan, Wrap)
+	if err != nil {
+		t.Fatalf("error while create pipeline %v", err)
+	}
+	if err = pipe.SetStderrRateLimit(-1); err == nil {
+		t.Errorf("pipe.SetStderrRateLimit(-1) = nil, want error")
+	}
+}
+
+func TestPipelinePauseAndResume(t *testing.T) {
+	logChan := make(chan *Samplelog)
+	defer close(logChan)
+
+	pipe, err := sensorPipe.NewPipe("sensor", loger, logChan, Wrap)
+	if err != nil {
+		t.Fatalf("error while create pipeline %v", err)
+	}
+	err = pipe.Start(filepath.Join(pwd, "testdata", "dummy.sh"))
+	if err != nil {
+		t.Fatalf("Error while starting pipeline %v", err)
+	}
+	if !pipe.IsRunning() || pipe.Pid() <= 0 || pipe.StartedAt().IsZero() {
+		t.Errorf("IsRunning() = %v, Pid() = %d, StartedAt() = %v, want running sensor", pipe.IsRunning(), pipe.Pid(), pipe.StartedAt())
+	}
+	<-logChan
+
+	if err = pipe.Pause(); err != nil {
+		t.Fatalf("pipe.Pause() = %v, want nil", err)
+	}
+	if !pipe.IsPaused() || !pipe.IsRunning() {
+		t.Errorf("IsPaused() = %v, IsRunning() = %v, want paused & running", pipe.IsPaused(), pipe.IsRunning())
+	}
+	// drain logs written before pause
+	for quiet := false; !quiet; {
+		select {
+		case <-logChan:
+		case <-time.After(300 * time.Millisecond):
+			quiet = true
+		}
+	}
+	select {
+	case log := <-logChan:
+		t.Errorf("paused sensor sent log %v", log)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err = pipe.Resume(); err != nil {
+		t.Fatalf("pipe.Resume() = %v, want nil", err)
+	}
+	select {
+	case <-logChan:
+	case <-time.After(5 * time.Second):
+		t.Errorf("resumed sensor sent no log")
+	}
+
+	// paused sensor is stopped, too
+	if err = pipe.Pause(); err != nil {
+		t.Fatalf("pipe.Pause() = %v, want nil", err)
+	}
+	if err = pipe.Stop(); err != nil {
+		t.Errorf("pipe.Stop() = %v, want nil", err)
+	}
+	if pipe.IsRunning() || pipe.Pid() != 0 {
+		t.Errorf("IsRunning() = %v, Pid() = %d, want stopped sensor", pipe.IsRunning(), pipe.Pid())
+	}
+}
+
+func TestPipelineFramingSkipsOversizedRecords(t *testing.T) {
+	for _, test := range []struct {
+		script  string
+		framing sensorPipe.Framing
+	}{
+		{"dummy_oversized.sh", sensorPipe.Framing{MaxRecordSize: 64}},
+		{"dummy_framed.sh", sensorPipe.Framing{Mode: sensorPipe.FramingLengthPrefixed, MaxRecordSize: 64}},
+	} {
+		logChan := make(chan *Samplelog)
+		pipe, err := sensorPipe.NewPipe("sensor", loger, logChan, Wrap)
+		if err != nil {
+			t.Fatalf("error while create pipeline %v", err)
+		}
+		if err = pipe.SetFraming(test.framing); err != nil {
+			t.Fatalf("SetFraming(%v) = %v, want nil", test.framing, err)
+		}
+		if err = pipe.Start(filepath.Join(pwd, "testdata", test.script)); err != nil {
+			t.Fatalf("Error while starting pipeline %v", err)
+		}
+		// records after the oversized record are read
+		for _, want := range []string{"1", "2"} {
+			select {
+			case log := <-logChan:
+				if log.Pid != want {
+					t.Errorf("%s: log = %v, want small %s", test.script, log, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("%s: log small %s is not read", test.script, want)
+			}
+		}
+		if err = pipe.Stop(); err != nil {
+			t.Errorf("pipe.Stop() = %v, want nil", err)
+		}
+		close(logChan)
+	}
+}
+
+func TestPipelineInvalidFraming(t *testing.T) {
+	pipe, err := sensorPipe.NewPipe("sensor", loger, make(chan *Samplelog), Wrap)
+	if err != nil {
+		t.Fatalf("error while create pipeline %v", err)
+	}
+	if err = pipe.SetFraming(sensorPipe.Framing{Mode: "chunked"}); err == nil {
+		t.Errorf("SetFraming(chunked) = nil, want error")
+	}
+	if err = pipe.SetFraming(sensorPipe.Framing{MaxRecordSize: -1}); err == nil {
+		t.Errorf("SetFraming(-1) = nil, want error")
+	}
+}
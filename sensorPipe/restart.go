@@ -0,0 +1,104 @@
+package sensorPipe
+
+import (
+	"fmt"
+	"time"
+)
+
+// restart policies of sensor
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+const (
+	// default backoff of restart
+	defaultRestartBackoff    = 1 * time.Second
+	defaultRestartMaxBackoff = 60 * time.Second
+)
+
+// # RestartPolicy
+//
+// RestartPolicy decides whether the sensor is restarted after it exits.
+//
+// - Policy is one of RestartNever (default), RestartOnFailure and RestartAlways.
+//
+// - MaxRestarts is the maximum number of restarts within Window. 0 means unlimited.
+// If Window is 0, restarts are counted from the start of the pipeline.
+//
+// - Backoff is the delay before the first restart. It is doubled for each restart up to MaxBackoff,
+// and reset when the sensor has run longer than MaxBackoff.
+type RestartPolicy struct {
+	Policy      string
+	MaxRestarts int
+	Window      time.Duration
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+func (rp *RestartPolicy) validate() error {
+	switch rp.Policy {
+	case "":
+		rp.Policy = RestartNever
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("invalid restart policy %s", rp.Policy)
+	}
+	if rp.MaxRestarts < 0 || rp.Window < 0 || rp.Backoff < 0 || rp.MaxBackoff < 0 {
+		return fmt.Errorf("restart limits must not be negative")
+	}
+	if rp.Backoff == 0 {
+		rp.Backoff = defaultRestartBackoff
+	}
+	if rp.MaxBackoff == 0 {
+		rp.MaxBackoff = max(defaultRestartMaxBackoff, rp.Backoff)
+	}
+	return nil
+}
+
+// restartTracker counts restarts & computes backoff of a sensor.
+type restartTracker struct {
+	policy   RestartPolicy
+	restarts []time.Time
+	backoff  time.Duration
+}
+
+func newRestartTracker(policy RestartPolicy) *restartTracker {
+	return &restartTracker{policy: policy, backoff: policy.Backoff}
+}
+
+// next decides whether the sensor which exited with err after running for uptime is restarted.
+// It returns the delay before restart, or an error explaining why it is not restarted.
+func (rt *restartTracker) next(exitErr error, uptime time.Duration, now time.Time) (time.Duration, error) {
+	switch rt.policy.Policy {
+	case RestartAlways:
+	case RestartOnFailure:
+		if exitErr == nil {
+			return 0, fmt.Errorf("sensor exited successfully")
+		}
+	default:
+		return 0, fmt.Errorf("restart policy is %s", rt.policy.Policy)
+	}
+	// forget restarts out of window
+	if rt.policy.Window > 0 {
+		valid := rt.restarts[:0]
+		for _, restartedAt := range rt.restarts {
+			if now.Sub(restartedAt) < rt.policy.Window {
+				valid = append(valid, restartedAt)
+			}
+		}
+		rt.restarts = valid
+	}
+	if rt.policy.MaxRestarts > 0 && len(rt.restarts) >= rt.policy.MaxRestarts {
+		return 0, fmt.Errorf("sensor is restarted %d times within %v", len(rt.restarts), rt.policy.Window)
+	}
+	// sensor was stable. start over the backoff
+	if uptime > rt.policy.MaxBackoff {
+		rt.backoff = rt.policy.Backoff
+	}
+	delay := rt.backoff
+	rt.backoff = min(rt.backoff*2, rt.policy.MaxBackoff)
+	rt.restarts = append(rt.restarts, now.Add(delay))
+	return delay, nil
+}
@@ -0,0 +1,95 @@
+package sensorPipe
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// default rate limit of stderr lines per second
+const defaultStderrRateLimit = 100
+
+// keywords of log levels in sensor stderr. Lines without keywords are logged as info.
+var stderrLevelKeywords = map[string]zapcore.Level{
+	"panic":   zapcore.ErrorLevel,
+	"fatal":   zapcore.ErrorLevel,
+	"error":   zapcore.ErrorLevel,
+	"err":     zapcore.ErrorLevel,
+	"warn":    zapcore.WarnLevel,
+	"warning": zapcore.WarnLevel,
+	"info":    zapcore.InfoLevel,
+	"debug":   zapcore.DebugLevel,
+	"trace":   zapcore.DebugLevel,
+}
+
+var (
+	// level token at the beginning of line after timestamps & brackets. e.g. "ERROR: ...", "[warn] ...", "12:00:01 debug ..."
+	stderrLeadingLevel = regexp.MustCompile(`^[^a-z]*(?:level=|lvl=)?([a-z]+)\b`)
+	// level field of logfmt lines. e.g. time=... level=error msg=...
+	stderrLevelField = regexp.MustCompile(`\b(?:level|lvl)=["']?([a-z]+)\b`)
+)
+
+// # stderrForwarder
+//
+// stderrForwarder writes stderr lines of a sensor into the agent log with the sensor name & pid.
+// Lines exceeding the rate limit are dropped & reported as a single line once the limit allows it again.
+type stderrForwarder struct {
+	logger  *zap.Logger
+	pidFunc func() int
+	// token bucket
+	rate       float64
+	tokens     float64
+	last       time.Time
+	suppressed int
+}
+
+func newStderrForwarder(sensorName string, logger *zap.Logger, rateLimit int, pidFunc func() int) *stderrForwarder {
+	if rateLimit <= 0 {
+		rateLimit = defaultStderrRateLimit
+	}
+	return &stderrForwarder{
+		// caller & stacktrace of agent are meaningless for sensor logs
+		logger:  logger.WithOptions(zap.WithCaller(false), zap.AddStacktrace(zapcore.FatalLevel)).With(zap.String("sensor", sensorName), zap.String("stream", "stderr")),
+		pidFunc: pidFunc,
+		rate:    float64(rateLimit),
+		tokens:  float64(rateLimit),
+		last:    time.Now(),
+	}
+}
+
+func (sf *stderrForwarder) forward(line string, now time.Time) {
+	// refill tokens. burst is a second of lines.
+	sf.tokens = min(sf.rate, sf.tokens+now.Sub(sf.last).Seconds()*sf.rate)
+	sf.last = now
+	if sf.tokens < 1 {
+		sf.suppressed++
+		return
+	}
+	sf.tokens--
+
+	pid := zap.Int("pid", sf.pidFunc())
+	if sf.suppressed > 0 {
+		sf.logger.Warn("stderr lines are suppressed by rate limit", pid, zap.Int("suppressed", sf.suppressed))
+		sf.suppressed = 0
+	}
+	if entry := sf.logger.Check(detectLevel(line), line); entry != nil {
+		entry.Write(pid)
+	}
+}
+
+// detectLevel returns the level of the leading level token or the level field of line.
+// Keywords elsewhere in line are not levels. e.g. "0 errors", "no error found" & "debugger attached" are info.
+func detectLevel(line string) zapcore.Level {
+	lower := strings.ToLower(line)
+	for _, pattern := range []*regexp.Regexp{stderrLeadingLevel, stderrLevelField} {
+		if match := pattern.FindStringSubmatch(lower); match != nil {
+			if level, ok := stderrLevelKeywords[match[1]]; ok {
+				return level
+			}
+		}
+	}
+	return zapcore.InfoLevel
+}
@@ -0,0 +1,611 @@
+package sensorPipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	perror "polvo/error"
+	plogger "polvo/logger"
+	"polvo/metrics"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultTailPollInterval is the interval to read files when TailConfig.PollInterval is 0.
+const DefaultTailPollInterval = 1 * time.Second
+
+// size of chunk read from file at once
+const tailReadSize = 32 * 1024
+
+// # TailConfig
+//
+// TailConfig is the files followed by a tail sensor.
+type TailConfig struct {
+	// Paths are glob patterns of files. New files matching them are read from the beginning.
+	Paths []string
+	// StatePath is the file where read offsets are persisted. Empty means offsets are not persisted.
+	StatePath string
+	// PollInterval is the interval to read files & check rotation. 0 means DefaultTailPollInterval.
+	PollInterval time.Duration
+	// StartAtEnd skips the content of files which exist on start & have no persisted offset.
+	StartAtEnd bool
+}
+
+// tailOffset is the read offset of a file. Files are identified by device & inode, so offsets follow renamed files.
+type tailOffset struct {
+	Path   string `json:"path"`
+	Dev    uint64 `json:"dev"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// tailFile is a file followed by tailPipe. It is accessed only by the poll thread.
+type tailFile struct {
+	path string
+	file *os.File
+	dev  uint64
+	ino  uint64
+	// offset is the end of the last line which is sent or skipped
+	offset int64
+	// partial is the last line which is not terminated yet
+	partial []byte
+	// skipping is true while the rest of an oversized line is discarded. skipped is its size.
+	skipping bool
+	skipped  int64
+}
+
+// # tailPipe
+//
+// tailPipe is an in-process sensor which follows files like tail -F. It implements Pipe, so it is managed like
+// sensors launched as subprocesses. Each line is converted by wrapFunc with the path of its file.
+//
+// Files are polled. A file is reopened when its path points to another inode (rotation),
+// and read from the beginning when it becomes shorter than the read offset (truncation).
+// The rest of a rotated file, including its unterminated last line, is read before it is closed.
+type tailPipe[log any] struct {
+	sensorName string
+	logChannel chan<- *log
+	wrapFunc   func(path string, line string) (*log, error)
+	config     TailConfig
+	// maxLineSize is the maximum size of a line without newline
+	maxLineSize int
+	// files are accessed only by the poll thread
+	files map[string]*tailFile
+	// offsets loaded from StatePath
+	savedOffsets []tailOffset
+	// last error per path. errors are logged when they are changed.
+	lastErrors map[string]string
+	// status
+	lock      sync.Mutex
+	isPaused  bool
+	startedAt time.Time
+	isStarted int32
+	waitCount int32
+	done      chan struct{}
+	finished  chan struct{}
+	stopOnce  sync.Once
+	// metrics of sensor
+	lines      *metrics.Counter
+	wrapErrors *metrics.Counter
+	oversized  *metrics.Counter
+	// dependency
+	logger plogger.PolvoLogger
+}
+
+// # NewTailPipe
+//
+// NewTailPipe creates a sensor which sends lines of files in config to logChannel.
+// Persisted offsets are loaded from config.StatePath.
+func NewTailPipe[log any](
+	sensorName string,
+	logger plogger.PolvoLogger,
+	logChannel chan<- *log,
+	wrapFunc func(path string, line string) (*log, error),
+	config TailConfig) (Pipe[log], error) {
+
+	// param check
+	if sensorName == "" {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrInvalidSensorName,
+			Origin: fmt.Errorf("invalid sensor name %s", sensorName),
+			Msg:    "error while construct new tail pipeline",
+		}
+	}
+	if len(config.Paths) == 0 || config.PollInterval < 0 {
+		return nil, perror.PolvoGeneralError{
+			Code:   perror.InvalidArgumentError,
+			Origin: fmt.Errorf("paths are empty or poll interval %v is negative", config.PollInterval),
+			Msg:    "error while construct new tail pipeline",
+		}
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = DefaultTailPollInterval
+	}
+	t := new(tailPipe[log])
+	t.sensorName = sensorName
+	t.logChannel = logChannel
+	t.wrapFunc = wrapFunc
+	t.config = config
+	t.maxLineSize = DefaultMaxRecordSize
+	t.files = make(map[string]*tailFile)
+	t.lastErrors = make(map[string]string)
+	t.done = make(chan struct{})
+	t.finished = make(chan struct{})
+	t.lines = sensorLines.With(sensorName)
+	t.wrapErrors = sensorWrapErrors.With(sensorName)
+	t.oversized = sensorOversizedRecords.With(sensorName)
+	t.logger = logger
+	// load persisted offsets
+	if config.StatePath != "" {
+		data, err := os.ReadFile(config.StatePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, perror.PolvoGeneralError{
+				Code:   perror.SystemError,
+				Origin: err,
+				Msg:    "error while construct new tail pipeline",
+			}
+		}
+		if err == nil {
+			if err = json.Unmarshal(data, &t.savedOffsets); err != nil {
+				// broken state is ignored, so the sensor is not blocked by it
+				logger.PrintError("pipeline [%s]: offsets in %s are ignored. %s", sensorName, config.StatePath, err.Error())
+				t.savedOffsets = nil
+			}
+		}
+	}
+	return t, nil
+}
+
+/****************************************************
+* Getter & Setter
+****************************************************/
+
+func (t *tailPipe[log]) Name() string {
+	return t.sensorName
+}
+
+// IsRunning returns whether files are followed. Paused sensor is running.
+func (t *tailPipe[log]) IsRunning() bool {
+	if atomic.LoadInt32(&t.isStarted) == 0 {
+		return false
+	}
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (t *tailPipe[log]) IsPaused() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.isPaused
+}
+
+// Pid returns 0 because tail sensor runs in the agent.
+func (t *tailPipe[log]) Pid() int {
+	return 0
+}
+
+func (t *tailPipe[log]) StartedAt() time.Time {
+	if !t.IsRunning() {
+		return time.Time{}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.startedAt
+}
+
+// Restarts returns 0 because tail sensor does not exit.
+func (t *tailPipe[log]) Restarts() int {
+	return 0
+}
+
+// SetRestartPolicy is ignored because tail sensor does not exit.
+func (t *tailPipe[log]) SetRestartPolicy(RestartPolicy) error {
+	return nil
+}
+
+// SetProcAttr is not supported because tail sensor has no process.
+func (t *tailPipe[log]) SetProcAttr(*ProcAttr) error {
+	return perror.PolvoGeneralError{
+		Code:   perror.InvalidOperationError,
+		Origin: fmt.Errorf("tail sensor has no process"),
+		Msg:    fmt.Sprintf("error while execute pipeline[%s].SetProcAttr()", t.sensorName),
+	}
+}
+
+// SetStderrRateLimit is ignored because tail sensor has no stderr.
+func (t *tailPipe[log]) SetStderrRateLimit(int) error {
+	return nil
+}
+
+// SetFraming sets the maximum size of lines. Only FramingLine is supported. It must be called before Start.
+func (t *tailPipe[log]) SetFraming(framing Framing) error {
+	if atomic.LoadInt32(&t.isStarted) > 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("sensor is already started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetFraming()", t.sensorName),
+		}
+	}
+	err := framing.validate()
+	if err == nil && framing.Mode != FramingLine {
+		err = fmt.Errorf("tail sensor supports only %s framing", FramingLine)
+	}
+	if err != nil {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidArgumentError,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].SetFraming()", t.sensorName),
+		}
+	}
+	t.maxLineSize = framing.MaxRecordSize
+	return nil
+}
+
+/****************************************************
+* Pipeline methods
+****************************************************/
+
+// Start starts to follow files. Arguments are ignored because files are given by TailConfig.
+func (t *tailPipe[log]) Start(string, ...string) error {
+	if !atomic.CompareAndSwapInt32(&t.isStarted, 0, 1) {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorExecute,
+			Origin: fmt.Errorf("sensor is already started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Start()", t.sensorName),
+		}
+	}
+	t.lock.Lock()
+	t.startedAt = time.Now()
+	t.lock.Unlock()
+	go t.pollThread()
+	return nil
+}
+
+// Wait waits until the sensor is stopped.
+func (t *tailPipe[log]) Wait() error {
+	if atomic.LoadInt32(&t.isStarted) != 1 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is not started"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Wait()", t.sensorName),
+		}
+	}
+	if !atomic.CompareAndSwapInt32(&t.waitCount, 0, 1) {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("pipeline is already waited"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Wait()", t.sensorName),
+		}
+	}
+	<-t.finished
+	return nil
+}
+
+// Stop stops following files & persists offsets.
+func (t *tailPipe[log]) Stop() error {
+	if atomic.LoadInt32(&t.isStarted) == 0 {
+		return perror.PolvoGeneralError{
+			Code:   perror.InvalidOperationError,
+			Origin: fmt.Errorf("stop is called before start"),
+			Msg:    fmt.Sprintf("error while execute pipeline[%s].Stop()", t.sensorName),
+		}
+	}
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+	<-t.finished
+	return nil
+}
+
+// Pause stops reading files. Lines written while paused are read after Resume.
+func (t *tailPipe[log]) Pause() error {
+	return t.setPaused(true)
+}
+
+func (t *tailPipe[log]) Resume() error {
+	return t.setPaused(false)
+}
+
+func (t *tailPipe[log]) setPaused(paused bool) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.isPaused = paused
+	t.logger.PrintInfo("pipeline [%s]: sensor is paused: %v", t.sensorName, paused)
+	return nil
+}
+
+/****************************************************
+* goroutines & private methods
+****************************************************/
+
+func (t *tailPipe[log]) pollThread() {
+	defer close(t.finished)
+	t.logger.PrintInfo("pipeline [%s]: tail thread is started", t.sensorName)
+
+	ticker := time.NewTicker(t.config.PollInterval)
+	defer ticker.Stop()
+	initial := true
+	for {
+		if !t.IsPaused() {
+			t.poll(initial)
+			initial = false
+		}
+		select {
+		case <-t.done:
+			for _, tf := range t.files {
+				tf.file.Close()
+			}
+			t.logger.PrintInfo("pipeline [%s]: tail thread is closed", t.sensorName)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll opens new files, reads lines & handles rotation, truncation and removal of files.
+// Files which exist on the first poll are read from the end if StartAtEnd is set.
+func (t *tailPipe[log]) poll(initial bool) {
+	for _, path := range t.match() {
+		if _, ok := t.files[path]; !ok {
+			t.open(path, initial)
+		}
+	}
+	paths := make([]string, 0, len(t.files))
+	for path := range t.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	// offsets are saved even if the sensor is stopped while reading
+	defer t.saveOffsets()
+	for _, path := range paths {
+		tf := t.files[path]
+		if !t.read(tf) {
+			// stopped
+			return
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil || !sameFile(info, tf):
+			// rotated or removed. lines written to the old file just before rotation are read,
+			// and its unterminated last line is sent because nothing is appended to it anymore.
+			if !t.read(tf) || !t.flushPartial(tf) {
+				return
+			}
+			t.forget(tf)
+			if err == nil {
+				t.logger.PrintInfo("pipeline [%s]: %s is rotated", t.sensorName, path)
+				if t.open(path, false) && !t.read(t.files[path]) {
+					return
+				}
+			}
+		case info.Size() < tf.offset+int64(len(tf.partial))+tf.skipped:
+			t.logger.PrintInfo("pipeline [%s]: %s is truncated", t.sensorName, path)
+			if _, err = tf.file.Seek(0, io.SeekStart); err != nil {
+				t.reportError(path, err)
+				t.forget(tf)
+				continue
+			}
+			tf.offset, tf.partial, tf.skipping, tf.skipped = 0, nil, false, 0
+			if !t.read(tf) {
+				return
+			}
+		}
+	}
+}
+
+// flushPartial sends the unterminated last line of tf. It returns false if the sensor is stopped.
+func (t *tailPipe[log]) flushPartial(tf *tailFile) bool {
+	if len(tf.partial) == 0 || tf.skipping {
+		return true
+	}
+	if !t.send(tf.path, string(bytes.TrimSuffix(tf.partial, []byte{'\r'}))) {
+		return false
+	}
+	tf.offset += int64(len(tf.partial))
+	tf.partial = tf.partial[:0]
+	return true
+}
+
+// forget closes tf & drops its offset. A new file may reuse the inode of tf, so the offset must not be applied to it.
+func (t *tailPipe[log]) forget(tf *tailFile) {
+	tf.file.Close()
+	delete(t.files, tf.path)
+	offsets := t.savedOffsets[:0]
+	for _, offset := range t.savedOffsets {
+		if offset.Dev != tf.dev || offset.Inode != tf.ino {
+			offsets = append(offsets, offset)
+		}
+	}
+	t.savedOffsets = offsets
+}
+
+// match returns the paths of regular files matching the patterns.
+func (t *tailPipe[log]) match() []string {
+	seen := make(map[string]bool)
+	paths := make([]string, 0)
+	for _, pattern := range t.config.Paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.reportError(pattern, err)
+			continue
+		}
+		for _, path := range matches {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// open opens path at its persisted offset. It returns false if the file can not be opened.
+func (t *tailPipe[log]) open(path string, initial bool) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		t.reportError(path, err)
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		t.reportError(path, err)
+		return false
+	}
+	tf := &tailFile{path: path, file: file}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		tf.dev, tf.ino = uint64(stat.Dev), uint64(stat.Ino)
+	}
+	saved := false
+	for _, offset := range t.savedOffsets {
+		if offset.Dev == tf.dev && offset.Inode == tf.ino && offset.Offset <= info.Size() {
+			tf.offset, saved = offset.Offset, true
+			break
+		}
+	}
+	if !saved && initial && t.config.StartAtEnd {
+		tf.offset = info.Size()
+	}
+	if _, err = file.Seek(tf.offset, io.SeekStart); err != nil {
+		file.Close()
+		t.reportError(path, err)
+		return false
+	}
+	delete(t.lastErrors, path)
+	t.files[path] = tf
+	t.logger.PrintInfo("pipeline [%s]: follow %s from %d", t.sensorName, path, tf.offset)
+	return true
+}
+
+// read sends lines of tf until EOF. It returns false if the sensor is stopped.
+func (t *tailPipe[log]) read(tf *tailFile) bool {
+	buf := make([]byte, tailReadSize)
+	for {
+		n, err := tf.file.Read(buf)
+		chunk := buf[:n]
+		for len(chunk) > 0 {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				if tf.skipping {
+					tf.skipped += int64(len(chunk))
+				} else if len(tf.partial)+len(chunk) > t.maxLineSize {
+					t.skipLine(tf, int64(len(tf.partial)+len(chunk)))
+				} else {
+					tf.partial = append(tf.partial, chunk...)
+				}
+				break
+			}
+			line := chunk[:i]
+			chunk = chunk[i+1:]
+			if tf.skipping {
+				tf.offset += tf.skipped + int64(i) + 1
+				tf.skipping, tf.skipped = false, 0
+				continue
+			}
+			size := len(tf.partial) + i
+			if size > t.maxLineSize {
+				t.skipLine(tf, int64(size))
+				tf.offset += tf.skipped + 1
+				tf.skipping, tf.skipped = false, 0
+				continue
+			}
+			if len(tf.partial) > 0 {
+				line = append(tf.partial, line...)
+			}
+			if !t.send(tf.path, string(bytes.TrimSuffix(line, []byte{'\r'}))) {
+				return false
+			}
+			tf.offset += int64(size) + 1
+			tf.partial = tf.partial[:0]
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.reportError(tf.path, err)
+			}
+			return true
+		}
+	}
+}
+
+// skipLine starts to discard an oversized line of which size bytes are read.
+func (t *tailPipe[log]) skipLine(tf *tailFile, size int64) {
+	t.oversized.Inc()
+	t.logger.PrintError("pipeline [%s] sensor: line of %s is skipped. size %d is larger than max record size", t.sensorName, tf.path, size)
+	tf.skipping, tf.skipped, tf.partial = true, size, tf.partial[:0]
+}
+
+// send wraps line & sends it to logChannel. It returns false if the sensor is stopped.
+func (t *tailPipe[log]) send(path string, line string) bool {
+	t.lines.Inc()
+	lg, err := t.wrapFunc(path, line)
+	if err != nil {
+		// if error while wrap log, just skip this log
+		t.wrapErrors.Inc()
+		t.logger.PrintError("pipeline [%s] sensor: error while wrap log. %s", t.sensorName, err.Error())
+		return true
+	}
+	select {
+	case t.logChannel <- lg:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// saveOffsets persists offsets of open files. The state file is replaced atomically.
+func (t *tailPipe[log]) saveOffsets() {
+	if t.config.StatePath == "" {
+		return
+	}
+	offsets := make([]tailOffset, 0, len(t.files))
+	for _, tf := range t.files {
+		offsets = append(offsets, tailOffset{Path: tf.path, Dev: tf.dev, Inode: tf.ino, Offset: tf.offset})
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].Path < offsets[j].Path })
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		t.reportError(t.config.StatePath, err)
+		return
+	}
+	tmpPath := t.config.StatePath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err == nil {
+		err = os.Rename(tmpPath, t.config.StatePath)
+	}
+	if err != nil {
+		t.reportError(t.config.StatePath, err)
+		return
+	}
+	t.savedOffsets = offsets
+}
+
+// reportError logs err of path if it is different from the last error of path.
+func (t *tailPipe[log]) reportError(path string, err error) {
+	if t.lastErrors[path] == err.Error() {
+		return
+	}
+	t.lastErrors[path] = err.Error()
+	t.logger.PrintError("pipeline [%s] sensor: error while tail %s. %s", t.sensorName, path, err.Error())
+}
+
+// sameFile returns whether info is the file opened by tf.
+func sameFile(info os.FileInfo, tf *tailFile) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && uint64(stat.Dev) == tf.dev && uint64(stat.Ino) == tf.ino
+}
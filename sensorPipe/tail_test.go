@@ -0,0 +1,166 @@
+package sensorPipe_test
+
+import (
+	"os"
+	"path/filepath"
+	"polvo/sensorPipe"
+	"testing"
+	"time"
+)
+
+func WrapLine(path string, line string) (*Samplelog, error) {
+	return &Samplelog{Event: path, Content: line}, nil
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("error while open %s %v", path, err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(data); err != nil {
+		t.Fatalf("error while write %s %v", path, err)
+	}
+}
+
+func expectLines(t *testing.T, logChan chan *Samplelog, path string, lines ...string) {
+	t.Helper()
+	for _, want := range lines {
+		select {
+		case log := <-logChan:
+			if log.Event != path || log.Content != want {
+				t.Errorf("log = (%s, %s), want (%s, %s)", log.Event, log.Content, path, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("line %s of %s is not read", want, path)
+		}
+	}
+}
+
+func newTailPipe(t *testing.T, logChan chan *Samplelog, config sensorPipe.TailConfig) sensorPipe.Pipe[Samplelog] {
+	t.Helper()
+	config.PollInterval = 10 * time.Millisecond
+	pipe, err := sensorPipe.NewTailPipe("tail", loger, logChan, WrapLine, config)
+	if err != nil {
+		t.Fatalf("error while create tail pipeline %v", err)
+	}
+	if err = pipe.Start(""); err != nil {
+		t.Fatalf("Error while starting tail pipeline %v", err)
+	}
+	return pipe
+}
+
+func TestTailFollowsRotationAndTruncation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "first\nsecond\n")
+
+	logChan := make(chan *Samplelog)
+	pipe := newTailPipe(t, logChan, sensorPipe.TailConfig{Paths: []string{filepath.Join(dir, "*.log")}})
+	expectLines(t, logChan, path, "first", "second")
+	// partial line is sent when it is terminated
+	appendFile(t, path, "thi")
+	appendFile(t, path, "rd\n")
+	expectLines(t, logChan, path, "third")
+	// rotation by rename. rotated file does not match the glob.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("error while rotate %v", err)
+	}
+	appendFile(t, path, "rotated\n")
+	expectLines(t, logChan, path, "rotated")
+	// truncation
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("error while truncate %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "truncated\n")
+	expectLines(t, logChan, path, "truncated")
+	// new file matching the glob
+	other := filepath.Join(dir, "other.log")
+	appendFile(t, other, "other\n")
+	expectLines(t, logChan, other, "other")
+
+	if err := pipe.Stop(); err != nil {
+		t.Errorf("pipe.Stop() = %v, want nil", err)
+	}
+}
+
+func TestTailPersistsOffsets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	config := sensorPipe.TailConfig{
+		Paths:      []string{path},
+		StatePath:  filepath.Join(dir, "offsets.json"),
+		StartAtEnd: true,
+	}
+	appendFile(t, path, "old\n")
+
+	logChan := make(chan *Samplelog)
+	// start_at end skips the content without offset
+	pipe := newTailPipe(t, logChan, config)
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "first\n")
+	expectLines(t, logChan, path, "first")
+	if err := pipe.Stop(); err != nil {
+		t.Errorf("pipe.Stop() = %v, want nil", err)
+	}
+	// lines written while stopped are read from the persisted offset
+	appendFile(t, path, "second\n")
+	pipe = newTailPipe(t, logChan, config)
+	expectLines(t, logChan, path, "second")
+	if err := pipe.Stop(); err != nil {
+		t.Errorf("pipe.Stop() = %v, want nil", err)
+	}
+}
+
+func TestTailSendsPartialLineOfRotatedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	config := sensorPipe.TailConfig{Paths: []string{path}, StatePath: filepath.Join(dir, "offsets.json")}
+	appendFile(t, path, "first\nlast")
+
+	logChan := make(chan *Samplelog)
+	pipe := newTailPipe(t, logChan, config)
+	expectLines(t, logChan, path, "first")
+	// unterminated last line is sent when the file is rotated
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("error while rotate %v", err)
+	}
+	expectLines(t, logChan, path, "last")
+	// delete & recreate. the new file is read from the beginning even if its inode is reused.
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatalf("error while remove %v", err)
+	}
+	appendFile(t, path, "recreated\n")
+	expectLines(t, logChan, path, "recreated")
+	if err := pipe.Stop(); err != nil {
+		t.Errorf("pipe.Stop() = %v, want nil", err)
+	}
+}
+
+func TestTailSkipsOversizedLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "small\n"+string(make([]byte, 100))+"\nafter\n")
+
+	logChan := make(chan *Samplelog)
+	pipe, err := sensorPipe.NewTailPipe("tail", loger, logChan, WrapLine, sensorPipe.TailConfig{Paths: []string{path}})
+	if err != nil {
+		t.Fatalf("error while create tail pipeline %v", err)
+	}
+	if err = pipe.SetFraming(sensorPipe.Framing{Mode: sensorPipe.FramingLengthPrefixed}); err == nil {
+		t.Errorf("SetFraming(length-prefixed) = nil, want error")
+	}
+	if err = pipe.SetFraming(sensorPipe.Framing{MaxRecordSize: 64}); err != nil {
+		t.Fatalf("SetFraming(64) = %v, want nil", err)
+	}
+	if err = pipe.Start(""); err != nil {
+		t.Fatalf("Error while starting tail pipeline %v", err)
+	}
+	expectLines(t, logChan, path, "small", "after")
+	if err = pipe.Stop(); err != nil {
+		t.Errorf("pipe.Stop() = %v, want nil", err)
+	}
+}
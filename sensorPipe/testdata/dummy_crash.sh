@@ -0,0 +1,5 @@
+#!/bin/bash
+
+# prints a log & crashes immediately
+echo "crash $$ restarted"
+exit 1
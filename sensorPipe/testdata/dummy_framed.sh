@@ -0,0 +1,9 @@
+#!/bin/bash
+
+trap "exit 0" SIGINT
+
+# records prefixed by varint length. the second record is larger than max record size
+printf '\x09small 1 a'
+printf '\x64'; printf 'x%.0s' {1..100}
+printf '\x09small 2 b'
+while true; do sleep 0.01; done
@@ -0,0 +1,9 @@
+#!/bin/bash
+
+trap "exit 0" SIGINT
+
+# a line larger than max record size between small lines
+echo "small 1 a"
+printf 'x%.0s' {1..100}; echo
+echo "small 2 b"
+while true; do sleep 0.01; done
@@ -0,0 +1,17 @@
+#!/bin/bash
+
+# prints diagnostics to stderr & a log to stdout
+echo "warning: ring buffer is almost full" >&2
+echo "ERROR: failed to attach probe" >&2
+# keywords which are not level tokens
+echo "0 errors in config" >&2
+echo "no error found in maps" >&2
+echo "debugger attached" >&2
+echo 'time=2025-03-11T10:00:00Z level=warn msg="slow consumer"' >&2
+for i in $(seq 1 50); do
+    echo "noise $i" >&2
+done
+# wait for the rate limit to be refilled
+sleep 1.2
+echo "sensor is exiting" >&2
+echo "stderr $$ done"
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"fmt"
+	perror "polvo/error"
+	"polvo/exporter"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// # Status
+//
+// Status is the runtime state of components of service. Components are sorted by name.
+type Status struct {
+	Pipelines []PipelineStatus `json:"pipelines"`
+	Sensors   []SensorStatus   `json:"sensors"`
+	Exporters []ExporterStatus `json:"exporters"`
+}
+
+type PipelineStatus struct {
+	Name     string   `json:"name"`
+	Sensors  []string `json:"sensors"`
+	Filters  []string `json:"filters"`
+	Exporter string   `json:"exporter"`
+	// Paused pipeline drops logs for its exporter.
+	Paused bool `json:"paused"`
+}
+
+type SensorStatus struct {
+	Name string `json:"name"`
+	// Running is false while the sensor waits for restart or after it exited.
+	Running bool `json:"running"`
+	Paused  bool `json:"paused"`
+	// Pid, StartedAt & Uptime are of the current process. They are zero if the sensor is not running.
+	Pid       int        `json:"pid"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Uptime    float64    `json:"uptime_seconds"`
+	Restarts  int        `json:"restarts"`
+}
+
+type ExporterStatus struct {
+	Name        string `json:"name"`
+	Mode        string `json:"mode"`
+	Destination string `json:"destination"`
+	// ChannelLength is the number of logs waiting in the channel of exporter.
+	ChannelLength int `json:"channel_length"`
+	// QueueRecords & QueueBytes are the depth of the disk-backed queue. nil if the queue is not used.
+	QueueRecords *int64     `json:"queue_records,omitempty"`
+	QueueBytes   *int64     `json:"queue_bytes,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at,omitempty"`
+}
+
+// # Status
+//
+// Status returns the runtime state of pipelines, sensors & exporters.
+func (s *service) Status() Status {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	status := Status{
+		Pipelines: make([]PipelineStatus, 0, len(s.info.Service.Pipeline)),
+		Sensors:   make([]SensorStatus, 0, len(s.info.Sensors)),
+		Exporters: make([]ExporterStatus, 0, len(s.info.Exporters)),
+	}
+	for pipelineName, pipelineInfo := range s.info.Service.Pipeline {
+		pipelineStatus := PipelineStatus{
+			Name:     pipelineName,
+			Sensors:  sensorNames(pipelineInfo),
+			Filters:  make([]string, 0, len(pipelineInfo.Filters)),
+			Exporter: pipelineInfo.Exporter.Name,
+			Paused:   s.pausedPipelines[pipelineName],
+		}
+		for _, filterInfo := range pipelineInfo.Filters {
+			pipelineStatus.Filters = append(pipelineStatus.Filters, filterInfo.Name)
+		}
+		status.Pipelines = append(status.Pipelines, pipelineStatus)
+	}
+	for sensorName := range s.info.Sensors {
+		sensorStatus := SensorStatus{Name: sensorName, Paused: s.pausedSensors[sensorName]}
+		if pipe, ok := s.sensorPipeMap[sensorName]; ok {
+			sensorStatus.Running = pipe.IsRunning()
+			sensorStatus.Pid = pipe.Pid()
+			sensorStatus.Restarts = pipe.Restarts()
+			if startedAt := pipe.StartedAt(); !startedAt.IsZero() {
+				sensorStatus.StartedAt = &startedAt
+				sensorStatus.Uptime = time.Since(startedAt).Seconds()
+			}
+		}
+		status.Sensors = append(status.Sensors, sensorStatus)
+	}
+	for exporterName, exporterInfo := range s.info.Exporters {
+		exporterStatus := ExporterStatus{
+			Name:        exporterName,
+			Mode:        exporterInfo.Mode,
+			Destination: exporterInfo.Destination,
+		}
+		if exp, ok := s.exporterMap[exporterName]; ok {
+			exporterStatus.ChannelLength = len(exp.LogChannel())
+			if queued, ok := exp.(interface{ QueueStats() exporter.QueueStats }); ok {
+				stats := queued.QueueStats()
+				exporterStatus.QueueRecords, exporterStatus.QueueBytes = &stats.Queued, &stats.Bytes
+			}
+			if reporter, ok := exp.(exporter.ErrorReporter); ok {
+				if msg, at := reporter.LastError(); msg != "" {
+					exporterStatus.LastError, exporterStatus.LastErrorAt = msg, &at
+				}
+			}
+		}
+		status.Exporters = append(status.Exporters, exporterStatus)
+	}
+	sort.Slice(status.Pipelines, func(i, j int) bool { return status.Pipelines[i].Name < status.Pipelines[j].Name })
+	sort.Slice(status.Sensors, func(i, j int) bool { return status.Sensors[i].Name < status.Sensors[j].Name })
+	sort.Slice(status.Exporters, func(i, j int) bool { return status.Exporters[i].Name < status.Exporters[j].Name })
+	return status
+}
+
+/************************************************************************************************************
+* Control
+************************************************************************************************************/
+
+// PauseSensor stops the process of sensor by SIGSTOP. The sensor stays paused across restart & reload until it is resumed.
+func (s *service) PauseSensor(name string) error {
+	return s.setSensorPaused(name, true)
+}
+
+// ResumeSensor continues the sensor paused by PauseSensor.
+func (s *service) ResumeSensor(name string) error {
+	return s.setSensorPaused(name, false)
+}
+
+// RestartSensor stops the sensor & its filter worker, and starts them again with the current compose.
+func (s *service) RestartSensor(name string) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if err := s.checkControl(); err != nil {
+		return err
+	}
+	if _, ok := s.info.Sensors[name]; !ok {
+		return sensorNotFound(name)
+	}
+	s.logger.PrintInfo("restart sensor [%s]", name)
+	return s.restartComponents(componentSet{sensors: []string{name}})
+}
+
+// PausePipeline makes the processor of pipeline drop logs for its exporter.
+// Other pipelines of the same sensors are not affected. The pipeline stays paused across restart & reload until it is resumed.
+func (s *service) PausePipeline(name string) error {
+	return s.setPipelinePaused(name, true)
+}
+
+// ResumePipeline makes the pipeline paused by PausePipeline send logs again.
+func (s *service) ResumePipeline(name string) error {
+	return s.setPipelinePaused(name, false)
+}
+
+// RestartPipeline restarts the processor of pipeline & its sensors, which are connected to the new processor.
+func (s *service) RestartPipeline(name string) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if err := s.checkControl(); err != nil {
+		return err
+	}
+	pipelineInfo, ok := s.info.Service.Pipeline[name]
+	if !ok {
+		return pipelineNotFound(name)
+	}
+	s.logger.PrintInfo("restart pipeline [%s]", name)
+	return s.restartComponents(componentSet{pipelines: []string{name}, sensors: sensorNames(pipelineInfo)})
+}
+
+func (s *service) setSensorPaused(name string, paused bool) error {
+	var err error
+
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if err = s.checkControl(); err != nil {
+		return err
+	}
+	if _, ok := s.info.Sensors[name]; !ok {
+		return sensorNotFound(name)
+	}
+	if pipe, ok := s.sensorPipeMap[name]; ok {
+		if paused {
+			err = pipe.Pause()
+		} else {
+			err = pipe.Resume()
+		}
+	}
+	if err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineControl,
+			Origin: err,
+			Msg:    fmt.Sprintf("error while pause sensor [%s]", name),
+		}
+	}
+	s.pausedSensors[name] = paused
+	s.logger.PrintInfo("sensor [%s] is paused: %v", name, paused)
+	return nil
+}
+
+func (s *service) setPipelinePaused(name string, paused bool) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if err := s.checkControl(); err != nil {
+		return err
+	}
+	if _, ok := s.info.Service.Pipeline[name]; !ok {
+		return pipelineNotFound(name)
+	}
+	if processorWorker, ok := s.processorWorkerMap[name]; ok {
+		processorWorker.setPaused(paused)
+	}
+	s.pausedPipelines[name] = paused
+	s.logger.PrintInfo("pipeline [%s] is paused: %v", name, paused)
+	return nil
+}
+
+// restartComponents stops & starts components of set with the current compose.
+func (s *service) restartComponents(set componentSet) error {
+	defer s.holdSensorGroup()()
+
+	if err := s.stopComponents(set); err != nil {
+		// components are removed from maps even if they are not stopped gracefully
+		s.logger.PrintError("error while stop components on restart %v", err)
+	}
+	if err := s.startComponents(*s.info, set); err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineControl,
+			Origin: err,
+			Msg:    "error while restart components",
+		}
+	}
+	return nil
+}
+
+// checkControl returns an error if the service is already stopped.
+func (s *service) checkControl() error {
+	if atomic.LoadInt32(&s.isStopped) > 0 {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineControl,
+			Origin: fmt.Errorf("service is already stopped"),
+			Msg:    "error while control service",
+		}
+	}
+	return nil
+}
+
+func sensorNotFound(name string) error {
+	return perror.PolvoPipelineError{
+		Code:   perror.ErrInvalidSensorName,
+		Origin: fmt.Errorf("sensor %s is not defined", name),
+		Msg:    "error while control service",
+	}
+}
+
+func pipelineNotFound(name string) error {
+	return perror.PolvoPipelineError{
+		Code:   perror.ErrInvalidPipelineName,
+		Origin: fmt.Errorf("pipeline %s is not defined", name),
+		Msg:    "error while control service",
+	}
+}
@@ -0,0 +1,171 @@
+package filter
+
+import (
+	"fmt"
+	perror "polvo/error"
+	"sort"
+	"strings"
+)
+
+// # ConditionExpr
+//
+// ConditionExpr is the parsed condition expression of a deny entry.
+// It combines the named selections of the entry like sigma rules.
+// e.g. selection and not (filter_a or filter_b), 1 of filter_*, all of them
+//
+// Precedence of operators is not > and > or.
+type ConditionExpr struct {
+	Or []*ConditionAnd `parser:"@@ ( 'or' @@ )*"`
+}
+
+type ConditionAnd struct {
+	And []*ConditionNot `parser:"@@ ( 'and' @@ )*"`
+}
+
+type ConditionNot struct {
+	Not  *ConditionNot  `parser:"  'not' @@"`
+	Term *ConditionTerm `parser:"| @@"`
+}
+
+type ConditionTerm struct {
+	Sub       *ConditionExpr `parser:"  '(' @@ ')'"`
+	Of        *ConditionOf   `parser:"| @@"`
+	Selection *string        `parser:"| @Ident"`
+}
+
+// # ConditionOf
+//
+// ConditionOf selects selections by pattern.
+//
+// - Quantifier is "1" (any of selections) or "all" (all of selections).
+//
+// - Pattern is "them" (all selections), a selection name or a prefix of selection names ending with "*".
+type ConditionOf struct {
+	Quantifier string `parser:"@( '1' | 'all' ) 'of'"`
+	Pattern    string `parser:"@( 'them' | '*' | Ident '*'? )"`
+}
+
+// keywords of condition expression. they can not be used as selection names.
+var conditionKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "of": true, "all": true, "them": true,
+}
+
+// # ParseCondition
+//
+// ParseCondition parses the condition expression of a deny entry.
+func ParseCondition(parser Parser, condition string) (*ConditionExpr, error) {
+	// null check
+	if parser == nil || parser.ConditionParser() == nil {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrCollectionField,
+			Origin: fmt.Errorf("parser is nil"),
+			Msg:    "error in ParseCondition",
+		}
+	}
+	expr, err := parser.ConditionParser().ParseString(condition, condition)
+	if err != nil {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrCollectionField,
+			Origin: err,
+			Msg:    "Failed to parse condition",
+		}
+	}
+	return expr, nil
+}
+
+// conditionCompiler compiles the condition expression into Logic tree with the selections.
+type conditionCompiler struct {
+	selections map[string]Logic
+	// names of selections sorted for deterministic evaluation
+	names      []string
+	referenced map[string]bool
+}
+
+func newConditionCompiler(selections map[string]Logic) *conditionCompiler {
+	cc := &conditionCompiler{
+		selections: selections,
+		referenced: make(map[string]bool),
+	}
+	for name := range selections {
+		cc.names = append(cc.names, name)
+	}
+	sort.Strings(cc.names)
+	return cc
+}
+
+func (cc *conditionCompiler) compile(expr *ConditionExpr) (Logic, error) {
+	ors := make(Or, 0, len(expr.Or))
+	for _, andExpr := range expr.Or {
+		ands := make(And, 0, len(andExpr.And))
+		for _, notExpr := range andExpr.And {
+			logic, err := cc.compileNot(notExpr)
+			if err != nil {
+				return nil, err
+			}
+			ands = append(ands, logic)
+		}
+		ors = append(ors, ands)
+	}
+	return ors, nil
+}
+
+func (cc *conditionCompiler) compileNot(expr *ConditionNot) (Logic, error) {
+	if expr.Not != nil {
+		logic, err := cc.compileNot(expr.Not)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{logic: logic}, nil
+	}
+	term := expr.Term
+	switch {
+	case term.Sub != nil:
+		return cc.compile(term.Sub)
+	case term.Of != nil:
+		return cc.compileOf(term.Of)
+	default:
+		selection, ok := cc.selections[*term.Selection]
+		if !ok {
+			return nil, fmt.Errorf("selection %s is not defined", *term.Selection)
+		}
+		cc.referenced[*term.Selection] = true
+		return selection, nil
+	}
+}
+
+func (cc *conditionCompiler) compileOf(expr *ConditionOf) (Logic, error) {
+	matched := make([]Logic, 0)
+	for _, name := range cc.names {
+		var ok bool
+		switch {
+		case expr.Pattern == "them" || expr.Pattern == "*":
+			ok = true
+		case strings.HasSuffix(expr.Pattern, "*"):
+			ok = strings.HasPrefix(name, strings.TrimSuffix(expr.Pattern, "*"))
+		default:
+			ok = name == expr.Pattern
+		}
+		if ok {
+			matched = append(matched, cc.selections[name])
+			cc.referenced[name] = true
+		}
+	}
+	if len(matched) <= 0 {
+		return nil, fmt.Errorf("no selection matches %s of %s", expr.Quantifier, expr.Pattern)
+	}
+	if expr.Quantifier == "all" {
+		return And(matched), nil
+	}
+	return Or(matched), nil
+}
+
+// unreferenced returns the selections which are not used by the condition.
+func (cc *conditionCompiler) unreferenced() []string {
+	ret := make([]string, 0)
+	for _, name := range cc.names {
+		if !cc.referenced[name] {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
@@ -1,8 +1,14 @@
 package filter
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	perror "polvo/error"
 	"polvo/service/model"
+	"sort"
+	"sync/atomic"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,61 +16,254 @@ import (
 // # FilterOperator
 //
 // FilterOperator implements filter operations based on a filter yaml file.
-// If the Deny operation is true, the result is false.
+// Operation returns true if the log is filtered out.
+//
+// - allow: if any allow selection is defined, logs matching none of them are filtered out.
+//
+// - deny: logs matching any deny selection are filtered out. deny takes precedence over allow.
 type FilterOperator interface {
 	Operation(log *model.CommonLogWrapper) bool
 }
 
+// # FilterChain
+//
+// FilterChain combines filter operators. The log is filtered out if any operator filters it out.
+// Empty FilterChain filters out nothing.
+type FilterChain []FilterOperator
+
+func (fc FilterChain) Operation(log *model.CommonLogWrapper) bool {
+	for _, op := range fc {
+		if op.Operation(log) {
+			return true
+		}
+	}
+	return false
+}
+
+// # SelectionError
+//
+// SelectionError is the error of an allow or deny selection of a filter.
+// Section is "allow" or "deny". It is used to locate the selection in the filter yaml.
+type SelectionError struct {
+	Section string
+	Name    string
+	Err     error
+}
+
+func (e SelectionError) Error() string {
+	return fmt.Sprintf("%s selection %s: %s", e.Section, e.Name, e.Err.Error())
+}
+
+func (e SelectionError) Unwrap() error {
+	return e.Err
+}
+
 type filterOperator struct {
 	parser    Parser
 	filterObj *Filter
+	allow     []Logic
 	deny      []Logic
+	// names of selections in the same order as allow & deny
+	allowNames []string
+	denyNames  []string
+	// number of logs denied by each deny selection
+	denyHits []atomic.Uint64
 }
 
 func NewFilterOperator(filterData []byte) (FilterOperator, error) {
-	var err error
+	newFilterOP, errs := compileFilter(filterData)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return newFilterOP, nil
+}
+
+// # ValidateFilter
+//
+// ValidateFilter compiles every selection of filterData & returns the errors of all invalid selections.
+// Errors of selections are PolvoFilterError wrapping SelectionError. A yaml error is returned alone.
+func ValidateFilter(filterData []byte) []error {
+	_, errs := compileFilter(filterData)
+	return errs
+}
+
+// compileFilter constructs filterOperator. Selections are compiled after an invalid selection, so all errors are returned.
+func compileFilter(filterData []byte) (*filterOperator, []error) {
+	var (
+		err  error
+		errs []error
+	)
 	newFilterOP := new(filterOperator)
 
 	newFilterOP.parser, err = NewParser()
 	if err != nil {
-		return nil, perror.PolvoFilterError{
+		return nil, []error{perror.PolvoFilterError{
 			Code:   perror.ErrFilterConstructor,
 			Origin: err,
 			Msg:    "error while NewFilterOperator",
-		}
+		}}
 	}
 	// parse Filter object from yaml byte slice
+	// unknown keys are rejected to prevent rules from being silently ignored.
 	newFilterOP.filterObj = new(Filter)
-	err = yaml.Unmarshal(filterData, newFilterOP.filterObj)
-	if err != nil {
-		return nil, perror.PolvoFilterError{
+	decoder := yaml.NewDecoder(bytes.NewReader(filterData))
+	decoder.KnownFields(true)
+	err = decoder.Decode(newFilterOP.filterObj)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, []error{perror.PolvoFilterError{
 			Code:   perror.ErrFilterConstructor,
 			Origin: err,
 			Msg:    "error while NewFilterOperator",
+		}}
+	}
+	selectionError := func(section string, name string, err error) error {
+		return perror.PolvoFilterError{
+			Code:   perror.ErrFilterConstructor,
+			Origin: SelectionError{Section: section, Name: name, Err: err},
+			Msg:    "error while NewFilterOperator",
 		}
 	}
+	// construct allow selections in the order of names,
+	// so the deny selection which counts a hit & the reported error do not depend on map order
+	for _, allowSelectionName := range sortedNames(newFilterOP.filterObj.Allow) {
+		allowSelection := newFilterOP.filterObj.Allow[allowSelectionName]
+		if len(allowSelection) <= 0 {
+			errs = append(errs, selectionError("allow", allowSelectionName, fmt.Errorf("selection is empty")))
+			continue
+		}
+		selection, err := NewRuleSelectionOperator(newFilterOP.parser, allowSelectionName, &allowSelection)
+		if err != nil {
+			errs = append(errs, selectionError("allow", allowSelectionName, err))
+			continue
+		}
+		newFilterOP.allow = append(newFilterOP.allow, selection)
+		newFilterOP.allowNames = append(newFilterOP.allowNames, allowSelectionName)
+	}
 	// construct deny selections
-	for denySelectionName, denySellections := range newFilterOP.filterObj.Deny {
+	for _, denySelectionName := range sortedNames(newFilterOP.filterObj.Deny) {
+		denySellections := newFilterOP.filterObj.Deny[denySelectionName]
 		denySelection, err := NewDenyOperator(newFilterOP.parser, denySelectionName, &denySellections)
 		if err != nil {
-			return nil, perror.PolvoFilterError{
-				Code:   perror.ErrFilterConstructor,
-				Origin: err,
-				Msg:    "error while NewFilterOperator",
-			}
+			errs = append(errs, selectionError("deny", denySelectionName, err))
+			continue
 		}
 		newFilterOP.deny = append(newFilterOP.deny, denySelection)
+		newFilterOP.denyNames = append(newFilterOP.denyNames, denySelectionName)
+	}
+	if len(errs) > 0 {
+		return nil, errs
 	}
+	newFilterOP.denyHits = make([]atomic.Uint64, len(newFilterOP.deny))
 	return newFilterOP, nil
 }
 
+// sortedNames returns the selection names of selections in sorted order.
+func sortedNames[selection any](selections map[string]selection) []string {
+	names := make([]string, 0, len(selections))
+	for name := range selections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // FilterOperation works as Follows:
 // 1. If Operation returns true, log will be denied
 // 2. If Operation returns false, log will be allowed
-// 3. If exception returns true, log will be allowed
+// 3. If any deny selection matches without its exception, log will be denied
+// 4. If allow selections exist and none of them matches, log will be denied
+// The hit of the first matched deny selection is counted.
 func (f *filterOperator) Operation(log *model.CommonLogWrapper) bool {
 	// check Deny First, then Allow
-	return Or(f.deny).Operation(log)
+	for idx, deny := range f.deny {
+		if deny.Operation(log) {
+			f.denyHits[idx].Add(1)
+			return true
+		}
+	}
+	if len(f.allow) > 0 {
+		return !Or(f.allow).Operation(log)
+	}
+	return false
+}
+
+// # Verdict
+//
+// Verdict explains the result of FilterOperator.Operation for a log.
+type Verdict struct {
+	// Filtered is the result of Operation.
+	Filtered bool
+	// Deny is the sorted names of matched deny selections.
+	Deny []string
+	// Allow is the sorted names of matched allow selections.
+	// If allow selections are defined & Allow is empty, the log is filtered out by allow.
+	Allow []string
+}
+
+// # Explain
+//
+// Explain evaluates every selection of op for log & returns the matched ones.
+// Selections of FilterChain are merged. Other operators report Filtered only.
+func Explain(op FilterOperator, log *model.CommonLogWrapper) Verdict {
+	var verdict Verdict
+
+	switch op := op.(type) {
+	case *filterOperator:
+		for idx, deny := range op.deny {
+			if deny.Operation(log) {
+				verdict.Deny = append(verdict.Deny, op.denyNames[idx])
+			}
+		}
+		for idx, allow := range op.allow {
+			if allow.Operation(log) {
+				verdict.Allow = append(verdict.Allow, op.allowNames[idx])
+			}
+		}
+		verdict.Filtered = len(verdict.Deny) > 0 || (len(op.allow) > 0 && len(verdict.Allow) <= 0)
+	case FilterChain:
+		for _, member := range op {
+			memberVerdict := Explain(member, log)
+			verdict.Filtered = verdict.Filtered || memberVerdict.Filtered
+			verdict.Deny = append(verdict.Deny, memberVerdict.Deny...)
+			verdict.Allow = append(verdict.Allow, memberVerdict.Allow...)
+		}
+	default:
+		verdict.Filtered = op.Operation(log)
+	}
+	sort.Strings(verdict.Deny)
+	sort.Strings(verdict.Allow)
+	return verdict
+}
+
+// # SelectionHits
+//
+// SelectionHits is the number of logs denied by a deny selection.
+type SelectionHits struct {
+	Name string
+	Hits uint64
+}
+
+// # DenyHits
+//
+// DenyHits returns the hits of deny selections of op sorted by name.
+// Only the first matched selection of a log in name order is counted. Selections of FilterChain are merged.
+func DenyHits(op FilterOperator) []SelectionHits {
+	var hits []SelectionHits
+
+	switch op := op.(type) {
+	case *filterOperator:
+		for idx, name := range op.denyNames {
+			hits = append(hits, SelectionHits{Name: name, Hits: op.denyHits[idx].Load()})
+		}
+	case FilterChain:
+		for _, member := range op {
+			hits = append(hits, DenyHits(member)...)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Name < hits[j].Name
+	})
+	return hits
 }
 
 type DenyOperator struct {
@@ -80,16 +279,50 @@ func NewDenyOperator(parser Parser, selectionName string, rules *Deny) (Logic, e
 	// read rules from condition map
 	dOP.condition = make([]Logic, 0)
 	dOP.exception = make([]Logic, 0)
-	for key, val := range rules.Condition {
-		rOP, err := NewRuleOperator(parser, key, &val)
-		if err != nil {
+	switch rules.Condition.Kind {
+	case yaml.MappingNode:
+		if len(rules.Selections) > 0 {
+			return nil, perror.PolvoFilterError{
+				Code:   perror.ErrCollectionField,
+				Msg:    "error while NewDenyOperator",
+				Origin: fmt.Errorf("selections of %s must be combined by condition expression", selectionName),
+			}
+		}
+		var condition Rule
+		if err := rules.Condition.Decode(&condition); err != nil {
 			return nil, perror.PolvoFilterError{
 				Code:   perror.ErrRuleField,
 				Msg:    "error while NewDenyOperator",
 				Origin: err,
 			}
 		}
-		dOP.condition = append(dOP.condition, rOP)
+		for key, val := range condition {
+			rOP, err := NewRuleOperator(parser, key, &val)
+			if err != nil {
+				return nil, perror.PolvoFilterError{
+					Code:   perror.ErrRuleField,
+					Msg:    "error while NewDenyOperator",
+					Origin: err,
+				}
+			}
+			dOP.condition = append(dOP.condition, rOP)
+		}
+	case yaml.ScalarNode:
+		expression, err := newConditionExpression(parser, rules.Condition.Value, rules.Selections)
+		if err != nil {
+			return nil, perror.PolvoFilterError{
+				Code:   perror.ErrCollectionField,
+				Msg:    "error while NewDenyOperator",
+				Origin: err,
+			}
+		}
+		dOP.condition = append(dOP.condition, expression)
+	default:
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrCollectionField,
+			Msg:    "error while NewDenyOperator",
+			Origin: fmt.Errorf("condition of %s must be rule or expression", selectionName),
+		}
 	}
 	// read rules from exception map
 	for key, val := range rules.Exception {
@@ -119,3 +352,35 @@ func (dOP *DenyOperator) Operation(log *model.CommonLogWrapper) bool {
 	exceptionResult = Or(dOP.exception).Operation(log)
 	return denyResult && !exceptionResult
 }
+
+// newConditionExpression compiles the condition expression over the selections.
+// Every selection must be referenced by the expression, so typos of keys are not ignored silently.
+func newConditionExpression(parser Parser, condition string, selections map[string]Rule) (Logic, error) {
+	selectionOPs := make(map[string]Logic)
+	for name, rules := range selections {
+		if conditionKeywords[name] {
+			return nil, fmt.Errorf("selection name %s is keyword", name)
+		}
+		if len(rules) <= 0 {
+			return nil, fmt.Errorf("selection %s is empty", name)
+		}
+		selectionOP, err := NewRuleSelectionOperator(parser, name, &rules)
+		if err != nil {
+			return nil, err
+		}
+		selectionOPs[name] = selectionOP
+	}
+	expr, err := ParseCondition(parser, condition)
+	if err != nil {
+		return nil, err
+	}
+	compiler := newConditionCompiler(selectionOPs)
+	expression, err := compiler.compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if unused := compiler.unreferenced(); len(unused) > 0 {
+		return nil, fmt.Errorf("selections %v are not used in condition %s", unused, condition)
+	}
+	return expression, nil
+}
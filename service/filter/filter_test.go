@@ -2,9 +2,11 @@ package filter_test
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"polvo/service/filter"
 	"polvo/service/model"
+	"reflect"
 	"testing"
 
 	"gopkg.in/yaml.v3"
@@ -627,3 +629,526 @@ func TestFilterOperationWithDrop(t *testing.T) {
 	}
 	t.Logf("FilterOperation() = %v, want true", out)
 }
+
+const sampleAllowFilter = `
+version: 1.0
+allow:
+  "allow_bash":
+    "eventname|startswith": "bash"
+  "!allow_not_root":
+    "Username": "root"
+deny:
+  "filter_sudo":
+    "condition":
+      "Commandline|contains": "sudo"
+`
+
+func TestFilterOperationWithAllow(t *testing.T) {
+	allowFilterOP, err := filter.NewFilterOperator([]byte(sampleAllowFilter))
+	if err != nil {
+		t.Fatalf("NewFilterOperator(%s) = %v, want nil", sampleAllowFilter, err)
+	}
+	testcases := []struct {
+		log  string
+		want bool
+	}{
+		// allowed by allow_bash
+		{`{"eventname": "bashReadline", "metadata": {"Commandline": "ls", "Username": "root"}}`, false},
+		// allowed by !allow_not_root
+		{`{"eventname": "fileCreate", "metadata": {"Commandline": "touch", "Username": "shhong"}}`, false},
+		// matches no allow selection
+		{`{"eventname": "fileCreate", "metadata": {"Commandline": "touch", "Username": "root"}}`, true},
+		// deny takes precedence over allow
+		{`{"eventname": "bashReadline", "metadata": {"Commandline": "sudo ls", "Username": "shhong"}}`, true},
+	}
+	for _, tc := range testcases {
+		log := new(model.CommonLogWrapper)
+		if err = json.Unmarshal([]byte(tc.log), log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", tc.log, err)
+		}
+		if out := allowFilterOP.Operation(log); out != tc.want {
+			t.Errorf("FilterOperation(%s) = %v, want %v", tc.log, out, tc.want)
+		}
+	}
+}
+
+func TestNewFilterOperatorWithUnknownKey(t *testing.T) {
+	sample := `
+version: 1.0
+Deny:
+  "filter_null":
+    "condition":
+      "eventname": "bashReadline"
+`
+	_, err := filter.NewFilterOperator([]byte(sample))
+	if err == nil {
+		t.Fatalf("NewFilterOperator(%s) = nil, want not nil", sample)
+	}
+	t.Logf("NewFilterOperator(%s) = %v, want not nil", sample, err)
+}
+
+func TestNewRuleSelectionOperatorWithWrongSelectionName(t *testing.T) {
+	rules := filter.Rule{"eventname": yaml.Node{Kind: yaml.ScalarNode, Value: "bashReadline"}}
+	for _, name := range []string{"fil!ter_null", "!", "!!filter_null"} {
+		_, err := filter.NewRuleSelectionOperator(parser, name, &rules)
+		if err == nil {
+			t.Errorf("NewRuleSelectionOperator(%s) = nil, want not nil", name)
+		}
+	}
+}
+
+func TestNewFilterOperatorWithSampleFilter(t *testing.T) {
+	filterData, err := os.ReadFile("../../sample_polvo_filter.yml")
+	if err != nil {
+		t.Fatalf("os.ReadFile() = %v, want nil", err)
+	}
+	_, err = filter.NewFilterOperator(filterData)
+	if err != nil {
+		t.Fatalf("NewFilterOperator(sample_polvo_filter.yml) = %v, want nil", err)
+	}
+}
+
+func TestParseRuleFieldWithModifiers(t *testing.T) {
+	sample := "field|i|contains|windash|all"
+
+	// test
+	result, err := filter.ParseRuleField(parser.RuleFieldParser(), sample)
+	if err != nil {
+		t.Fatalf("ParseRuleField(%s) = %v, want nil", sample, err)
+	}
+	if result.Op == nil || *result.Op != "contains" {
+		t.Errorf("ParseRuleField(%s).Op = %v, want contains", sample, result.Op)
+	}
+	if result.Cond == nil || *result.Cond != "all" {
+		t.Errorf("ParseRuleField(%s).Cond = %v, want all", sample, result.Cond)
+	}
+	if len(result.Flags) != 2 || result.Flags[0] != "i" || result.Flags[1] != "windash" {
+		t.Errorf("ParseRuleField(%s).Flags = %v, want [i windash]", sample, result.Flags)
+	}
+}
+
+func TestParseRuleFieldWithExclusiveModifiers(t *testing.T) {
+	for _, sample := range []string{"field|re|contains", "field|i|cased", "field|i|i"} {
+		_, err := filter.ParseRuleField(parser.RuleFieldParser(), sample)
+		if err == nil {
+			t.Errorf("ParseRuleField(%s) = nil, want not nil", sample)
+		}
+	}
+}
+
+func TestFilterOperationWithModifiers(t *testing.T) {
+	testcases := []struct {
+		rule string
+		want bool
+	}{
+		{`"Commandline|re": "^echo .* world$"`, true},
+		{`"Commandline|re": "^ECHO"`, false},
+		{`"Commandline|re|i": "^ECHO"`, true},
+		{`"Commandline|wildcard": "echo*wor?d"`, true},
+		{`"Commandline|wildcard": "echo*"`, true},
+		{`"Commandline|wildcard": "*hello"`, false},
+		{`"Commandline|wildcard|i": "ECHO * WORLD"`, true},
+		{`"Username|i": "SHHONG"`, true},
+		{`"Username|cased": "SHHONG"`, false},
+		{`"Commandline|contains|i|all": ["HELLO", "World"]`, true},
+		{`"Commandline|contains|windash": "echo /n"`, true},
+		{`"Commandline|contains": "echo /n"`, false},
+	}
+	sampleLog := `{"eventname": "bashReadline", "metadata": {"Commandline": "echo -n hello world", "Username": "shhong"}}`
+
+	log := new(model.CommonLogWrapper)
+	if err := json.Unmarshal([]byte(sampleLog), log); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = %v, want nil", sampleLog, err)
+	}
+	for _, tc := range testcases {
+		sample := "deny:\n  filter_null:\n    condition:\n      " + tc.rule + "\n"
+		op, err := filter.NewFilterOperator([]byte(sample))
+		if err != nil {
+			t.Fatalf("NewFilterOperator(%s) = %v, want nil", tc.rule, err)
+		}
+		if out := op.Operation(log); out != tc.want {
+			t.Errorf("FilterOperation(%s) = %v, want %v", tc.rule, out, tc.want)
+		}
+	}
+}
+
+func TestNewFilterOperatorWithInvalidRegex(t *testing.T) {
+	sample := `
+deny:
+  filter_null:
+    condition:
+      "Commandline|re": "echo ("
+`
+	_, err := filter.NewFilterOperator([]byte(sample))
+	if err == nil {
+		t.Fatalf("NewFilterOperator(%s) = nil, want not nil", sample)
+	}
+}
+
+func TestFilterOperationWithTypedModifiers(t *testing.T) {
+	testcases := []struct {
+		rule string
+		want bool
+	}{
+		{`"UID|lt": 1000`, false},
+		{`"UID|lte": 1000`, true},
+		{`"UID|gt": 999.5`, true},
+		{`"UID|gte": 1001`, false},
+		{`"PID|range": "191000..192000"`, true},
+		{`"PID|range": "0..1000"`, false},
+		{`"Port|lt": 1024`, true},
+		{`"Daddr|cidr": "10.0.0.0/8"`, true},
+		{`"Daddr|cidr": ["192.168.0.0/16", "172.16.0.0/12"]`, false},
+		{`"Saddr|cidr": "fe80::/10"`, true},
+		{`"Username|cidr": "10.0.0.0/8"`, false},
+		{`"Username|exists": true`, true},
+		{`"Hostname|exists": false`, true},
+		{`"Hostname|exists": true`, false},
+		{`"Username|lt": 1000`, false},
+	}
+	sampleLog := `{"eventname": "tcpConnect", "metadata": {"UID": 1000, "PID": 191998, "Port": "80", "Daddr": "10.1.2.3", "Saddr": "[fe80::1]:4242", "Username": "shhong"}}`
+
+	log := new(model.CommonLogWrapper)
+	if err := json.Unmarshal([]byte(sampleLog), log); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = %v, want nil", sampleLog, err)
+	}
+	for _, tc := range testcases {
+		sample := "deny:\n  filter_null:\n    condition:\n      " + tc.rule + "\n"
+		op, err := filter.NewFilterOperator([]byte(sample))
+		if err != nil {
+			t.Fatalf("NewFilterOperator(%s) = %v, want nil", tc.rule, err)
+		}
+		if out := op.Operation(log); out != tc.want {
+			t.Errorf("FilterOperation(%s) = %v, want %v", tc.rule, out, tc.want)
+		}
+	}
+}
+
+func TestNewFilterOperatorWithInvalidTypedCondition(t *testing.T) {
+	for _, rule := range []string{
+		`"UID|lt": "thousand"`,
+		`"PID|range": "2000..1000"`,
+		`"PID|range": "1000"`,
+		`"Daddr|cidr": "10.0.0.0"`,
+		`"Username|exists": "maybe"`,
+		`"UID|gt|i": 1000`,
+	} {
+		sample := "deny:\n  filter_null:\n    condition:\n      " + rule + "\n"
+		_, err := filter.NewFilterOperator([]byte(sample))
+		if err == nil {
+			t.Errorf("NewFilterOperator(%s) = nil, want not nil", rule)
+		}
+	}
+}
+
+func TestParseRuleFieldWithPath(t *testing.T) {
+	for sample, want := range map[string]string{
+		"process.parent.exe|endswith": "process.parent.exe",
+		"args[0]":                     "args[0]",
+		"children[*].pid|gt":          "children[*].pid",
+		"metadata.log|contains":       "metadata.log",
+	} {
+		result, err := filter.ParseRuleField(parser.RuleFieldParser(), sample)
+		if err != nil {
+			t.Fatalf("ParseRuleField(%s) = %v, want nil", sample, err)
+		}
+		if result.Field != want {
+			t.Errorf("ParseRuleField(%s).Field = %s, want %s", sample, result.Field, want)
+		}
+	}
+	for _, sample := range []string{"process.|contains", "args[x]", "args[0", ".field"} {
+		if _, err := filter.ParseRuleField(parser.RuleFieldParser(), sample); err == nil {
+			t.Errorf("ParseRuleField(%s) = nil, want not nil", sample)
+		}
+	}
+}
+
+func TestFilterOperationWithPath(t *testing.T) {
+	testcases := []struct {
+		rule string
+		want bool
+	}{
+		{`"process.parent.exe|endswith": "/bash"`, true},
+		{`"process.parent.pid|gt": 100`, true},
+		{`"process.args[0]": "sleep"`, true},
+		{`"process.args[1]": "sleep"`, false},
+		{`"process.args[5]|exists": false`, true},
+		{`"process.args[*]": "10"`, true},
+		{`"children[*].pid|range": "300..400"`, true},
+		{`"children[*].exe": "/usr/bin/vim"`, false},
+		{`"file.path|exists": true`, false},
+		{`"container.id": "abc"`, true},
+		{`"log": "A process has been created"`, true},
+		{`"metadata.log": "raw line"`, true},
+		{`"metadata.log": "A process has been created"`, false},
+	}
+	sampleLog := `
+{
+	"eventname": "processCreate",
+	"log": "A process has been created",
+	"metadata": {
+		"log": "raw line",
+		"container.id": "abc",
+		"process": {
+			"args": ["sleep", "10"],
+			"parent": {"exe": "/usr/bin/bash", "pid": 1234}
+		},
+		"children": [{"pid": 100, "exe": "/usr/bin/ls"}, {"pid": 321, "exe": "/usr/bin/cat"}]
+	}
+}`
+
+	log := new(model.CommonLogWrapper)
+	if err := json.Unmarshal([]byte(sampleLog), log); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = %v, want nil", sampleLog, err)
+	}
+	for _, tc := range testcases {
+		sample := "deny:\n  filter_null:\n    condition:\n      " + tc.rule + "\n"
+		op, err := filter.NewFilterOperator([]byte(sample))
+		if err != nil {
+			t.Fatalf("NewFilterOperator(%s) = %v, want nil", tc.rule, err)
+		}
+		if out := op.Operation(log); out != tc.want {
+			t.Errorf("FilterOperation(%s) = %v, want %v", tc.rule, out, tc.want)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	for _, sample := range []string{
+		"selection",
+		"selection and not filter",
+		"not (filter_a or filter_b) and selection",
+		"1 of filter_*",
+		"all of them",
+		"all_users and 1 of them",
+	} {
+		if _, err := filter.ParseCondition(parser, sample); err != nil {
+			t.Errorf("ParseCondition(%s) = %v, want nil", sample, err)
+		}
+	}
+	for _, sample := range []string{"", "selection and", "(selection", "2 of them", "1 of"} {
+		if _, err := filter.ParseCondition(parser, sample); err == nil {
+			t.Errorf("ParseCondition(%s) = nil, want not nil", sample)
+		}
+	}
+}
+
+func TestFilterOperationWithConditionExpression(t *testing.T) {
+	selections := `
+    selection:
+      "eventname": "processCreate"
+    filter_agent:
+      "Filename|endswith": "ebpf_sensor"
+    filter_cmd:
+      "Commandline|contains": "ebpf_sensor"
+    root:
+      "UID": 0
+`
+	testcases := []struct {
+		condition string
+		want      bool
+	}{
+		{"selection", true},
+		{"selection and not 1 of filter_*", true},
+		{"selection and 1 of filter_*", false},
+		{"all of filter_*", false},
+		{"not selection or root", false},
+		{"selection and not (root or filter_agent or filter_cmd)", true},
+		{"1 of them", true},
+		{"all of them", false},
+	}
+	sampleLog := `{"eventname": "processCreate", "metadata": {"Filename": "/usr/bin/sleep", "Commandline": "sleep 1", "UID": 1000}}`
+
+	log := new(model.CommonLogWrapper)
+	if err := json.Unmarshal([]byte(sampleLog), log); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = %v, want nil", sampleLog, err)
+	}
+	for _, tc := range testcases {
+		// unreferenced selections are rejected. "1 of them" is added to keep them all referenced.
+		sample := "deny:\n  filter_agent:\n    condition: \"(" + tc.condition + ") or (1 of them and not 1 of them)\"" + selections
+		op, err := filter.NewFilterOperator([]byte(sample))
+		if err != nil {
+			t.Fatalf("NewFilterOperator(%s) = %v, want nil", tc.condition, err)
+		}
+		if out := op.Operation(log); out != tc.want {
+			t.Errorf("FilterOperation(%s) = %v, want %v", tc.condition, out, tc.want)
+		}
+	}
+}
+
+func TestNewFilterOperatorWithInvalidConditionExpression(t *testing.T) {
+	for _, sample := range []string{
+		// undefined selection
+		"deny:\n  f:\n    condition: selection and filter\n    selection:\n      eventname: a\n",
+		// unreferenced selection
+		"deny:\n  f:\n    condition: selection\n    selection:\n      eventname: a\n    filter:\n      eventname: b\n",
+		// no selection matches
+		"deny:\n  f:\n    condition: selection or 1 of filter_*\n    selection:\n      eventname: a\n",
+		// selections without expression
+		"deny:\n  f:\n    condition:\n      eventname: a\n    selection:\n      eventname: b\n",
+		// keyword selection name
+		"deny:\n  f:\n    condition: all of them\n    them:\n      eventname: a\n",
+	} {
+		if _, err := filter.NewFilterOperator([]byte(sample)); err == nil {
+			t.Errorf("NewFilterOperator(%s) = nil, want not nil", sample)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	allowFilterOP, err := filter.NewFilterOperator([]byte(sampleAllowFilter))
+	if err != nil {
+		t.Fatalf("NewFilterOperator(%s) = %v, want nil", sampleAllowFilter, err)
+	}
+	testcases := []struct {
+		log  string
+		want filter.Verdict
+	}{
+		{`{"eventname": "bashReadline", "metadata": {"Commandline": "ls", "Username": "shhong"}}`,
+			filter.Verdict{Filtered: false, Allow: []string{"!allow_not_root", "allow_bash"}}},
+		{`{"eventname": "fileCreate", "metadata": {"Commandline": "touch", "Username": "root"}}`,
+			filter.Verdict{Filtered: true}},
+		{`{"eventname": "bashReadline", "metadata": {"Commandline": "sudo ls", "Username": "root"}}`,
+			filter.Verdict{Filtered: true, Deny: []string{"filter_sudo"}, Allow: []string{"allow_bash"}}},
+	}
+	for _, tc := range testcases {
+		log := new(model.CommonLogWrapper)
+		if err = json.Unmarshal([]byte(tc.log), log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", tc.log, err)
+		}
+		// chain merges verdicts of members
+		for _, op := range []filter.FilterOperator{allowFilterOP, filter.FilterChain{allowFilterOP}} {
+			if out := filter.Explain(op, log); !reflect.DeepEqual(out, tc.want) {
+				t.Errorf("Explain(%s) = %+v, want %+v", tc.log, out, tc.want)
+			}
+		}
+	}
+}
+
+func TestNewFilterOperatorWithSelectionError(t *testing.T) {
+	sample := `
+deny:
+  "filter_bad":
+    "condition":
+      "eventname|unknown": "bashReadline"
+`
+	_, err := filter.NewFilterOperator([]byte(sample))
+	var selectionErr filter.SelectionError
+	if !errors.As(err, &selectionErr) {
+		t.Fatalf("NewFilterOperator(%s) = %v, want SelectionError", sample, err)
+	}
+	if selectionErr.Section != "deny" || selectionErr.Name != "filter_bad" {
+		t.Errorf("NewFilterOperator(%s) = %v, want error of deny selection filter_bad", sample, selectionErr)
+	}
+}
+
+func TestDenyHits(t *testing.T) {
+	sample := `
+deny:
+  "filter_sudo":
+    "condition":
+      "Commandline|startswith": "sudo"
+  "filter_touch":
+    "condition":
+      "Commandline|contains": "touch"
+`
+	op, err := filter.NewFilterOperator([]byte(sample))
+	if err != nil {
+		t.Fatalf("NewFilterOperator(%s) = %v, want nil", sample, err)
+	}
+	for _, sampleLog := range []string{
+		`{"eventname": "bashReadline", "metadata": {"Commandline": "sudo ls"}}`,
+		`{"eventname": "bashReadline", "metadata": {"Commandline": "sudo cat"}}`,
+		`{"eventname": "bashReadline", "metadata": {"Commandline": "touch a"}}`,
+		`{"eventname": "bashReadline", "metadata": {"Commandline": "ls"}}`,
+	} {
+		log := new(model.CommonLogWrapper)
+		if err = json.Unmarshal([]byte(sampleLog), log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", sampleLog, err)
+		}
+		op.Operation(log)
+	}
+	want := []filter.SelectionHits{{Name: "filter_sudo", Hits: 2}, {Name: "filter_touch", Hits: 1}}
+	if out := filter.DenyHits(filter.FilterChain{op}); !reflect.DeepEqual(out, want) {
+		t.Errorf("DenyHits() = %+v, want %+v", out, want)
+	}
+}
+
+func TestDenyHitsOfOverlappingSelections(t *testing.T) {
+	sample := `
+deny:
+  "filter_touch":
+    "condition":
+      "Commandline|contains": "touch"
+  "filter_sudo":
+    "condition":
+      "Commandline|startswith": "sudo"
+`
+	log := new(model.CommonLogWrapper)
+	if err := json.Unmarshal([]byte(`{"eventname": "bashReadline", "metadata": {"Commandline": "sudo touch a"}}`), log); err != nil {
+		t.Fatalf("json.Unmarshal() = %v, want nil", err)
+	}
+	// the hit goes to the first selection in name order regardless of map order
+	want := []filter.SelectionHits{{Name: "filter_sudo", Hits: 1}, {Name: "filter_touch", Hits: 0}}
+	for i := 0; i < 20; i++ {
+		op, err := filter.NewFilterOperator([]byte(sample))
+		if err != nil {
+			t.Fatalf("NewFilterOperator(%s) = %v, want nil", sample, err)
+		}
+		op.Operation(log)
+		if out := filter.DenyHits(op); !reflect.DeepEqual(out, want) {
+			t.Fatalf("DenyHits() = %+v, want %+v", out, want)
+		}
+	}
+}
+
+func TestNewFilterOperatorReportsFirstSelectionError(t *testing.T) {
+	sample := `
+deny:
+  "filter_c":
+    "condition":
+      "eventname|unknown": "bashReadline"
+  "filter_a":
+    "condition":
+      "eventname|unknown": "bashReadline"
+  "filter_b":
+    "condition":
+      "eventname|unknown": "bashReadline"
+`
+	for i := 0; i < 20; i++ {
+		_, err := filter.NewFilterOperator([]byte(sample))
+		var selectionErr filter.SelectionError
+		if !errors.As(err, &selectionErr) || selectionErr.Name != "filter_a" {
+			t.Fatalf("NewFilterOperator(%s) = %v, want error of deny selection filter_a", sample, err)
+		}
+	}
+}
+
+func TestValidateFilterReportsAllSelections(t *testing.T) {
+	sample := `
+allow:
+  "shell":
+    "eventname|unknown": "bashReadline"
+deny:
+  "filter_ok":
+    "condition":
+      "eventname": "bashReadline"
+  "filter_bad":
+    "condition":
+      "eventname|unknown": "bashReadline"
+`
+	errs := filter.ValidateFilter([]byte(sample))
+	want := []string{"allow.shell", "deny.filter_bad"}
+	if len(errs) != len(want) {
+		t.Fatalf("ValidateFilter(%s) = %v, want errors of %v", sample, errs, want)
+	}
+	for i, err := range errs {
+		var selectionErr filter.SelectionError
+		if !errors.As(err, &selectionErr) || selectionErr.Section+"."+selectionErr.Name != want[i] {
+			t.Errorf("ValidateFilter()[%d] = %v, want error of %s", i, err, want[i])
+		}
+	}
+	if errs = filter.ValidateFilter([]byte(sampleFilter)); len(errs) != 0 {
+		t.Errorf("ValidateFilter(sampleFilter) = %v, want no error", errs)
+	}
+}
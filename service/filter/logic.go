@@ -2,8 +2,11 @@ package filter
 
 import (
 	"fmt"
+	"net"
 	perror "polvo/error"
 	"polvo/service/model"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/alecthomas/participle/v2"
@@ -26,29 +29,63 @@ type Logic interface {
 // - STARTSWITH : checks if the field starts with the condition
 // - ENDSWITH : checks if the field ends with the condition
 // - CONTAINS : checks if the field contains the condition
+// - REGEX : checks if the field matches the regular expression
+// - WILDCARD : checks if the whole field matches the glob pattern with * and ?
+// - LT, LTE, GT, GTE : compares the numeric field with the condition
+// - RANGE : checks if the numeric field is within the condition "min..max" (inclusive)
+// - CIDR : checks if the IP address field is within the network of the condition
+// - EXISTS : checks if the field exists or not
 type RuleOpCode int
 
 const (
 	STARTSWITH RuleOpCode = iota
 	ENDSWITH
 	CONTAINS
+	REGEX
+	WILDCARD
+	LT
+	LTE
+	GT
+	GTE
+	RANGE
+	CIDR
+	EXISTS
 	NONE
 )
 
+// dashes of windows command line options, which are all accepted by many windows programs.
+var windowsDashes = []string{"-", "/", "\u2013", "\u2014", "\u2015"}
+
+// windash replaces the option prefixes of the condition.
+var windashPattern = regexp.MustCompile(`(^|\s)[-/\x{2013}\x{2014}\x{2015}]`)
+
 // # RuleSelectionOperator
 //
 // RuleSelectionOperator combines multiple rules with AND or NOT operations.
 // The group name is the name of the group to which the rules belong.
 // If the group name starts with "!", it is a NOT operation.
-// So if the group name contains "!", it is not a valid group name. so error will be returned.
+// So if the group name contains "!" elsewhere, it is not a valid group name. so error will be returned.
 type RuleSelectionOperator struct {
 	groupName string
+	isNot     bool
 	rules     []Logic
 }
 
 func NewRuleSelectionOperator(parser Parser, groupName string, rules *Rule) (Logic, error) {
 	rgOP := new(RuleSelectionOperator)
 	rgOP.groupName = groupName
+	// check NOT operation
+	if strings.HasPrefix(groupName, "!") {
+		rgOP.isNot = true
+		rgOP.groupName = groupName[1:]
+	}
+	if rgOP.groupName == "" || strings.Contains(rgOP.groupName, "!") {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrCollectionField,
+			Msg:    "error while NewRuleSelectionOperator",
+			Origin: fmt.Errorf("invalid selection name %s", groupName),
+		}
+	}
 
 	// read rules from map
 	rgOP.rules = make([]Logic, 0)
@@ -68,7 +105,11 @@ func NewRuleSelectionOperator(parser Parser, groupName string, rules *Rule) (Log
 
 func (rgOP *RuleSelectionOperator) Operation(log *model.CommonLogWrapper) bool {
 	// set boolean with isAnd
-	return And(rgOP.rules).Operation(log)
+	result := And(rgOP.rules).Operation(log)
+	if rgOP.isNot {
+		return !result
+	}
+	return result
 }
 
 // # RuleOperator
@@ -76,10 +117,12 @@ func (rgOP *RuleSelectionOperator) Operation(log *model.CommonLogWrapper) bool {
 // RuleOperator implements the actual behavior of the Rule written by the user.
 // A rule consists of N events.
 type RuleOperator struct {
-	op        RuleOpCode
-	isAnd     bool
-	fieldName string
-	events    []Logic
+	op         RuleOpCode
+	isAnd      bool
+	ignoreCase bool
+	windash    bool
+	fieldName  string
+	events     []Logic
 }
 
 func NewRuleOperator(parser Parser, key string, val *yaml.Node) (Logic, error) {
@@ -102,6 +145,24 @@ func NewRuleOperator(parser Parser, key string, val *yaml.Node) (Logic, error) {
 			rOP.op = ENDSWITH
 		case "contains":
 			rOP.op = CONTAINS
+		case "re":
+			rOP.op = REGEX
+		case "wildcard":
+			rOP.op = WILDCARD
+		case "lt":
+			rOP.op = LT
+		case "lte":
+			rOP.op = LTE
+		case "gt":
+			rOP.op = GT
+		case "gte":
+			rOP.op = GTE
+		case "range":
+			rOP.op = RANGE
+		case "cidr":
+			rOP.op = CIDR
+		case "exists":
+			rOP.op = EXISTS
 		default:
 			return nil, perror.PolvoFilterError{
 				Code:   perror.ErrRuleField,
@@ -114,18 +175,36 @@ func NewRuleOperator(parser Parser, key string, val *yaml.Node) (Logic, error) {
 	}
 	// set the condition
 	rOP.isAnd = field.Cond != nil
+	// value modifiers are for string comparison
+	if rOP.op.isTyped() && len(field.Flags) > 0 {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrRuleField,
+			Msg:    "error while NewRuleOperate",
+			Origin: fmt.Errorf("modifiers %v can not be used with %s", field.Flags, *field.Op),
+		}
+	}
+	// set the value modifiers
+	for _, flag := range field.Flags {
+		switch flag {
+		case "i":
+			rOP.ignoreCase = true
+		case "windash":
+			rOP.windash = true
+		}
+	}
 	// set the field
 	rOP.fieldName = field.Field
 	// set the value with kind
 	rOP.events = make([]Logic, 0)
 
 	// check detection kind is scalar or sequence not map
+	var values []string
 	switch val.Kind {
 	case yaml.ScalarNode:
-		rOP.events = append(rOP.events, NewEventOperator(rOP.fieldName, rOP.op, val.Value))
+		values = append(values, val.Value)
 	case yaml.SequenceNode:
 		for _, v := range val.Content {
-			rOP.events = append(rOP.events, NewEventOperator(rOP.fieldName, rOP.op, v.Value))
+			values = append(values, v.Value)
 		}
 	default:
 		return nil, perror.PolvoFilterError{
@@ -134,9 +213,44 @@ func NewRuleOperator(parser Parser, key string, val *yaml.Node) (Logic, error) {
 			Origin: fmt.Errorf("invalid detection value. detection must be scalar or sequence"),
 		}
 	}
+	// compile events once
+	for _, value := range values {
+		event, err := rOP.newEvent(value)
+		if err != nil {
+			return nil, perror.PolvoFilterError{
+				Code:   perror.ErrRuleField,
+				Msg:    "error while NewRuleOperate",
+				Origin: err,
+			}
+		}
+		rOP.events = append(rOP.events, event)
+	}
 	return rOP, nil
 }
 
+// newEvent constructs the event of a value. windash expands the value into variants joined with OR.
+func (rOP *RuleOperator) newEvent(value string) (Logic, error) {
+	if !rOP.windash || !windashPattern.MatchString(value) {
+		return NewEventOperator(rOP.fieldName, rOP.op, rOP.ignoreCase, value)
+	}
+	variants := make(Or, 0, len(windowsDashes))
+	for _, dash := range windowsDashes {
+		variant := windashPattern.ReplaceAllStringFunc(value, func(prefix string) string {
+			// keep the leading whitespace
+			if r := []rune(prefix); len(r) > 1 {
+				return string(r[0]) + dash
+			}
+			return dash
+		})
+		event, err := NewEventOperator(rOP.fieldName, rOP.op, rOP.ignoreCase, variant)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, event)
+	}
+	return variants, nil
+}
+
 func (rOP *RuleOperator) Operation(log *model.CommonLogWrapper) bool {
 	// set boolean with isAnd
 	switch rOP.isAnd {
@@ -153,65 +267,108 @@ func (rOP *RuleOperator) Operation(log *model.CommonLogWrapper) bool {
 // EventOperator is an operator that compares the actual log and the condition and derives the result.
 // There is a premise that the log that is the target of all operations uses CommonLogModel.
 type EventOperator struct {
-	op        RuleOpCode
-	fieldName string
-	condition string
+	op         RuleOpCode
+	ignoreCase bool
+	fieldName  string
+	path       *fieldPath
+	condition  string
+	// compiled pattern of REGEX & WILDCARD
+	pattern *regexp.Regexp
+	// parsed condition of typed operations
+	min, max float64
+	network  *net.IPNet
+	exists   bool
 }
 
-func NewEventOperator(fieldName string, op RuleOpCode, condition string) Logic {
+func NewEventOperator(fieldName string, op RuleOpCode, ignoreCase bool, condition string) (Logic, error) {
+	var err error
+
 	// set the operation
 	eOP := new(EventOperator)
 	// set the field
 	eOP.fieldName = fieldName
+	eOP.path, err = newFieldPath(fieldName)
+	if err != nil {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrRuleField,
+			Msg:    "error while NewEventOperator",
+			Origin: err,
+		}
+	}
 	// set the value
 	eOP.condition = condition
 	// set the operation
 	eOP.op = op
-	return eOP
+	eOP.ignoreCase = ignoreCase
+	// compile pattern
+	switch op {
+	case REGEX:
+		if ignoreCase {
+			condition = "(?i)" + condition
+		}
+		eOP.pattern, err = regexp.Compile(condition)
+	case WILDCARD:
+		eOP.pattern, err = compileWildcard(condition, ignoreCase)
+	case LT, LTE, GT, GTE:
+		eOP.min, err = strconv.ParseFloat(strings.TrimSpace(condition), 64)
+		eOP.max = eOP.min
+	case RANGE:
+		eOP.min, eOP.max, err = parseRange(condition)
+	case CIDR:
+		_, eOP.network, err = net.ParseCIDR(strings.TrimSpace(condition))
+	case EXISTS:
+		eOP.exists, err = strconv.ParseBool(strings.TrimSpace(condition))
+	default:
+		if ignoreCase {
+			eOP.condition = strings.ToLower(condition)
+		}
+	}
+	if err != nil {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrRuleField,
+			Msg:    "error while NewEventOperator",
+			Origin: err,
+		}
+	}
+	return eOP, nil
 }
 
 func (eOP *EventOperator) Operation(log *model.CommonLogWrapper) bool {
-	var strVal string
+	found, matched := eOP.path.visit(log, eOP.match)
+	if eOP.op == EXISTS {
+		return found == eOP.exists
+	}
+	return matched
+}
 
-	// check log has field & get value
-	switch eOP.fieldName {
-	case "eventname":
-		return eOP.compare(log.EventName)
-	case "source":
-		return eOP.compare(log.Source)
-	case "timestamp":
-		return eOP.compare(log.Timestmp)
-	case "log":
-		return eOP.compare(log.Log)
+// match compares a value of the field with the condition.
+func (eOP *EventOperator) match(val interface{}) bool {
+	switch eOP.op {
+	case LT, LTE, GT, GTE, RANGE:
+		return eOP.compareNumber(val)
+	case CIDR:
+		return eOP.compareIP(val)
+	case EXISTS:
+		return true
+	}
+	// check value's type
+	switch val := val.(type) {
+	case string:
+		return eOP.compare(val)
+	case []string:
+		return eOP.compare(strings.Join(val, " "))
 	default:
-		// metadata
-		if log.MetaData == nil {
-			return false
-		}
-		// check metadata's type is map
-		if _, ok := log.MetaData.(map[string]interface{}); !ok {
-			return false
-		}
-		// check metadata has field & get value
-		val, ok := log.MetaData.(map[string]interface{})[eOP.fieldName]
-		if !ok {
-			// not found field
-			return false
-		}
-		// check value's type
-		switch val := val.(type) {
-		case string:
-			strVal = val
-		case []string:
-			strVal = strings.Join(val, " ")
-		default:
-			strVal = fmt.Sprintf("%v", val)
-		}
-		return eOP.compare(strVal)
+		return eOP.compare(fmt.Sprintf("%v", val))
 	}
 }
 
 func (eOP *EventOperator) compare(dest string) bool {
+	if eOP.pattern != nil {
+		return eOP.pattern.MatchString(dest)
+	}
+	if eOP.ignoreCase {
+		dest = strings.ToLower(dest)
+	}
 	switch eOP.op {
 	case STARTSWITH:
 		return strings.HasPrefix(dest, eOP.condition)
@@ -224,14 +381,47 @@ func (eOP *EventOperator) compare(dest string) bool {
 	}
 }
 
+// compileWildcard converts the glob pattern into an anchored regular expression.
+// * matches any string, ? matches any character and \ escapes the next character.
+func compileWildcard(glob string, ignoreCase bool) (*regexp.Regexp, error) {
+	var builder strings.Builder
+
+	if ignoreCase {
+		builder.WriteString("(?i)")
+	}
+	builder.WriteString("^")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			builder.WriteString("(?s:.*)")
+		case '?':
+			builder.WriteString("(?s:.)")
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
 // # ParseRuleField
 //
 // The condition field of the Rule has the following format:
-// - field|operation|condition
+// - field|operation|modifier|condition
 //
 // # field
 //
 // The field is the field name of the target log.
+// eventname, source, timestamp & log are the header fields, and the others are the fields of metadata.
+// Nested fields of metadata are referred by dotted path, and elements of array by [index] or [*] for any element.
+// e.g. process.parent.exe, args[0], children[*].pid
+// metadata.<path> refers to metadata even if the path collides with the header fields. e.g. metadata.log
 //
 // # operation
 //
@@ -240,6 +430,19 @@ func (eOP *EventOperator) compare(dest string) bool {
 // - startswith : checks if the field starts with the condition
 // - endswith : checks if the field ends with the condition
 // - contains : checks if the field contains the condition
+// - re : checks if the field matches the regular expression
+// - wildcard : checks if the whole field matches the glob pattern with * and ?
+// - lt, lte, gt, gte : compares the numeric field with the number condition
+// - range : checks if the numeric field is within the condition "min..max" (inclusive)
+// - cidr : checks if the IP address field is within the network of the condition. e.g. 10.0.0.0/8
+// - exists : checks if the field exists when the condition is true, or not exists when false
+//
+// # modifier
+//
+// The modifiers change how the values are compared. They can be placed in any order.
+// - i : compares case-insensitively
+// - cased : compares case-sensitively. it is the default.
+// - windash : also matches the values whose option prefix "-" is replaced with "/" or unicode dashes
 //
 // # condition
 //
@@ -263,5 +466,45 @@ func ParseRuleField(parser *participle.Parser[RuleFieldOper], key string) (*Rule
 			Origin: err,
 		}
 	}
+	// classify modifiers
+	seen := make(map[string]bool)
+	for _, modifier := range detectOpr.Modifiers {
+		if seen[modifier] {
+			return nil, perror.PolvoFilterError{
+				Code:   perror.ErrRuleField,
+				Msg:    "Failed to parse detection field",
+				Origin: fmt.Errorf("duplicated modifier %s in %s", modifier, key),
+			}
+		}
+		seen[modifier] = true
+		switch modifier {
+		case "startswith", "endswith", "contains", "re", "wildcard", "lt", "lte", "gt", "gte", "range", "cidr", "exists":
+			if detectOpr.Op != nil {
+				return nil, perror.PolvoFilterError{
+					Code:   perror.ErrRuleField,
+					Msg:    "Failed to parse detection field",
+					Origin: fmt.Errorf("operations %s & %s are exclusive in %s", *detectOpr.Op, modifier, key),
+				}
+			}
+			detectOpr.Op = &modifier
+		case "all":
+			detectOpr.Cond = &modifier
+		case "i", "cased", "windash":
+			detectOpr.Flags = append(detectOpr.Flags, modifier)
+		default:
+			return nil, perror.PolvoFilterError{
+				Code:   perror.ErrRuleField,
+				Msg:    "Failed to parse detection field",
+				Origin: fmt.Errorf("invalid modifier %s in %s", modifier, key),
+			}
+		}
+	}
+	if seen["i"] && seen["cased"] {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrRuleField,
+			Msg:    "Failed to parse detection field",
+			Origin: fmt.Errorf("modifiers i & cased are exclusive in %s", key),
+		}
+	}
 	return detectOpr, nil
 }
@@ -6,12 +6,23 @@ import (
 
 type Rule map[string]yaml.Node
 
+// # Deny
+//
+// Deny is a deny entry of the filter. The condition is either
+//
+// - a rule. the log is denied if any field of the rule matches.
+//
+// - an expression over the named selections of the entry. e.g. "selection and not 1 of filter_*"
+//
+// The log is not denied if any field of the exception matches.
 type Deny struct {
-	Condition Rule `yaml:"condition"`
-	Exception Rule `yaml:"exception,omitempty"`
+	Condition  yaml.Node       `yaml:"condition"`
+	Exception  Rule            `yaml:"exception,omitempty"`
+	Selections map[string]Rule `yaml:",inline"`
 }
 
 type Filter struct {
 	Version string          `yaml:"version"`
+	Allow   map[string]Rule `yaml:"allow,omitempty"`
 	Deny    map[string]Deny `yaml:"deny"`
 }
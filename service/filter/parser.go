@@ -6,24 +6,39 @@ import (
 	"github.com/alecthomas/participle/v2"
 )
 
+// # RuleFieldOper
+//
+// RuleFieldOper is the parsed key of a rule. e.g. field|contains|i|all
+// Modifiers are classified into Op, Cond & Flags by ParseRuleField.
 type RuleFieldOper struct {
-	Field string  `@Ident`
-	Op    *string `("|" @("startswith" | "endswith" | "contains"))?`
-	Cond  *string `("|" @("all"))?`
+	Field     string   `parser:"@Ident ( @'.' @Ident | @'[' ( @Int | @'*' ) @']' )*"`
+	Modifiers []string `parser:"('|' @Ident)*"`
+	// comparison modifier. startswith, endswith, contains, re or wildcard
+	Op *string
+	// condition modifier. all
+	Cond *string
+	// value modifiers. i, cased or windash
+	Flags []string
 }
 
 type Parser interface {
 	RuleFieldParser() *participle.Parser[RuleFieldOper]
+	ConditionParser() *participle.Parser[ConditionExpr]
 }
 
 type parser struct {
 	ruleFieldParser *participle.Parser[RuleFieldOper]
+	conditionParser *participle.Parser[ConditionExpr]
 }
 
 func (p *parser) RuleFieldParser() *participle.Parser[RuleFieldOper] {
 	return p.ruleFieldParser
 }
 
+func (p *parser) ConditionParser() *participle.Parser[ConditionExpr] {
+	return p.conditionParser
+}
+
 func NewParser() (Parser, error) {
 	var err error
 
@@ -37,6 +52,15 @@ func NewParser() (Parser, error) {
 			Origin: err,
 		}
 	}
+	// selection names may start with keywords. e.g. "all of them" & "allowed_users"
+	p.conditionParser, err = participle.Build[ConditionExpr](participle.UseLookahead(2))
+	if err != nil {
+		return nil, perror.PolvoFilterError{
+			Code:   perror.ErrCollectionField,
+			Msg:    "Failed to build parser in ParseCondition",
+			Origin: err,
+		}
+	}
 
 	return p, nil
 }
@@ -0,0 +1,149 @@
+package filter
+
+import (
+	"fmt"
+	"polvo/service/model"
+	"strconv"
+	"strings"
+)
+
+// prefix of field paths which always refer to metadata.
+// e.g. metadata.log refers to "log" key of metadata, not the header field.
+const metadataPrefix = "metadata"
+
+// header fields of CommonLogWrapper
+var headerFields = map[string]func(log *model.CommonLogWrapper) string{
+	"eventname": func(log *model.CommonLogWrapper) string { return log.EventName },
+	"source":    func(log *model.CommonLogWrapper) string { return log.Source },
+	"timestamp": func(log *model.CommonLogWrapper) string { return log.Timestmp },
+	"log":       func(log *model.CommonLogWrapper) string { return log.Log },
+}
+
+type pathSegment struct {
+	key     string
+	isIndex bool
+	// index of array. any means [*]
+	index int
+	any   bool
+}
+
+// # fieldPath
+//
+// fieldPath is the compiled field name of a rule.
+//
+// - eventname, source, timestamp & log refer to the header fields of the log.
+//
+// - other names are dotted paths of metadata. e.g. process.parent.exe
+//
+// - args[0] refers to the first element of array, and args[*] refers to any element of array.
+//
+// - metadata.<path> always refers to metadata, even if the path collides with the header fields.
+type fieldPath struct {
+	header   func(log *model.CommonLogWrapper) string
+	segments []pathSegment
+	// literal key of metadata for sensors which emit flat dotted keys. e.g. "process.pid"
+	literal string
+}
+
+func newFieldPath(field string) (*fieldPath, error) {
+	fp := new(fieldPath)
+	if header, ok := headerFields[field]; ok {
+		fp.header = header
+		return fp, nil
+	}
+	if field == metadataPrefix {
+		// metadata itself
+		return fp, nil
+	}
+	field = strings.TrimPrefix(field, metadataPrefix+".")
+	for _, part := range strings.Split(field, ".") {
+		// split key & indexes. e.g. args[0][*]
+		key, indexes, _ := strings.Cut(part, "[")
+		if key == "" {
+			return nil, fmt.Errorf("invalid field path %s", field)
+		}
+		fp.segments = append(fp.segments, pathSegment{key: key})
+		if indexes == "" {
+			continue
+		}
+		for _, index := range strings.Split(strings.TrimSuffix(indexes, "]"), "][") {
+			if index == "*" {
+				fp.segments = append(fp.segments, pathSegment{isIndex: true, any: true})
+				continue
+			}
+			num, err := strconv.Atoi(index)
+			if err != nil || num < 0 {
+				return nil, fmt.Errorf("invalid index [%s] of field path %s", index, field)
+			}
+			fp.segments = append(fp.segments, pathSegment{isIndex: true, index: num})
+		}
+	}
+	if len(fp.segments) > 1 && !strings.Contains(field, "[") {
+		fp.literal = field
+	}
+	return fp, nil
+}
+
+// visit calls fn with the values of the path in log until fn returns true.
+// found is false if log has no value of the path.
+func (fp *fieldPath) visit(log *model.CommonLogWrapper, fn func(interface{}) bool) (found bool, matched bool) {
+	if fp.header != nil {
+		return true, fn(fp.header(log))
+	}
+	if log.MetaData == nil {
+		return false, false
+	}
+	if fp.literal != "" {
+		if metadata, ok := log.MetaData.(map[string]interface{}); ok {
+			if val, ok := metadata[fp.literal]; ok {
+				return true, fn(val)
+			}
+		}
+	}
+	return visitPath(log.MetaData, fp.segments, fn)
+}
+
+func visitPath(val interface{}, segments []pathSegment, fn func(interface{}) bool) (found bool, matched bool) {
+	if len(segments) <= 0 {
+		return true, fn(val)
+	}
+	segment := segments[0]
+	if !segment.isIndex {
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return false, false
+		}
+		child, ok := obj[segment.key]
+		if !ok {
+			return false, false
+		}
+		return visitPath(child, segments[1:], fn)
+	}
+	// array
+	var elems []interface{}
+	switch arr := val.(type) {
+	case []interface{}:
+		elems = arr
+	case []string:
+		elems = make([]interface{}, len(arr))
+		for i, elem := range arr {
+			elems[i] = elem
+		}
+	default:
+		return false, false
+	}
+	if !segment.any {
+		if segment.index >= len(elems) {
+			return false, false
+		}
+		return visitPath(elems[segment.index], segments[1:], fn)
+	}
+	for _, elem := range elems {
+		elemFound, elemMatched := visitPath(elem, segments[1:], fn)
+		found = found || elemFound
+		if elemMatched {
+			return true, true
+		}
+	}
+	return found, false
+}
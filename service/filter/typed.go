@@ -0,0 +1,95 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// isTyped returns true if the operation compares typed values instead of strings.
+func (op RuleOpCode) isTyped() bool {
+	switch op {
+	case LT, LTE, GT, GTE, RANGE, CIDR, EXISTS:
+		return true
+	}
+	return false
+}
+
+// parseRange parses the range condition "min..max".
+func parseRange(condition string) (float64, float64, error) {
+	lower, upper, ok := strings.Cut(condition, "..")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %s. range must be min..max", condition)
+	}
+	lowerNum, err := strconv.ParseFloat(strings.TrimSpace(lower), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	upperNum, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lowerNum > upperNum {
+		return 0, 0, fmt.Errorf("invalid range %s. min is larger than max", condition)
+	}
+	return lowerNum, upperNum, nil
+}
+
+// toNumber converts the decoded metadata value into float64.
+// numbers in strings are also accepted because some sensors print numbers as strings.
+func toNumber(val interface{}) (float64, bool) {
+	switch val := val.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case json.Number:
+		num, err := val.Float64()
+		return num, err == nil
+	case string:
+		num, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return num, err == nil
+	}
+	return 0, false
+}
+
+func (eOP *EventOperator) compareNumber(val interface{}) bool {
+	num, ok := toNumber(val)
+	if !ok {
+		return false
+	}
+	switch eOP.op {
+	case LT:
+		return num < eOP.min
+	case LTE:
+		return num <= eOP.min
+	case GT:
+		return num > eOP.min
+	case GTE:
+		return num >= eOP.min
+	case RANGE:
+		return eOP.min <= num && num <= eOP.max
+	}
+	return false
+}
+
+func (eOP *EventOperator) compareIP(val interface{}) bool {
+	str, ok := val.(string)
+	if !ok {
+		return false
+	}
+	// strip port of address. e.g. 10.0.0.1:80, [::1]:80
+	if host, _, err := net.SplitHostPort(str); err == nil {
+		str = host
+	}
+	ip := net.ParseIP(strings.TrimSpace(str))
+	return ip != nil && eOP.network.Contains(ip)
+}
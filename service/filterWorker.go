@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"polvo/compose"
+	"polvo/metrics"
 	"polvo/service/filter"
 	"polvo/service/model"
 	"sync"
@@ -21,8 +22,9 @@ type filterWorker struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
 	// dependency injection
-	info        *compose.SensorInfo // TODO: processor info will be added.
-	eventHeader map[string][]string
+	info *compose.SensorInfo // TODO: processor info will be added.
+	// schema is nil if logs are not checked against events_header
+	schema *schemaValidator
 	// sensor pipe
 	inboundChannel chan *model.CommonLogWrapper
 	// outbound pipes
@@ -30,6 +32,10 @@ type filterWorker struct {
 	// filter Operator
 	filterOperator        filter.FilterOperator
 	returnLogObjectToPool func(*model.CommonLogWrapper)
+	// metrics of sensor
+	eventsIn      *metrics.Counter
+	eventsOut     *metrics.Counter
+	eventsDropped *metrics.Counter
 	// wait group for filter thread
 	waitForEndRemainTasks sync.WaitGroup
 }
@@ -47,13 +53,17 @@ func newFilterWorker(filterOperator filter.FilterOperator,
 
 	// dependency injection
 	nw.info = info
-	nw.eventHeader = info.EventsHeader
+	nw.schema = newSchemaValidator(info)
 	// init filter operator
 	// The relationship between filterWorker and filterOperator is has-a relationship.
-	// There are multiple filterWorkers and each filterWorker uses a singleton filterOperator.
-	// However, I judged it to be threadsafe because no write operation occurs in filteroperator.
+	// Each filterWorker uses the chain of the global filter & the filters of its sensor.
+	// Filter operators are shared between workers. They are threadsafe because no write operation occurs in filteroperator.
 	nw.filterOperator = filterOperator
 	nw.returnLogObjectToPool = returnLogObjectToPool
+	// metrics
+	nw.eventsIn = sensorEventsIn.With(info.Name)
+	nw.eventsOut = sensorEventsOut.With(info.Name)
+	nw.eventsDropped = sensorEventsDropped.With(info.Name)
 	// context
 	nw.ctx, nw.cancel = context.WithCancel(context.Background())
 	// set channels
@@ -99,8 +109,10 @@ func (fw *filterWorker) filterThread() {
 			return
 		case log = <-fw.inboundChannel:
 			fw.waitForEndRemainTasks.Add(1)
-			// filter log
-			if fw.filterOperator.Operation(log) {
+			fw.eventsIn.Inc()
+			// check schema & filter log
+			if (fw.schema != nil && fw.schema.validate(log)) || fw.filterOperator.Operation(log) {
+				fw.eventsDropped.Inc()
 				// drop log if it is filtered
 				// put log to sync.Pool
 				// fmt.Fprintf(os.Stderr, "log is filtered: %v\n", log)
@@ -109,12 +121,17 @@ func (fw *filterWorker) filterThread() {
 				continue
 			}
 
+			// every pipeline holds a reference until it drops the log or its exporter serializes it.
+			// references are set before the first send, so a pipeline can not release the log before others receive it.
+			if len(fw.outboundChannel) == 0 {
+				fw.returnLogObjectToPool(log)
+			}
+			atomic.StoreInt32(&log.RefCount, int32(len(fw.outboundChannel)))
 			// send to outbound channels
 			for _, outboundChannel = range fw.outboundChannel {
-				// add ref count if log is sent to another worker
-				atomic.AddInt32(&log.RefCount, 1)
 				outboundChannel <- log
 			}
+			fw.eventsOut.Inc()
 			fw.waitForEndRemainTasks.Done()
 		}
 	}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"polvo/compose"
+	perror "polvo/error"
+	"polvo/service/model"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// keys of logfmt & kv lines which are set to the common log header instead of metadata
+const (
+	keyEventName = "eventname"
+	keySource    = "source"
+	keyTimestamp = "timestamp"
+	keyLog       = "log"
+)
+
+// logHeader is the common log header of a decoded line.
+type logHeader struct {
+	eventName string
+	source    string
+	timestamp string
+	log       string
+}
+
+// unMarshalFunc returns the function which converts a stdout line of sensor to CommonLogWrapper by the format of sensor.
+func (s *service) unMarshalFunc(sensorInfo *compose.SensorInfo) func(string) (*model.CommonLogWrapper, error) {
+	var parse func(line string) (header logHeader, metadata map[string]interface{}, err error)
+
+	switch sensorInfo.Format {
+	case compose.FormatCSV:
+		parse = func(line string) (logHeader, map[string]interface{}, error) {
+			reader := csv.NewReader(strings.NewReader(line))
+			reader.FieldsPerRecord = -1
+			record, err := reader.Read()
+			if err != nil {
+				return logHeader{}, nil, err
+			}
+			return parseColumns(sensorInfo.EventsHeader, record)
+		}
+	case compose.FormatTSV:
+		parse = func(line string) (logHeader, map[string]interface{}, error) {
+			return parseColumns(sensorInfo.EventsHeader, strings.Split(line, "\t"))
+		}
+	case compose.FormatLogfmt:
+		parse = func(line string) (logHeader, map[string]interface{}, error) {
+			return parsePairs(line, true)
+		}
+	case compose.FormatKeyValue:
+		parse = func(line string) (logHeader, map[string]interface{}, error) {
+			return parsePairs(line, false)
+		}
+	case compose.FormatMsgpack:
+		return s.msgpackUnMarshalFunc
+	case compose.FormatRaw:
+		parse = func(line string) (logHeader, map[string]interface{}, error) {
+			return logHeader{eventName: sensorInfo.Name, log: line}, nil, nil
+		}
+	default:
+		return s.jsonUnMarshalFunc
+	}
+
+	return func(line string) (*model.CommonLogWrapper, error) {
+		header, metadata, err := parse(strings.TrimRight(line, "\r"))
+		if err != nil {
+			return nil, perror.PolvoPipelineError{
+				Code:   perror.ErrPipelineUnmarshal,
+				Origin: err,
+				Msg:    fmt.Sprintf("error while decode %s log", sensorInfo.Format),
+			}
+		}
+		// get from sync pool. every field is overwritten because the wrapper is reused.
+		common := s.logWrapperPool.Get().(*model.CommonLogWrapper)
+		atomic.StoreInt32(&common.RefCount, 1)
+		common.EventName, common.Source, common.Timestmp, common.Log = header.eventName, header.source, header.timestamp, header.log
+		// lines without source & timestamp are stamped by the agent
+		if common.Source == "" {
+			common.Source = sensorInfo.Name
+		}
+		if common.Timestmp == "" {
+			common.Timestmp = time.Now().Format(time.RFC3339)
+		}
+		common.MetaData = metadata
+		common.SchemaViolations = nil
+		return common, nil
+	}
+}
+
+// parseColumns maps columns to the fields of events_header for the event name in the first column.
+// Missing trailing columns are omitted from metadata.
+func parseColumns(eventsHeader map[string][]string, columns []string) (logHeader, map[string]interface{}, error) {
+	eventName := columns[0]
+	fields, ok := eventsHeader[eventName]
+	if !ok {
+		return logHeader{}, nil, fmt.Errorf("event %s is not declared in events_header", eventName)
+	}
+	if len(columns)-1 > len(fields) {
+		return logHeader{}, nil, fmt.Errorf("event %s has %d columns, but events_header has %d fields", eventName, len(columns)-1, len(fields))
+	}
+	metadata := make(map[string]interface{}, len(fields))
+	for i, value := range columns[1:] {
+		metadata[fields[i]] = value
+	}
+	return logHeader{eventName: eventName}, metadata, nil
+}
+
+// parsePairs parses whitespace separated key=value pairs. eventname, source, timestamp & log are the common log header.
+// If quoted is true, values may be double-quoted with Go escapes as in logfmt. A key without value is an empty string.
+func parsePairs(line string, quoted bool) (logHeader, map[string]interface{}, error) {
+	var header logHeader
+
+	metadata := make(map[string]interface{})
+	for line = strings.TrimLeftFunc(line, unicode.IsSpace); line != ""; line = strings.TrimLeftFunc(line, unicode.IsSpace) {
+		var key, value string
+
+		end := strings.IndexFunc(line, func(r rune) bool { return r == '=' || unicode.IsSpace(r) })
+		if end < 0 {
+			key, line = line, ""
+		} else {
+			key, line = line[:end], line[end:]
+		}
+		if key == "" {
+			return header, nil, fmt.Errorf("empty key before %q", line)
+		}
+		if strings.HasPrefix(line, "=") {
+			line = line[1:]
+			if quoted && strings.HasPrefix(line, `"`) {
+				prefix, err := strconv.QuotedPrefix(line)
+				if err != nil {
+					return header, nil, fmt.Errorf("invalid quoted value of %s", key)
+				}
+				if value, err = strconv.Unquote(prefix); err != nil {
+					return header, nil, fmt.Errorf("invalid quoted value of %s", key)
+				}
+				line = line[len(prefix):]
+			} else {
+				end = strings.IndexFunc(line, unicode.IsSpace)
+				if end < 0 {
+					end = len(line)
+				}
+				value, line = line[:end], line[end:]
+			}
+		}
+		switch key {
+		case keyEventName:
+			header.eventName = value
+		case keySource:
+			header.source = value
+		case keyTimestamp:
+			header.timestamp = value
+		case keyLog:
+			header.log = value
+		default:
+			metadata[key] = value
+		}
+	}
+	if header.eventName == "" {
+		return header, nil, fmt.Errorf("%s is empty", keyEventName)
+	}
+	return header, metadata, nil
+}
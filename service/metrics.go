@@ -0,0 +1,86 @@
+package service
+
+import (
+	"polvo/exporter"
+	"polvo/metrics"
+	"polvo/service/filter"
+)
+
+// name of the global filter in metrics
+const globalFilterName = "global"
+
+// metrics of workers. counters are kept by workers, so they are updated without lookup per log.
+var (
+	sensorEventsIn = metrics.Default.NewCounterVec("polvo_sensor_events_in_total",
+		"Logs of sensor received by its filter worker.", "sensor")
+	sensorEventsOut = metrics.Default.NewCounterVec("polvo_sensor_events_out_total",
+		"Logs of sensor sent to pipelines after schema policies, the global filter & filters of sensor.", "sensor")
+	sensorEventsDropped = metrics.Default.NewCounterVec("polvo_sensor_events_dropped_total",
+		"Logs of sensor dropped by schema policies, the global filter & filters of sensor.", "sensor")
+	pipelineEventsOut = metrics.Default.NewCounterVec("polvo_pipeline_events_out_total",
+		"Logs sent to the exporter of pipeline.", "pipeline")
+	pipelineEventsDropped = metrics.Default.NewCounterVec("polvo_pipeline_events_dropped_total",
+		"Logs dropped by filters of pipeline or while the pipeline is paused.", "pipeline")
+	pipelineSendDuration = metrics.Default.NewHistogramVec("polvo_pipeline_send_duration_seconds",
+		"Time a pipeline blocks while handing a log to its exporter.", nil, "pipeline", "exporter")
+	marshalErrors = metrics.Default.NewCounterVec("polvo_marshal_errors_total",
+		"Logs which could not be marshaled by exporters.")
+)
+
+// # Collect
+//
+// Collect reports the state of running workers at scrape time. It implements metrics.Collector.
+//
+// - length & capacity of channels of filter workers, processors & exporters.
+//
+// - hits of deny selections of the global filter & the filters declared in compose.
+//
+// - records & bytes of disk-backed queues of exporters.
+func (s *service) Collect(emit func(metrics.Sample)) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	// channels
+	emitChannel := func(stage string, name string, length int, capacity int) {
+		emit(metrics.Sample{Name: "polvo_channel_length", Help: "Logs waiting in the inbound channel of worker.",
+			Type: metrics.TypeGauge, Labels: []string{"stage", stage, "name", name}, Value: float64(length)})
+		emit(metrics.Sample{Name: "polvo_channel_capacity", Help: "Capacity of the inbound channel of worker. 0 means unbuffered.",
+			Type: metrics.TypeGauge, Labels: []string{"stage", stage, "name", name}, Value: float64(capacity)})
+	}
+	for sensorName, filterWorker := range s.filterWorkerMap {
+		emitChannel("filter", sensorName, len(filterWorker.inboundChannel), cap(filterWorker.inboundChannel))
+	}
+	for pipelineName, processorWorker := range s.processorWorkerMap {
+		emitChannel("processor", pipelineName, len(processorWorker.inboundChannel), cap(processorWorker.inboundChannel))
+	}
+	for exporterName, exp := range s.exporterMap {
+		emitChannel("exporter", exporterName, len(exp.LogChannel()), cap(exp.LogChannel()))
+	}
+
+	// deny selections
+	emitHits := func(filterName string, filterOp filter.FilterOperator) {
+		for _, hits := range filter.DenyHits(filterOp) {
+			emit(metrics.Sample{Name: "polvo_filter_deny_hits_total", Help: "Logs denied by deny selection of filter. Counters restart when filters are reloaded.",
+				Type: metrics.TypeCounter, Labels: []string{"filter", filterName, "selection", hits.Name}, Value: float64(hits.Hits)})
+		}
+	}
+	if s.filterOp != nil {
+		emitHits(globalFilterName, s.filterOp)
+	}
+	for filterName, filterOp := range s.filterOpMap {
+		emitHits(filterName, filterOp)
+	}
+
+	// queues
+	for exporterName, exp := range s.exporterMap {
+		queued, ok := exp.(interface{ QueueStats() exporter.QueueStats })
+		if !ok {
+			continue
+		}
+		stats := queued.QueueStats()
+		emit(metrics.Sample{Name: "polvo_exporter_queue_records", Help: "Logs waiting in the disk-backed queue of exporter.",
+			Type: metrics.TypeGauge, Labels: []string{"exporter", exporterName}, Value: float64(stats.Queued)})
+		emit(metrics.Sample{Name: "polvo_exporter_queue_bytes", Help: "Size of segment files of the disk-backed queue of exporter.",
+			Type: metrics.TypeGauge, Labels: []string{"exporter", exporterName}, Value: float64(stats.Bytes)})
+	}
+}
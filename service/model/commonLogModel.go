@@ -7,6 +7,8 @@ type CommonLogWrapper struct {
 	Timestmp  string      `json:"timestamp"`
 	Log       string      `json:"log"`
 	MetaData  interface{} `json:"metadata"`
+	// SchemaViolations are set by "tag" policies of sensor schema. e.g. missing:PID, extra:Comm, undeclared_event
+	SchemaViolations []string `json:"schema_violations,omitempty"`
 	// Reference count is used to track the number of references to the log sync pool
 	RefCount int32  `json:"-"`
 	Tag      string `json:"-"`
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	perror "polvo/error"
+	"polvo/service/model"
+	"sync/atomic"
+)
+
+// maximum depth of nested arrays & maps in a MessagePack record
+const msgpackMaxDepth = 64
+
+// msgpackUnMarshalFunc converts a MessagePack map with the keys of CommonLogWrapper to CommonLogWrapper.
+// Maps in metadata are decoded as map[string]interface{} like JSON, so filters & schema work in the same way.
+func (s *service) msgpackUnMarshalFunc(record string) (*model.CommonLogWrapper, error) {
+	decoder := msgpackDecoder{data: []byte(record)}
+	value, err := decoder.decode(0)
+	if err == nil && decoder.offset != len(decoder.data) {
+		err = fmt.Errorf("%d bytes remain after record", len(decoder.data)-decoder.offset)
+	}
+	fields, ok := value.(map[string]interface{})
+	if err == nil && !ok {
+		err = fmt.Errorf("record is not a map")
+	}
+	if err != nil {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineUnmarshal,
+			Origin: err,
+			Msg:    "error while unmarshal msgpack log",
+		}
+	}
+	// get from sync pool. every field is overwritten because the wrapper is reused.
+	common := s.logWrapperPool.Get().(*model.CommonLogWrapper)
+	atomic.StoreInt32(&common.RefCount, 1)
+	common.EventName, _ = fields[keyEventName].(string)
+	common.Source, _ = fields[keySource].(string)
+	common.Timestmp, _ = fields[keyTimestamp].(string)
+	common.Log, _ = fields[keyLog].(string)
+	common.MetaData = fields["metadata"]
+	common.SchemaViolations = nil
+	return common, nil
+}
+
+// msgpackDecoder decodes MessagePack values into nil, bool, int64, uint64, float64, string, []byte,
+// []interface{} & map[string]interface{}. Extension types are not supported.
+type msgpackDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("record is nested deeper than %d", msgpackMaxDepth)
+	}
+	code, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	switch c := code[0]; {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return d.decodeMap(int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return d.decodeArray(int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		return d.decodeString(int(c & 0x1f))
+	}
+	switch code[0] {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLength(1 << (code[0] - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), raw...), nil
+	case 0xca:
+		raw, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), nil
+	case 0xcb:
+		raw, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		value, err := d.readUint(1 << (code[0] - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if value > math.MaxInt64 {
+			return value, nil
+		}
+		return int64(value), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code[0] - 0xd0)
+		value, err := d.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		// sign extension
+		shift := 64 - 8*size
+		return int64(value<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLength(1 << (code[0] - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLength(2 << (code[0] - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.readLength(2 << (code[0] - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	}
+	return nil, fmt.Errorf("unsupported type 0x%02x at %d", code[0], d.offset-1)
+}
+
+func (d *msgpackDecoder) decodeString(n int) (interface{}, error) {
+	raw, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+func (d *msgpackDecoder) decodeArray(n int, depth int) (interface{}, error) {
+	// each element takes 1 byte at least
+	if n > len(d.data)-d.offset {
+		return nil, fmt.Errorf("array of %d elements is truncated", n)
+	}
+	array := make([]interface{}, n)
+	for i := range array {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array[i] = value
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int, depth int) (interface{}, error) {
+	// each key & value take 1 byte at least
+	if n > (len(d.data)-d.offset)/2 {
+		return nil, fmt.Errorf("map of %d entries is truncated", n)
+	}
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		// keys are strings in JSON
+		switch key := key.(type) {
+		case string:
+			object[key] = value
+		case []byte:
+			object[string(key)] = value
+		default:
+			object[fmt.Sprint(key)] = value
+		}
+	}
+	return object, nil
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.offset {
+		return nil, fmt.Errorf("record is truncated at %d", d.offset)
+	}
+	raw := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return raw, nil
+}
+
+// readUint reads big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	raw, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for _, b := range raw {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+func (d *msgpackDecoder) readLength(size int) (int, error) {
+	value, err := d.readUint(size)
+	if err != nil {
+		return 0, err
+	}
+	if value > uint64(len(d.data)) {
+		return 0, fmt.Errorf("length %d is larger than record", value)
+	}
+	return int(value), nil
+}
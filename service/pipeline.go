@@ -9,12 +9,15 @@ import (
 	perror "polvo/error"
 	"polvo/exporter"
 	plogger "polvo/logger"
+	"polvo/metrics"
 	"polvo/sensorPipe"
 	"polvo/service/filter"
 	"polvo/service/model"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -27,6 +30,17 @@ type Service interface {
 	Start()
 	Stop() error
 	Wait() error
+	Reload(info *compose.Compose, filterOp filter.FilterOperator) error
+	// Status & control of components at runtime. see control.go
+	Status() Status
+	PauseSensor(name string) error
+	ResumeSensor(name string) error
+	RestartSensor(name string) error
+	PausePipeline(name string) error
+	ResumePipeline(name string) error
+	RestartPipeline(name string) error
+	// Collect reports the state of workers to metrics.
+	metrics.Collector
 }
 
 type service struct {
@@ -34,26 +48,40 @@ type service struct {
 	logger   plogger.PolvoLogger
 	info     *compose.Compose
 	filterOp filter.FilterOperator
+	// filter operators declared in compose
+	filterOpMap map[string]filter.FilterOperator
+	// filters used by workers. they are swapped on reload.
+	sensorFilterMap   map[string]*swappableFilter
+	pipelineFilterMap map[string]*swappableFilter
 	// maps for workers
 	filterWorkerMap    map[string]*filterWorker
 	processorWorkerMap map[string]*processorWorker
 	sensorPipeMap      map[string]sensorPipe.Pipe[model.CommonLogWrapper]
 	exporterMap        map[string]exporter.Exporter[model.CommonLogWrapper]
+	// components paused by control. they stay paused when they are recreated.
+	pausedSensors   map[string]bool
+	pausedPipelines map[string]bool
 	// sync pool
 	logWrapperPool sync.Pool
 	// wait group
 	sensorGroup *errgroup.Group
 	ctx         context.Context
 	wg          sync.WaitGroup
+	// reloadLock serializes Start, Reload & Stop
+	reloadLock sync.Mutex
+	isStopped  int32
 }
 
 // NewService creates a new service with the given compose file and logger.
 // Initialize sequence is as follows:
 // 1. Create service struct & maps to control workers.
 // 2. Create exporter.
-// 3. Create processor workers per pipeline.
-// 4. Create filter workers & sensorPipe per sensor pipeline.
+// 3. Create filter operators declared in compose.
+// 4. Create processor workers per pipeline.
+// 5. Create filter workers & sensorPipe per sensor pipeline.
 // Stop function operates in the opposite order.
+//
+// filterOp is applied to logs of all sensors. nil means no global filter.
 func NewService(info *compose.Compose, loger plogger.PolvoLogger, filterOp filter.FilterOperator) (Service, error) {
 	// create service
 	svc := new(service)
@@ -81,6 +109,10 @@ func NewService(info *compose.Compose, loger plogger.PolvoLogger, filterOp filte
 	svc.processorWorkerMap = make(map[string]*processorWorker)
 	svc.sensorPipeMap = make(map[string]sensorPipe.Pipe[model.CommonLogWrapper])
 	svc.exporterMap = make(map[string]exporter.Exporter[model.CommonLogWrapper])
+	svc.sensorFilterMap = make(map[string]*swappableFilter)
+	svc.pipelineFilterMap = make(map[string]*swappableFilter)
+	svc.pausedSensors = make(map[string]bool)
+	svc.pausedPipelines = make(map[string]bool)
 
 	// create exporters
 	err := svc.createExporters(*info, loger)
@@ -92,32 +124,28 @@ func NewService(info *compose.Compose, loger plogger.PolvoLogger, filterOp filte
 		}
 	}
 
-	// create processor workers per pipeline
-	err = svc.createProcessors(*info, loger)
+	// create filter operators
+	svc.filterOpMap, err = newFilterOperators(*info, loger)
 	if err != nil {
 		return nil, perror.PolvoPipelineError{
-			Code:   perror.ErrProcessorCreate,
+			Code:   perror.ErrFilterWorkerCreate,
 			Origin: err,
 			Msg:    "error while construct new pipeline",
 		}
 	}
 
-	// make pipemap to connect workers
-	// add sensor & pipeline info to pipeMap
-	// The relationship between the sensor and the pipeline is as follows:
-	// multiple sensors -> single filterWorker per sensors -> processorWorker per pipelines -> exporter
-	pipeMap := make(map[string][]chan<- *model.CommonLogWrapper)
-	for _, sensorInfo := range info.Sensors {
-		pipeMap[sensorInfo.Name] = make([]chan<- *model.CommonLogWrapper, 0)
-	}
-	for pipelineName, pipelineInfo := range info.Service.Pipeline {
-		for _, sensorInfo := range pipelineInfo.Sensors {
-			pipeMap[sensorInfo.Name] = append(pipeMap[sensorInfo.Name], svc.processorWorkerMap[pipelineName].LogChannel())
+	// create processor workers per pipeline
+	err = svc.createProcessors(*info, loger)
+	if err != nil {
+		return nil, perror.PolvoPipelineError{
+			Code:   perror.ErrProcessorCreate,
+			Origin: err,
+			Msg:    "error while construct new pipeline",
 		}
 	}
 
 	// create filter workers & sensorPipe per sensor pipeline
-	err = svc.createFilterAndSensors(*info, loger, pipeMap)
+	err = svc.createFilterAndSensors(*info, loger)
 	if err != nil {
 		return nil, perror.PolvoPipelineError{
 			Code:   perror.ErrSensorCreate,
@@ -129,90 +157,213 @@ func NewService(info *compose.Compose, loger plogger.PolvoLogger, filterOp filte
 }
 
 func (svc *service) createExporters(info compose.Compose, loger plogger.PolvoLogger) error {
-	// create exporters
-	// exporterName is predefined in
-	for exporterName, exporterInfo := range info.Exporters {
-		// create exporter
-		switch exporterName {
-		case "kafka":
-			// TODO: create kafka exporter
-			fallthrough
-		case "otel":
-			// TODO: create otel exporter
-			fallthrough
-		case "file":
-			// create file exporter
-			exporter, err := exporter.NewFileExporter(
-				exporterInfo.Name,
-				0,
-				svc.jsonMarshalFunc,
-				loger,
-				exporterInfo,
-			)
-			if err != nil {
-				return perror.PolvoPipelineError{
-					Code:   perror.ErrExporterCreate,
-					Origin: err,
-					Msg:    "error while construct new pipeline",
-				}
-			}
-			// add to exporter map
-			svc.exporterMap[exporterInfo.Name] = exporter
-		default:
-			return perror.PolvoPipelineError{
-				Code:   perror.ErrInvalidExporterName,
-				Origin: fmt.Errorf("invalid exporter name %s", exporterInfo.Name),
-				Msg:    "error while construct new exporters",
+	// create exporters with the constructor registered for each exporter mode
+	for _, exporterInfo := range info.Exporters {
+		if err := svc.createExporter(info, exporterInfo, loger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (svc *service) createExporter(info compose.Compose, exporterInfo *compose.ExporterInfo, loger plogger.PolvoLogger) error {
+	newExporter, err := exporter.New(exporterInfo, info.Service, svc.jsonMarshalFunc, svc.returnLogObjectToPool, loger)
+	if err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrExporterCreate,
+			Origin: err,
+			Msg:    "error while construct new pipeline",
+		}
+	}
+	// add to exporter map
+	svc.exporterMap[exporterInfo.Name] = newExporter
+	// print info
+	loger.PrintInfo("exporter [%s] created", exporterInfo.Name)
+	return nil
+}
+
+// newFilterOperators constructs the filter operators declared in compose.
+func newFilterOperators(info compose.Compose, loger plogger.PolvoLogger) (map[string]filter.FilterOperator, error) {
+	filterOpMap := make(map[string]filter.FilterOperator)
+	for filterName, filterInfo := range info.Filters {
+		filterOp, err := filter.NewFilterOperator(filterInfo.Data)
+		if err != nil {
+			return nil, perror.PolvoPipelineError{
+				Code:   perror.ErrFilterWorkerCreate,
+				Origin: fmt.Errorf("filter %s: %w", filterName, err),
+				Msg:    "error while construct new filter operators",
 			}
 		}
+		filterOpMap[filterName] = filterOp
 		// print info
-		loger.PrintInfo("exporter [%s] created", exporterName)
+		loger.PrintInfo("filter operator [%s] created", filterName)
 	}
-	return nil
+	return filterOpMap, nil
+}
+
+// filterChain returns the filter operators of the filters.
+func (svc *service) filterChain(filters []*compose.FilterInfo) filter.FilterChain {
+	chain := make(filter.FilterChain, 0, len(filters))
+	for _, filterInfo := range filters {
+		chain = append(chain, svc.filterOpMap[filterInfo.Name])
+	}
+	return chain
+}
+
+// sensorFilterChain returns the global filter & the filters of sensor.
+func (svc *service) sensorFilterChain(sensorInfo *compose.SensorInfo) filter.FilterChain {
+	// global filter is applied before filters of sensor
+	chain := svc.filterChain(sensorInfo.Filters)
+	if svc.filterOp != nil {
+		chain = append(filter.FilterChain{svc.filterOp}, chain...)
+	}
+	return chain
+}
+
+// outboundChannels returns the channels of the processors of pipelines which the sensor belongs to.
+// The relationship between the sensor and the pipeline is as follows:
+// multiple sensors -> single filterWorker per sensors -> processorWorker per pipelines -> exporter
+func (svc *service) outboundChannels(info compose.Compose, sensorName string) []chan<- *model.CommonLogWrapper {
+	outbound := make([]chan<- *model.CommonLogWrapper, 0)
+	for pipelineName, pipelineInfo := range info.Service.Pipeline {
+		for _, sensorInfo := range pipelineInfo.Sensors {
+			if sensorInfo.Name == sensorName {
+				outbound = append(outbound, svc.processorWorkerMap[pipelineName].LogChannel())
+			}
+		}
+	}
+	return outbound
 }
 
 func (svc *service) createProcessors(info compose.Compose, loger plogger.PolvoLogger) error {
 	// create processor workers per pipeline
 	for pipelineName, pipelineInfo := range info.Service.Pipeline {
-		// create worker per pipeline
-		// get exporter channel from exporter map
-		exporter, ok := svc.exporterMap[pipelineInfo.Exporter.Name]
-		if !ok {
-			return perror.PolvoPipelineError{
-				Code:   perror.ErrInvalidPipelineCompose,
-				Origin: fmt.Errorf("exporter %s not found", pipelineInfo.Exporter.Name),
-				Msg:    "error while construct new processors",
-			}
+		if err := svc.createProcessor(pipelineName, pipelineInfo, loger); err != nil {
+			return err
 		}
-		processorWorker := newProcessorWorker(pipelineName, &pipelineInfo, exporter.LogChannel())
-		svc.processorWorkerMap[pipelineName] = processorWorker
-		// print info
-		loger.PrintInfo("processor [%s] created", pipelineName)
 	}
 	return nil
 }
 
-func (svc *service) createFilterAndSensors(info compose.Compose, loger plogger.PolvoLogger, pipeMap map[string][]chan<- *model.CommonLogWrapper) error {
+func (svc *service) createProcessor(pipelineName string, pipelineInfo compose.PipelineInfo, loger plogger.PolvoLogger) error {
+	// create worker per pipeline
+	// get exporter channel from exporter map
+	exporter, ok := svc.exporterMap[pipelineInfo.Exporter.Name]
+	if !ok {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrInvalidPipelineCompose,
+			Origin: fmt.Errorf("exporter %s not found", pipelineInfo.Exporter.Name),
+			Msg:    "error while construct new processors",
+		}
+	}
+	pipelineFilter := newSwappableFilter(svc.filterChain(pipelineInfo.Filters))
+	svc.pipelineFilterMap[pipelineName] = pipelineFilter
+	processorWorker := newProcessorWorker(pipelineName, &pipelineInfo, pipelineFilter, svc.returnLogObjectToPool, exporter.LogChannel())
+	processorWorker.setPaused(svc.pausedPipelines[pipelineName])
+	svc.processorWorkerMap[pipelineName] = processorWorker
+	// print info
+	loger.PrintInfo("processor [%s] created", pipelineName)
+	return nil
+}
+
+func (svc *service) createFilterAndSensors(info compose.Compose, loger plogger.PolvoLogger) error {
 	// create filter workers & sensorPipe per sensor pipeline
 	for _, sensorInfo := range info.Sensors {
-		// create filter workers
-		filterWorker := newFilterWorker(svc.filterOp, svc.returnLogObjectToPool, sensorInfo, pipeMap[sensorInfo.Name]...)
-		svc.filterWorkerMap[sensorInfo.Name] = filterWorker
-		// create worker per sensor
-		sensorPipe, err := sensorPipe.NewPipe(sensorInfo.Name, loger, filterWorker.LogChannel(), svc.jsonUnMarshalFunc)
-		if err != nil {
+		if err := svc.createFilterAndSensor(info, sensorInfo, loger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (svc *service) createFilterAndSensor(info compose.Compose, sensorInfo *compose.SensorInfo, loger plogger.PolvoLogger) error {
+	// privileges of agent are checked when the sensor is created, not while compose file is parsed
+	if err := sensorInfo.CheckPrivileges(); err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorCreate,
+			Origin: err,
+			Msg:    "error while construct new sensorPipe",
+		}
+	}
+	// create filter workers
+	sensorFilter := newSwappableFilter(svc.sensorFilterChain(sensorInfo))
+	svc.sensorFilterMap[sensorInfo.Name] = sensorFilter
+	filterWorker := newFilterWorker(sensorFilter, svc.returnLogObjectToPool, sensorInfo, svc.outboundChannels(info, sensorInfo.Name)...)
+	svc.filterWorkerMap[sensorInfo.Name] = filterWorker
+	// create worker per sensor
+	pipe, err := svc.newSensorPipe(sensorInfo, filterWorker.LogChannel(), loger)
+	if err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorCreate,
+			Origin: err,
+			Msg:    "error while construct new sensorPipe",
+		}
+	}
+	// set restart policy of sensor
+	err = pipe.SetRestartPolicy(sensorPipe.RestartPolicy{
+		Policy:      sensorInfo.Restart.Policy,
+		MaxRestarts: sensorInfo.Restart.MaxRestarts,
+		Window:      time.Duration(sensorInfo.Restart.Window) * time.Second,
+		Backoff:     time.Duration(sensorInfo.Restart.Backoff) * time.Millisecond,
+		MaxBackoff:  time.Duration(sensorInfo.Restart.MaxBackoff) * time.Millisecond,
+	})
+	if err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorCreate,
+			Origin: err,
+			Msg:    "error while construct new sensorPipe",
+		}
+	}
+	// forward stderr of sensor to agent log
+	if err = pipe.SetStderrRateLimit(sensorInfo.StderrRateLimit); err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorCreate,
+			Origin: err,
+			Msg:    "error while construct new sensorPipe",
+		}
+	}
+	// split stdout of sensor into records
+	err = pipe.SetFraming(sensorPipe.Framing{Mode: sensorInfo.Framing.Mode, MaxRecordSize: sensorInfo.Framing.MaxRecordSize})
+	if err != nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrSensorCreate,
+			Origin: err,
+			Msg:    "error while construct new sensorPipe",
+		}
+	}
+	// drop privileges of sensor with run_as_root: false
+	if credential := sensorInfo.Credential; credential != nil {
+		attr := &sensorPipe.ProcAttr{AmbientCaps: credential.Capabilities}
+		if credential.SwitchUser {
+			attr.Credential = &syscall.Credential{
+				Uid:    credential.Uid,
+				Gid:    credential.Gid,
+				Groups: credential.Groups,
+			}
+		}
+		if err = pipe.SetProcAttr(attr); err != nil {
+			return perror.PolvoPipelineError{
+				Code:   perror.ErrSensorCreate,
+				Origin: err,
+				Msg:    "error while construct new sensorPipe",
+			}
+		}
+	}
+	// keep the sensor paused by control
+	if svc.pausedSensors[sensorInfo.Name] {
+		if err = pipe.Pause(); err != nil {
 			return perror.PolvoPipelineError{
 				Code:   perror.ErrSensorCreate,
 				Origin: err,
 				Msg:    "error while construct new sensorPipe",
 			}
 		}
-		svc.sensorPipeMap[sensorInfo.Name] = sensorPipe
-
-		// print info
-		loger.PrintInfo("filter [%s] created", sensorInfo.Name)
-		loger.PrintInfo("sensor [%s] created", sensorInfo.Name)
 	}
+	svc.sensorPipeMap[sensorInfo.Name] = pipe
+
+	// print info
+	loger.PrintInfo("filter [%s] created", sensorInfo.Name)
+	loger.PrintInfo("sensor [%s] created", sensorInfo.Name)
 	return nil
 }
 
@@ -221,10 +372,12 @@ func (svc *service) createFilterAndSensors(info compose.Compose, loger plogger.P
 ************************************************************************************************************/
 
 func (s *service) Start() {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
 	// start exporters
 	for _, exporter := range s.exporterMap {
-		exporter.Start()
-		s.wg.Add(1)
+		s.startExporter(exporter)
 	}
 	// start processors
 	for _, processorWorker := range s.processorWorkerMap {
@@ -232,23 +385,20 @@ func (s *service) Start() {
 	}
 	// start sensors & filter workers
 	for _, sensorInfo := range s.info.Sensors {
-		// start sensor worker
-		s.filterWorkerMap[sensorInfo.Name].Start()
-		// run sensor
-		s.sensorPipeMap[sensorInfo.Name].Start(sensorInfo.ExecPath, strings.Split(sensorInfo.Param, " ")...)
+		if err := s.startSensor(sensorInfo); err != nil {
+			s.logger.PrintError("error while start sensor [%s] %v", sensorInfo.Name, err)
+			// report error of sensor to Wait
+			s.sensorGroup.Go(func() error {
+				return err
+			})
+		}
 	}
 }
 
 func (s *service) Wait() error {
 	var err error
 
-	// register sensorWait to sensorGroup
-	for _, sensorInfo := range s.info.Sensors {
-		s.sensorGroup.Go(func() error {
-			return s.sensorPipeMap[sensorInfo.Name].Wait()
-		})
-	}
-
+	// sensors are registered to sensorGroup when they are started
 	err = s.sensorGroup.Wait()
 	if err != nil {
 		s.logger.PrintError("error while wait sensor", err)
@@ -265,90 +415,23 @@ func (s *service) Wait() error {
 }
 
 func (s *service) Stop() error {
-	var (
-		err       error
-		joinedErr error
-	)
+	var joinedErr error
 
-	joinedErr = nil
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	atomic.StoreInt32(&s.isStopped, 1)
 
 	// stop sensors & filter workers
 	for _, sensorInfo := range s.info.Sensors {
-		// stop sensor
-		err = s.sensorPipeMap[sensorInfo.Name].Stop()
-		if err != nil {
-			if joinedErr != nil {
-				joinedErr = errors.Join(joinedErr, perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				})
-			} else {
-				joinedErr = perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				}
-			}
-		}
-	}
-	// stop filter workers
-	for _, filterWorker := range s.filterWorkerMap {
-		err = filterWorker.Kill()
-		if err != nil {
-			if joinedErr != nil {
-				joinedErr = errors.Join(joinedErr, perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				})
-			} else {
-				joinedErr = perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				}
-			}
-		}
+		joinedErr = joinError(joinedErr, s.stopSensor(sensorInfo.Name))
 	}
 	// stop processors
-	for _, processorWorker := range s.processorWorkerMap {
-		err = processorWorker.Kill()
-		if err != nil {
-			if joinedErr != nil {
-				joinedErr = errors.Join(joinedErr, perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				})
-			} else {
-				joinedErr = perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				}
-			}
-		}
+	for pipelineName := range s.processorWorkerMap {
+		joinedErr = joinError(joinedErr, s.stopProcessor(pipelineName))
 	}
 	// stop exporters
-	for _, exporter := range s.exporterMap {
-		err = exporter.Stop()
-		if err != nil {
-			if joinedErr != nil {
-				joinedErr = errors.Join(joinedErr, perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				})
-			} else {
-				joinedErr = perror.PolvoPipelineError{
-					Code:   perror.ErrPipelineKill,
-					Origin: err,
-					Msg:    "error while kill pipeline",
-				}
-			}
-		}
-		s.wg.Done()
+	for exporterName := range s.exporterMap {
+		joinedErr = joinError(joinedErr, s.stopExporter(exporterName))
 	}
 	return joinedErr
 }
@@ -357,9 +440,115 @@ func (s *service) Stop() error {
 * Service private methods
 ************************************************************************************************************/
 
+func (s *service) startExporter(exporter exporter.Exporter[model.CommonLogWrapper]) {
+	exporter.Start()
+	s.wg.Add(1)
+}
+
+// newSensorPipe constructs the sensorPipe by the type of sensor.
+// Lines of tail sensor are decoded by the format of sensor, and their source is the path of file.
+func (svc *service) newSensorPipe(sensorInfo *compose.SensorInfo, logChannel chan<- *model.CommonLogWrapper, loger plogger.PolvoLogger) (sensorPipe.Pipe[model.CommonLogWrapper], error) {
+	if sensorInfo.Type != compose.SensorTypeTail {
+		return sensorPipe.NewPipe(sensorInfo.Name, loger, logChannel, svc.unMarshalFunc(sensorInfo))
+	}
+	unMarshal := svc.unMarshalFunc(sensorInfo)
+	wrapFunc := func(path string, line string) (*model.CommonLogWrapper, error) {
+		lg, err := unMarshal(line)
+		if err != nil {
+			return nil, err
+		}
+		lg.Source = path
+		return lg, nil
+	}
+	return sensorPipe.NewTailPipe(sensorInfo.Name, loger, logChannel, wrapFunc, sensorPipe.TailConfig{
+		Paths:        sensorInfo.Tail.Paths,
+		StatePath:    sensorInfo.Tail.StatePath,
+		PollInterval: time.Duration(sensorInfo.Tail.PollInterval) * time.Millisecond,
+		StartAtEnd:   sensorInfo.Tail.StartAt == compose.TailStartAtEnd,
+	})
+}
+
+// startSensor starts the filter worker & the sensor, and registers the sensor to sensorGroup.
+func (s *service) startSensor(sensorInfo *compose.SensorInfo) error {
+	// start sensor worker
+	s.filterWorkerMap[sensorInfo.Name].Start()
+	// run sensor
+	pipe := s.sensorPipeMap[sensorInfo.Name]
+	err := pipe.Start(sensorInfo.ExecPath, strings.Split(sensorInfo.Param, " ")...)
+	if err != nil {
+		return err
+	}
+	s.sensorGroup.Go(pipe.Wait)
+	return nil
+}
+
+// stopSensor stops the sensor & its filter worker, and removes them from the maps.
+func (s *service) stopSensor(sensorName string) error {
+	var joinedErr error
+
+	if pipe, ok := s.sensorPipeMap[sensorName]; ok {
+		joinedErr = joinError(joinedErr, killError(pipe.Stop()))
+		delete(s.sensorPipeMap, sensorName)
+	}
+	if filterWorker, ok := s.filterWorkerMap[sensorName]; ok {
+		joinedErr = joinError(joinedErr, killError(filterWorker.Kill()))
+		delete(s.filterWorkerMap, sensorName)
+	}
+	delete(s.sensorFilterMap, sensorName)
+	return joinedErr
+}
+
+// stopProcessor stops the processor worker of pipeline, and removes it from the maps.
+func (s *service) stopProcessor(pipelineName string) error {
+	var err error
+
+	if processorWorker, ok := s.processorWorkerMap[pipelineName]; ok {
+		err = killError(processorWorker.Kill())
+		delete(s.processorWorkerMap, pipelineName)
+	}
+	delete(s.pipelineFilterMap, pipelineName)
+	return err
+}
+
+// stopExporter stops the exporter, and removes it from the map.
+func (s *service) stopExporter(exporterName string) error {
+	var err error
+
+	if exporter, ok := s.exporterMap[exporterName]; ok {
+		err = killError(exporter.Stop())
+		delete(s.exporterMap, exporterName)
+		s.wg.Done()
+	}
+	return err
+}
+
+func killError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return perror.PolvoPipelineError{
+		Code:   perror.ErrPipelineKill,
+		Origin: err,
+		Msg:    "error while kill pipeline",
+	}
+}
+
+// joinError joins err to joinedErr. A single error is returned as it is.
+func joinError(joinedErr error, err error) error {
+	if err == nil {
+		return joinedErr
+	}
+	if joinedErr == nil {
+		return err
+	}
+	return errors.Join(joinedErr, err)
+}
+
+// returnLogObjectToPool releases a reference of logWrapper.
+// The wrapper is returned to the pool by the last holder, which drops it or serializes it in the exporter.
 func (s *service) returnLogObjectToPool(logWrapper *model.CommonLogWrapper) {
 	// if ref count is 0, it means this wrapper is unused. return to pool
-	if atomic.LoadInt32(&logWrapper.RefCount) == 0 {
+	if atomic.AddInt32(&logWrapper.RefCount, -1) <= 0 {
 		logWrapper.Tag = "USED"
 		s.logWrapperPool.Put(logWrapper)
 	}
@@ -369,8 +558,10 @@ func (s *service) jsonUnMarshalFunc(log string) (*model.CommonLogWrapper, error)
 	// Reason for control sync pool flow in pipeline is to prevent GC overhead in massive data processing
 	// get from sync pool
 	common := s.logWrapperPool.Get().(*model.CommonLogWrapper)
-	// set ref count to 0
-	atomic.StoreInt32(&common.RefCount, 0)
+	// the filter worker of the sensor holds the only reference
+	atomic.StoreInt32(&common.RefCount, 1)
+	// violations are not overwritten by logs without them
+	common.SchemaViolations = nil
 	// unmarshal json
 	err := json.Unmarshal([]byte(log), common)
 	if err != nil {
@@ -390,6 +581,7 @@ func (s *service) jsonMarshalFunc(logWrapper *model.CommonLogWrapper) (ret []byt
 
 	ret, err = json.Marshal(logWrapper)
 	if err != nil {
+		marshalErrors.With().Inc()
 		// if ref count is 0, it means this wrapper is unused. return to pool
 		s.returnLogObjectToPool(logWrapper)
 		return nil, perror.PolvoPipelineError{
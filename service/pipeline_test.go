@@ -1,13 +1,19 @@
 package service_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"polvo/compose"
+	perror "polvo/error"
 	plogger "polvo/logger"
+	"polvo/metrics"
 	"polvo/service"
 	"polvo/service/filter"
+	"strings"
 	"testing"
 	"time"
 )
@@ -89,3 +95,464 @@ func TestService(t *testing.T) {
 		return
 	}
 }
+
+func TestServiceWithFilters(t *testing.T) {
+	filterComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_filters.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	defer os.Remove(filepath.Join(logpath, "output_even.log"))
+	defer os.Remove(filepath.Join(logpath, "output_all.log"))
+
+	// no global filter
+	serv, err := service.NewService(filterComposer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	serv.Start()
+	time.Sleep(2 * time.Second)
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+
+	eventNames := func(fileName string) []string {
+		data, err := os.ReadFile(filepath.Join(logpath, fileName))
+		if err != nil {
+			t.Fatalf("error while read %s %v", fileName, err)
+		}
+		names := make([]string, 0)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var log struct {
+				EventName string `json:"eventname"`
+			}
+			if err := json.Unmarshal([]byte(line), &log); err != nil {
+				t.Fatalf("json.Unmarshal(%s) = %v, want nil", line, err)
+			}
+			names = append(names, log.EventName)
+		}
+		return names
+	}
+	var odd bool
+	for _, name := range eventNames("output_all.log") {
+		if strings.HasSuffix(name, "5") {
+			t.Errorf("%s of all_pipe should be dropped by sensor filter", name)
+		}
+		odd = odd || strings.IndexAny(name[len(name)-1:], "13579") >= 0
+	}
+	if !odd {
+		t.Errorf("odd events of all_pipe should not be dropped by filter of even_pipe")
+	}
+	for _, name := range eventNames("output_even.log") {
+		if strings.IndexAny(name[len(name)-1:], "13579") >= 0 {
+			t.Errorf("%s of even_pipe should be dropped by pipeline filter", name)
+		}
+	}
+}
+
+func TestServiceReload(t *testing.T) {
+	filterComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_filters.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	reloadComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_reload.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	invalidComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_reload_invalid.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	defer os.Remove(filepath.Join(logpath, "output_even.log"))
+	defer os.Remove(filepath.Join(logpath, "output_all.log"))
+
+	serv, err := service.NewService(filterComposer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	serv.Start()
+	time.Sleep(1 * time.Second)
+	// invalid compose is rejected & the running service is kept
+	if err = serv.Reload(invalidComposer.GetCompose(), nil); err == nil {
+		t.Errorf("Reload(compose_reload_invalid.yml) = nil, want error")
+	}
+	time.Sleep(1 * time.Second)
+	// only filter of even_pipe is changed. sensor must not be restarted.
+	if err = serv.Reload(reloadComposer.GetCompose(), nil); err != nil {
+		t.Errorf("Reload(compose_reload.yml) = %v, want nil", err)
+	}
+	time.Sleep(1 * time.Second)
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+
+	indexes := func(fileName string) []int {
+		data, err := os.ReadFile(filepath.Join(logpath, fileName))
+		if err != nil {
+			t.Fatalf("error while read %s %v", fileName, err)
+		}
+		ret := make([]int, 0)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var log struct {
+				EventName string `json:"eventname"`
+			}
+			if err := json.Unmarshal([]byte(line), &log); err != nil {
+				t.Fatalf("json.Unmarshal(%s) = %v, want nil", line, err)
+			}
+			var index int
+			if _, err := fmt.Sscanf(log.EventName, "bashReadline=%d", &index); err != nil {
+				t.Fatalf("fmt.Sscanf(%s) = %v, want nil", log.EventName, err)
+			}
+			ret = append(ret, index)
+		}
+		return ret
+	}
+	all := indexes("output_all.log")
+	for i := 1; i < len(all); i++ {
+		if all[i] <= all[i-1] {
+			t.Errorf("index %d follows %d in all_pipe, sensor should not be restarted by reload", all[i], all[i-1])
+			break
+		}
+	}
+	var even, odd bool
+	for _, index := range indexes("output_even.log") {
+		even = even || index%2 == 0
+		odd = odd || index%2 == 1
+	}
+	if !even || !odd {
+		t.Errorf("even_pipe has even %v & odd %v logs, want both before & after reload", even, odd)
+	}
+}
+
+func TestServiceMetrics(t *testing.T) {
+	serv, err := service.NewService(composer.GetCompose(), loger, filterOp)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	registry := metrics.Default
+	registry.Register(serv)
+	defer registry.Unregister(serv)
+	serv.Start()
+	time.Sleep(1 * time.Second)
+
+	var out bytes.Buffer
+	if err = registry.WriteText(&out); err != nil {
+		t.Errorf("WriteText() = %v, want nil", err)
+	}
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+	// all logs of dummy sensor are denied by global filter
+	for _, want := range []string{
+		`polvo_sensor_events_in_total{sensor="dummy_sensor"} `,
+		`polvo_sensor_events_dropped_total{sensor="dummy_sensor"} `,
+		`polvo_filter_deny_hits_total{filter="global",selection="filter_test"} `,
+		`polvo_channel_length{stage="processor",name="sample_pipe"} 0`,
+		`polvo_sensor_lines_total{sensor="dummy_sensor"} `,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics do not contain %s\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `polvo_filter_deny_hits_total{filter="global",selection="filter_test"} 0`) {
+		t.Errorf("deny hits of filter_test = 0, want logs of dummy sensor")
+	}
+}
+
+func TestServiceControl(t *testing.T) {
+	serv, err := service.NewService(composer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	serv.Start()
+	time.Sleep(500 * time.Millisecond)
+
+	sensorStatus := func() service.SensorStatus {
+		status := serv.Status()
+		if len(status.Sensors) != 1 {
+			t.Fatalf("Status().Sensors = %v, want dummy_sensor", status.Sensors)
+		}
+		return status.Sensors[0]
+	}
+	status := serv.Status()
+	if len(status.Pipelines) != 2 || status.Pipelines[0].Name != "sample_2_pipe" || status.Pipelines[1].Exporter != "file" {
+		t.Errorf("Status().Pipelines = %v, want sample_2_pipe & sample_pipe", status.Pipelines)
+	}
+	if len(status.Exporters) != 2 || status.Exporters[0].Mode != "file" || status.Exporters[0].QueueRecords != nil {
+		t.Errorf("Status().Exporters = %v, want file & otel without queue", status.Exporters)
+	}
+	started := sensorStatus()
+	if !started.Running || started.Pid <= 0 || started.StartedAt == nil {
+		t.Errorf("Status() of dummy_sensor = %+v, want running", started)
+	}
+
+	// paused sensor stays paused after restart
+	if err = serv.PauseSensor("dummy_sensor"); err != nil {
+		t.Errorf("PauseSensor() = %v, want nil", err)
+	}
+	if err = serv.RestartSensor("dummy_sensor"); err != nil {
+		t.Errorf("RestartSensor() = %v, want nil", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	restarted := sensorStatus()
+	if !restarted.Paused || restarted.Pid == started.Pid || restarted.Pid <= 0 {
+		t.Errorf("Status() of restarted dummy_sensor = %+v, want paused with new pid", restarted)
+	}
+	if err = serv.ResumeSensor("dummy_sensor"); err != nil {
+		t.Errorf("ResumeSensor() = %v, want nil", err)
+	}
+	if sensorStatus().Paused {
+		t.Errorf("dummy_sensor is paused after ResumeSensor()")
+	}
+
+	// paused pipeline stays paused after restart
+	if err = serv.PausePipeline("sample_pipe"); err != nil {
+		t.Errorf("PausePipeline() = %v, want nil", err)
+	}
+	if err = serv.RestartPipeline("sample_pipe"); err != nil {
+		t.Errorf("RestartPipeline() = %v, want nil", err)
+	}
+	if status = serv.Status(); !status.Pipelines[1].Paused || status.Pipelines[0].Paused {
+		t.Errorf("Status().Pipelines = %v, want only sample_pipe paused", status.Pipelines)
+	}
+	if err = serv.ResumePipeline("sample_pipe"); err != nil {
+		t.Errorf("ResumePipeline() = %v, want nil", err)
+	}
+
+	// unknown components
+	var pipelineErr perror.PolvoPipelineError
+	if err = serv.PauseSensor("unknown"); !errors.As(err, &pipelineErr) || pipelineErr.Code != perror.ErrInvalidSensorName {
+		t.Errorf("PauseSensor(unknown) = %v, want ErrInvalidSensorName", err)
+	}
+	if err = serv.RestartPipeline("unknown"); !errors.As(err, &pipelineErr) || pipelineErr.Code != perror.ErrInvalidPipelineName {
+		t.Errorf("RestartPipeline(unknown) = %v, want ErrInvalidPipelineName", err)
+	}
+
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+	if err = serv.RestartSensor("dummy_sensor"); !errors.As(err, &pipelineErr) || pipelineErr.Code != perror.ErrPipelineControl {
+		t.Errorf("RestartSensor() after Stop() = %v, want ErrPipelineControl", err)
+	}
+}
+
+func TestServicePausedPipelineKeepsSharedLogs(t *testing.T) {
+	os.Remove(filepath.Join(logpath, "output.log"))
+	serv, err := service.NewService(composer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	// sample_2_pipe drops logs of dummy_sensor which sample_pipe exports
+	if err = serv.PausePipeline("sample_2_pipe"); err != nil {
+		t.Fatalf("PausePipeline() = %v, want nil", err)
+	}
+	serv.Start()
+	time.Sleep(1 * time.Second)
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+
+	// every log is exported once & in order. a log reused before it is exported breaks the sequence.
+	data, err := os.ReadFile(filepath.Join(logpath, "output.log"))
+	if err != nil {
+		t.Fatalf("error while read output.log %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 10 {
+		t.Fatalf("sample_pipe exported %d logs, want 10 at least", len(lines))
+	}
+	first := -1
+	for idx, line := range lines {
+		var log struct {
+			EventName string `json:"eventname"`
+		}
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", line, err)
+		}
+		var index int
+		if _, err := fmt.Sscanf(log.EventName, "bashReadline=%d", &index); err != nil {
+			t.Fatalf("eventname %s is not exported by dummy_sensor", log.EventName)
+		}
+		if first < 0 {
+			first = index
+		}
+		if index != first+idx {
+			t.Fatalf("log %d of sample_pipe is %s, want bashReadline=%d", idx, log.EventName, first+idx)
+		}
+	}
+}
+
+func TestServiceSchema(t *testing.T) {
+	schemaComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_schema.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	defer os.Remove(filepath.Join(logpath, "output_schema.log"))
+
+	serv, err := service.NewService(schemaComposer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	serv.Start()
+	time.Sleep(1 * time.Second)
+	var out bytes.Buffer
+	metrics.Default.Register(serv)
+	metrics.Default.WriteText(&out)
+	metrics.Default.Unregister(serv)
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logpath, "output_schema.log"))
+	if err != nil {
+		t.Fatalf("error while read output_schema.log %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range lines {
+		var log struct {
+			EventName        string                 `json:"eventname"`
+			MetaData         map[string]interface{} `json:"metadata"`
+			SchemaViolations []string               `json:"schema_violations"`
+		}
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", line, err)
+		}
+		// undeclared vfsOpen is dropped, extra Comm is tagged & removed by projection
+		if log.EventName != "bashReadline" || len(log.MetaData) != 2 ||
+			strings.Join(log.SchemaViolations, ",") != "missing:Username,extra:Comm" {
+			t.Errorf("exported log = %s, want bashReadline with PID & UID tagged by missing:Username,extra:Comm", line)
+		}
+	}
+	for _, want := range []string{
+		`polvo_sensor_schema_violations_total{sensor="schema_sensor",violation="missing"} `,
+		`polvo_sensor_schema_violations_total{sensor="schema_sensor",violation="extra"} `,
+		`polvo_sensor_schema_violations_total{sensor="schema_sensor",violation="undeclared_event"} `,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
+
+func TestServiceFormat(t *testing.T) {
+	formatComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_format.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	defer os.Remove(filepath.Join(logpath, "output_format.log"))
+
+	serv, err := service.NewService(formatComposer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	serv.Start()
+	time.Sleep(1 * time.Second)
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logpath, "output_format.log"))
+	if err != nil {
+		t.Fatalf("error while read output_format.log %v", err)
+	}
+	want := map[string]string{
+		"csv_sensor":    "echo hello, world",
+		"tsv_sensor":    "echo hello, world",
+		"logfmt_sensor": `echo "hello", world`,
+		"kv_sensor":     "ls",
+	}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var log struct {
+			EventName string            `json:"eventname"`
+			Source    string            `json:"source"`
+			Timestamp string            `json:"timestamp"`
+			MetaData  map[string]string `json:"metadata"`
+		}
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", line, err)
+		}
+		seen[log.Source] = true
+		if log.EventName != "bashReadLine" || log.Timestamp == "" || log.MetaData["PID"] != "191998" ||
+			log.MetaData["UID"] != "1000" || log.MetaData["CommandLine"] != want[log.Source] {
+			t.Errorf("exported log = %s, want bashReadLine of %s with CommandLine %s", line, log.Source, want[log.Source])
+		}
+	}
+	for sensorName := range want {
+		if !seen[sensorName] {
+			t.Errorf("logs of %s are not exported", sensorName)
+		}
+	}
+}
+
+func TestServiceTail(t *testing.T) {
+	tailComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_tail.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	defer os.Remove(filepath.Join(logpath, "output_tail.log"))
+
+	serv, err := service.NewService(tailComposer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	serv.Start()
+	time.Sleep(1 * time.Second)
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logpath, "output_tail.log"))
+	if err != nil {
+		t.Fatalf("error while read output_tail.log %v", err)
+	}
+	want := []string{
+		"Oct 16 10:00:00 host sshd[100]: Accepted publickey for root",
+		"Oct 16 10:00:01 host sshd[101]: Failed password for invalid user admin",
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("exported logs = %s, want %d lines", data, len(want))
+	}
+	for i, line := range lines {
+		var log struct {
+			EventName string `json:"eventname"`
+			Source    string `json:"source"`
+			Log       string `json:"log"`
+		}
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", line, err)
+		}
+		if log.EventName != "tail_sensor" || log.Source != "testdata/tail_auth.log" || log.Log != want[i] {
+			t.Errorf("exported log = %s, want line %q of testdata/tail_auth.log", line, want[i])
+		}
+	}
+}
+
+func TestServiceMsgpack(t *testing.T) {
+	msgpackComposer, err := compose.NewComposeFile(filepath.Join(pwd, "testdata", "compose_msgpack.yml"))
+	if err != nil {
+		t.Fatalf("error while create composer %v", err)
+	}
+	defer os.Remove(filepath.Join(logpath, "output_msgpack.log"))
+
+	serv, err := service.NewService(msgpackComposer.GetCompose(), loger, nil)
+	if err != nil {
+		t.Fatalf("error while create service %v", err)
+	}
+	serv.Start()
+	time.Sleep(1 * time.Second)
+	if err = serv.Stop(); err != nil {
+		t.Fatalf("error while stop service %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logpath, "output_msgpack.log"))
+	if err != nil {
+		t.Fatalf("error while read output_msgpack.log %v", err)
+	}
+	want := `{"eventname":"bashReadLine","source":"eBPF","timestamp":"","log":"","metadata":{"Args":["ls","-al"],"Delta":-5,"PID":191998,"Ratio":0.5,"Root":false,"UID":1000}}`
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line != want {
+			t.Fatalf("exported log = %s, want %s", line, want)
+		}
+	}
+}
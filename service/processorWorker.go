@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"polvo/compose"
+	"polvo/metrics"
+	"polvo/service/filter"
 	"polvo/service/model"
 	"sync"
 	"sync/atomic"
@@ -13,19 +15,27 @@ import (
 //
 // processorWorker is a worker that process logs from filterWorker.
 // It receives logs from filterWorker and sends them to the next exporter.
+// Logs dropped by the filters of pipeline are not sent to the exporter of the pipeline only.
 type processorWorker struct {
 	Name string
 	// status variables & context
 	isRunning int32
+	isPaused  int32 // paused processor drops logs instead of sending them to the exporter
 	ctx       context.Context
 	cancel    context.CancelFunc
 	// dependency injection
-	info                 *compose.PipelineInfo // TODO: processor info will be added.
-	eventHeaderPerSensor map[string]map[string][]string
+	info *compose.PipelineInfo // TODO: processor info will be added.
 	// sensor pipe
 	inboundChannel chan *model.CommonLogWrapper
 	// outbound pipes
 	outboundChannel chan<- *model.CommonLogWrapper
+	// filter operator of pipeline
+	filterOperator        filter.FilterOperator
+	returnLogObjectToPool func(*model.CommonLogWrapper)
+	// metrics of pipeline
+	eventsOut     *metrics.Counter
+	eventsDropped *metrics.Counter
+	sendDuration  *metrics.Histogram
 	// wait group for processor thread
 	waitForEndRemainTasks sync.WaitGroup
 }
@@ -34,21 +44,25 @@ func (p *processorWorker) LogChannel() chan<- *model.CommonLogWrapper {
 	return p.inboundChannel
 }
 
-func newProcessorWorker(name string, info *compose.PipelineInfo, exporterChan chan<- *model.CommonLogWrapper) *processorWorker {
+func newProcessorWorker(name string,
+	info *compose.PipelineInfo,
+	filterOperator filter.FilterOperator,
+	returnLogObjectToPool func(*model.CommonLogWrapper),
+	exporterChan chan<- *model.CommonLogWrapper) *processorWorker {
 	nw := new(processorWorker)
 
 	// set name
 	nw.Name = name
-	nw.eventHeaderPerSensor = make(map[string]map[string][]string)
 	// dependency injection
 	nw.info = info
+	nw.filterOperator = filterOperator
+	nw.returnLogObjectToPool = returnLogObjectToPool
+	// metrics
+	nw.eventsOut = pipelineEventsOut.With(name)
+	nw.eventsDropped = pipelineEventsDropped.With(name)
+	nw.sendDuration = pipelineSendDuration.With(name, info.Exporter.Name)
 	// context
 	nw.ctx, nw.cancel = context.WithCancel(context.Background())
-	// set event headers
-	for _, sensorInfo := range nw.info.Sensors {
-		// set event headers
-		nw.eventHeaderPerSensor[sensorInfo.Name] = sensorInfo.EventsHeader
-	}
 	// set channel
 	nw.inboundChannel = make(chan *model.CommonLogWrapper)
 	nw.outboundChannel = exporterChan
@@ -56,6 +70,15 @@ func newProcessorWorker(name string, info *compose.PipelineInfo, exporterChan ch
 	return nw
 }
 
+// setPaused makes the processor drop logs while paused.
+func (p *processorWorker) setPaused(paused bool) {
+	var value int32
+	if paused {
+		value = 1
+	}
+	atomic.StoreInt32(&p.isPaused, value)
+}
+
 func (p *processorWorker) Start() {
 	atomic.StoreInt32(&p.isRunning, 1)
 	go p.processorThread()
@@ -96,9 +119,20 @@ func (p *processorWorker) processorThread() {
 			// e.g. parser, signature detect, etc.
 			// fmt.Fprintf(os.Stderr, "Processor Worker[%s] received log from Filter Worker[%s]\n", p.Name, log.Tag)
 
-			// decrease ref count
-			atomic.AddInt32(&log.RefCount, -1)
+			// filter log for this pipeline. logs are dropped while the pipeline is paused.
+			if atomic.LoadInt32(&p.isPaused) > 0 || p.filterOperator.Operation(log) {
+				p.eventsDropped.Inc()
+				// release the reference of this pipeline. log is put to sync.Pool if other pipelines do not use it.
+				p.returnLogObjectToPool(log)
+				p.waitForEndRemainTasks.Done()
+				continue
+			}
+			// the reference of this pipeline is released by the exporter
+			// time blocked by exporter
+			startedAt := time.Now()
 			p.outboundChannel <- log
+			p.sendDuration.Observe(time.Since(startedAt).Seconds())
+			p.eventsOut.Inc()
 			p.waitForEndRemainTasks.Done()
 		}
 	}
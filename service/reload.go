@@ -0,0 +1,324 @@
+package service
+
+import (
+	"fmt"
+	"polvo/compose"
+	perror "polvo/error"
+	"polvo/service/filter"
+	"polvo/service/model"
+	"reflect"
+	"sort"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// # swappableFilter
+//
+// swappableFilter is the filter operator of a worker which can be replaced while the worker is running.
+// Workers keep the same swappableFilter across reloads, and only the filter chain in it is swapped.
+type swappableFilter struct {
+	chain atomic.Pointer[filter.FilterChain]
+}
+
+func newSwappableFilter(chain filter.FilterChain) *swappableFilter {
+	sf := new(swappableFilter)
+	sf.swap(chain)
+	return sf
+}
+
+func (sf *swappableFilter) Operation(log *model.CommonLogWrapper) bool {
+	return sf.chain.Load().Operation(log)
+}
+
+func (sf *swappableFilter) swap(chain filter.FilterChain) {
+	sf.chain.Store(&chain)
+}
+
+// componentSet is the names of sensors, pipelines & exporters.
+type componentSet struct {
+	sensors   []string
+	pipelines []string
+	exporters []string
+}
+
+/************************************************************************************************************
+* Reload
+************************************************************************************************************/
+
+// # Reload
+//
+// Reload applies new compose & global filter to the running service.
+// Reload sequence is as follows:
+// 1. Create filter operators of new compose. If it fails, the running service is not changed.
+// 2. Stop sensors, processors & exporters whose definitions are changed.
+// 3. Create & start them with new compose.
+// 4. Swap filters of unchanged sensors & pipelines.
+// If step 3 fails, the changed components are restarted with the old compose & the error is returned.
+//
+// filterOp is applied to logs of all sensors. nil means no global filter.
+func (s *service) Reload(info *compose.Compose, filterOp filter.FilterOperator) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if atomic.LoadInt32(&s.isStopped) > 0 {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineReload,
+			Origin: fmt.Errorf("service is already stopped"),
+			Msg:    "error while reload service",
+		}
+	}
+	if info == nil || info.Service == nil {
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineReload,
+			Origin: fmt.Errorf("compose is nil"),
+			Msg:    "error while reload service",
+		}
+	}
+	// validate filters before touching running workers
+	filterOpMap, err := newFilterOperators(*info, s.logger)
+	if err != nil {
+		s.logger.PrintError("reload is rejected %v", err)
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineReload,
+			Origin: err,
+			Msg:    "error while reload service",
+		}
+	}
+
+	defer s.holdSensorGroup()()
+
+	oldInfo, oldFilterOpMap, oldFilterOp := s.info, s.filterOpMap, s.filterOp
+	changed := diffCompose(oldInfo, info)
+	s.logger.PrintInfo("reload service: sensors %v, pipelines %v, exporters %v are changed", changed.sensors, changed.pipelines, changed.exporters)
+	// metrics listener is owned by the agent, not by the service
+	if !reflect.DeepEqual(oldInfo.Metrics, info.Metrics) {
+		s.logger.PrintError("reload service: metrics is changed. it is applied after restart of agent")
+	}
+
+	if err = s.stopComponents(changed); err != nil {
+		// components are removed from maps even if they are not stopped gracefully
+		s.logger.PrintError("error while stop components on reload %v", err)
+	}
+	s.filterOpMap, s.filterOp = filterOpMap, filterOp
+	if err = s.startComponents(*info, changed); err != nil {
+		s.logger.PrintError("reload is rolled back %v", err)
+		// roll back to old compose
+		if stopErr := s.stopComponents(changed); stopErr != nil {
+			s.logger.PrintError("error while stop components on rollback %v", stopErr)
+		}
+		s.filterOpMap, s.filterOp = oldFilterOpMap, oldFilterOp
+		if rollbackErr := s.startComponents(*oldInfo, changed); rollbackErr != nil {
+			s.logger.PrintError("error while rollback %v", rollbackErr)
+			err = joinError(err, rollbackErr)
+		}
+		return perror.PolvoPipelineError{
+			Code:   perror.ErrPipelineReload,
+			Origin: err,
+			Msg:    "error while reload service",
+		}
+	}
+
+	// swap filters of unchanged workers. changed workers are created with new filters.
+	for sensorName, sensorInfo := range info.Sensors {
+		s.sensorFilterMap[sensorName].swap(s.sensorFilterChain(sensorInfo))
+	}
+	for pipelineName, pipelineInfo := range info.Service.Pipeline {
+		s.pipelineFilterMap[pipelineName].swap(s.filterChain(pipelineInfo.Filters))
+	}
+	// forget pause of removed components
+	for sensorName := range s.pausedSensors {
+		if _, ok := info.Sensors[sensorName]; !ok {
+			delete(s.pausedSensors, sensorName)
+		}
+	}
+	for pipelineName := range s.pausedPipelines {
+		if _, ok := info.Service.Pipeline[pipelineName]; !ok {
+			delete(s.pausedPipelines, pipelineName)
+		}
+	}
+	s.info = info
+	s.logger.PrintInfo("service is reloaded")
+	return nil
+}
+
+// holdSensorGroup keeps sensorGroup waiting while sensors are replaced, and returns the function to release it.
+// Otherwise Wait returns when all sensors are stopped.
+func (s *service) holdSensorGroup() func() {
+	release := make(chan struct{})
+	s.sensorGroup.Go(func() error {
+		<-release
+		return nil
+	})
+	return func() {
+		close(release)
+	}
+}
+
+// stopComponents stops components of set in the order of sensors, processors & exporters.
+// Components which do not exist are ignored.
+func (s *service) stopComponents(set componentSet) error {
+	var joinedErr error
+
+	for _, sensorName := range set.sensors {
+		joinedErr = joinError(joinedErr, s.stopSensor(sensorName))
+	}
+	for _, pipelineName := range set.pipelines {
+		joinedErr = joinError(joinedErr, s.stopProcessor(pipelineName))
+	}
+	for _, exporterName := range set.exporters {
+		joinedErr = joinError(joinedErr, s.stopExporter(exporterName))
+	}
+	return joinedErr
+}
+
+// startComponents creates & starts components of set defined in info in the order of exporters, processors & sensors.
+// Components which are not defined in info are ignored.
+func (s *service) startComponents(info compose.Compose, set componentSet) error {
+	for _, exporterName := range set.exporters {
+		exporterInfo, ok := info.Exporters[exporterName]
+		if !ok {
+			continue
+		}
+		if err := s.createExporter(info, exporterInfo, s.logger); err != nil {
+			return err
+		}
+		s.startExporter(s.exporterMap[exporterName])
+	}
+	for _, pipelineName := range set.pipelines {
+		pipelineInfo, ok := info.Service.Pipeline[pipelineName]
+		if !ok {
+			continue
+		}
+		if err := s.createProcessor(pipelineName, pipelineInfo, s.logger); err != nil {
+			return err
+		}
+		s.processorWorkerMap[pipelineName].Start()
+	}
+	for _, sensorName := range set.sensors {
+		sensorInfo, ok := info.Sensors[sensorName]
+		if !ok {
+			continue
+		}
+		if err := s.createFilterAndSensor(info, sensorInfo, s.logger); err != nil {
+			return err
+		}
+		if err := s.startSensor(sensorInfo); err != nil {
+			return perror.PolvoPipelineError{
+				Code:   perror.ErrSensorExecute,
+				Origin: err,
+				Msg:    fmt.Sprintf("error while start sensor [%s]", sensorName),
+			}
+		}
+	}
+	return nil
+}
+
+/************************************************************************************************************
+* Compose diff
+************************************************************************************************************/
+
+// diffCompose returns the components which must be restarted to apply newInfo.
+//
+// - exporters are changed if they are added, removed or modified. All exporters are changed if group or description of service is modified.
+//
+// - pipelines are changed if they are added, removed, their sensors or exporter are modified.
+//
+// - sensors are changed if they are added, removed, modified or they belong to changed pipelines.
+//
+// Filters are not compared because they are swapped without restart.
+func diffCompose(oldInfo, newInfo *compose.Compose) componentSet {
+	var set componentSet
+
+	// exporters
+	serviceChanged := oldInfo.Service.Group != newInfo.Service.Group || oldInfo.Service.Description != newInfo.Service.Description
+	changedExporters := make(map[string]bool)
+	for _, name := range unionKeys(oldInfo.Exporters, newInfo.Exporters) {
+		oldExporter, oldOk := oldInfo.Exporters[name]
+		newExporter, newOk := newInfo.Exporters[name]
+		if serviceChanged || !oldOk || !newOk || !equalExporter(oldExporter, newExporter) {
+			changedExporters[name] = true
+			set.exporters = append(set.exporters, name)
+		}
+	}
+
+	// pipelines
+	changedSensors := make(map[string]bool)
+	for _, name := range unionKeys(oldInfo.Service.Pipeline, newInfo.Service.Pipeline) {
+		oldPipeline, oldOk := oldInfo.Service.Pipeline[name]
+		newPipeline, newOk := newInfo.Service.Pipeline[name]
+		if oldOk && newOk && !changedExporters[newPipeline.Exporter.Name] &&
+			oldPipeline.Exporter.Name == newPipeline.Exporter.Name &&
+			reflect.DeepEqual(sensorNames(oldPipeline), sensorNames(newPipeline)) {
+			continue
+		}
+		set.pipelines = append(set.pipelines, name)
+		// sensors of changed pipelines are connected to new processor
+		for _, sensorName := range append(sensorNames(oldPipeline), sensorNames(newPipeline)...) {
+			changedSensors[sensorName] = true
+		}
+	}
+
+	// sensors
+	for _, name := range unionKeys(oldInfo.Sensors, newInfo.Sensors) {
+		oldSensor, oldOk := oldInfo.Sensors[name]
+		newSensor, newOk := newInfo.Sensors[name]
+		if changedSensors[name] || !oldOk || !newOk || !equalSensor(oldSensor, newSensor) {
+			set.sensors = append(set.sensors, name)
+		}
+	}
+	return set
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sensorNames(pipelineInfo compose.PipelineInfo) []string {
+	names := make([]string, 0, len(pipelineInfo.Sensors))
+	for _, sensorInfo := range pipelineInfo.Sensors {
+		names = append(names, sensorInfo.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// equalSensor compares the definitions of sensors except filters.
+func equalSensor(a, b *compose.SensorInfo) bool {
+	return a.Type == b.Type &&
+		a.ExecPath == b.ExecPath &&
+		a.Param == b.Param &&
+		a.RunAsRoot == b.RunAsRoot &&
+		a.Format == b.Format &&
+		a.Framing == b.Framing &&
+		a.StderrRateLimit == b.StderrRateLimit &&
+		a.Restart == b.Restart &&
+		reflect.DeepEqual(a.EventsHeader, b.EventsHeader) &&
+		reflect.DeepEqual(a.Schema, b.Schema) &&
+		reflect.DeepEqual(a.Credential, b.Credential) &&
+		reflect.DeepEqual(a.Tail, b.Tail)
+}
+
+func equalExporter(a, b *compose.ExporterInfo) bool {
+	if a.Mode != b.Mode || a.Destination != b.Destination || a.Timeout != b.Timeout ||
+		!reflect.DeepEqual(a.Queue, b.Queue) {
+		return false
+	}
+	// options are compared as yaml because nodes have positions
+	if a.Options.Kind == 0 || b.Options.Kind == 0 {
+		return a.Options.Kind == b.Options.Kind
+	}
+	aOptions, aErr := yaml.Marshal(&a.Options)
+	bOptions, bErr := yaml.Marshal(&b.Options)
+	return aErr == nil && bErr == nil && string(aOptions) == string(bOptions)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"os"
+	"polvo/compose"
+	plogger "polvo/logger"
+	"polvo/service/filter"
+	"sync"
+	"time"
+)
+
+// # Reloader
+//
+// Reloader re-parses the compose & filter files and reloads the service with them.
+// It is triggered by SIGHUP, or by Watch when the files are modified.
+// If the files are invalid, the error is logged and the running service is not changed.
+type Reloader interface {
+	Reload() error
+	Watch(ctx context.Context, interval time.Duration)
+}
+
+type reloader struct {
+	svc         Service
+	logger      plogger.PolvoLogger
+	composePath string
+	// filterPath is the global filter file. empty means no global filter.
+	filterPath string
+	// modification times of the watched files
+	modTimes   map[string]time.Time
+	reloadLock sync.Mutex
+}
+
+func NewReloader(svc Service, composePath string, filterPath string, loger plogger.PolvoLogger) Reloader {
+	r := new(reloader)
+
+	// dependency injection
+	r.svc = svc
+	r.logger = loger
+	r.composePath = composePath
+	r.filterPath = filterPath
+	r.modTimes = r.scanModTimes(r.watchedFiles(nil))
+	return r
+}
+
+func (r *reloader) Reload() error {
+	var filterOp filter.FilterOperator
+
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	// invalid files are not reloaded again by Watch until they are modified
+	r.modTimes = r.scanModTimes(r.watchedPaths())
+	r.logger.PrintInfo("reload compose %s", r.composePath)
+	composer, err := compose.NewComposeFile(r.composePath)
+	if err != nil {
+		r.logger.PrintError("reload is rejected %v", err)
+		return err
+	}
+	if r.filterPath != "" {
+		filterData, err := os.ReadFile(r.filterPath)
+		if err != nil {
+			r.logger.PrintError("reload is rejected %v", err)
+			return err
+		}
+		filterOp, err = filter.NewFilterOperator(filterData)
+		if err != nil {
+			r.logger.PrintError("reload is rejected %v", err)
+			return err
+		}
+	}
+	// watch filter files of new compose
+	r.modTimes = r.scanModTimes(r.watchedFiles(composer))
+	return r.svc.Reload(composer.GetCompose(), filterOp)
+}
+
+// # Watch
+//
+// Watch polls modification times of the sources of compose, the global filter file & the filter files declared in compose.
+// Service is reloaded when any of them is modified. Watch blocks until ctx is done.
+func (r *reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.isModified() {
+				continue
+			}
+			// errors are logged by Reload
+			_ = r.Reload()
+		}
+	}
+}
+
+func (r *reloader) isModified() bool {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	for path, modTime := range r.scanModTimes(r.watchedPaths()) {
+		if !modTime.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reloader) watchedPaths() []string {
+	paths := make([]string, 0, len(r.modTimes))
+	for path := range r.modTimes {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// watchedFiles returns the sources of compose, the global filter file & the filter files declared in compose.
+func (r *reloader) watchedFiles(composer compose.ComposeFile) []string {
+	paths := []string{r.composePath}
+	if r.filterPath != "" {
+		paths = append(paths, r.filterPath)
+	}
+	if composer == nil {
+		var err error
+		if composer, err = compose.NewComposeFile(r.composePath); err != nil {
+			return paths
+		}
+	}
+	// extended files & files of ${file:path}
+	paths = append(paths, composer.Sources()...)
+	for _, filterInfo := range composer.GetCompose().Filters {
+		if filterInfo.Path != "" {
+			paths = append(paths, filterInfo.Path)
+		}
+	}
+	return paths
+}
+
+// scanModTimes returns modification times of paths. Files which do not exist have zero time.
+func (r *reloader) scanModTimes(paths []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			modTimes[path] = time.Time{}
+			continue
+		}
+		modTimes[path] = stat.ModTime()
+	}
+	return modTimes
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"polvo/compose"
+	"polvo/metrics"
+	"polvo/service/model"
+	"sort"
+)
+
+// kinds of schema violations
+const (
+	violationMissing    = "missing"
+	violationExtra      = "extra"
+	violationUndeclared = "undeclared_event"
+)
+
+var sensorSchemaViolations = metrics.Default.NewCounterVec("polvo_sensor_schema_violations_total",
+	"Logs of sensor which do not match events_header. A log with several missing or extra fields is counted once per kind.", "sensor", "violation")
+
+// # schemaValidator
+//
+// schemaValidator checks the metadata of logs against the fields declared in events_header for their eventname,
+// and applies the policies of sensor schema. It runs in the filterWorker of sensor,
+// so each log is checked & normalized once before it is shared by pipelines.
+type schemaValidator struct {
+	info *compose.SchemaInfo
+	// declared fields per eventname
+	fields map[string][]string
+	// set of declared fields per eventname
+	declared map[string]map[string]bool
+	// metrics of sensor
+	missing    *metrics.Counter
+	extra      *metrics.Counter
+	undeclared *metrics.Counter
+}
+
+// newSchemaValidator returns nil if schema is not configured for sensor.
+func newSchemaValidator(sensorInfo *compose.SensorInfo) *schemaValidator {
+	if sensorInfo.Schema == nil {
+		return nil
+	}
+	sv := new(schemaValidator)
+	sv.info = sensorInfo.Schema
+	sv.fields = sensorInfo.EventsHeader
+	sv.declared = make(map[string]map[string]bool, len(sensorInfo.EventsHeader))
+	for eventName, fields := range sensorInfo.EventsHeader {
+		sv.declared[eventName] = make(map[string]bool, len(fields))
+		for _, field := range fields {
+			sv.declared[eventName][field] = true
+		}
+	}
+	sv.missing = sensorSchemaViolations.With(sensorInfo.Name, violationMissing)
+	sv.extra = sensorSchemaViolations.With(sensorInfo.Name, violationExtra)
+	sv.undeclared = sensorSchemaViolations.With(sensorInfo.Name, violationUndeclared)
+	return sv
+}
+
+// validate checks log & returns true if log must be dropped.
+// Violations of "tag" policies are added to SchemaViolations of log.
+// If projection is enabled, the fields which are not declared are removed from metadata.
+func (sv *schemaValidator) validate(log *model.CommonLogWrapper) bool {
+	declared, ok := sv.declared[log.EventName]
+	if !ok {
+		sv.undeclared.Inc()
+		return sv.apply(log, sv.info.Undeclared, []string{violationUndeclared})
+	}
+	// metadata which is not an object has no fields
+	metadata, _ := log.MetaData.(map[string]interface{})
+
+	var missing, extra []string
+	for _, field := range sv.fields[log.EventName] {
+		if _, ok := metadata[field]; !ok {
+			missing = append(missing, violationMissing+":"+field)
+		}
+	}
+	for field := range metadata {
+		if !declared[field] {
+			extra = append(extra, field)
+		}
+	}
+	if len(missing) > 0 {
+		sv.missing.Inc()
+		if sv.apply(log, sv.info.Missing, missing) {
+			return true
+		}
+	}
+	if len(extra) > 0 {
+		sv.extra.Inc()
+		sort.Strings(extra)
+		violations := make([]string, 0, len(extra))
+		for _, field := range extra {
+			violations = append(violations, violationExtra+":"+field)
+		}
+		if sv.apply(log, sv.info.Extra, violations) {
+			return true
+		}
+		if sv.info.Projection {
+			for _, field := range extra {
+				delete(metadata, field)
+			}
+		}
+	}
+	return false
+}
+
+// apply applies policy to log with violations & returns true if log must be dropped.
+func (sv *schemaValidator) apply(log *model.CommonLogWrapper, policy string, violations []string) bool {
+	switch policy {
+	case compose.SchemaDrop:
+		return true
+	case compose.SchemaTag:
+		log.SchemaViolations = append(log.SchemaViolations, violations...)
+	}
+	return false
+}
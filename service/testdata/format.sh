@@ -0,0 +1,13 @@
+#!/bin/bash
+
+# prints events in the format given as the first argument
+while true;
+do
+    case "$1" in
+        csv) echo 'bashReadLine,191998,1000,"echo hello, world"' ;;
+        tsv) printf 'bashReadLine\t191998\t1000\techo hello, world\n' ;;
+        logfmt) echo 'eventname=bashReadLine PID=191998 UID=1000 CommandLine="echo \"hello\", world"' ;;
+        kv) echo 'eventname=bashReadLine source=kv_sensor PID=191998 UID=1000 CommandLine=ls' ;;
+    esac
+    sleep 0.01
+done
@@ -0,0 +1,10 @@
+#!/bin/bash
+
+trap "exit 0" SIGINT
+
+# length-prefixed MessagePack records
+while true;
+do
+    cat "$(dirname "$0")/msgpack_record.bin"
+    sleep 0.01
+done
@@ -0,0 +1,9 @@
+#!/bin/bash
+
+# declared event with a missing & an extra field, and an undeclared event
+while true;
+do
+    echo '{"eventname": "bashReadline", "source": "eBPF", "timestamp": "2025-03-11T15:29:34+09:00", "log": "A user has entered a command in the bash shell", "metadata": {"PID":191998,"UID":1000,"Comm":"bash"}}'
+    echo '{"eventname": "vfsOpen", "source": "eBPF", "timestamp": "2025-03-11T15:29:34+09:00", "log": "A file is opened", "metadata": {"PID":191998}}'
+    sleep 0.01
+done
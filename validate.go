@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"polvo/compose"
+	perror "polvo/error"
+	"polvo/service/filter"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// # validateCommand
+//
+// validateCommand checks compose & filter files without running sensors, and prints all problems.
+// Filters declared in compose are compiled, too.
+//
+// usage: polvo validate [-compose <file>] [-filter <file>] [-json]
+//
+// With -json, problems are printed as a JSON array of {code, file, path, line, column, message}.
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	composePath := flags.String("compose", "", "compose file")
+	filterPath := flags.String("filter", "", "global filter file")
+	asJSON := flags.Bool("json", false, "print problems as JSON")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if (*composePath == "" && *filterPath == "") || flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: polvo validate [-compose <file>] [-filter <file>] [-json]")
+		return exitUsage
+	}
+
+	problems := make(compose.ValidationErrors, 0)
+	if *composePath != "" {
+		problems = append(problems, validateCompose(*composePath)...)
+	}
+	if *filterPath != "" {
+		problems = append(problems, validateFilterFile(*filterPath)...)
+	}
+	if *asJSON {
+		out, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error while marshal problems %v\n", err)
+			return exitFailure
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) <= 0 {
+			fmt.Println("OK")
+		} else {
+			fmt.Printf("%d problem(s) found\n", len(problems))
+		}
+	}
+	if len(problems) > 0 {
+		return exitInvalidConfig
+	}
+	return exitOK
+}
+
+func validateCompose(composePath string) compose.ValidationErrors {
+	composer, err := compose.NewComposeFile(composePath)
+	if err != nil {
+		// all problems of compose are reported at once
+		var validationErrs compose.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			return validationErrs
+		}
+		return compose.ValidationErrors{{Code: perror.ErrInvalidCompose, File: composePath, Err: err}}
+	}
+	problems := make(compose.ValidationErrors, 0)
+	info := composer.GetCompose()
+	names := make([]string, 0, len(info.Filters))
+	for name := range info.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filterInfo := info.Filters[name]
+		if filterInfo.Path != "" {
+			problems = append(problems, validateFilterFile(filterInfo.Path)...)
+			continue
+		}
+		// inline rules are located in compose file
+		for _, err := range filter.ValidateFilter(filterInfo.Data) {
+			problems = append(problems, locate(composePath, err, "filters", name, "rules"))
+		}
+	}
+	return problems
+}
+
+func validateFilterFile(filterPath string) compose.ValidationErrors {
+	filterData, err := os.ReadFile(filterPath)
+	if err != nil {
+		return compose.ValidationErrors{{Code: perror.InvalidFilterError, File: filterPath, Err: err}}
+	}
+	// every invalid selection is reported
+	problems := make(compose.ValidationErrors, 0)
+	for _, err := range filter.ValidateFilter(filterData) {
+		problems = append(problems, locate(filterPath, err))
+	}
+	return problems
+}
+
+// locate returns the problem at the selection of err.
+// prefix is the path of keys to the filter rules in the file.
+func locate(file string, err error, prefix ...string) *compose.ValidationError {
+	p := &compose.ValidationError{Code: perror.InvalidFilterError, File: file, Err: err}
+
+	data, readErr := os.ReadFile(file)
+	if readErr != nil {
+		return p
+	}
+	var root yaml.Node
+	if yaml.Unmarshal(data, &root) != nil || len(root.Content) <= 0 {
+		return p
+	}
+	keys := prefix
+	var selectionErr filter.SelectionError
+	if errors.As(err, &selectionErr) {
+		keys = append(keys, selectionErr.Section, selectionErr.Name)
+		p.Err = selectionErr.Err
+	}
+	p.Path = strings.Join(keys, ".")
+	// position of the deepest key found
+	node := root.Content[0]
+	for _, key := range keys {
+		keyNode, valueNode := lookupKey(node, key)
+		if keyNode == nil {
+			break
+		}
+		p.Line, p.Column = keyNode.Line, keyNode.Column
+		node = valueNode
+	}
+	return p
+}
+
+// lookupKey returns the key & value nodes of key in mapping node.
+func lookupKey(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value == key {
+			return node.Content[idx], node.Content[idx+1]
+		}
+	}
+	return nil, nil
+}
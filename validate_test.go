@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"polvo/service/filter"
+	"testing"
+)
+
+func TestValidateCommandExitCode(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"-unknown"}, exitUsage},
+		{[]string{"-compose", "testdata/compose_valid.yml", "extra"}, exitUsage},
+		{[]string{"-compose", "testdata/compose_valid.yml"}, exitOK},
+		{[]string{"-filter", "testdata/filter_valid.yml", "-json"}, exitOK},
+		{[]string{"-filter", "testdata/filter_invalid.yml"}, exitInvalidConfig},
+		{[]string{"-compose", "testdata/compose_invalid_filter.yml", "-json"}, exitInvalidConfig},
+		{[]string{"-filter", "testdata/not_exist.yml"}, exitInvalidConfig},
+	} {
+		if got := validateCommand(test.args); got != test.want {
+			t.Errorf("validateCommand(%v) = %d, want %d", test.args, got, test.want)
+		}
+	}
+}
+
+func TestValidateFilterFileReportsAllSelections(t *testing.T) {
+	problems := validateFilterFile(filepath.Join("testdata", "filter_invalid.yml"))
+	if len(problems) != 2 || problems[0].Path != "allow.shell" || problems[1].Path != "deny.filter_touch" {
+		t.Errorf("validateFilterFile() = %v, want problems of allow.shell & deny.filter_touch", problems)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	filterErrs := filter.ValidateFilter([]byte(`
+allow:
+  shell:
+    "eventname|unknown": "Readline"
+deny:
+  filter_touch:
+    condition:
+      "Commandline|re": "("
+`))
+	if len(filterErrs) != 2 {
+		t.Fatalf("ValidateFilter() = %v, want 2 errors", filterErrs)
+	}
+	composeErrs := filter.ValidateFilter([]byte(`{allow: {shell: {"eventname|unknown": "Readline"}}}`))
+	if len(composeErrs) != 1 {
+		t.Fatalf("ValidateFilter() = %v, want 1 error", composeErrs)
+	}
+	for _, test := range []struct {
+		file   string
+		err    error
+		prefix []string
+		path   string
+		line   int
+		column int
+	}{
+		{"filter_invalid.yml", filterErrs[0], nil, "allow.shell", 2, 3},
+		{"filter_invalid.yml", filterErrs[1], nil, "deny.filter_touch", 9, 3},
+		// inline rules are located under the prefix
+		{"compose_invalid_filter.yml", composeErrs[0], []string{"filters", "shell_only", "rules"}, "filters.shell_only.rules.allow.shell", 16, 17},
+		// errors without selection are located at the deepest key of prefix
+		{"compose_invalid_filter.yml", errors.New("broken rules"), []string{"filters", "shell_only", "rules", "deny"}, "filters.shell_only.rules.deny", 14, 9},
+		// files which can not be read have no position
+		{"not_exist.yml", errors.New("broken rules"), nil, "", 0, 0},
+	} {
+		p := locate(filepath.Join("testdata", test.file), test.err, test.prefix...)
+		if p.Path != test.path || p.Line != test.line || p.Column != test.column {
+			t.Errorf("locate(%s, %v) = %s at %d:%d, want %s at %d:%d", test.file, test.err, p.Path, p.Line, p.Column, test.path, test.line, test.column)
+		}
+	}
+}